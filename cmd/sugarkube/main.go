@@ -19,6 +19,7 @@ package main
 import (
 	"github.com/sugarkube/sugarkube/internal/pkg/cmd"
 	"github.com/sugarkube/sugarkube/internal/pkg/cmd/cli"
+	"github.com/sugarkube/sugarkube/internal/pkg/secrets"
 	"os"
 	"path/filepath"
 )
@@ -28,5 +29,6 @@ func main() {
 	baseName := filepath.Base(os.Args[0])
 
 	err := cli.NewCommand(baseName).Execute()
+	secrets.DeleteSecretFiles()
 	cmd.CheckError(err)
 }
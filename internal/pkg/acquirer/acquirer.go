@@ -19,6 +19,7 @@ package acquirer
 import (
 	"fmt"
 	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"strings"
 )
@@ -31,10 +32,46 @@ type Acquirer interface {
 	Uri() string
 }
 
+// source option to pin a source to an exact revision, e.g. a commit SHA for git sources or an
+// archive digest for helm charts. This is normally set from lock files.
+const RevisionKey = "revision"
+
+// Acquirers that can report the exact revision they acquired implement this so sources can be
+// locked to that revision
+type Lockable interface {
+	Revision(dest string) (string, error)
+}
+
 // Instantiates a new acquirer from a source
 func New(source structs.Source) (Acquirer, error) {
 
-	if strings.Contains(source.Uri, ".git") {
+	// check for archives first since their URIs may contain '.git' (e.g. githubusercontent.com)
+	if isArchiveUri(source.Uri) {
+		acquirerObj, err := newHttpAcquirer(source)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return acquirerObj, nil
+	} else if isHelmUri(source.Uri) {
+		acquirerObj, err := newHelmAcquirer(source)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return acquirerObj, nil
+	} else if strings.Contains(source.Uri, ".git") {
+		acquirerName, err := gitAcquirerName(source)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if acquirerName == GoGitAcquirerName {
+			acquirerObj, err := newGoGitAcquirer(source)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			return acquirerObj, nil
+		}
+
 		acquirerObj, err := newGitAcquirer(source)
 		if err != nil {
 			return nil, errors.WithStack(err)
@@ -52,6 +89,59 @@ func New(source structs.Source) (Acquirer, error) {
 	return nil, errors.New(fmt.Sprintf("Couldn't identify acquirer for URI '%s'", source.Uri))
 }
 
+// Returns the name of the acquirer to use for git sources. An acquirer explicitly set in the
+// source's options takes precedence over the globally configured one.
+func gitAcquirerName(source structs.Source) (string, error) {
+	name := GitAcquirerName
+
+	if config.CurrentConfig != nil && config.CurrentConfig.GitAcquirer != "" {
+		name = config.CurrentConfig.GitAcquirer
+	}
+
+	if optionValue, ok := source.Options[AcquirerKey]; ok {
+		optionName, ok := optionValue.(string)
+		if !ok {
+			return "", errors.New(fmt.Sprintf("The '%s' option for source '%s' must be a string",
+				AcquirerKey, source.Uri))
+		}
+		name = optionName
+	}
+
+	switch name {
+	case GitAcquirerName, GoGitAcquirerName:
+		return name, nil
+	}
+
+	return "", errors.New(fmt.Sprintf("Unknown git acquirer '%s' for source '%s'. Valid "+
+		"values are '%s' and '%s'", name, source.Uri, GitAcquirerName, GoGitAcquirerName))
+}
+
+// Returns the source option used to pin the version of a source with the given URI, e.g. a
+// branch for git sources or a version constraint for helm charts
+func VersionOptionKey(uri string) string {
+	if isHelmUri(uri) {
+		return VersionKey
+	}
+
+	return BranchKey
+}
+
+// Returns the revision a source should be pinned to, if any
+func revisionFromOptions(source structs.Source) (string, error) {
+	optionValue, ok := source.Options[RevisionKey]
+	if !ok {
+		return "", nil
+	}
+
+	revision, ok := optionValue.(string)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("The '%s' option for source '%s' must be a string",
+			RevisionKey, source.Uri))
+	}
+
+	return strings.TrimSpace(revision), nil
+}
+
 // Delegate to an acquirer implementation
 func Acquire(a Acquirer, dest string) error {
 	return a.acquire(dest)
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// What to do when a checkout needs switching to a different branch, tag or commit but it
+// contains local modifications
+type ConflictPolicy string
+
+const (
+	ConflictFail    ConflictPolicy = "fail"    // leave the checkout alone and return an error
+	ConflictSkip    ConflictPolicy = "skip"    // leave the checkout alone and carry on
+	ConflictStash   ConflictPolicy = "stash"   // stash the modifications then switch
+	ConflictDiscard ConflictPolicy = "discard" // throw away the modifications then switch
+)
+
+// Describes a checkout with local modifications that needed switching to a different revision
+type Conflict struct {
+	Dest       string
+	Current    string   // the branch or commit that's checked out
+	Wanted     string   // the branch, tag or commit the checkout should contain
+	Modified   []string // paths of modified or untracked files
+	Resolution ConflictPolicy
+}
+
+// Returned when a checkout couldn't be switched because of local modifications
+type ConflictError struct {
+	Conflict *Conflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("The path at '%s' contains '%s' but needs updating to '%s'. "+
+		"Aborting to prevent losing local modifications to: %s", e.Conflict.Dest,
+		e.Conflict.Current, e.Conflict.Wanted, strings.Join(e.Conflict.Modified, ", "))
+}
+
+// Acquirers that switch existing checkouts between revisions implement this so local
+// modifications can be handled according to a policy
+type Switchable interface {
+	acquireWithPolicy(dest string, policy ConflictPolicy) (*Conflict, error)
+}
+
+// Parses the name of a conflict policy that users can choose on the command line. Discarding
+// local modifications is deliberately excluded so it has to be requested explicitly.
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case ConflictFail, ConflictSkip, ConflictStash:
+		return policy, nil
+	}
+
+	return "", errors.New(fmt.Sprintf("Invalid conflict policy '%s'. Valid values are "+
+		"'%s', '%s' and '%s'", name, ConflictSkip, ConflictFail, ConflictStash))
+}
+
+// Acquires a source, resolving conflicts with local modifications in existing checkouts using
+// the given policy. Returns details of any conflict. If the policy is to fail, a
+// *ConflictError is returned too.
+func AcquireWithPolicy(a Acquirer, dest string, policy ConflictPolicy) (*Conflict, error) {
+	if switchable, ok := a.(Switchable); ok {
+		return switchable.acquireWithPolicy(dest, policy)
+	}
+
+	return nil, a.acquire(dest)
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates a remote with 'master' and 'feature' branches that contain different versions of a
+// kapp. Returns the remote and a function to clean it up.
+func newBranchedTestRemote(t *testing.T) (*testRemote, func()) {
+	remote, cleanup := newTestRemote(t)
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+	})
+	masterHash, err := remote.repo.Head()
+	assert.Nil(t, err)
+
+	featureHash := remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: feature",
+	})
+
+	err = remote.repo.Storer.SetReference(plumbing.NewHashReference(
+		plumbing.NewBranchReferenceName("feature"), featureHash))
+	assert.Nil(t, err)
+	err = remote.repo.Storer.SetReference(plumbing.NewHashReference(
+		plumbing.NewBranchReferenceName("master"), masterHash.Hash()))
+	assert.Nil(t, err)
+	remote.push(t)
+
+	return remote, cleanup
+}
+
+// Returns a git CLI acquirer for the given path and branch in the bare repo
+func (r *testRemote) gitAcquirer(t *testing.T, path string, branch string) Acquirer {
+	acquirerObj, err := New(structs.Source{
+		Uri: fmt.Sprintf("file://%s//%s#%s", r.bareDir, path, branch),
+		Options: map[string]interface{}{
+			AcquirerKey: GitAcquirerName,
+		},
+	})
+	assert.Nil(t, err)
+	assert.IsType(t, &GitAcquirer{}, acquirerObj)
+
+	return acquirerObj
+}
+
+// Returns the branch checked out in a cache
+func checkedOutBranch(t *testing.T, dest string) string {
+	var stdoutBuf, stderrBuf bytes.Buffer
+	assert.Nil(t, utils.ExecCommand(GitPath, []string{"rev-parse", "--abbrev-ref", "HEAD"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false))
+	return strings.TrimSpace(stdoutBuf.String())
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, name := range []string{"skip", "fail", "stash"} {
+		policy, err := ParseConflictPolicy(name)
+		assert.Nil(t, err)
+		assert.Equal(t, ConflictPolicy(name), policy)
+	}
+
+	// discarding has to be requested with --force
+	_, err := ParseConflictPolicy("discard")
+	assert.NotNil(t, err)
+}
+
+func TestGitSwitchBranch(t *testing.T) {
+	tests := []struct {
+		name             string
+		modify           bool
+		policy           ConflictPolicy
+		expectErr        bool
+		expectConflict   bool
+		expectBranch     string
+		expectContents   string
+		expectStashEntry bool
+	}{
+		{
+			name:           "clean",
+			policy:         ConflictFail,
+			expectBranch:   "feature",
+			expectContents: "version: feature",
+		},
+		{
+			name:           "dirty_fail",
+			modify:         true,
+			policy:         ConflictFail,
+			expectErr:      true,
+			expectConflict: true,
+			expectBranch:   "master",
+			expectContents: "version: local",
+		},
+		{
+			name:           "dirty_skip",
+			modify:         true,
+			policy:         ConflictSkip,
+			expectConflict: true,
+			expectBranch:   "master",
+			expectContents: "version: local",
+		},
+		{
+			name:             "dirty_stash",
+			modify:           true,
+			policy:           ConflictStash,
+			expectConflict:   true,
+			expectBranch:     "feature",
+			expectContents:   "version: feature",
+			expectStashEntry: true,
+		},
+		{
+			name:           "dirty_discard",
+			modify:         true,
+			policy:         ConflictDiscard,
+			expectConflict: true,
+			expectBranch:   "feature",
+			expectContents: "version: feature",
+		},
+	}
+
+	remote, cleanup := newBranchedTestRemote(t)
+	defer cleanup()
+
+	for _, test := range tests {
+		tmpDir, err := ioutil.TempDir("", "git-switch-")
+		assert.Nil(t, err)
+		dest := filepath.Join(tmpDir, "source")
+		path := filepath.Join(dest, "kapps/wordpress/sugarkube.yaml")
+
+		assert.Nil(t, Acquire(remote.gitAcquirer(t, "kapps/wordpress", "master"), dest))
+
+		if test.modify {
+			assert.Nil(t, ioutil.WriteFile(path, []byte("version: local"), 0644))
+			assert.Nil(t, ioutil.WriteFile(filepath.Join(dest, "kapps/wordpress/new.txt"),
+				[]byte("new"), 0644))
+		}
+
+		conflict, err := AcquireWithPolicy(remote.gitAcquirer(t, "kapps/wordpress", "feature"),
+			dest, test.policy)
+
+		if test.expectErr {
+			assert.NotNil(t, err, "Expected an error in test '%s'", test.name)
+			assert.IsType(t, &ConflictError{}, errors.Cause(err), test.name)
+		} else {
+			assert.Nil(t, err, "Unexpected error in test '%s'", test.name)
+		}
+
+		if test.expectConflict {
+			assert.NotNil(t, conflict, test.name)
+			assert.Equal(t, "master", conflict.Current, test.name)
+			assert.Equal(t, "feature", conflict.Wanted, test.name)
+			assert.Equal(t, test.policy, conflict.Resolution, test.name)
+			assert.Equal(t, []string{"kapps/wordpress/new.txt", "kapps/wordpress/sugarkube.yaml"},
+				conflict.Modified, test.name)
+		} else {
+			assert.Nil(t, conflict, test.name)
+		}
+
+		assert.Equal(t, test.expectBranch, checkedOutBranch(t, dest), test.name)
+
+		contents, err := ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expectContents, string(contents), test.name)
+
+		var stdoutBuf, stderrBuf bytes.Buffer
+		assert.Nil(t, utils.ExecCommand(GitPath, []string{"stash", "list"},
+			map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false))
+		assert.Equal(t, test.expectStashEntry, stdoutBuf.Len() > 0, test.name)
+
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestGoGitSwitchBranchDirty(t *testing.T) {
+	remote, cleanup := newBranchedTestRemote(t)
+	defer cleanup()
+
+	tmpDir, err := ioutil.TempDir("", "gogit-switch-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	dest := filepath.Join(tmpDir, "source")
+	path := filepath.Join(dest, "kapps/wordpress/sugarkube.yaml")
+
+	assert.Nil(t, Acquire(remote.acquirer(t, "kapps/wordpress", "master"), dest))
+	assert.Nil(t, ioutil.WriteFile(path, []byte("version: local"), 0644))
+
+	feature := remote.acquirer(t, "kapps/wordpress", "feature")
+
+	// go-git can't stash
+	conflict, err := AcquireWithPolicy(feature, dest, ConflictStash)
+	assert.NotNil(t, err)
+	assert.NotNil(t, conflict)
+
+	conflict, err = AcquireWithPolicy(feature, dest, ConflictSkip)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kapps/wordpress/sugarkube.yaml"}, conflict.Modified)
+
+	contents, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "version: local", string(contents))
+
+	conflict, err = AcquireWithPolicy(feature, dest, ConflictDiscard)
+	assert.Nil(t, err)
+	assert.Equal(t, ConflictDiscard, conflict.Resolution)
+
+	contents, err = ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "version: feature", string(contents))
+}
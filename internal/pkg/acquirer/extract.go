@@ -0,0 +1,254 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extracts a gzipped tarball into a directory. Entries that would be written outside of the
+// directory are rejected.
+func ExtractTarGz(archivePath string, dest string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = extractDir(dest, header.Name)
+		case tar.TypeReg, tar.TypeRegA:
+			err = extractFile(dest, header.Name, header.FileInfo().Mode(), tarReader)
+		case tar.TypeSymlink:
+			err = extractSymlink(dest, header.Name, header.Linkname)
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			log.Logger.Warnf("Skipping unsupported entry '%s' of type '%c' in archive '%s'",
+				header.Name, header.Typeflag, archivePath)
+		}
+
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Extracts a zip file into a directory
+func extractZip(archivePath string, dest string) error {
+	zipReader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer zipReader.Close()
+
+	for _, zipFile := range zipReader.File {
+		err = extractZipEntry(dest, zipFile)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Extracts a single entry from a zip file
+func extractZipEntry(dest string, zipFile *zip.File) error {
+	mode := zipFile.Mode()
+
+	if mode.IsDir() {
+		return extractDir(dest, zipFile.Name)
+	}
+
+	reader, err := zipFile.Open()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer reader.Close()
+
+	if mode&os.ModeSymlink != 0 {
+		target, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return extractSymlink(dest, zipFile.Name, string(target))
+	}
+
+	if !mode.IsRegular() {
+		log.Logger.Warnf("Skipping unsupported entry '%s' with mode '%s' in zip archive",
+			zipFile.Name, mode)
+		return nil
+	}
+
+	return extractFile(dest, zipFile.Name, mode, reader)
+}
+
+// Returns the path an archive entry should be extracted to, returning an error if it'd be
+// outside of the destination directory
+func safeJoin(dest string, name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+
+	if filepath.IsAbs(cleaned) || cleaned == ".." ||
+		strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("Archive entry '%s' would be extracted outside "+
+			"of the destination directory", name))
+	}
+
+	return filepath.Join(dest, cleaned), nil
+}
+
+// Returns the path to extract an archive entry to. Returns an error if the path would be outside
+// of the destination directory, or if any of its parent directories are symlinks since they
+// could be used to write outside of it.
+func entryPath(dest string, name string) (string, error) {
+	path, err := safeJoin(dest, name)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	relativeDir, err := filepath.Rel(dest, filepath.Dir(path))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	parent := dest
+	for _, component := range strings.Split(relativeDir, string(filepath.Separator)) {
+		if component == "." {
+			continue
+		}
+
+		parent = filepath.Join(parent, component)
+		info, err := os.Lstat(parent)
+		if err != nil {
+			if os.IsNotExist(err) {
+				break
+			}
+			return "", errors.WithStack(err)
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", errors.New(fmt.Sprintf("Archive entry '%s' would be extracted "+
+				"through a symlink", name))
+		}
+	}
+
+	return path, nil
+}
+
+// Creates a directory from an archive
+func extractDir(dest string, name string) error {
+	path, err := entryPath(dest, name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return os.MkdirAll(path, 0755)
+}
+
+// Writes a regular file from an archive. Only permission bits are preserved.
+func extractFile(dest string, name string, mode os.FileMode, reader io.Reader) error {
+	path, err := entryPath(dest, name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// remove anything already at the path so we never write through a symlink
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return errors.WithStack(err)
+}
+
+// Creates a symlink from an archive. Symlinks must be relative and point to a path inside the
+// destination directory.
+func extractSymlink(dest string, name string, target string) error {
+	path, err := entryPath(dest, name)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if filepath.IsAbs(target) {
+		return errors.New(fmt.Sprintf("Archive entry '%s' is a symlink to the absolute "+
+			"path '%s'", name, target))
+	}
+
+	relativeTarget, err := filepath.Rel(dest, filepath.Join(filepath.Dir(path), target))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = safeJoin(dest, relativeTarget)
+	if err != nil {
+		return errors.Wrapf(err, "Archive entry '%s' is a symlink to '%s'", name, target)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return os.Symlink(target, path)
+}
@@ -25,17 +25,20 @@ import (
 	"github.com/sugarkube/sugarkube/internal/pkg/utils"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 type GitAcquirer struct {
-	id     string
-	uri    string
-	branch string
-	path   string
+	id          string
+	uri         string
+	branch      string
+	path        string
+	trustedKeys []string // GPG fingerprints of keys trusted to sign the checked out tag/commit
+	revision    string   // if set, this exact commit will be checked out instead of the head of the branch
 }
 
-// todo - make configurable, or use go-git
 const GitPath = "git"
 
 const PathSeparator = "//"
@@ -43,6 +46,11 @@ const BranchSeparator = "#"
 
 const BranchKey = "branch"
 
+// source option to select which acquirer to use for git sources
+const AcquirerKey = "acquirer"
+
+const GitAcquirerName = "git"
+
 // Returns an instance. This allows us to build objects for testing instead of
 // directly instantiating objects in the acquirer factory.
 func newGitAcquirer(source structs.Source) (*GitAcquirer, error) {
@@ -98,11 +106,23 @@ func newGitAcquirer(source structs.Source) (*GitAcquirer, error) {
 		id = strings.Trim(id, "/")
 	}
 
+	keys, err := trustedKeys(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	revision, err := revisionFromOptions(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	return &GitAcquirer{
-		id:     id,
-		uri:    uri,
-		branch: branch,
-		path:   path,
+		id:          id,
+		uri:         uri,
+		branch:      branch,
+		path:        path,
+		trustedKeys: keys,
+		revision:    revision,
 	}, nil
 }
 
@@ -142,6 +162,13 @@ func (a GitAcquirer) Uri() string {
 
 // Acquires kapps via git and saves them to `dest`.
 func (a GitAcquirer) acquire(dest string) error {
+	_, err := a.acquireWithPolicy(dest, ConflictFail)
+	return err
+}
+
+// Acquires kapps via git and saves them to `dest`. If `dest` needs switching to a different
+// branch, tag or commit, local modifications are dealt with according to the policy.
+func (a GitAcquirer) acquireWithPolicy(dest string, policy ConflictPolicy) (*Conflict, error) {
 
 	var destExists bool
 
@@ -150,7 +177,7 @@ func (a GitAcquirer) acquire(dest string) error {
 			log.Logger.Debugf("Destination directory '%s' doesn't exist... will create it", dest)
 			destExists = false
 		} else {
-			return errors.WithStack(err)
+			return nil, errors.WithStack(err)
 		}
 	} else {
 		log.Logger.Debugf("Destination directory '%s' already exists... will update it", dest)
@@ -158,9 +185,9 @@ func (a GitAcquirer) acquire(dest string) error {
 	}
 
 	if destExists {
-		return a.update(dest)
+		return a.update(dest, policy)
 	} else {
-		return a.clone(dest)
+		return nil, a.clone(dest)
 	}
 }
 
@@ -191,11 +218,29 @@ func (a GitAcquirer) clone(dest string) error {
 		return errors.WithStack(err)
 	}
 
-	// fetch
-	err = utils.ExecCommand(GitPath, []string{"fetch"}, map[string]string{},
-		&stdoutBuf, &stderrBuf, dest, 60, false)
-	if err != nil {
-		return errors.WithStack(err)
+	if gitMirrorsEnabled() {
+		// borrow objects from the mirror so they aren't fetched and stored for every source
+		mirrorPath, err := syncGitMirror(a.uri)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = addMirrorAlternate(dest, mirrorPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = a.fetchFromMirror(dest, mirrorPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	} else {
+		// fetch
+		err = utils.ExecCommand(GitPath, []string{"fetch"}, map[string]string{},
+			&stdoutBuf, &stderrBuf, dest, 60, false)
+		if err != nil {
+			return errors.WithStack(err)
+		}
 	}
 
 	// git configure sparse checkout
@@ -211,64 +256,476 @@ func (a GitAcquirer) clone(dest string) error {
 		return errors.WithStack(err)
 	}
 
-	log.Logger.Debugf("Checking out branch '%s'", a.branch)
+	// check out the locked revision if there is one
+	ref := a.branch
+	if a.revision != "" {
+		ref = a.revision
+	}
+
+	log.Logger.Debugf("Checking out '%s'", ref)
 
 	// git checkout - this will put us in a detached head if the branch isn't master
-	err = utils.ExecCommand(GitPath, []string{"checkout", a.branch},
+	err = utils.ExecCommand(GitPath, []string{"checkout", ref},
 		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 90, false)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	// we could optionally verify tags with:
-	// git tag -v a.branch 2>&1 >/dev/null | grep -E '{{ trusted_gpg_keys|join('|') }}'
+	err = a.verify(dest)
+	if err != nil {
+		// don't leave an untrusted checkout in the cache
+		removeErr := os.RemoveAll(dest)
+		if removeErr != nil {
+			log.Logger.Warnf("Failed to remove untrusted checkout at '%s': %s", dest, removeErr)
+		}
+		return errors.WithStack(err)
+	}
 
 	return nil
 }
 
-// Pulls a previously checked out source to update it
-func (a GitAcquirer) update(dest string) error {
+// Updates a previously checked out source. Checkouts of a different branch, tag or commit are
+// switched, otherwise the branch is pulled.
+func (a GitAcquirer) update(dest string, policy ConflictPolicy) (*Conflict, error) {
 
 	var stdoutBuf, stderrBuf bytes.Buffer
-	var err error
 
-	// find out which branch is currently checked out
-	err = utils.ExecCommand(GitPath, []string{"branch", "--format", "%(refname:short)"},
-		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 2, false)
+	current, isWanted, err := a.currentCheckout(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
 
-	log.Logger.Debugf("Stdout=%s", stdoutBuf.String())
-	log.Logger.Debugf("Stderr=%s", stderrBuf.String())
+	if !isWanted {
+		return a.switchCheckout(dest, current, policy)
+	}
 
+	if a.revision != "" {
+		log.Logger.Debugf("Locked revision '%s' already checked out into local cache at '%s'",
+			a.revision, dest)
+		return nil, nil
+	}
+
+	log.Logger.Debugf("Branch '%s' already checked out into local cache at '%s'. Will "+
+		"update it...", current, dest)
+
+	// record the current commit so we can roll back if the update can't be verified
+	err = utils.ExecCommand(GitPath, []string{"rev-parse", "HEAD"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
-	localBranch := strings.TrimSpace(stdoutBuf.String())
+	previousHead := strings.TrimSpace(stdoutBuf.String())
 
-	detachedHead := fmt.Sprintf("(HEAD detached at %s)", a.branch)
+	pullRemote := RemoteName
 
-	if localBranch == a.branch || localBranch == detachedHead {
-		log.Logger.Debugf("Branch '%s' already checked out into local cache at '%s'. Will "+
-			"update it...", localBranch, dest)
-	} else {
-		// todo - work out if there's anything we can do to help (a flag to force overwriting,
-		// or a flag to just go ahead and switch? We could do a 'git status' and ignore the
-		// different branches if there are no modified files, etc.)
-		return errors.New(fmt.Sprintf("Error updating the cache. The path "+
-			"at '%s' already contains the branch '%s', but we need to populate it with "+
-			"the branch '%s'. Aborting to prevent losing work.",
-			dest, localBranch, a.branch))
+	if gitMirrorsEnabled() {
+		pullRemote, err = syncGitMirror(a.uri)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		err = a.fetchFromMirror(dest, pullRemote)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 	}
 
-	err = utils.ExecCommand(GitPath, []string{"pull", "origin", a.branch},
+	err = utils.ExecCommand(GitPath, []string{"pull", pullRemote, a.branch},
 		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 90, false)
 
 	log.Logger.Debugf("Stdout=%s", stdoutBuf.String())
 	log.Logger.Debugf("Stderr=%s", stderrBuf.String())
 
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
+	}
+
+	err = a.verify(dest)
+	if err != nil {
+		log.Logger.Warnf("Rolling back '%s' to %s because the update couldn't be verified",
+			dest, previousHead)
+
+		// --keep preserves any local modifications
+		resetErr := utils.ExecCommand(GitPath, []string{"reset", "--keep", previousHead},
+			map[string]string{}, &stdoutBuf, &stderrBuf, dest, 30, false)
+		if resetErr != nil {
+			log.Logger.Warnf("Failed to roll back '%s': %s", dest, resetErr)
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	return nil, nil
+}
+
+// Returns the branch checked out in `dest` (or the commit if HEAD is detached) and whether
+// it's the branch, tag or commit this acquirer should populate the checkout with
+func (a GitAcquirer) currentCheckout(dest string) (string, bool, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	head, err := a.Revision(dest)
+	if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+
+	err = utils.ExecCommand(GitPath, []string{"rev-parse", "--abbrev-ref", "HEAD"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+	if err != nil {
+		return "", false, errors.WithStack(err)
+	}
+
+	current := strings.TrimSpace(stdoutBuf.String())
+	detached := current == "HEAD"
+	if detached {
+		current = head
+	}
+
+	if a.revision != "" {
+		return current, head == a.revision, nil
+	}
+
+	if !detached {
+		return current, current == a.branch, nil
+	}
+
+	// a detached HEAD is wanted if it's the commit or tag the branch refers to
+	if len(a.branch) >= 7 && strings.HasPrefix(head, a.branch) {
+		return current, true, nil
+	}
+
+	err = utils.ExecCommand(GitPath, []string{"rev-parse", "--verify", "--quiet",
+		"refs/tags/" + a.branch + "^{commit}"}, map[string]string{}, &stdoutBuf, &stderrBuf,
+		dest, 5, false)
+	if err == nil && strings.TrimSpace(stdoutBuf.String()) == head {
+		return current, true, nil
+	}
+
+	return current, false, nil
+}
+
+// Switches a checkout from `current` to the branch, tag or commit this acquirer should populate
+// it with. Local modifications are dealt with according to the policy first.
+func (a GitAcquirer) switchCheckout(dest string, current string, policy ConflictPolicy) (*Conflict, error) {
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	wanted := a.branch
+	if a.revision != "" {
+		wanted = a.revision
+	}
+
+	modified, err := a.modifiedFiles(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var conflict *Conflict
+
+	if len(modified) > 0 {
+		conflict = &Conflict{
+			Dest:       dest,
+			Current:    current,
+			Wanted:     wanted,
+			Modified:   modified,
+			Resolution: policy,
+		}
+
+		err = a.resolveConflict(conflict)
+		if err != nil {
+			return conflict, errors.WithStack(err)
+		}
+
+		if policy == ConflictSkip {
+			return conflict, nil
+		}
+	}
+
+	log.Logger.Infof("Switching '%s' from '%s' to '%s'", dest, current, wanted)
+
+	err = a.fetch(dest)
+	if err != nil {
+		return conflict, errors.WithStack(err)
+	}
+
+	args := []string{"checkout", wanted}
+	if a.revision != "" {
+		args = []string{"checkout", "--detach", wanted}
+	}
+
+	err = utils.ExecCommand(GitPath, args, map[string]string{}, &stdoutBuf, &stderrBuf,
+		dest, 90, false)
+	if err != nil {
+		return conflict, errors.Wrapf(err, "Error checking out '%s' in '%s'", wanted, dest)
+	}
+
+	err = a.fastForward(dest)
+	if err == nil {
+		err = a.verify(dest)
+	}
+	if err != nil {
+		log.Logger.Warnf("Switching '%s' back to '%s' because '%s' couldn't be checked out",
+			dest, current, wanted)
+
+		resetErr := utils.ExecCommand(GitPath, []string{"checkout", current},
+			map[string]string{}, &stdoutBuf, &stderrBuf, dest, 30, false)
+		if resetErr != nil {
+			log.Logger.Warnf("Failed to switch '%s' back to '%s': %s", dest, current, resetErr)
+		}
+
+		return conflict, errors.WithStack(err)
+	}
+
+	return conflict, nil
+}
+
+// Deals with local modifications that prevent a checkout being switched according to the
+// conflict's resolution policy
+func (a GitAcquirer) resolveConflict(conflict *Conflict) error {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	switch conflict.Resolution {
+	case ConflictSkip:
+		log.Logger.Warnf("Leaving '%s' on '%s' instead of switching it to '%s' because it "+
+			"has local modifications", conflict.Dest, conflict.Current, conflict.Wanted)
+		return nil
+	case ConflictStash:
+		log.Logger.Infof("Stashing local modifications in '%s'", conflict.Dest)
+
+		// stashes are commits so need an identity, but they never leave the cache so use a
+		// fixed one in case none is configured
+		err := utils.ExecCommand(GitPath, []string{"-c", "user.name=sugarkube",
+			"-c", "user.email=sugarkube@localhost", "stash", "push", "--include-untracked",
+			"--message", fmt.Sprintf("sugarkube: switching from '%s' to '%s'",
+				conflict.Current, conflict.Wanted)}, map[string]string{}, &stdoutBuf,
+			&stderrBuf, conflict.Dest, 30, false)
+		if err != nil {
+			return errors.Wrapf(err, "Error stashing local modifications in '%s'", conflict.Dest)
+		}
+		return nil
+	case ConflictDiscard:
+		log.Logger.Warnf("Discarding local modifications in '%s' to: %s", conflict.Dest,
+			strings.Join(conflict.Modified, ", "))
+
+		for _, args := range [][]string{{"reset", "--hard", "HEAD"}, {"clean", "-fd"}} {
+			err := utils.ExecCommand(GitPath, args, map[string]string{}, &stdoutBuf, &stderrBuf,
+				conflict.Dest, 30, false)
+			if err != nil {
+				return errors.Wrapf(err, "Error discarding local modifications in '%s'",
+					conflict.Dest)
+			}
+		}
+		return nil
+	default:
+		return &ConflictError{Conflict: conflict}
+	}
+}
+
+// Returns a sorted list of the paths of modified and untracked files in a checkout
+func (a GitAcquirer) modifiedFiles(dest string) ([]string, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	err := utils.ExecCommand(GitPath, []string{"status", "--porcelain"}, map[string]string{},
+		&stdoutBuf, &stderrBuf, dest, 30, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	modified := make([]string, 0)
+
+	for _, line := range strings.Split(stdoutBuf.String(), "\n") {
+		// lines are formatted 'XY path' where X and Y are status codes
+		if len(line) > 3 {
+			modified = append(modified, line[3:])
+		}
+	}
+
+	sort.Strings(modified)
+
+	return modified, nil
+}
+
+// Fetches branches and tags from the shared mirror of the remote if mirrors are enabled,
+// otherwise from the remote itself
+func (a GitAcquirer) fetch(dest string) error {
+	if gitMirrorsEnabled() {
+		mirrorPath, err := syncGitMirror(a.uri)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return a.fetchFromMirror(dest, mirrorPath)
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	err := utils.ExecCommand(GitPath, []string{"fetch", "--tags", RemoteName},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 90, false)
+	if err != nil {
+		return errors.Wrapf(err, "Error fetching '%s' into '%s'", a.uri, dest)
 	}
 
 	return nil
 }
+
+// Fast-forwards the branch checked out in `dest` to its remote-tracking branch if it's behind.
+// Existing local branches aren't updated when they're checked out.
+func (a GitAcquirer) fastForward(dest string) error {
+	if a.revision != "" {
+		return nil
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	remoteBranch := fmt.Sprintf("refs/remotes/%s/%s", RemoteName, a.branch)
+
+	err := utils.ExecCommand(GitPath, []string{"rev-parse", "--verify", "--quiet", remoteBranch},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+	if err != nil {
+		// it's a tag or commit
+		return nil
+	}
+
+	err = utils.ExecCommand(GitPath, []string{"merge", "--ff-only", remoteBranch},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 90, false)
+	if err != nil {
+		return errors.Wrapf(err, "Error fast-forwarding '%s' in '%s'. The local branch may "+
+			"have diverged from the remote", a.branch, dest)
+	}
+
+	return nil
+}
+
+// Fetches branches and tags from the shared mirror of the remote into a checkout
+func (a GitAcquirer) fetchFromMirror(dest string, mirrorPath string) error {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	log.Logger.Debugf("Fetching '%s' into '%s' from mirror at '%s'", a.uri, dest, mirrorPath)
+
+	args := append([]string{"fetch", mirrorPath}, mirrorRefSpecs...)
+	err := utils.ExecCommand(GitPath, args, map[string]string{}, &stdoutBuf, &stderrBuf,
+		dest, 90, false)
+	if err != nil {
+		return errors.Wrapf(err, "Error fetching from mirror at '%s'", mirrorPath)
+	}
+
+	return nil
+}
+
+// Returns the SHA of the commit checked out in `dest`
+func (a GitAcquirer) Revision(dest string) (string, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	err := utils.ExecCommand(GitPath, []string{"rev-parse", "HEAD"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return strings.TrimSpace(stdoutBuf.String()), nil
+}
+
+// Returns the state of a source checked out into `dest`
+func (a GitAcquirer) Inspect(dest string) (*SourceState, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	current, isWanted, err := a.currentCheckout(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	revision, err := a.Revision(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	modified, err := a.modifiedFiles(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	state := &SourceState{
+		Wanted:   a.branch,
+		Current:  current,
+		Revision: revision,
+		IsWanted: isWanted,
+		Modified: modified,
+	}
+
+	if a.revision != "" {
+		state.Wanted = a.revision
+	}
+
+	state.Ahead, err = countUnpushedCommits(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = utils.ExecCommand(GitPath, []string{"log", "-1", "--format=%cI", "HEAD"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	state.CommitDate, err = time.Parse(time.RFC3339, strings.TrimSpace(stdoutBuf.String()))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	remoteBranch := fmt.Sprintf("refs/remotes/%s/%s", RemoteName, current)
+
+	err = utils.ExecCommand(GitPath, []string{"rev-list", "--count", "HEAD.." + remoteBranch},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 30, false)
+	if err == nil {
+		_, err = fmt.Sscanf(stdoutBuf.String(), "%d", &state.Behind)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return state, nil
+}
+
+// Verifies the checked out tag or commit is signed by a trusted key. Annotated tags pointing
+// at the checked out commit are verified using their own signature, otherwise the signature on
+// the commit is verified. The trusted keys must be in the local GnuPG keyring.
+func (a GitAcquirer) verify(dest string) error {
+	if len(a.trustedKeys) == 0 {
+		return nil
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	args := []string{"verify-commit", "--raw", "HEAD"}
+
+	err := utils.ExecCommand(GitPath, []string{"cat-file", "-t", "refs/tags/" + a.branch},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+	if err == nil && strings.TrimSpace(stdoutBuf.String()) == "tag" {
+		err = utils.ExecCommand(GitPath, []string{"rev-parse", "refs/tags/" + a.branch + "^{commit}",
+			"HEAD"}, map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+		commits := strings.Fields(stdoutBuf.String())
+		if err == nil && len(commits) == 2 && commits[0] == commits[1] {
+			args = []string{"verify-tag", "--raw", a.branch}
+		}
+	}
+
+	log.Logger.Infof("Verifying the signature on '%s' in '%s'", a.branch, dest)
+
+	// this fails if there's no signature or it can't be checked, in which case the status
+	// output won't contain any valid signatures
+	err = utils.ExecCommand(GitPath, args, map[string]string{}, &stdoutBuf, &stderrBuf,
+		dest, 30, false)
+	if err != nil {
+		log.Logger.Debugf("Signature verification failed: %s", err)
+	}
+
+	for _, fingerprint := range parseValidSignatures(stderrBuf.String()) {
+		if isTrustedKey(a.trustedKeys, fingerprint) {
+			log.Logger.Infof("'%s' is signed by trusted key %s", a.branch, fingerprint)
+			return nil
+		}
+	}
+
+	return errors.New(fmt.Sprintf("Refusing to cache '%s' from '%s' because it isn't signed "+
+		"by a trusted key (trusted keys: %s)", a.branch, a.uri, strings.Join(a.trustedKeys, ", ")))
+}
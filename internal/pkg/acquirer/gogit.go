@@ -0,0 +1,1036 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const GoGitAcquirerName = "go-git"
+
+const RemoteName = "origin"
+
+// refspecs fetched from the remote. We fetch tags explicitly so they can be resolved even if they
+// aren't reachable from any branch.
+var fetchRefSpecs = []gitconfig.RefSpec{
+	"+refs/heads/*:refs/remotes/origin/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+// Acquires git sources using a pure-go git implementation so the git binary isn't needed. It
+// parses URIs in exactly the same way as the GitAcquirer so the two are interchangeable.
+type GoGitAcquirer struct {
+	GitAcquirer
+}
+
+// Returns an instance. This allows us to build objects for testing instead of
+// directly instantiating objects in the acquirer factory.
+func newGoGitAcquirer(source structs.Source) (*GoGitAcquirer, error) {
+	gitAcquirer, err := newGitAcquirer(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &GoGitAcquirer{
+		GitAcquirer: *gitAcquirer,
+	}, nil
+}
+
+// Acquires kapps via go-git and saves them to `dest`.
+func (a GoGitAcquirer) acquire(dest string) error {
+	_, err := a.acquireWithPolicy(dest, ConflictFail)
+	return err
+}
+
+// Acquires kapps via go-git and saves them to `dest`. Local modifications that would be
+// overwritten by updating `dest` are dealt with according to the policy.
+func (a GoGitAcquirer) acquireWithPolicy(dest string, policy ConflictPolicy) (*Conflict, error) {
+
+	if _, err := os.Stat(dest); err != nil {
+		if os.IsNotExist(err) {
+			log.Logger.Debugf("Destination directory '%s' doesn't exist... will create it", dest)
+			return nil, a.clone(dest)
+		} else {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	log.Logger.Debugf("Destination directory '%s' already exists... will update it", dest)
+	return a.update(dest, policy)
+}
+
+// Returns the directory to sparsely check out, or an empty string if the whole repo should
+// be checked out
+func (a GoGitAcquirer) sparsePath() string {
+	return strings.Trim(a.path, "/")
+}
+
+// Initialises a repo, fetches from the remote and performs a sparse checkout of the path
+func (a GoGitAcquirer) clone(dest string) error {
+
+	log.Logger.Infof("Cloning git source '%s' into '%s' with go-git", a.uri, dest)
+
+	err := os.MkdirAll(dest, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating directory '%s'", dest)
+	}
+
+	repo, err := git.PlainInit(dest, false)
+	if err != nil {
+		return errors.Wrapf(err, "Error initialising git repo in '%s'", dest)
+	}
+
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: RemoteName,
+		URLs: []string{a.uri},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Error adding remote '%s' to repo in '%s'", a.uri, dest)
+	}
+
+	err = a.fetch(repo)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = a.enableSparseCheckout(repo, dest)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	hash, isBranch, err := a.resolve(repo)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = a.verify(repo, hash)
+	if err != nil {
+		// don't leave an untrusted repo in the cache
+		removeErr := os.RemoveAll(dest)
+		if removeErr != nil {
+			log.Logger.Warnf("Failed to remove untrusted repo at '%s': %s", dest, removeErr)
+		}
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Checking out '%s' (%s) into '%s'", a.branch, hash, dest)
+
+	return a.checkout(repo, dest, hash, isBranch, false)
+}
+
+// Updates a previously checked out source, switching it to a different branch, tag or commit
+// if necessary. go-git can't merge, so local modifications are dealt with according to the
+// policy whenever the checked out commit changes.
+func (a GoGitAcquirer) update(dest string, policy ConflictPolicy) (*Conflict, error) {
+
+	repo, err := git.PlainOpen(dest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening git repo in '%s'", dest)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading HEAD of repo in '%s'", dest)
+	}
+
+	err = a.fetch(repo)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	hash, isBranch, err := a.resolve(repo)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	current := head.Hash().String()
+	if head.Name().IsBranch() {
+		current = head.Name().Short()
+	}
+
+	wanted := a.branch
+	if a.revision != "" {
+		wanted = a.revision
+	}
+
+	modified, err := a.modifiedFiles(repo, dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var conflict *Conflict
+	force := false
+
+	if len(modified) > 0 && head.Hash() != hash {
+		conflict = &Conflict{
+			Dest:       dest,
+			Current:    current,
+			Wanted:     wanted,
+			Modified:   modified,
+			Resolution: policy,
+		}
+
+		switch policy {
+		case ConflictSkip:
+			log.Logger.Warnf("Leaving '%s' on '%s' instead of updating it to '%s' because it "+
+				"has local modifications", dest, current, wanted)
+			return conflict, nil
+		case ConflictDiscard:
+			log.Logger.Warnf("Discarding local modifications in '%s' to: %s", dest,
+				strings.Join(modified, ", "))
+			force = true
+		case ConflictStash:
+			return conflict, errors.New(fmt.Sprintf("Can't stash local modifications in "+
+				"'%s' because go-git doesn't support stashing. Use the '%s' acquirer instead.",
+				dest, GitAcquirerName))
+		default:
+			return conflict, &ConflictError{Conflict: conflict}
+		}
+	}
+
+	if isBranch {
+		err = a.checkNotDiverged(repo, dest, hash)
+		if err != nil {
+			return conflict, errors.WithStack(err)
+		}
+	}
+
+	err = a.verify(repo, hash)
+	if err != nil {
+		return conflict, errors.WithStack(err)
+	}
+
+	if current != a.branch && head.Hash() != hash {
+		log.Logger.Infof("Switching '%s' from '%s' to '%s' (%s)", dest, current, wanted, hash)
+	} else {
+		log.Logger.Debugf("'%s' already checked out into local cache at '%s'. Will "+
+			"update it to %s...", a.branch, dest, hash)
+	}
+
+	return conflict, a.checkout(repo, dest, hash, isBranch, force)
+}
+
+// Returns an error if the local branch exists but checking out the given commit would discard
+// commits on it
+func (a GoGitAcquirer) checkNotDiverged(repo *git.Repository, dest string, hash plumbing.Hash) error {
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(a.branch), true)
+	if err != nil || ref.Hash() == hash {
+		return nil
+	}
+
+	localCommit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	remoteCommit, err := repo.CommitObject(hash)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	isAncestor, err := localCommit.IsAncestor(remoteCommit)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !isAncestor {
+		return errors.New(fmt.Sprintf("Error updating the cache. The branch '%s' "+
+			"at '%s' has diverged from '%s'. Aborting to prevent losing work.",
+			a.branch, dest, a.uri))
+	}
+
+	return nil
+}
+
+// Returns the state of a source checked out into `dest`. Untracked files aren't reported.
+func (a GoGitAcquirer) Inspect(dest string) (*SourceState, error) {
+	repo, err := git.PlainOpen(dest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening git repo in '%s'", dest)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading HEAD of repo in '%s'", dest)
+	}
+
+	modified, err := a.modifiedFiles(repo, dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	state := &SourceState{
+		Wanted:   a.branch,
+		Current:  head.Hash().String(),
+		Revision: head.Hash().String(),
+		Modified: modified,
+	}
+
+	if a.revision != "" {
+		state.Wanted = a.revision
+	}
+
+	// resolving uses the refs from the last fetch
+	hash, isBranch, err := a.resolve(repo)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if head.Name().IsBranch() {
+		state.Current = head.Name().Short()
+		state.IsWanted = a.revision == "" && isBranch && state.Current == a.branch
+	} else {
+		state.IsWanted = head.Hash() == hash
+	}
+
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	state.CommitDate = headCommit.Committer.When
+
+	ref, err := repo.Reference(plumbing.NewRemoteReferenceName(RemoteName, state.Current), true)
+	if err != nil {
+		// there's no remote branch to compare with so everything is unpushed unless it's the
+		// wanted tag or commit
+		if !state.IsWanted {
+			remoteCommit, err := repo.CommitObject(hash)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			state.Ahead, err = countExclusiveCommits(headCommit, remoteCommit)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		return state, nil
+	}
+
+	remoteCommit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	state.Ahead, err = countExclusiveCommits(headCommit, remoteCommit)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	state.Behind, err = countExclusiveCommits(remoteCommit, headCommit)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return state, nil
+}
+
+// Counts the commits reachable from `from` that aren't reachable from any of `to`. History is
+// only walked once from each side.
+func countExclusiveCommits(from *object.Commit, to ...*object.Commit) (int, error) {
+	reachable := map[plumbing.Hash]bool{}
+	err := walkCommits(to, func(commit *object.Commit) bool {
+		reachable[commit.Hash] = true
+		return true
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	count := 0
+	err = walkCommits([]*object.Commit{from}, func(commit *object.Commit) bool {
+		// everything behind a commit reachable from `to` is reachable from it too
+		if reachable[commit.Hash] {
+			return false
+		}
+		count++
+		return true
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return count, nil
+}
+
+// Visits each commit reachable from the given commits once, breadth first. The parents of a
+// commit are only visited if `visit` returns true.
+func walkCommits(commits []*object.Commit, visit func(commit *object.Commit) bool) error {
+	seen := map[plumbing.Hash]bool{}
+	queue := append([]*object.Commit{}, commits...)
+
+	for len(queue) > 0 {
+		commit := queue[0]
+		queue = queue[1:]
+
+		if seen[commit.Hash] {
+			continue
+		}
+		seen[commit.Hash] = true
+
+		if !visit(commit) {
+			continue
+		}
+
+		err := commit.Parents().ForEach(func(parent *object.Commit) error {
+			if !seen[parent.Hash] {
+				queue = append(queue, parent)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Returns descriptions of local work in a checkout. Untracked files that aren't ignored are
+// reported too since they'd be lost if the checkout was removed.
+func (a GoGitAcquirer) LocalWork(dest string) ([]string, error) {
+	repo, err := git.PlainOpen(dest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error opening git repo in '%s'", dest)
+	}
+
+	modified, err := a.modifiedFiles(repo, dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	untracked, err := a.untrackedFiles(repo)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	modified = append(modified, untracked...)
+	sort.Strings(modified)
+
+	headResolved := true
+	unpushed, err := countUnpushedGoGitCommits(repo)
+	if err != nil {
+		if errors.Cause(err) != errUnresolvedHead {
+			return nil, errors.WithStack(err)
+		}
+		headResolved = false
+	}
+
+	stashes, err := countStashes(repo, dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return describeLocalWork(modified, headResolved, unpushed, stashes), nil
+}
+
+// Returns the number of commits reachable from HEAD that aren't on any remote-tracking branch,
+// which includes every commit if there's no remote-tracking branch. Returns `errUnresolvedHead`
+// if HEAD doesn't resolve to a commit but there are local branches.
+func countUnpushedGoGitCommits(repo *git.Repository) (int, error) {
+	head, err := repo.Head()
+	if err != nil {
+		if err != plumbing.ErrReferenceNotFound {
+			return 0, errors.WithStack(err)
+		}
+
+		branches, err := repo.Branches()
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+		defer branches.Close()
+
+		// there's nothing to push if nothing's been committed (e.g. if a clone was interrupted)
+		_, err = branches.Next()
+		if err == io.EOF {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		return 0, errUnresolvedHead
+	}
+
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	remoteCommits := make([]*object.Commit, 0)
+
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		// skip symbolic refs like 'origin/HEAD'
+		if !ref.Name().IsRemote() || ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		remoteCommit, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		remoteCommits = append(remoteCommits, remoteCommit)
+		return nil
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return countExclusiveCommits(headCommit, remoteCommits...)
+}
+
+// Returns the number of stash entries in a repo. go-git doesn't create stashes but they may have
+// been created with the git CLI. Since go-git doesn't read reflogs the log is read directly.
+func countStashes(repo *git.Repository, dest string) (int, error) {
+	_, err := repo.Reference(plumbing.ReferenceName("refs/stash"), false)
+	if err != nil {
+		if err == plumbing.ErrReferenceNotFound {
+			return 0, nil
+		}
+		return 0, errors.WithStack(err)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(dest, git.GitDirName, "logs", "refs", "stash"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 1, nil
+		}
+		return 0, errors.WithStack(err)
+	}
+
+	stashes := strings.Count(string(contents), "\n")
+	if stashes == 0 {
+		stashes = 1
+	}
+
+	return stashes, nil
+}
+
+// Returns a list of files under the source path that aren't in the index or ignored
+func (a GoGitAcquirer) untrackedFiles(repo *git.Repository) ([]string, error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tracked := map[string]bool{}
+	for _, entry := range idx.Entries {
+		tracked[entry.Name] = true
+	}
+
+	patterns, err := gitignore.ReadPatterns(worktree.Filesystem, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	matcher := gitignore.NewMatcher(patterns)
+
+	untracked := make([]string, 0)
+	root := worktree.Filesystem.Root()
+
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		name := filepath.ToSlash(relPath)
+		if name == git.GitDirName || matcher.Match(strings.Split(name, "/"), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.IsDir() && !tracked[name] && a.inSparsePath(name) {
+			untracked = append(untracked, name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return untracked, nil
+}
+
+// Fetches all branches and tags from the remote
+func (a GoGitAcquirer) fetch(repo *git.Repository) error {
+	log.Logger.Debugf("Fetching from remote '%s'", a.uri)
+
+	err := repo.Fetch(&git.FetchOptions{
+		RemoteName: RemoteName,
+		RefSpecs:   fetchRefSpecs,
+		Tags:       git.AllTags,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return errors.Wrapf(err, "Error fetching from '%s'", a.uri)
+	}
+
+	return nil
+}
+
+// Resolves the configured branch, tag or SHA to a commit hash. Also returns whether the
+// revision is a branch (in which case a local branch should be checked out) or not (in which
+// case HEAD will be detached).
+func (a GoGitAcquirer) resolve(repo *git.Repository) (plumbing.Hash, bool, error) {
+	if a.revision != "" {
+		commit, err := repo.CommitObject(plumbing.NewHash(a.revision))
+		if err != nil {
+			return plumbing.ZeroHash, false, errors.Wrapf(err, "Couldn't find locked "+
+				"revision '%s' in '%s'", a.revision, a.uri)
+		}
+		return commit.Hash, false, nil
+	}
+
+	remoteBranch := plumbing.NewRemoteReferenceName(RemoteName, a.branch)
+	ref, err := repo.Reference(remoteBranch, true)
+	if err == nil {
+		return ref.Hash(), true, nil
+	}
+
+	tag := plumbing.NewTagReferenceName(a.branch)
+	ref, err = repo.Reference(tag, true)
+	if err == nil {
+		// dereference annotated tags to the commit they point to
+		tagObject, err := repo.TagObject(ref.Hash())
+		if err == nil {
+			commit, err := tagObject.Commit()
+			if err != nil {
+				return plumbing.ZeroHash, false, errors.Wrapf(err,
+					"Error resolving tag '%s' to a commit", a.branch)
+			}
+			return commit.Hash, false, nil
+		}
+
+		return ref.Hash(), false, nil
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(a.branch))
+	if err != nil {
+		return plumbing.ZeroHash, false, errors.Wrapf(err, "Couldn't resolve '%s' to a "+
+			"branch, tag or commit in '%s'", a.branch, a.uri)
+	}
+
+	return *hash, false, nil
+}
+
+// Verifies the configured tag or the commit with the given hash is signed by a trusted key
+// before it's checked out. Annotated tags are verified using their own signature.
+func (a GoGitAcquirer) verify(repo *git.Repository, hash plumbing.Hash) error {
+	if len(a.trustedKeys) == 0 {
+		return nil
+	}
+
+	keyRing, err := exportPublicKeys(a.trustedKeys)
+	if err != nil {
+		return errors.Wrapf(err, "Error loading trusted keys to verify '%s'", a.uri)
+	}
+
+	log.Logger.Infof("Verifying the signature on '%s' from '%s'", a.branch, a.uri)
+
+	var entity *openpgp.Entity
+
+	if tagObject := annotatedTag(repo, a.branch, hash); tagObject != nil {
+		entity, err = tagObject.Verify(keyRing)
+	} else {
+		commit, commitErr := repo.CommitObject(hash)
+		if commitErr != nil {
+			return errors.WithStack(commitErr)
+		}
+		entity, err = commit.Verify(keyRing)
+	}
+
+	if err == nil && entity != nil {
+		for _, fingerprint := range entityFingerprints(entity) {
+			if isTrustedKey(a.trustedKeys, fingerprint) {
+				log.Logger.Infof("'%s' is signed by trusted key %s", a.branch, fingerprint)
+				return nil
+			}
+		}
+	}
+
+	if err != nil {
+		log.Logger.Debugf("Signature verification failed: %s", err)
+	}
+
+	return errors.New(fmt.Sprintf("Refusing to cache '%s' from '%s' because it isn't signed "+
+		"by a trusted key (trusted keys: %s)", a.branch, a.uri, strings.Join(a.trustedKeys, ", ")))
+}
+
+// Returns the annotated tag with the given name if it points to the given commit, or nil if
+// there isn't one
+func annotatedTag(repo *git.Repository, name string, hash plumbing.Hash) *object.Tag {
+	ref, err := repo.Reference(plumbing.NewTagReferenceName(name), true)
+	if err != nil {
+		return nil
+	}
+
+	tagObject, err := repo.TagObject(ref.Hash())
+	if err != nil {
+		return nil
+	}
+
+	commit, err := tagObject.Commit()
+	if err != nil || commit.Hash != hash {
+		return nil
+	}
+
+	return tagObject
+}
+
+// Returns the SHA of the commit checked out in `dest`
+func (a GoGitAcquirer) Revision(dest string) (string, error) {
+	repo, err := git.PlainOpen(dest)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error opening git repo in '%s'", dest)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", errors.Wrapf(err, "Error reading HEAD of repo in '%s'", dest)
+	}
+
+	return head.Hash().String(), nil
+}
+
+// Returns the hex-encoded fingerprints of an entity's primary key and subkeys
+func entityFingerprints(entity *openpgp.Entity) []string {
+	fingerprints := []string{hex.EncodeToString(entity.PrimaryKey.Fingerprint)}
+
+	for _, subkey := range entity.Subkeys {
+		fingerprints = append(fingerprints, hex.EncodeToString(subkey.PublicKey.Fingerprint))
+	}
+
+	return fingerprints
+}
+
+// Checks out a commit. If `isBranch` is true, a local branch tracking the remote branch will be
+// created or fast-forwarded and checked out, otherwise HEAD will be detached. If `force` is
+// true, every file is rewritten which discards any local modifications.
+func (a GoGitAcquirer) checkout(repo *git.Repository, dest string, hash plumbing.Hash, isBranch bool,
+	force bool) error {
+	err := a.checkoutTree(repo, dest, hash, force)
+	if err != nil {
+		return errors.Wrapf(err, "Error checking out '%s' from '%s'", a.branch, a.uri)
+	}
+
+	if !isBranch {
+		return repo.Storer.SetReference(plumbing.NewHashReference(plumbing.HEAD, hash))
+	}
+
+	branch := plumbing.NewBranchReferenceName(a.branch)
+
+	err = repo.Storer.SetReference(plumbing.NewHashReference(branch, hash))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// make the branch track the remote so users can work with the cache using the git CLI
+	err = repo.CreateBranch(&gitconfig.Branch{
+		Name:   a.branch,
+		Remote: RemoteName,
+		Merge:  branch,
+	})
+	if err != nil && err != git.ErrBranchExists {
+		return errors.WithStack(err)
+	}
+
+	return repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch))
+}
+
+// Writes files under the source path from the given commit into the working tree and rebuilds
+// the index. Entries outside the path are flagged as skip-worktree like a sparse checkout by
+// the git CLI. Files that were checked out previously but that no longer exist are removed.
+// Unchanged files are only rewritten if `force` is true.
+func (a GoGitAcquirer) checkoutTree(repo *git.Repository, dest string, hash plumbing.Hash, force bool) error {
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	previousIndex, err := repo.Storer.Index()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	previousHashes := make(map[string]plumbing.Hash, len(previousIndex.Entries))
+	for _, entry := range previousIndex.Entries {
+		if !entry.SkipWorktree {
+			previousHashes[entry.Name] = entry.Hash
+		}
+	}
+
+	newIndex := &index.Index{Version: 2}
+	checkedOut := map[string]bool{}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, treeEntry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if treeEntry.Mode == filemode.Dir || treeEntry.Mode == filemode.Submodule {
+			continue
+		}
+
+		indexEntry := &index.Entry{
+			Name: name,
+			Hash: treeEntry.Hash,
+			Mode: treeEntry.Mode,
+		}
+		newIndex.Entries = append(newIndex.Entries, indexEntry)
+
+		if !a.inSparsePath(name) {
+			indexEntry.SkipWorktree = true
+			newIndex.Version = 3
+			continue
+		}
+
+		checkedOut[name] = true
+		path := filepath.Join(dest, filepath.FromSlash(name))
+
+		if previousHash, ok := previousHashes[name]; force || !ok || previousHash != treeEntry.Hash {
+			err = writeBlob(repo, path, &treeEntry)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		indexEntry.ModifiedAt = info.ModTime()
+		indexEntry.Size = uint32(info.Size())
+	}
+
+	for name := range previousHashes {
+		if checkedOut[name] {
+			continue
+		}
+
+		log.Logger.Debugf("Removing file '%s' that no longer exists in '%s'", name, a.branch)
+		err = removeFileAndEmptyParents(dest, name)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return repo.Storer.SetIndex(newIndex)
+}
+
+// Returns whether a path in the repo is under the source path
+func (a GoGitAcquirer) inSparsePath(name string) bool {
+	sparsePath := a.sparsePath()
+	return sparsePath == "" || name == sparsePath || strings.HasPrefix(name, sparsePath+"/")
+}
+
+// Writes a blob to the given path, creating parent directories as necessary
+func writeBlob(repo *git.Repository, path string, treeEntry *object.TreeEntry) error {
+	blob, err := repo.BlobObject(treeEntry.Hash)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer reader.Close()
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	if treeEntry.Mode == filemode.Symlink {
+		target, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return os.Symlink(string(target), path)
+	}
+
+	perm := os.FileMode(0644)
+	if treeEntry.Mode == filemode.Executable {
+		perm = 0755
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, reader)
+	return errors.WithStack(err)
+}
+
+// Removes a file from a working tree and any parent directories left empty
+func removeFileAndEmptyParents(root string, name string) error {
+	path := filepath.Join(root, filepath.FromSlash(name))
+
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		// this fails if the directory isn't empty which is what we want
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Configures sparse checkouts the same way the git CLI would so caches can be used with either
+// acquirer and with the git CLI
+func (a GoGitAcquirer) enableSparseCheckout(repo *git.Repository, dest string) error {
+	sparsePath := a.sparsePath()
+	if sparsePath == "" {
+		return nil
+	}
+
+	repoConfig, err := repo.Config()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	repoConfig.Raw.Section("core").SetOption("sparsecheckout", "true")
+
+	err = repo.SetConfig(repoConfig)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	infoDir := filepath.Join(dest, ".git", "info")
+	err = os.MkdirAll(infoDir, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return utils.AppendToFile(filepath.Join(infoDir, "sparse-checkout"),
+		fmt.Sprintf("%s/*\n", sparsePath))
+}
+
+// Returns a sorted list of tracked files under the source path whose contents differ from
+// the index, or that have been deleted
+func (a GoGitAcquirer) modifiedFiles(repo *git.Repository, dest string) ([]string, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	modified := make([]string, 0)
+
+	for _, entry := range idx.Entries {
+		if entry.SkipWorktree || !a.inSparsePath(entry.Name) {
+			continue
+		}
+
+		path := filepath.Join(dest, filepath.FromSlash(entry.Name))
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				modified = append(modified, entry.Name)
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		var contents []byte
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			contents = []byte(target)
+		} else {
+			contents, err = ioutil.ReadFile(path)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		if plumbing.ComputeHash(plumbing.BlobObject, contents) != entry.Hash {
+			modified = append(modified, entry.Name)
+		}
+	}
+
+	sort.Strings(modified)
+
+	return modified, nil
+}
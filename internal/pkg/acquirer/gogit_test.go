@@ -0,0 +1,440 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"fmt"
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A local git repo with a bare remote that sources can be acquired from over file://
+type testRemote struct {
+	workDir  string
+	bareDir  string
+	repo     *git.Repository
+	worktree *git.Worktree
+}
+
+// Creates a working repo and a bare repo to push to. Returns a function to clean them up
+func newTestRemote(t *testing.T) (*testRemote, func()) {
+	rootDir, err := ioutil.TempDir("", "gogit-")
+	assert.Nil(t, err)
+
+	workDir := filepath.Join(rootDir, "work")
+	bareDir := filepath.Join(rootDir, "kapps.git")
+
+	repo, err := git.PlainInit(workDir, false)
+	assert.Nil(t, err)
+
+	_, err = git.PlainInit(bareDir, true)
+	assert.Nil(t, err)
+
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{
+		Name: RemoteName,
+		URLs: []string{"file://" + bareDir},
+	})
+	assert.Nil(t, err)
+
+	worktree, err := repo.Worktree()
+	assert.Nil(t, err)
+
+	return &testRemote{
+		workDir:  workDir,
+		bareDir:  bareDir,
+		repo:     repo,
+		worktree: worktree,
+	}, func() {
+		os.RemoveAll(rootDir)
+	}
+}
+
+// Writes files into the working repo, commits them and pushes all branches and tags
+func (r *testRemote) commit(t *testing.T, files map[string]string) plumbing.Hash {
+	for path, contents := range files {
+		fullPath := filepath.Join(r.workDir, path)
+		assert.Nil(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		assert.Nil(t, ioutil.WriteFile(fullPath, []byte(contents), 0644))
+		_, err := r.worktree.Add(path)
+		assert.Nil(t, err)
+	}
+
+	hash, err := r.worktree.Commit("test commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	assert.Nil(t, err)
+
+	r.push(t)
+
+	return hash
+}
+
+// Pushes all branches and tags to the bare repo
+func (r *testRemote) push(t *testing.T) {
+	err := r.repo.Push(&git.PushOptions{
+		RemoteName: RemoteName,
+		RefSpecs:   []gitconfig.RefSpec{"+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*"},
+	})
+	if err != git.NoErrAlreadyUpToDate {
+		assert.Nil(t, err)
+	}
+}
+
+// Returns a go-git acquirer for the given path and branch in the bare repo
+func (r *testRemote) acquirer(t *testing.T, path string, branch string) Acquirer {
+	acquirerObj, err := New(structs.Source{
+		Uri: fmt.Sprintf("file://%s//%s#%s", r.bareDir, path, branch),
+		Options: map[string]interface{}{
+			AcquirerKey: GoGitAcquirerName,
+		},
+	})
+	assert.Nil(t, err)
+	assert.IsType(t, &GoGitAcquirer{}, acquirerObj)
+
+	return acquirerObj
+}
+
+func TestNewAcquirerGoGit(t *testing.T) {
+	var expectedAcquirer = &GoGitAcquirer{
+		GitAcquirer: GitAcquirer{
+			id:     "tiller",
+			uri:    "git@github.com:sugarkube/kapps.git",
+			branch: "master",
+			path:   "incubator/tiller",
+		},
+	}
+
+	actual, err := New(structs.Source{
+		Uri: GoodGitUri,
+		Options: map[string]interface{}{
+			AcquirerKey: GoGitAcquirerName,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, expectedAcquirer, actual)
+
+	_, err = New(structs.Source{
+		Uri: GoodGitUri,
+		Options: map[string]interface{}{
+			AcquirerKey: "banana",
+		},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestGoGitAcquireSparseBranch(t *testing.T) {
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+		"kapps/other/sugarkube.yaml":     "version: 1",
+		"README.md":                      "readme",
+	})
+
+	dest, err := ioutil.TempDir("", "gogit-dest-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dest)
+	dest = filepath.Join(dest, "source")
+
+	acquirerObj := remote.acquirer(t, "kapps/wordpress", "master")
+	assert.Nil(t, Acquire(acquirerObj, dest))
+
+	assert.FileExists(t, filepath.Join(dest, "kapps/wordpress/sugarkube.yaml"))
+	assert.NoFileExists(t, filepath.Join(dest, "kapps/other/sugarkube.yaml"))
+	assert.NoFileExists(t, filepath.Join(dest, "README.md"))
+
+	repo, err := git.PlainOpen(dest)
+	assert.Nil(t, err)
+	head, err := repo.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, "refs/heads/master", head.Name().String())
+
+	// new commits should be pulled in when updating
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 2",
+	})
+	assert.Nil(t, Acquire(acquirerObj, dest))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dest, "kapps/wordpress/sugarkube.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "version: 2", string(contents))
+	assert.NoFileExists(t, filepath.Join(dest, "README.md"))
+}
+
+func TestGoGitAcquireTagsAndShas(t *testing.T) {
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	firstHash := remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+	})
+
+	_, err := remote.repo.CreateTag("v1.0.0", firstHash, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		Message: "annotated tag",
+	})
+	assert.Nil(t, err)
+
+	secondHash := remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 2",
+	})
+
+	_, err = remote.repo.CreateTag("v2.0.0", secondHash, nil)
+	assert.Nil(t, err)
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 3",
+	})
+
+	tests := []struct {
+		name     string
+		branch   string
+		expected string
+	}{
+		{
+			name:     "annotated_tag",
+			branch:   "v1.0.0",
+			expected: "version: 1",
+		},
+		{
+			name:     "lightweight_tag",
+			branch:   "v2.0.0",
+			expected: "version: 2",
+		},
+		{
+			name:     "sha",
+			branch:   firstHash.String(),
+			expected: "version: 1",
+		},
+	}
+
+	for _, test := range tests {
+		dest, err := ioutil.TempDir("", "gogit-dest-")
+		assert.Nil(t, err)
+		defer os.RemoveAll(dest)
+		dest = filepath.Join(dest, "source")
+
+		acquirerObj := remote.acquirer(t, "kapps/wordpress", test.branch)
+		assert.Nil(t, Acquire(acquirerObj, dest), "Error acquiring in test '%s'", test.name)
+
+		contents, err := ioutil.ReadFile(filepath.Join(dest, "kapps/wordpress/sugarkube.yaml"))
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, string(contents), "Unexpected contents in test '%s'", test.name)
+
+		repo, err := git.PlainOpen(dest)
+		assert.Nil(t, err)
+		head, err := repo.Head()
+		assert.Nil(t, err)
+		assert.Equal(t, plumbing.HEAD, head.Name(), "HEAD should be detached in test '%s'", test.name)
+
+		// updating should be a no-op
+		assert.Nil(t, Acquire(acquirerObj, dest), "Error updating in test '%s'", test.name)
+	}
+}
+
+func TestGoGitUpdateLocalModifications(t *testing.T) {
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+		"kapps/other/sugarkube.yaml":     "version: 1",
+	})
+
+	dest, err := ioutil.TempDir("", "gogit-dest-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dest)
+	dest = filepath.Join(dest, "source")
+
+	acquirerObj := remote.acquirer(t, "kapps/wordpress", "master")
+	assert.Nil(t, Acquire(acquirerObj, dest))
+
+	// files outside the sparse path shouldn't be reported as deleted
+	repo, err := git.PlainOpen(dest)
+	assert.Nil(t, err)
+	modified, err := acquirerObj.(*GoGitAcquirer).modifiedFiles(repo, dest)
+	assert.Nil(t, err)
+	assert.Empty(t, modified)
+
+	path := filepath.Join(dest, "kapps/wordpress/sugarkube.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("version: local"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dest, "kapps/wordpress/new.txt"), []byte("new"), 0644))
+
+	modified, err = acquirerObj.(*GoGitAcquirer).modifiedFiles(repo, dest)
+	assert.Nil(t, err)
+	// untracked files don't count, just like with `git pull`
+	assert.Equal(t, []string{"kapps/wordpress/sugarkube.yaml"}, modified)
+
+	// there's nothing to overwrite if the remote hasn't changed
+	assert.Nil(t, Acquire(acquirerObj, dest))
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 2",
+	})
+	remote.push(t)
+
+	// updating must fail rather than lose work
+	err = Acquire(acquirerObj, dest)
+	assert.NotNil(t, err)
+	assert.IsType(t, &ConflictError{}, errors.Cause(err))
+
+	contents, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "version: local", string(contents))
+}
+
+func TestGoGitUpdateDifferentBranch(t *testing.T) {
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	hash := remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+	})
+
+	err := remote.repo.Storer.SetReference(plumbing.NewHashReference(
+		plumbing.NewBranchReferenceName("feature"), hash))
+	assert.Nil(t, err)
+	remote.push(t)
+
+	dest, err := ioutil.TempDir("", "gogit-dest-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dest)
+	dest = filepath.Join(dest, "source")
+
+	assert.Nil(t, Acquire(remote.acquirer(t, "kapps/wordpress", "master"), dest))
+
+	// clean checkouts are switched to the new branch
+	assert.Nil(t, Acquire(remote.acquirer(t, "kapps/wordpress", "feature"), dest))
+
+	repo, err := git.PlainOpen(dest)
+	assert.Nil(t, err)
+	head, err := repo.Head()
+	assert.Nil(t, err)
+	assert.Equal(t, "feature", head.Name().Short())
+}
+
+func TestGoGitLockedRevision(t *testing.T) {
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	firstHash := remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+	})
+	secondHash := remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 2",
+	})
+
+	dest, err := ioutil.TempDir("", "gogit-dest-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dest)
+	dest = filepath.Join(dest, "source")
+
+	for _, hash := range []plumbing.Hash{firstHash, secondHash, firstHash} {
+		acquirerObj, err := New(structs.Source{
+			Uri: fmt.Sprintf("file://%s//kapps/wordpress#master", remote.bareDir),
+			Options: map[string]interface{}{
+				AcquirerKey: GoGitAcquirerName,
+				RevisionKey: hash.String(),
+			},
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, Acquire(acquirerObj, dest))
+
+		revision, err := acquirerObj.(Lockable).Revision(dest)
+		assert.Nil(t, err)
+		assert.Equal(t, hash.String(), revision)
+	}
+
+	contents, err := ioutil.ReadFile(filepath.Join(dest, "kapps/wordpress/sugarkube.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "version: 1", string(contents))
+
+	// unknown revisions can't be acquired
+	acquirerObj, err := New(structs.Source{
+		Uri: fmt.Sprintf("file://%s//kapps/wordpress#master", remote.bareDir),
+		Options: map[string]interface{}{
+			AcquirerKey: GoGitAcquirerName,
+			RevisionKey: strings.Repeat("a", 40),
+		},
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, Acquire(acquirerObj, dest+"-missing"))
+}
+
+func TestCountExclusiveCommits(t *testing.T) {
+	storer := memory.NewStorage()
+
+	// stores a commit with the given parents and returns it
+	commit := func(message string, parents ...*object.Commit) *object.Commit {
+		obj := &object.Commit{
+			Message:   message,
+			Author:    object.Signature{Name: "test", When: time.Unix(0, 0)},
+			Committer: object.Signature{Name: "test", When: time.Unix(0, 0)},
+		}
+		for _, parent := range parents {
+			obj.ParentHashes = append(obj.ParentHashes, parent.Hash)
+		}
+
+		encoded := storer.NewEncodedObject()
+		assert.Nil(t, obj.Encode(encoded))
+		hash, err := storer.SetEncodedObject(encoded)
+		assert.Nil(t, err)
+
+		stored, err := object.GetCommit(storer, hash)
+		assert.Nil(t, err)
+		return stored
+	}
+
+	// a <- b <- c and a <- d <- e, merged into m
+	a := commit("a")
+	b := commit("b", a)
+	c := commit("c", b)
+	d := commit("d", a)
+	e := commit("e", d)
+	m := commit("m", e, c)
+
+	tests := []struct {
+		from     *object.Commit
+		to       []*object.Commit
+		expected int
+	}{
+		{from: m, to: []*object.Commit{c}, expected: 3},
+		{from: c, to: []*object.Commit{m}, expected: 0},
+		{from: m, to: []*object.Commit{b, d}, expected: 3},
+		{from: m, expected: 6},
+		{from: e, to: []*object.Commit{c}, expected: 2},
+	}
+
+	for _, test := range tests {
+		count, err := countExclusiveCommits(test.from, test.to...)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, count, "Unexpected count from '%s'", test.from.Message)
+	}
+}
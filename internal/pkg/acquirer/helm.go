@@ -0,0 +1,316 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"fmt"
+	"github.com/Masterminds/semver"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// charts are fetched from chart repos over HTTPS
+const HelmProtocol = "helm://"
+
+// for chart repos that are only served over plain HTTP
+const HelmHttpProtocol = "helm+http://"
+
+// source option/URI query parameter containing a chart version constraint
+const VersionKey = "version"
+
+const helmIndexFile = "index.yaml"
+
+// Acquires charts from helm chart repositories. The chart version is resolved from the
+// repo's index when acquiring, and the chart archive is then downloaded, verified and
+// extracted like any other archive.
+type HelmAcquirer struct {
+	id       string
+	repoUri  string
+	chart    string
+	version  string
+	revision string // if set, the chart version with this digest will be acquired
+}
+
+// The parts of a chart repo index that we need
+type helmIndex struct {
+	Entries map[string][]helmChartVersion `yaml:"entries"`
+}
+
+type helmChartVersion struct {
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version"`
+	Urls    []string `yaml:"urls"`
+	Digest  string   `yaml:"digest"`
+}
+
+// Returns whether a URI refers to a chart in a helm chart repo
+func isHelmUri(uri string) bool {
+	return strings.HasPrefix(uri, HelmProtocol) || strings.HasPrefix(uri, HelmHttpProtocol)
+}
+
+// Returns an instance. This allows us to build objects for testing instead of
+// directly instantiating objects in the acquirer factory.
+func newHelmAcquirer(source structs.Source) (*HelmAcquirer, error) {
+
+	uri := strings.TrimSpace(source.Uri)
+
+	scheme := "https"
+	if strings.HasPrefix(uri, HelmHttpProtocol) {
+		scheme = "http"
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing helm URI '%s'", uri)
+	}
+
+	chartPath := strings.Trim(parsed.Path, "/")
+	chart := path.Base(chartPath)
+
+	if parsed.Host == "" || chartPath == "" {
+		return nil, errors.New(fmt.Sprintf("Invalid helm URI '%s'. Expected a URI "+
+			"like '%scharts.example.com/chart-name?%s=~1.2'", uri, HelmProtocol, VersionKey))
+	}
+
+	repoUri := url.URL{
+		Scheme: scheme,
+		Host:   parsed.Host,
+		Path:   path.Dir("/" + chartPath),
+	}
+
+	version := parsed.Query().Get(VersionKey)
+
+	if optionValue, ok := source.Options[VersionKey]; ok {
+		version, ok = optionValue.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("The '%s' option for source '%s' must be a string",
+				VersionKey, uri))
+		}
+	}
+
+	version = strings.TrimSpace(version)
+	if version != "" {
+		_, err = semver.NewConstraint(version)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid version constraint '%s' for source '%s'",
+				version, uri)
+		}
+	}
+
+	revision, err := revisionFromOptions(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	id := source.Id
+
+	if id == "" {
+		id = chart
+	}
+
+	return &HelmAcquirer{
+		id:       id,
+		repoUri:  strings.TrimSuffix(repoUri.String(), "/"),
+		chart:    chart,
+		version:  version,
+		revision: strings.ToLower(revision),
+	}, nil
+}
+
+// Generate an ID based on the chart repo and ID. This doesn't contain the version for the
+// same reason git IDs don't contain the branch.
+func (a HelmAcquirer) FullyQualifiedId() (string, error) {
+	parsed, err := url.Parse(a.repoUri)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error parsing URI '%s'", a.repoUri)
+	}
+
+	hyphenatedRepo := strings.Replace(strings.Trim(parsed.Host+parsed.Path, "/"), "/", "-", -1)
+
+	if a.id != "" {
+		hyphenatedName := strings.Replace(a.id, "/", "-", -1)
+		return strings.Join([]string{hyphenatedRepo, hyphenatedName}, "-"), nil
+	} else {
+		return hyphenatedRepo, nil
+	}
+}
+
+// Return the ID. This is used as a subcomponent of a fully-qualified ID and can be explicitly configured in config
+func (a HelmAcquirer) Id() string {
+	return a.id
+}
+
+// return the path to the chart in the extracted archive
+func (a HelmAcquirer) Path() string {
+	return a.chart
+}
+
+// return the uri
+func (a HelmAcquirer) Uri() string {
+	protocol := HelmProtocol
+	if strings.HasPrefix(a.repoUri, "http://") {
+		protocol = HelmHttpProtocol
+	}
+
+	uri := protocol + strings.TrimPrefix(strings.TrimPrefix(a.repoUri, "https://"), "http://") +
+		"/" + a.chart
+
+	if a.version != "" {
+		uri = fmt.Sprintf("%s?%s=%s", uri, VersionKey, url.QueryEscape(a.version))
+	}
+
+	return uri
+}
+
+// Returns the digest of the chart archive extracted into `dest`
+func (a HelmAcquirer) Revision(dest string) (string, error) {
+	return extractedDigest(dest)
+}
+
+// Resolves the chart version from the repo's index then downloads and extracts it to `dest`
+func (a HelmAcquirer) acquire(dest string) error {
+	chartVersion, err := a.resolve()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Infof("Resolved chart '%s' version '%s' to %s", a.chart, a.version,
+		chartVersion.Version)
+
+	if len(chartVersion.Urls) == 0 {
+		return errors.New(fmt.Sprintf("No URLs for version %s of chart '%s' in repo '%s'",
+			chartVersion.Version, a.chart, a.repoUri))
+	}
+
+	if chartVersion.Digest == "" {
+		return errors.New(fmt.Sprintf("No digest for version %s of chart '%s' in repo '%s' "+
+			"so it can't be verified", chartVersion.Version, a.chart, a.repoUri))
+	}
+
+	// chart URLs may be relative to the repo
+	base, err := url.Parse(a.repoUri + "/")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	chartUrl, err := base.Parse(chartVersion.Urls[0])
+	if err != nil {
+		return errors.Wrapf(err, "Invalid URL for chart '%s'", a.chart)
+	}
+
+	// charts are always gzipped tarballs containing a directory named after the chart
+	archiveAcquirer := HttpAcquirer{
+		id:         a.id,
+		archiveUri: chartUrl.String(),
+		path:       a.chart,
+		format:     TarGzFormat,
+		sha256:     strings.ToLower(chartVersion.Digest),
+	}
+
+	return archiveAcquirer.acquire(dest)
+}
+
+// Downloads the repo's index and returns the highest version of the chart that satisfies the
+// version constraint. Prereleases are only considered if the constraint includes one. If the
+// source is locked, the version with the locked digest is returned instead.
+func (a HelmAcquirer) resolve() (*helmChartVersion, error) {
+	indexUri := a.repoUri + "/" + helmIndexFile
+
+	log.Logger.Debugf("Downloading chart repo index '%s'", indexUri)
+
+	response, err := httpClient.Get(indexUri)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error downloading '%s'", indexUri)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Error downloading '%s': %s", indexUri,
+			response.Status))
+	}
+
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error downloading '%s'", indexUri)
+	}
+
+	index := helmIndex{}
+	err = yaml.Unmarshal(data, &index)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing chart repo index '%s'", indexUri)
+	}
+
+	var constraint *semver.Constraints
+	if a.version != "" {
+		constraint, err = semver.NewConstraint(a.version)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	var best *helmChartVersion
+	var bestVersion *semver.Version
+
+	chartVersions := index.Entries[a.chart]
+
+	if a.revision != "" {
+		for i, chartVersion := range chartVersions {
+			if strings.ToLower(chartVersion.Digest) == a.revision {
+				return &chartVersions[i], nil
+			}
+		}
+
+		return nil, errors.New(fmt.Sprintf("No version of chart '%s' in repo '%s' has the "+
+			"locked digest '%s'", a.chart, a.repoUri, a.revision))
+	}
+
+	for i, chartVersion := range chartVersions {
+		version, err := semver.NewVersion(chartVersion.Version)
+		if err != nil {
+			log.Logger.Debugf("Ignoring invalid version '%s' of chart '%s'",
+				chartVersion.Version, a.chart)
+			continue
+		}
+
+		if constraint != nil {
+			if !constraint.Check(version) {
+				continue
+			}
+		} else if version.Prerelease() != "" {
+			continue
+		}
+
+		if bestVersion == nil || version.GreaterThan(bestVersion) {
+			best = &chartVersions[i]
+			bestVersion = version
+		}
+	}
+
+	if best == nil {
+		return nil, errors.New(fmt.Sprintf("No version of chart '%s' in repo '%s' matches "+
+			"'%s'", a.chart, a.repoUri, a.version))
+	}
+
+	return best, nil
+}
@@ -0,0 +1,259 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Starts a chart repo serving an index and a chart archive for each version
+func newChartRepo(t *testing.T, chart string, versions []string) *httptest.Server {
+	archives := map[string][]byte{}
+	var index strings.Builder
+
+	index.WriteString("apiVersion: v1\nentries:\n")
+	index.WriteString(fmt.Sprintf("  %s:\n", chart))
+
+	for i, version := range versions {
+		archive := makeTarGz(t, []archiveEntry{
+			{
+				name:     fmt.Sprintf("%s/Chart.yaml", chart),
+				contents: fmt.Sprintf("name: %s\nversion: %s", chart, version),
+			},
+		})
+
+		archiveName := fmt.Sprintf("%s-%s.tgz", chart, version)
+		archives["/charts/"+archiveName] = archive
+
+		// alternate between relative and absolute chart URLs
+		chartUrl := "charts/" + archiveName
+		if i%2 == 1 {
+			chartUrl = "URL_PREFIX/charts/" + archiveName
+		}
+
+		index.WriteString(fmt.Sprintf("  - name: %s\n    version: %s\n    digest: %s\n"+
+			"    urls:\n    - %s\n", chart, version, digest(archive), chartUrl))
+	}
+
+	server, _ := newArchiveServer(archives)
+	archives["/index.yaml"] = []byte(strings.Replace(index.String(), "URL_PREFIX",
+		server.URL, -1))
+
+	return server
+}
+
+func TestNewHelmAcquirer(t *testing.T) {
+	actual, err := New(structs.Source{
+		Uri: "helm://charts.example.com/stable/nginx-ingress?version=~1.2",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &HelmAcquirer{
+		id:      "nginx-ingress",
+		repoUri: "https://charts.example.com/stable",
+		chart:   "nginx-ingress",
+		version: "~1.2",
+	}, actual)
+
+	fqId, err := actual.FullyQualifiedId()
+	assert.Nil(t, err)
+	assert.Equal(t, "charts.example.com-stable-nginx-ingress", fqId)
+	assert.Equal(t, "nginx-ingress", actual.Path())
+
+	// options take precedence over the URI
+	actual, err = New(structs.Source{
+		Uri: "helm+http://localhost:8080/nginx-ingress?version=~1.2",
+		Options: map[string]interface{}{
+			VersionKey: "1.3.0",
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &HelmAcquirer{
+		id:      "nginx-ingress",
+		repoUri: "http://localhost:8080",
+		chart:   "nginx-ingress",
+		version: "1.3.0",
+	}, actual)
+	assert.Equal(t, "helm+http://localhost:8080/nginx-ingress?version=1.3.0", actual.Uri())
+
+	_, err = New(structs.Source{
+		Uri: "helm://charts.example.com/nginx-ingress?version=not-a-version",
+	})
+	assert.NotNil(t, err)
+
+	assert.Equal(t, VersionKey, VersionOptionKey("helm://charts.example.com/nginx-ingress"))
+	assert.Equal(t, BranchKey, VersionOptionKey(GoodGitUri))
+}
+
+func TestHelmAcquirerResolve(t *testing.T) {
+	server := newChartRepo(t, "nginx-ingress", []string{
+		"1.1.0", "1.2.0", "1.2.5", "1.3.0", "2.0.0-beta.1",
+	})
+	defer server.Close()
+
+	repoUri := strings.Replace(server.URL, "http://", HelmHttpProtocol, 1)
+
+	tests := []struct {
+		name     string
+		version  string
+		expected string
+	}{
+		{
+			name:     "tilde",
+			version:  "~1.2",
+			expected: "1.2.5",
+		},
+		{
+			name:     "exact",
+			version:  "1.2.0",
+			expected: "1.2.0",
+		},
+		{
+			name:     "latest_stable",
+			version:  "",
+			expected: "1.3.0",
+		},
+		{
+			name:     "prerelease",
+			version:  ">=2.0.0-alpha",
+			expected: "2.0.0-beta.1",
+		},
+	}
+
+	for _, test := range tests {
+		acquirerObj, err := newHelmAcquirer(structs.Source{
+			Uri:     repoUri + "/nginx-ingress",
+			Options: map[string]interface{}{VersionKey: test.version},
+		})
+		assert.Nil(t, err)
+
+		chartVersion, err := acquirerObj.resolve()
+		assert.Nil(t, err, "Error resolving in test '%s'", test.name)
+		assert.Equal(t, test.expected, chartVersion.Version, "Unexpected version in test '%s'",
+			test.name)
+	}
+
+	acquirerObj, err := newHelmAcquirer(structs.Source{
+		Uri: repoUri + "/nginx-ingress?version=~3.0",
+	})
+	assert.Nil(t, err)
+	_, err = acquirerObj.resolve()
+	assert.NotNil(t, err)
+
+	acquirerObj, err = newHelmAcquirer(structs.Source{
+		Uri: repoUri + "/missing-chart",
+	})
+	assert.Nil(t, err)
+	_, err = acquirerObj.resolve()
+	assert.NotNil(t, err)
+}
+
+func TestHelmAcquirerAcquire(t *testing.T) {
+	tmpDir, cleanup := withArchiveCacheDir(t)
+	defer cleanup()
+
+	server := newChartRepo(t, "nginx-ingress", []string{"1.2.0", "1.2.5", "1.3.0"})
+	defer server.Close()
+
+	repoUri := strings.Replace(server.URL, "http://", HelmHttpProtocol, 1)
+
+	for _, version := range []string{"~1.2", "1.3.0"} {
+		acquirerObj, err := New(structs.Source{
+			Uri:     repoUri + "/nginx-ingress",
+			Options: map[string]interface{}{VersionKey: version},
+		})
+		assert.Nil(t, err)
+
+		dest := filepath.Join(tmpDir, version)
+		assert.Nil(t, Acquire(acquirerObj, dest))
+
+		contents, err := ioutil.ReadFile(filepath.Join(dest, acquirerObj.Path(), "Chart.yaml"))
+		assert.Nil(t, err)
+
+		expected, err := acquirerObj.(*HelmAcquirer).resolve()
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("name: nginx-ingress\nversion: %s", expected.Version),
+			string(contents))
+
+		// acquiring again is a no-op
+		assert.Nil(t, Acquire(acquirerObj, dest))
+	}
+
+	// changing the version replaces the chart
+	acquirerObj, err := New(structs.Source{
+		Uri:     repoUri + "/nginx-ingress",
+		Options: map[string]interface{}{VersionKey: "1.3.0"},
+	})
+	assert.Nil(t, err)
+
+	dest := filepath.Join(tmpDir, "~1.2")
+	assert.Nil(t, Acquire(acquirerObj, dest))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dest, acquirerObj.Path(), "Chart.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "name: nginx-ingress\nversion: 1.3.0", string(contents))
+}
+
+func TestHelmAcquirerLocked(t *testing.T) {
+	tmpDir, cleanup := withArchiveCacheDir(t)
+	defer cleanup()
+
+	server := newChartRepo(t, "nginx-ingress", []string{"1.2.0", "1.2.5"})
+	defer server.Close()
+
+	repoUri := strings.Replace(server.URL, "http://", HelmHttpProtocol, 1)
+
+	unlocked, err := newHelmAcquirer(structs.Source{
+		Uri: repoUri + "/nginx-ingress?version=1.2.0",
+	})
+	assert.Nil(t, err)
+	lockedVersion, err := unlocked.resolve()
+	assert.Nil(t, err)
+
+	// the locked digest takes precedence over the version constraint
+	acquirerObj, err := New(structs.Source{
+		Uri: repoUri + "/nginx-ingress?version=~1.2",
+		Options: map[string]interface{}{
+			RevisionKey: strings.ToUpper(lockedVersion.Digest),
+		},
+	})
+	assert.Nil(t, err)
+
+	dest := filepath.Join(tmpDir, "locked")
+	assert.Nil(t, Acquire(acquirerObj, dest))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dest, acquirerObj.Path(), "Chart.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "name: nginx-ingress\nversion: 1.2.0", string(contents))
+
+	revision, err := acquirerObj.(Lockable).Revision(dest)
+	assert.Nil(t, err)
+	assert.Equal(t, lockedVersion.Digest, revision)
+
+	acquirerObj, err = New(structs.Source{
+		Uri:     repoUri + "/nginx-ingress",
+		Options: map[string]interface{}{RevisionKey: strings.Repeat("a", 64)},
+	})
+	assert.Nil(t, err)
+	assert.NotNil(t, Acquire(acquirerObj, filepath.Join(tmpDir, "missing")))
+}
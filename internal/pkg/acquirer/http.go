@@ -0,0 +1,559 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const HttpProtocol = "http://"
+const HttpsProtocol = "https://"
+
+// source option containing the hex-encoded sha256 digest of an archive
+const Sha256Key = "sha256"
+
+// name of the file written into extracted archives recording the digest of the archive
+const ArchiveDigestFile = ".sugarkube-sha256"
+
+// name of the file written into extracted archives recording the digest of each extracted file,
+// so local modifications can be detected before replacing them with a different archive
+const ArchiveFilesFile = ".sugarkube-files"
+
+const TarGzFormat = "tar.gz"
+const ZipFormat = "zip"
+
+// maps archive file extensions to their format
+var archiveExtensions = []struct {
+	extension string
+	format    string
+}{
+	{".tar.gz", TarGzFormat},
+	{".tgz", TarGzFormat},
+	{".zip", ZipFormat},
+}
+
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// Acquires kapps from archives downloaded over HTTP(S). Archives must have a known sha256
+// digest which is used to verify them and to cache downloads.
+type HttpAcquirer struct {
+	id         string
+	archiveUri string
+	path       string
+	format     string
+	sha256     string
+}
+
+// Returns whether a URI points to an archive that can be downloaded over HTTP(S)
+func isArchiveUri(uri string) bool {
+	if !strings.HasPrefix(uri, HttpProtocol) && !strings.HasPrefix(uri, HttpsProtocol) {
+		return false
+	}
+
+	archiveUri, _ := splitArchiveUri(uri)
+	return archiveFormat(archiveUri) != ""
+}
+
+// Splits a URI into the URI of the archive and the path inside the archive
+func splitArchiveUri(uri string) (string, string) {
+	schemeEnd := strings.Index(uri, "://") + len("://")
+	lastSeparatorIndex := strings.LastIndex(uri, PathSeparator)
+
+	if lastSeparatorIndex < schemeEnd {
+		return uri, ""
+	}
+
+	return uri[0:lastSeparatorIndex], uri[lastSeparatorIndex+len(PathSeparator):]
+}
+
+// Returns the format of an archive based on the extension of its URL path, or an empty
+// string if it isn't a supported archive
+func archiveFormat(archiveUri string) string {
+	parsed, err := url.Parse(archiveUri)
+	if err != nil {
+		return ""
+	}
+
+	for _, archiveExtension := range archiveExtensions {
+		if strings.HasSuffix(parsed.Path, archiveExtension.extension) {
+			return archiveExtension.format
+		}
+	}
+
+	return ""
+}
+
+// Returns the base name of an archive without its extension
+func archiveName(archiveUri string) string {
+	parsed, err := url.Parse(archiveUri)
+	if err != nil {
+		return ""
+	}
+
+	name := filepath.Base(parsed.Path)
+	for _, archiveExtension := range archiveExtensions {
+		if strings.HasSuffix(name, archiveExtension.extension) {
+			return strings.TrimSuffix(name, archiveExtension.extension)
+		}
+	}
+
+	return name
+}
+
+// Returns an instance. This allows us to build objects for testing instead of
+// directly instantiating objects in the acquirer factory.
+func newHttpAcquirer(source structs.Source) (*HttpAcquirer, error) {
+
+	uri := strings.TrimSpace(source.Uri)
+	archiveUri, path := splitArchiveUri(uri)
+	path = strings.TrimSpace(path)
+
+	format := archiveFormat(archiveUri)
+	if format == "" {
+		return nil, errors.New(fmt.Sprintf("Unsupported archive URI '%s'. Archives must "+
+			"be .tar.gz, .tgz or .zip files", uri))
+	}
+
+	digest := ""
+	if optionValue, ok := source.Options[Sha256Key]; ok {
+		digest, ok = optionValue.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("The '%s' option for source '%s' must be a string",
+				Sha256Key, uri))
+		}
+	}
+
+	digest = strings.ToLower(strings.TrimSpace(digest))
+	if digest == "" {
+		return nil, errors.New(fmt.Sprintf("The '%s' option is mandatory for archive "+
+			"source '%s'", Sha256Key, uri))
+	}
+
+	decoded, err := hex.DecodeString(digest)
+	if err != nil || len(decoded) != sha256.Size {
+		return nil, errors.New(fmt.Sprintf("Invalid sha256 digest '%s' for source '%s'",
+			digest, uri))
+	}
+
+	// archives are already pinned by their digest so a locked revision must match it
+	revision, err := revisionFromOptions(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if revision != "" && strings.ToLower(revision) != digest {
+		return nil, errors.New(fmt.Sprintf("The sha256 digest for source '%s' doesn't "+
+			"match the locked revision '%s'", uri, revision))
+	}
+
+	id := source.Id
+
+	if id == "" {
+		if path != "" {
+			id = strings.Trim(filepath.Base(path), "/")
+		} else {
+			id = archiveName(archiveUri)
+		}
+	}
+
+	return &HttpAcquirer{
+		id:         id,
+		archiveUri: archiveUri,
+		path:       path,
+		format:     format,
+		sha256:     digest,
+	}, nil
+}
+
+// Generate an ID based on the URI of the archive and the ID
+func (a HttpAcquirer) FullyQualifiedId() (string, error) {
+	parsed, err := url.Parse(a.archiveUri)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error parsing URI '%s'", a.archiveUri)
+	}
+
+	archivePath := filepath.Join(filepath.Dir(parsed.Path), archiveName(a.archiveUri))
+	hyphenatedUri := strings.Replace(strings.Trim(parsed.Hostname()+archivePath, "/"),
+		"/", "-", -1)
+
+	if a.id != "" {
+		hyphenatedName := strings.Replace(a.id, "/", "-", -1)
+		return strings.Join([]string{hyphenatedUri, hyphenatedName}, "-"), nil
+	} else {
+		return hyphenatedUri, nil
+	}
+}
+
+// Return the ID. This is used as a subcomponent of a fully-qualified ID and can be explicitly configured in config
+func (a HttpAcquirer) Id() string {
+	return a.id
+}
+
+// return the path inside the archive
+func (a HttpAcquirer) Path() string {
+	return a.path
+}
+
+// return the uri
+func (a HttpAcquirer) Uri() string {
+	if a.path == "" {
+		return a.archiveUri
+	}
+
+	return strings.Join([]string{a.archiveUri, PathSeparator, a.path}, "")
+}
+
+// Downloads the archive (unless it's already in the download cache), verifies its digest and
+// extracts it to `dest`. Nothing is done if `dest` already contains the extracted archive. If it
+// contains a different archive (e.g. because the version or digest changed) it's replaced unless
+// the extracted files have been modified.
+func (a HttpAcquirer) acquire(dest string) error {
+
+	digestPath := filepath.Join(dest, ArchiveDigestFile)
+	replace := false
+
+	if _, err := os.Stat(dest); err == nil {
+		existingDigest, err := ioutil.ReadFile(digestPath)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Error updating the cache. The path at '%s' "+
+				"already exists but wasn't extracted from an archive. Aborting to prevent "+
+				"losing work.", dest))
+		}
+
+		if strings.TrimSpace(string(existingDigest)) == a.sha256 {
+			log.Logger.Debugf("Archive '%s' has already been extracted to '%s'",
+				a.archiveUri, dest)
+			return nil
+		}
+
+		if _, err := os.Stat(filepath.Join(dest, ArchiveFilesFile)); err != nil {
+			return errors.New(fmt.Sprintf("Error updating the cache. The archive extracted "+
+				"into '%s' can't be checked for local modifications because the digests of "+
+				"its files weren't recorded. Aborting to prevent losing work. Delete the "+
+				"directory to replace it with '%s' (sha256 %s).", dest, a.archiveUri, a.sha256))
+		}
+
+		modified, err := modifiedExtractedFiles(dest)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if len(modified) > 0 {
+			return errors.New(fmt.Sprintf("Error updating the cache. The archive extracted "+
+				"into '%s' has local modifications to: %s. Aborting to prevent losing work. "+
+				"Delete the directory to replace it with '%s' (sha256 %s).", dest,
+				strings.Join(modified, ", "), a.archiveUri, a.sha256))
+		}
+
+		log.Logger.Infof("Replacing the archive extracted into '%s' with '%s'", dest,
+			a.archiveUri)
+		replace = true
+	} else if !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	archivePath, err := a.download()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// extract to a temporary directory first so a failed extraction doesn't leave a
+	// partially populated cache behind
+	parentDir := filepath.Dir(dest)
+	err = os.MkdirAll(parentDir, 0755)
+	if err != nil {
+		return errors.Wrapf(err, "Error creating directory '%s'", parentDir)
+	}
+
+	tmpDir, err := ioutil.TempDir(parentDir, ".extract-")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	log.Logger.Infof("Extracting archive '%s' into '%s'", a.archiveUri, dest)
+
+	switch a.format {
+	case TarGzFormat:
+		err = ExtractTarGz(archivePath, tmpDir)
+	case ZipFormat:
+		err = extractZip(archivePath, tmpDir)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "Error extracting archive '%s'", a.archiveUri)
+	}
+
+	if a.path != "" {
+		if _, err := os.Stat(filepath.Join(tmpDir, a.path)); err != nil {
+			return errors.Wrapf(err, "Path '%s' doesn't exist in archive '%s'", a.path,
+				a.archiveUri)
+		}
+	}
+
+	err = writeExtractedFiles(tmpDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(tmpDir, ArchiveDigestFile), []byte(a.sha256), 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if replace {
+		// move the old extraction aside so it's only removed once the new one is in place
+		oldDir, err := ioutil.TempDir(parentDir, ".replaced-")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer os.RemoveAll(oldDir)
+
+		err = os.Rename(dest, filepath.Join(oldDir, filepath.Base(dest)))
+		if err != nil {
+			return errors.Wrapf(err, "Error moving the archive extracted into '%s' aside", dest)
+		}
+	}
+
+	err = os.Rename(tmpDir, dest)
+	if err != nil {
+		return errors.Wrapf(err, "Error moving extracted archive to '%s'", dest)
+	}
+
+	return nil
+}
+
+// Returns the digests of the files and symlinks extracted into a directory keyed by their
+// slash-separated paths. Symlinks are recorded by the digest of their targets.
+func extractedFileDigests(dir string) (map[string]string, error) {
+	digests := map[string]string{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		name := filepath.ToSlash(relativePath)
+		if name == ArchiveDigestFile || name == ArchiveFilesFile {
+			return nil
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			hash := sha256.Sum256([]byte(target))
+			digests[name] = hex.EncodeToString(hash[:])
+			return nil
+		}
+
+		digests[name], err = utils.FileSha256(path)
+		return errors.WithStack(err)
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return digests, nil
+}
+
+// Records the digest of each file extracted into a directory in the format used by `sha256sum`
+func writeExtractedFiles(dir string) error {
+	digests, err := extractedFileDigests(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("%s  %s\n", digests[name], name))
+	}
+
+	return errors.WithStack(ioutil.WriteFile(filepath.Join(dir, ArchiveFilesFile),
+		[]byte(builder.String()), 0644))
+}
+
+// Returns a sorted list of the files in a directory an archive was extracted into that have
+// been modified, deleted or added since extracting it
+func modifiedExtractedFiles(dir string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ArchiveFilesFile))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	recorded := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) == 2 {
+			recorded[fields[1]] = fields[0]
+		}
+	}
+
+	current, err := extractedFileDigests(dir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	modified := make([]string, 0)
+	for name, digest := range current {
+		if recorded[name] != digest {
+			modified = append(modified, name)
+		}
+	}
+	for name := range recorded {
+		if _, ok := current[name]; !ok {
+			modified = append(modified, name)
+		}
+	}
+	sort.Strings(modified)
+
+	return modified, nil
+}
+
+// Returns the digest of the archive extracted into `dest`
+func (a HttpAcquirer) Revision(dest string) (string, error) {
+	return extractedDigest(dest)
+}
+
+// Returns the digest of the archive extracted into a directory
+func extractedDigest(dest string) (string, error) {
+	digest, err := ioutil.ReadFile(filepath.Join(dest, ArchiveDigestFile))
+	if err != nil {
+		return "", errors.Wrapf(err, "Error reading the digest of the archive extracted "+
+			"into '%s'", dest)
+	}
+
+	return strings.TrimSpace(string(digest)), nil
+}
+
+// Returns the directory archives are downloaded to
+func archiveCacheDir() (string, error) {
+	if config.CurrentConfig != nil && config.CurrentConfig.ArchiveCacheDir != "" {
+		return config.CurrentConfig.ArchiveCacheDir, nil
+	}
+
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return filepath.Join(userCacheDir, "sugarkube", "archives"), nil
+}
+
+// Downloads the archive into the download cache unless an archive with the expected digest
+// is already there. Returns the path to the verified archive.
+func (a HttpAcquirer) download() (string, error) {
+	cacheDir, err := archiveCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	archivePath := filepath.Join(cacheDir, fmt.Sprintf("%s.%s", a.sha256, a.format))
+
+	if _, err := os.Stat(archivePath); err == nil {
+		digest, err := utils.FileSha256(archivePath)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		if digest == a.sha256 {
+			log.Logger.Debugf("Using cached download of '%s' at '%s'", a.archiveUri, archivePath)
+			return archivePath, nil
+		}
+
+		log.Logger.Warnf("Cached download at '%s' is corrupt. Will download '%s' again",
+			archivePath, a.archiveUri)
+	}
+
+	err = os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error creating directory '%s'", cacheDir)
+	}
+
+	log.Logger.Infof("Downloading archive '%s'", a.archiveUri)
+
+	response, err := httpClient.Get(a.archiveUri)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error downloading '%s'", a.archiveUri)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", errors.New(fmt.Sprintf("Error downloading '%s': %s", a.archiveUri,
+			response.Status))
+	}
+
+	tmpFile, err := ioutil.TempFile(cacheDir, ".download-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpFile, hash), response.Body)
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return "", errors.Wrapf(err, "Error downloading '%s'", a.archiveUri)
+	}
+	if closeErr != nil {
+		return "", errors.WithStack(closeErr)
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if digest != a.sha256 {
+		return "", errors.New(fmt.Sprintf("Checksum mismatch for '%s'. Expected sha256 %s "+
+			"but got %s", a.archiveUri, a.sha256, digest))
+	}
+
+	err = os.Rename(tmpFile.Name(), archivePath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return archivePath, nil
+}
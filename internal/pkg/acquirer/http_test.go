@@ -0,0 +1,425 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSha256 = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// An entry to add to a test archive. If `link` is set a symlink will be created.
+type archiveEntry struct {
+	name     string
+	contents string
+	link     string
+}
+
+func makeTarGz(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.name,
+			Mode:     0644,
+			Size:     int64(len(entry.contents)),
+			Typeflag: tar.TypeReg,
+		}
+
+		if entry.link != "" {
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.link
+			header.Size = 0
+		}
+
+		assert.Nil(t, tarWriter.WriteHeader(header))
+		if entry.link == "" {
+			_, err := tarWriter.Write([]byte(entry.contents))
+			assert.Nil(t, err)
+		}
+	}
+
+	assert.Nil(t, tarWriter.Close())
+	assert.Nil(t, gzipWriter.Close())
+
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+
+	for _, entry := range entries {
+		writer, err := zipWriter.Create(entry.name)
+		assert.Nil(t, err)
+		_, err = writer.Write([]byte(entry.contents))
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, zipWriter.Close())
+
+	return buf.Bytes()
+}
+
+func digest(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
+
+// Serves archives from a map of paths to contents and counts the number of requests made
+func newArchiveServer(archives map[string][]byte) (*httptest.Server, *int) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		contents, ok := archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(contents)
+	}))
+
+	return server, &requests
+}
+
+// Sets up a temporary download cache and returns a function to restore the original config
+func withArchiveCacheDir(t *testing.T) (string, func()) {
+	tmpDir, err := ioutil.TempDir("", "archive-acquirer-")
+	assert.Nil(t, err)
+
+	originalConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{
+		ArchiveCacheDir: filepath.Join(tmpDir, "downloads"),
+	}
+
+	return tmpDir, func() {
+		config.CurrentConfig = originalConfig
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestNewHttpAcquirer(t *testing.T) {
+	actual, err := New(structs.Source{
+		Uri: "https://example.com/vendor/kapp-1.2.3.tar.gz//kapps/wordpress",
+		Options: map[string]interface{}{
+			Sha256Key: testSha256,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &HttpAcquirer{
+		id:         "wordpress",
+		archiveUri: "https://example.com/vendor/kapp-1.2.3.tar.gz",
+		path:       "kapps/wordpress",
+		format:     TarGzFormat,
+		sha256:     testSha256,
+	}, actual)
+
+	fqId, err := actual.FullyQualifiedId()
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com-vendor-kapp-1.2.3-wordpress", fqId)
+	assert.Equal(t, "https://example.com/vendor/kapp-1.2.3.tar.gz//kapps/wordpress", actual.Uri())
+
+	// archives hosted on domains containing '.git' shouldn't be treated as git repos
+	actual, err = New(structs.Source{
+		Uri: "https://raw.githubusercontent.com/vendor/kapp.zip",
+		Options: map[string]interface{}{
+			Sha256Key: testSha256,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, &HttpAcquirer{
+		id:         "kapp",
+		archiveUri: "https://raw.githubusercontent.com/vendor/kapp.zip",
+		format:     ZipFormat,
+		sha256:     testSha256,
+	}, actual)
+}
+
+func TestNewHttpAcquirerErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+	}{
+		{
+			name: "missing_digest",
+		},
+		{
+			name:    "invalid_digest",
+			options: map[string]interface{}{Sha256Key: "abc"},
+		},
+		{
+			name:    "non_string_digest",
+			options: map[string]interface{}{Sha256Key: 123},
+		},
+		{
+			name: "locked_revision_mismatch",
+			options: map[string]interface{}{
+				Sha256Key:   testSha256,
+				RevisionKey: strings.Repeat("b", 64),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		_, err := newHttpAcquirer(structs.Source{
+			Uri:     "https://example.com/kapp.tgz//kapp",
+			Options: test.options,
+		})
+		assert.NotNil(t, err, "Expected an error in test '%s'", test.name)
+	}
+}
+
+func TestHttpAcquirerAcquire(t *testing.T) {
+	tmpDir, cleanup := withArchiveCacheDir(t)
+	defer cleanup()
+
+	tarGz := makeTarGz(t, []archiveEntry{
+		{name: "kapp-1.0/kapps/wordpress/sugarkube.yaml", contents: "version: 1"},
+		{name: "kapp-1.0/kapps/wordpress/link.yaml", link: "sugarkube.yaml"},
+	})
+	zipFile := makeZip(t, []archiveEntry{
+		{name: "kapps/wordpress/sugarkube.yaml", contents: "version: 1"},
+	})
+
+	server, requests := newArchiveServer(map[string][]byte{
+		"/kapp-1.0.tar.gz": tarGz,
+		"/kapp-1.0.zip":    zipFile,
+	})
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		uri    string
+		sha256 string
+		path   string
+	}{
+		{
+			name:   "tar_gz",
+			uri:    server.URL + "/kapp-1.0.tar.gz//kapp-1.0/kapps/wordpress",
+			sha256: digest(tarGz),
+			path:   "kapp-1.0/kapps/wordpress/sugarkube.yaml",
+		},
+		{
+			name:   "zip",
+			uri:    server.URL + "/kapp-1.0.zip//kapps/wordpress",
+			sha256: digest(zipFile),
+			path:   "kapps/wordpress/sugarkube.yaml",
+		},
+	}
+
+	for _, test := range tests {
+		*requests = 0
+
+		acquirerObj, err := New(structs.Source{
+			Uri:     test.uri,
+			Options: map[string]interface{}{Sha256Key: test.sha256},
+		})
+		assert.Nil(t, err)
+
+		dest := filepath.Join(tmpDir, test.name, "first")
+		assert.Nil(t, Acquire(acquirerObj, dest), "Error acquiring in test '%s'", test.name)
+
+		contents, err := ioutil.ReadFile(filepath.Join(dest, test.path))
+		assert.Nil(t, err)
+		assert.Equal(t, "version: 1", string(contents))
+
+		// reacquiring into the same directory is a no-op
+		assert.Nil(t, Acquire(acquirerObj, dest), "Error reacquiring in test '%s'", test.name)
+
+		// downloads are cached so acquiring into a different directory shouldn't hit the server
+		dest = filepath.Join(tmpDir, test.name, "second")
+		assert.Nil(t, Acquire(acquirerObj, dest), "Error acquiring in test '%s'", test.name)
+		assert.FileExists(t, filepath.Join(dest, test.path))
+
+		assert.Equal(t, 1, *requests, "Unexpected number of requests in test '%s'", test.name)
+	}
+
+	linkContents, err := ioutil.ReadFile(filepath.Join(tmpDir, "tar_gz", "first",
+		"kapp-1.0/kapps/wordpress/link.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "version: 1", string(linkContents))
+}
+
+func TestHttpAcquirerReplace(t *testing.T) {
+	tmpDir, cleanup := withArchiveCacheDir(t)
+	defer cleanup()
+
+	archives := map[string][]byte{}
+	for _, version := range []string{"1", "2"} {
+		archives["/kapp-"+version+".tar.gz"] = makeTarGz(t, []archiveEntry{
+			{name: "kapp/sugarkube.yaml", contents: "version: " + version},
+			{name: "kapp/values-" + version + ".yaml", contents: "replicas: 1"},
+		})
+	}
+
+	server, _ := newArchiveServer(archives)
+	defer server.Close()
+
+	newAcquirer := func(version string) Acquirer {
+		archive := "/kapp-" + version + ".tar.gz"
+		acquirerObj, err := New(structs.Source{
+			Uri:     server.URL + archive + "//kapp",
+			Options: map[string]interface{}{Sha256Key: digest(archives[archive])},
+		})
+		assert.Nil(t, err)
+		return acquirerObj
+	}
+
+	dest := filepath.Join(tmpDir, "kapp")
+	assert.Nil(t, Acquire(newAcquirer("1"), dest))
+
+	// clean extractions are replaced when the digest changes
+	assert.Nil(t, Acquire(newAcquirer("2"), dest))
+
+	contents, err := ioutil.ReadFile(filepath.Join(dest, "kapp/sugarkube.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "version: 2", string(contents))
+	assert.FileExists(t, filepath.Join(dest, "kapp/values-2.yaml"))
+	_, err = os.Stat(filepath.Join(dest, "kapp/values-1.yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	// but modified, deleted and added files are never lost
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dest, "kapp/sugarkube.yaml"),
+		[]byte("version: local"), 0644))
+	assert.Nil(t, os.Remove(filepath.Join(dest, "kapp/values-2.yaml")))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dest, "kapp/new.yaml"), []byte{}, 0644))
+
+	err = Acquire(newAcquirer("1"), dest)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "has local modifications to: kapp/new.yaml, "+
+		"kapp/sugarkube.yaml, kapp/values-2.yaml")
+
+	contents, err = ioutil.ReadFile(filepath.Join(dest, "kapp/sugarkube.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "version: local", string(contents))
+
+	// extractions whose files weren't recorded can't be checked so aren't replaced
+	assert.Nil(t, os.RemoveAll(dest))
+	assert.Nil(t, Acquire(newAcquirer("2"), dest))
+	assert.Nil(t, os.Remove(filepath.Join(dest, ArchiveFilesFile)))
+
+	err = Acquire(newAcquirer("1"), dest)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "can't be checked for local modifications")
+}
+
+func TestHttpAcquirerChecksumMismatch(t *testing.T) {
+	tmpDir, cleanup := withArchiveCacheDir(t)
+	defer cleanup()
+
+	tarGz := makeTarGz(t, []archiveEntry{
+		{name: "kapp/sugarkube.yaml", contents: "version: 1"},
+	})
+
+	server, _ := newArchiveServer(map[string][]byte{"/kapp.tar.gz": tarGz})
+	defer server.Close()
+
+	acquirerObj, err := New(structs.Source{
+		Uri:     server.URL + "/kapp.tar.gz//kapp",
+		Options: map[string]interface{}{Sha256Key: testSha256},
+	})
+	assert.Nil(t, err)
+
+	dest := filepath.Join(tmpDir, "dest")
+	err = Acquire(acquirerObj, dest)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Checksum mismatch")
+
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+
+	// nothing should have been cached
+	files, err := ioutil.ReadDir(filepath.Join(tmpDir, "downloads"))
+	assert.Nil(t, err)
+	assert.Empty(t, files)
+}
+
+func TestHttpAcquirerPathTraversal(t *testing.T) {
+	tmpDir, cleanup := withArchiveCacheDir(t)
+	defer cleanup()
+
+	tests := []struct {
+		name    string
+		entries []archiveEntry
+	}{
+		{
+			name:    "parent_dir",
+			entries: []archiveEntry{{name: "../../evil.txt", contents: "evil"}},
+		},
+		{
+			name:    "nested_parent_dir",
+			entries: []archiveEntry{{name: "kapp/../../evil.txt", contents: "evil"}},
+		},
+		{
+			name:    "absolute_path",
+			entries: []archiveEntry{{name: "/tmp/evil.txt", contents: "evil"}},
+		},
+		{
+			name:    "absolute_symlink",
+			entries: []archiveEntry{{name: "kapp/link", link: "/etc/passwd"}},
+		},
+		{
+			name:    "escaping_symlink",
+			entries: []archiveEntry{{name: "kapp/link", link: "../../.."}},
+		},
+		{
+			name: "write_through_symlink",
+			entries: []archiveEntry{
+				{name: "kapp/link", link: "."},
+				{name: "kapp/link/evil.txt", contents: "evil"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		tarGz := makeTarGz(t, test.entries)
+		server, _ := newArchiveServer(map[string][]byte{"/kapp.tar.gz": tarGz})
+
+		acquirerObj, err := New(structs.Source{
+			Uri:     server.URL + "/kapp.tar.gz",
+			Options: map[string]interface{}{Sha256Key: digest(tarGz)},
+		})
+		assert.Nil(t, err)
+
+		dest := filepath.Join(tmpDir, test.name, "a", "b", "dest")
+		assert.NotNil(t, Acquire(acquirerObj, dest), "Expected an error in test '%s'", test.name)
+
+		_, err = os.Stat(dest)
+		assert.True(t, os.IsNotExist(err), "Destination exists in test '%s'", test.name)
+		assert.NoFileExists(t, filepath.Join(tmpDir, test.name, "evil.txt"))
+
+		server.Close()
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The state of a source that's already been acquired
+type SourceState struct {
+	Wanted   string   // the branch, tag or commit the source should contain
+	Current  string   // the branch checked out, or the commit if HEAD is detached
+	Revision string   // the SHA of the checked out commit
+	IsWanted bool     // whether the wanted branch, tag or commit is checked out
+	Modified []string // paths of modified (and for some acquirers untracked) files
+	Ahead    int      // number of local commits that haven't been pushed
+	Behind   int      // number of commits on the remote branch that haven't been pulled
+
+	CommitDate time.Time // when the checked out commit was committed
+}
+
+// Acquirers that can report on the state of sources they've acquired implement this. The
+// state is inspected as of the last time the source was acquired, so remotes aren't contacted.
+type Inspectable interface {
+	Inspect(dest string) (*SourceState, error)
+	// Returns descriptions of uncommitted changes, unpushed commits and stashes in `dest`. Unlike
+	// `Inspect` this doesn't need to know which branch, tag or commit the source should contain.
+	LocalWork(dest string) ([]string, error)
+}
+
+// Returns descriptions of uncommitted changes and unpushed commits in a git checkout, or of
+// files changed since an archive or chart was extracted, or an empty slice if there aren't any
+// or `dest` is neither. Since the source the checkout was acquired from may no longer be known,
+// it's inspected with the configured git acquirer.
+func LocalWork(dest string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dest, ".git")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, errors.WithStack(err)
+		}
+		return extractedLocalWork(dest)
+	}
+
+	name, err := gitAcquirerName(structs.Source{})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var inspectable Inspectable = GitAcquirer{}
+	if name == GoGitAcquirerName {
+		inspectable = GoGitAcquirer{}
+	}
+
+	return inspectable.LocalWork(dest)
+}
+
+// Returns descriptions of files that have been modified, deleted or added since an archive was
+// extracted into `dest`, or an empty slice if the digests of its files weren't recorded
+func extractedLocalWork(dest string) ([]string, error) {
+	work := make([]string, 0)
+
+	if _, err := os.Stat(filepath.Join(dest, ArchiveFilesFile)); err != nil {
+		if os.IsNotExist(err) {
+			return work, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	modified, err := modifiedExtractedFiles(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, path := range modified {
+		work = append(work, fmt.Sprintf("changes to '%s' since it was extracted", path))
+	}
+
+	return work, nil
+}
+
+// Describes local work found in a checkout. HEAD not resolving (e.g. because it refers to a
+// branch that was deleted) is treated as work since it's unknown which commits it had.
+func describeLocalWork(modified []string, headResolved bool, unpushed int, stashes int) []string {
+	work := make([]string, 0)
+
+	for _, path := range modified {
+		work = append(work, fmt.Sprintf("uncommitted changes to '%s'", path))
+	}
+
+	if !headResolved {
+		work = append(work, "HEAD can't be resolved so commits may not have been pushed")
+	} else if unpushed > 0 {
+		work = append(work, fmt.Sprintf("%d unpushed commit(s)", unpushed))
+	}
+
+	// stashes may have been created when switching the checkout between branches
+	if stashes > 0 {
+		work = append(work, fmt.Sprintf("%d stash entry(s)", stashes))
+	}
+
+	return work
+}
+
+// Returns descriptions of local work in a checkout using the git CLI
+func (a GitAcquirer) LocalWork(dest string) ([]string, error) {
+	modified, err := a.modifiedFiles(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	headResolved := true
+	unpushed, err := countUnpushedCommits(dest)
+	if err != nil {
+		if errors.Cause(err) != errUnresolvedHead {
+			return nil, errors.WithStack(err)
+		}
+		headResolved = false
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	err = utils.ExecCommand(GitPath, []string{"stash", "list"}, map[string]string{}, &stdoutBuf,
+		&stderrBuf, dest, 30, false)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stashes := strings.Count(stdoutBuf.String(), "\n")
+
+	return describeLocalWork(modified, headResolved, unpushed, stashes), nil
+}
+
+// Returned when HEAD doesn't resolve to a commit so unpushed commits can't be counted
+var errUnresolvedHead = errors.New("HEAD doesn't resolve to a commit")
+
+// Returns the number of commits checked out in `dest` that aren't on any remote-tracking branch,
+// which includes every commit if there's no remote-tracking branch. Returns `errUnresolvedHead`
+// if HEAD doesn't resolve to a commit but there are local branches.
+func countUnpushedCommits(dest string) (int, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	err := utils.ExecCommand(GitPath, []string{"rev-parse", "--verify", "--quiet", "HEAD"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+	if err != nil {
+		err = utils.ExecCommand(GitPath, []string{"for-each-ref", "--count=1", "refs/heads"},
+			map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		// there's nothing to push if nothing's been committed (e.g. if a clone was interrupted)
+		if strings.TrimSpace(stdoutBuf.String()) == "" {
+			return 0, nil
+		}
+
+		return 0, errUnresolvedHead
+	}
+
+	err = utils.ExecCommand(GitPath, []string{"rev-list", "--count", "HEAD", "--not", "--remotes"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, dest, 30, false)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var unpushed int
+	_, err = fmt.Sscanf(stdoutBuf.String(), "%d", &unpushed)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return unpushed, nil
+}
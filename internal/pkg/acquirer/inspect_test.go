@@ -0,0 +1,157 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	remote, cleanup := newBranchedTestRemote(t)
+	defer cleanup()
+
+	for _, newAcquirer := range []func(t *testing.T, path string, branch string) Acquirer{
+		remote.gitAcquirer, remote.acquirer} {
+
+		tmpDir, err := ioutil.TempDir("", "inspect-")
+		assert.Nil(t, err)
+		dest := filepath.Join(tmpDir, "source")
+
+		master := newAcquirer(t, "kapps/wordpress", "master")
+		assert.Nil(t, Acquire(master, dest))
+
+		state, err := master.(Inspectable).Inspect(dest)
+		assert.Nil(t, err)
+		assert.True(t, state.IsWanted)
+		assert.Equal(t, "master", state.Current)
+		assert.Equal(t, "master", state.Wanted)
+		assert.Empty(t, state.Modified)
+		assert.Equal(t, 0, state.Ahead)
+		assert.Equal(t, 0, state.Behind)
+		assert.False(t, state.CommitDate.IsZero())
+
+		// the feature branch is one commit ahead of master
+		state, err = newAcquirer(t, "kapps/wordpress", "feature").(Inspectable).Inspect(dest)
+		assert.Nil(t, err)
+		assert.False(t, state.IsWanted)
+		assert.Equal(t, "feature", state.Wanted)
+
+		// commit a local change
+		path := filepath.Join(dest, "kapps/wordpress/sugarkube.yaml")
+		assert.Nil(t, ioutil.WriteFile(path, []byte("version: local"), 0644))
+
+		var stdoutBuf, stderrBuf bytes.Buffer
+		assert.Nil(t, utils.ExecCommand(GitPath, []string{"-c", "user.name=test",
+			"-c", "user.email=test@localhost", "commit", "-am", "local"}, map[string]string{},
+			&stdoutBuf, &stderrBuf, dest, 10, false))
+
+		assert.Nil(t, ioutil.WriteFile(path, []byte("version: uncommitted"), 0644))
+
+		state, err = master.(Inspectable).Inspect(dest)
+		assert.Nil(t, err)
+		assert.True(t, state.IsWanted)
+		assert.Equal(t, []string{"kapps/wordpress/sugarkube.yaml"}, state.Modified)
+		assert.Equal(t, 1, state.Ahead)
+
+		os.RemoveAll(tmpDir)
+	}
+}
+
+func TestLocalWork(t *testing.T) {
+	remote, cleanup := newBranchedTestRemote(t)
+	defer cleanup()
+
+	git := func(t *testing.T, dest string, args ...string) {
+		var stdoutBuf, stderrBuf bytes.Buffer
+		assert.Nil(t, utils.ExecCommand(GitPath, append([]string{"-c", "user.name=test",
+			"-c", "user.email=test@localhost"}, args...), map[string]string{},
+			&stdoutBuf, &stderrBuf, dest, 10, false))
+	}
+
+	for _, newAcquirer := range []func(t *testing.T, path string, branch string) Acquirer{
+		remote.gitAcquirer, remote.acquirer} {
+
+		tmpDir, err := ioutil.TempDir("", "local-work-")
+		assert.Nil(t, err)
+		dest := filepath.Join(tmpDir, "source")
+
+		acquirerObj := newAcquirer(t, "kapps/wordpress", "master")
+		assert.Nil(t, Acquire(acquirerObj, dest))
+		inspectable := acquirerObj.(Inspectable)
+
+		work, err := inspectable.LocalWork(dest)
+		assert.Nil(t, err)
+		assert.Empty(t, work)
+
+		// commit a local change, then make uncommitted and untracked changes
+		path := filepath.Join(dest, "kapps/wordpress/sugarkube.yaml")
+		assert.Nil(t, ioutil.WriteFile(path, []byte("version: local"), 0644))
+		git(t, dest, "commit", "-am", "local")
+		assert.Nil(t, ioutil.WriteFile(path, []byte("version: uncommitted"), 0644))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dest, "kapps/wordpress/new.txt"),
+			[]byte("new"), 0644))
+
+		work, err = inspectable.LocalWork(dest)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"uncommitted changes to 'kapps/wordpress/new.txt'",
+			"uncommitted changes to 'kapps/wordpress/sugarkube.yaml'",
+			"1 unpushed commit(s)",
+		}, work)
+
+		// stashes are work too
+		git(t, dest, "stash", "--include-untracked")
+
+		work, err = inspectable.LocalWork(dest)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1 unpushed commit(s)", "1 stash entry(s)"}, work)
+
+		// commits on a detached HEAD that aren't on any remote branch are unpushed
+		git(t, dest, "checkout", "--detach")
+		git(t, dest, "commit", "--allow-empty", "-m", "detached")
+
+		work, err = inspectable.LocalWork(dest)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2 unpushed commit(s)", "1 stash entry(s)"}, work)
+
+		// if HEAD can't be resolved it's unknown whether anything's unpushed
+		git(t, dest, "symbolic-ref", "HEAD", "refs/heads/deleted")
+
+		work, err = inspectable.LocalWork(dest)
+		assert.Nil(t, err)
+		// the git CLI also reports the index as changes since there's no HEAD to compare it with
+		assert.Contains(t, work, "HEAD can't be resolved so commits may not have been pushed")
+		assert.Contains(t, work, "1 stash entry(s)")
+
+		// there's nothing to push from a repo without any commits
+		emptyDir := filepath.Join(tmpDir, "empty")
+		assert.Nil(t, os.MkdirAll(emptyDir, 0755))
+		git(t, emptyDir, "init")
+
+		work, err = inspectable.LocalWork(emptyDir)
+		assert.Nil(t, err)
+		assert.Empty(t, work)
+
+		os.RemoveAll(tmpDir)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// refspecs to fetch from a mirror into a checkout. These map the mirror's branches to
+// remote-tracking branches for origin so checkouts look as if they were fetched from the remote.
+var mirrorRefSpecs = []string{
+	"+refs/heads/*:refs/remotes/origin/*",
+	"+refs/tags/*:refs/tags/*",
+}
+
+var unsafeMirrorChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Tracks which mirrors have been refreshed by this process so each remote is only fetched once
+// however many sources use it. Each mirror has its own lock so sources from different remotes
+// can be acquired in parallel.
+var mirrors = struct {
+	sync.Mutex
+	locks     map[string]*sync.Mutex
+	refreshed map[string]bool
+}{
+	locks:     map[string]*sync.Mutex{},
+	refreshed: map[string]bool{},
+}
+
+// Returns whether git sources should be acquired via shared mirrors
+func gitMirrorsEnabled() bool {
+	return config.CurrentConfig != nil && config.CurrentConfig.GitMirrors
+}
+
+// Returns the path to the bare mirror for a remote
+func gitMirrorPath(uri string) (string, error) {
+	mirrorDir := ""
+
+	if config.CurrentConfig != nil && config.CurrentConfig.GitMirrorDir != "" {
+		mirrorDir = config.CurrentConfig.GitMirrorDir
+	} else {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		mirrorDir = filepath.Join(homeDir, ".sugarkube", "git-mirrors")
+	}
+
+	// make the name readable but also unique since sanitising could cause collisions
+	uriHash := sha256.Sum256([]byte(uri))
+	name := strings.Trim(unsafeMirrorChars.ReplaceAllString(strings.TrimSuffix(uri, ".git"), "-"), "-")
+
+	return filepath.Join(mirrorDir, fmt.Sprintf("%s-%s.git", name,
+		hex.EncodeToString(uriHash[:])[:8])), nil
+}
+
+// Returns the lock for a mirror
+func mirrorLock(mirrorPath string) *sync.Mutex {
+	mirrors.Lock()
+	defer mirrors.Unlock()
+
+	lock, ok := mirrors.locks[mirrorPath]
+	if !ok {
+		lock = &sync.Mutex{}
+		mirrors.locks[mirrorPath] = lock
+	}
+
+	return lock
+}
+
+// Returns the path to a bare mirror of the remote, creating it if necessary. Mirrors are
+// refreshed from the remote the first time they're used by each process.
+func syncGitMirror(uri string) (string, error) {
+	mirrorPath, err := gitMirrorPath(uri)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	lock := mirrorLock(mirrorPath)
+	lock.Lock()
+	defer lock.Unlock()
+
+	mirrors.Lock()
+	refreshed := mirrors.refreshed[mirrorPath]
+	mirrors.Unlock()
+
+	if refreshed {
+		log.Logger.Debugf("Mirror of '%s' at '%s' has already been refreshed", uri, mirrorPath)
+		return mirrorPath, nil
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	if _, err := os.Stat(mirrorPath); err == nil {
+		log.Logger.Infof("Refreshing mirror of '%s' at '%s'", uri, mirrorPath)
+
+		// don't prune deleted refs because checkouts borrow objects from the mirror
+		err = utils.ExecCommand(GitPath, []string{"fetch", "origin"}, map[string]string{},
+			&stdoutBuf, &stderrBuf, mirrorPath, 300, false)
+		if err != nil {
+			return "", errors.Wrapf(err, "Error refreshing mirror at '%s'", mirrorPath)
+		}
+	} else if os.IsNotExist(err) {
+		log.Logger.Infof("Creating mirror of '%s' at '%s'", uri, mirrorPath)
+
+		err = os.MkdirAll(filepath.Dir(mirrorPath), 0755)
+		if err != nil {
+			return "", errors.Wrapf(err, "Error creating directory '%s'", filepath.Dir(mirrorPath))
+		}
+
+		err = utils.ExecCommand(GitPath, []string{"clone", "--mirror", uri, mirrorPath},
+			map[string]string{}, &stdoutBuf, &stderrBuf, "", 300, false)
+		if err != nil {
+			removeErr := os.RemoveAll(mirrorPath)
+			if removeErr != nil {
+				log.Logger.Warnf("Failed to remove partial mirror at '%s': %s", mirrorPath, removeErr)
+			}
+			return "", errors.Wrapf(err, "Error creating mirror of '%s'", uri)
+		}
+
+		// never garbage collect the mirror since that could delete objects checkouts rely on
+		err = utils.ExecCommand(GitPath, []string{"config", "gc.auto", "0"},
+			map[string]string{}, &stdoutBuf, &stderrBuf, mirrorPath, 5, false)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	} else {
+		return "", errors.WithStack(err)
+	}
+
+	mirrors.Lock()
+	mirrors.refreshed[mirrorPath] = true
+	mirrors.Unlock()
+
+	return mirrorPath, nil
+}
+
+// Configures a checkout to borrow objects from a mirror instead of storing its own copies
+func addMirrorAlternate(dest string, mirrorPath string) error {
+	alternatesPath := filepath.Join(dest, ".git", "objects", "info", "alternates")
+
+	err := os.MkdirAll(filepath.Dir(alternatesPath), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return utils.AppendToFile(alternatesPath, filepath.Join(mirrorPath, "objects")+"\n")
+}
+
+// Copies the `.git` directory of a checkout that borrows objects from a mirror into `stagingDir`
+// along with the borrowed objects, so the copy can be used where the mirror doesn't exist. The
+// checkout itself isn't modified. Returns false without copying anything if the checkout doesn't
+// borrow objects.
+func CopyGitDirWithoutMirror(dest string, stagingDir string) (bool, error) {
+	alternatesPath := filepath.Join(dest, ".git", "objects", "info", "alternates")
+
+	if _, err := os.Stat(alternatesPath); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, errors.WithStack(err)
+	}
+
+	log.Logger.Infof("Copying '%s' with the objects it borrows from mirrors into '%s'",
+		dest, stagingDir)
+
+	err := utils.CopyTree(filepath.Join(dest, ".git"), filepath.Join(stagingDir, ".git"))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	// the copy still borrows from the mirror, so repacking it copies the borrowed objects
+	err = utils.ExecCommand(GitPath, []string{"repack", "-a", "-d", "-q"}, map[string]string{},
+		&stdoutBuf, &stderrBuf, stagingDir, 300, false)
+	if err != nil {
+		return false, errors.Wrapf(err, "Error repacking the copy of '%s'", dest)
+	}
+
+	err = os.Remove(filepath.Join(stagingDir, ".git", "objects", "info", "alternates"))
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
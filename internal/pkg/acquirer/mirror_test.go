@@ -0,0 +1,188 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Enables git mirrors in a temporary directory. Returns the directory and a function to restore
+// the original config.
+func withGitMirrors(t *testing.T) (string, func()) {
+	tmpDir, err := ioutil.TempDir("", "git-mirrors-")
+	assert.Nil(t, err)
+
+	originalConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{
+		GitMirrors:   true,
+		GitMirrorDir: filepath.Join(tmpDir, "mirrors"),
+	}
+	resetMirrors()
+
+	return tmpDir, func() {
+		config.CurrentConfig = originalConfig
+		os.RemoveAll(tmpDir)
+	}
+}
+
+// Forgets which mirrors have been refreshed, as if a new process had started
+func resetMirrors() {
+	mirrors.Lock()
+	defer mirrors.Unlock()
+	mirrors.locks = map[string]*sync.Mutex{}
+	mirrors.refreshed = map[string]bool{}
+}
+
+func TestGitMirrorPath(t *testing.T) {
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+	config.CurrentConfig = &config.Config{GitMirrorDir: "/mirrors"}
+
+	mirrorPath, err := gitMirrorPath("git@github.com:sugarkube/kapps.git")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(mirrorPath, "/mirrors/git-github.com-sugarkube-kapps-"))
+	assert.True(t, strings.HasSuffix(mirrorPath, ".git"))
+
+	otherPath, err := gitMirrorPath("git@github.com:sugarkube/kapps-A.git")
+	assert.Nil(t, err)
+	assert.NotEqual(t, mirrorPath, otherPath)
+}
+
+func TestGitAcquireWithMirror(t *testing.T) {
+	tmpDir, restore := withGitMirrors(t)
+	defer restore()
+
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+		"kapps/mysql/sugarkube.yaml":     "version: 1",
+	})
+	remote.push(t)
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	uri := "file://" + remote.bareDir
+
+	acquirers := map[string]Acquirer{}
+
+	for _, path := range []string{"kapps/wordpress", "kapps/mysql"} {
+		acquirerObj, err := New(structs.Source{
+			Uri:     fmt.Sprintf("%s//%s#master", uri, path),
+			Options: map[string]interface{}{AcquirerKey: GitAcquirerName},
+		})
+		assert.Nil(t, err)
+
+		dest := filepath.Join(tmpDir, filepath.Base(path))
+		assert.Nil(t, Acquire(acquirerObj, dest))
+		assert.FileExists(t, filepath.Join(dest, path, "sugarkube.yaml"))
+
+		// objects are borrowed from the mirror and origin still points at the remote
+		assert.FileExists(t, filepath.Join(dest, ".git/objects/info/alternates"))
+		assert.Nil(t, utils.ExecCommand(GitPath, []string{"remote", "get-url", RemoteName},
+			map[string]string{}, &stdoutBuf, &stderrBuf, dest, 5, false))
+		assert.Equal(t, uri, strings.TrimSpace(stdoutBuf.String()))
+
+		acquirers[dest] = acquirerObj
+	}
+
+	// both sources share a single mirror
+	mirrorDirs, err := ioutil.ReadDir(filepath.Join(tmpDir, "mirrors"))
+	assert.Nil(t, err)
+	assert.Len(t, mirrorDirs, 1)
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 2",
+	})
+	remote.push(t)
+
+	wordpressDest := filepath.Join(tmpDir, "wordpress")
+	wordpressFile := filepath.Join(wordpressDest, "kapps/wordpress/sugarkube.yaml")
+
+	// the mirror is only refreshed once per process so updates come from the mirror as it was
+	assert.Nil(t, Acquire(acquirers[wordpressDest], wordpressDest))
+	contents, err := ioutil.ReadFile(wordpressFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "version: 1", string(contents))
+
+	resetMirrors()
+
+	assert.Nil(t, Acquire(acquirers[wordpressDest], wordpressDest))
+	contents, err = ioutil.ReadFile(wordpressFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "version: 2", string(contents))
+}
+
+func TestCopyGitDirWithoutMirror(t *testing.T) {
+	tmpDir, restore := withGitMirrors(t)
+	defer restore()
+
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	remote.commit(t, map[string]string{
+		"kapps/wordpress/sugarkube.yaml": "version: 1",
+	})
+	remote.push(t)
+
+	acquirerObj, err := New(structs.Source{
+		Uri:     fmt.Sprintf("file://%s//kapps/wordpress#master", remote.bareDir),
+		Options: map[string]interface{}{AcquirerKey: GitAcquirerName},
+	})
+	assert.Nil(t, err)
+
+	dest := filepath.Join(tmpDir, "wordpress")
+	assert.Nil(t, Acquire(acquirerObj, dest))
+
+	stagingDir := filepath.Join(tmpDir, "staging")
+	copied, err := CopyGitDirWithoutMirror(dest, stagingDir)
+	assert.Nil(t, err)
+	assert.True(t, copied)
+
+	_, err = os.Stat(filepath.Join(stagingDir, ".git/objects/info/alternates"))
+	assert.True(t, os.IsNotExist(err))
+
+	// the checkout is left alone
+	assert.FileExists(t, filepath.Join(dest, ".git/objects/info/alternates"))
+
+	// the copy still works once the mirror has gone
+	assert.Nil(t, os.RemoveAll(filepath.Join(tmpDir, "mirrors")))
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	assert.Nil(t, utils.ExecCommand(GitPath, []string{"fsck", "--no-dangling"},
+		map[string]string{}, &stdoutBuf, &stderrBuf, stagingDir, 30, false))
+
+	// checkouts that don't use mirrors aren't copied
+	copied, err = CopyGitDirWithoutMirror(stagingDir, filepath.Join(tmpDir, "other"))
+	assert.Nil(t, err)
+	assert.False(t, copied)
+	_, err = os.Stat(filepath.Join(tmpDir, "other"))
+	assert.True(t, os.IsNotExist(err))
+}
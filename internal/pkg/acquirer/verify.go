@@ -0,0 +1,188 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"strings"
+)
+
+// source option to configure verifying signatures on git sources
+const VerifyKey = "verify"
+
+// key under the verify option listing the GPG fingerprints of trusted keys
+const TrustedKeysKey = "trusted_keys"
+
+const GpgPath = "gpg"
+
+// prefix of lines in GnuPG's machine-readable status output for good signatures
+const validSigStatus = "[GNUPG:] VALIDSIG "
+
+// long key IDs are the last 16 hex characters of a fingerprint
+const longKeyIdLength = 16
+
+// Exports the public keys with the given fingerprints from the local GnuPG keyring as an
+// armored keyring. This is a variable so it can be replaced in tests.
+var exportPublicKeys = func(fingerprints []string) (string, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	args := append([]string{"--batch", "--armor", "--export"}, fingerprints...)
+	err := utils.ExecCommand(GpgPath, args, map[string]string{}, &stdoutBuf, &stderrBuf,
+		"", 30, false)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if stdoutBuf.Len() == 0 {
+		return "", errors.New(fmt.Sprintf("None of the trusted keys (%s) are in the local "+
+			"GnuPG keyring", strings.Join(fingerprints, ", ")))
+	}
+
+	return stdoutBuf.String(), nil
+}
+
+// Returns the fingerprints of keys trusted to sign a source. Keys configured globally apply to
+// all git sources, and sources can add extra keys with the `verify` option. Sources can opt out
+// of global verification by setting `verify: false`. An empty list means signatures won't be
+// verified.
+func trustedKeys(source structs.Source) ([]string, error) {
+	var keys []string
+
+	optionValue, ok := source.Options[VerifyKey]
+	if ok {
+		switch value := optionValue.(type) {
+		case bool:
+			if !value {
+				return nil, nil
+			}
+		case map[string]interface{}:
+			sourceKeys, err := toStringSlice(value[TrustedKeysKey])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid '%s' for source '%s'", TrustedKeysKey,
+					source.Uri)
+			}
+			keys = append(keys, sourceKeys...)
+		case map[interface{}]interface{}:
+			sourceKeys, err := toStringSlice(value[TrustedKeysKey])
+			if err != nil {
+				return nil, errors.Wrapf(err, "Invalid '%s' for source '%s'", TrustedKeysKey,
+					source.Uri)
+			}
+			keys = append(keys, sourceKeys...)
+		default:
+			return nil, errors.New(fmt.Sprintf("The '%s' option for source '%s' must be a "+
+				"map containing '%s' or false", VerifyKey, source.Uri, TrustedKeysKey))
+		}
+	}
+
+	if config.CurrentConfig != nil {
+		keys = append(keys, config.CurrentConfig.TrustedKeys...)
+	}
+
+	if ok && len(keys) == 0 {
+		return nil, errors.New(fmt.Sprintf("Source '%s' should be verified but no trusted "+
+			"keys are configured", source.Uri))
+	}
+
+	for i, key := range keys {
+		keys[i] = normaliseFingerprint(key)
+		if _, err := hex.DecodeString(keys[i]); err != nil || len(keys[i]) < longKeyIdLength {
+			return nil, errors.New(fmt.Sprintf("Invalid trusted key '%s' for source '%s'. "+
+				"Keys must be fingerprints or long key IDs", key, source.Uri))
+		}
+	}
+
+	return keys, nil
+}
+
+// Converts a list from YAML to a list of strings
+func toStringSlice(value interface{}) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch list := value.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		strs := make([]string, len(list))
+		for i, item := range list {
+			str, ok := item.(string)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Expected a string but got '%v'", item))
+			}
+			strs[i] = str
+		}
+		return strs, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Expected a list of strings but got '%v'", value))
+}
+
+// Upper-cases a fingerprint and strips spaces and any hex prefix
+func normaliseFingerprint(fingerprint string) string {
+	fingerprint = strings.ToUpper(strings.Replace(fingerprint, " ", "", -1))
+	return strings.TrimPrefix(fingerprint, "0X")
+}
+
+// Returns whether a fingerprint belongs to one of the trusted keys. Trusted keys can either be
+// full fingerprints or long key IDs.
+func isTrustedKey(trustedKeys []string, fingerprint string) bool {
+	fingerprint = normaliseFingerprint(fingerprint)
+	if fingerprint == "" {
+		return false
+	}
+
+	for _, trustedKey := range trustedKeys {
+		if fingerprint == trustedKey ||
+			(len(trustedKey) == longKeyIdLength && strings.HasSuffix(fingerprint, trustedKey)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parses GnuPG status output (e.g. from `git verify-commit --raw`) and returns the fingerprints
+// of the signing keys and their primary keys for all good signatures
+func parseValidSignatures(statusOutput string) []string {
+	fingerprints := make([]string, 0)
+
+	for _, line := range strings.Split(statusOutput, "\n") {
+		if !strings.HasPrefix(line, validSigStatus) {
+			continue
+		}
+
+		// the format is: VALIDSIG <fingerprint> <date> <timestamp> <expiry> <version>
+		// <reserved> <pubkey-algo> <hash-algo> <sig-class> [<primary-key-fingerprint>]
+		fields := strings.Fields(strings.TrimPrefix(line, validSigStatus))
+		if len(fields) > 0 {
+			fingerprints = append(fingerprints, fields[0])
+		}
+		if len(fields) > 9 {
+			fingerprints = append(fingerprints, fields[9])
+		}
+	}
+
+	return fingerprints
+}
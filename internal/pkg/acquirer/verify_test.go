@@ -0,0 +1,250 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package acquirer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const trustedFingerprint = "0123456789ABCDEF0123456789ABCDEF01234567"
+
+func TestTrustedKeys(t *testing.T) {
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+
+	config.CurrentConfig = &config.Config{}
+
+	keys, err := trustedKeys(structs.Source{Uri: GoodGitUri})
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+
+	keys, err = trustedKeys(structs.Source{
+		Uri: GoodGitUri,
+		Options: map[string]interface{}{
+			VerifyKey: map[interface{}]interface{}{
+				TrustedKeysKey: []interface{}{"0x0123 4567 89ab cdef 0123  4567 89ab cdef 0123 4567"},
+			},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{trustedFingerprint}, keys)
+
+	// verification is requested but there are no keys
+	_, err = trustedKeys(structs.Source{
+		Uri: GoodGitUri,
+		Options: map[string]interface{}{
+			VerifyKey: map[string]interface{}{},
+		},
+	})
+	assert.NotNil(t, err)
+
+	_, err = trustedKeys(structs.Source{
+		Uri: GoodGitUri,
+		Options: map[string]interface{}{
+			VerifyKey: map[string]interface{}{
+				TrustedKeysKey: []interface{}{"not-a-key"},
+			},
+		},
+	})
+	assert.NotNil(t, err)
+
+	// global keys apply to all sources unless they opt out
+	config.CurrentConfig = &config.Config{
+		TrustedKeys: []string{"89abcdef01234567"},
+	}
+
+	keys, err = trustedKeys(structs.Source{Uri: GoodGitUri})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"89ABCDEF01234567"}, keys)
+
+	keys, err = trustedKeys(structs.Source{
+		Uri: GoodGitUri,
+		Options: map[string]interface{}{
+			VerifyKey: false,
+		},
+	})
+	assert.Nil(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestIsTrustedKey(t *testing.T) {
+	assert.True(t, isTrustedKey([]string{trustedFingerprint}, strings.ToLower(trustedFingerprint)))
+	assert.True(t, isTrustedKey([]string{"89ABCDEF01234567"}, trustedFingerprint))
+	assert.False(t, isTrustedKey([]string{"FFFFFFFFFFFFFFFF"}, trustedFingerprint))
+	assert.False(t, isTrustedKey([]string{trustedFingerprint}, ""))
+}
+
+func TestParseValidSignatures(t *testing.T) {
+	statusOutput := `[GNUPG:] NEWSIG
+[GNUPG:] KEY_CONSIDERED 0123456789ABCDEF0123456789ABCDEF01234567 0
+[GNUPG:] SIG_ID abcdefg 2019-06-01 1559347200
+[GNUPG:] GOODSIG 89ABCDEF01234567 Test <test@example.com>
+[GNUPG:] VALIDSIG FEDCBA9876543210FEDCBA9876543210FEDCBA98 2019-06-01 1559347200 0 4 0 1 8 00 0123456789ABCDEF0123456789ABCDEF01234567
+[GNUPG:] TRUST_UNDEFINED 0 pgp
+`
+	assert.Equal(t, []string{
+		"FEDCBA9876543210FEDCBA9876543210FEDCBA98",
+		trustedFingerprint,
+	}, parseValidSignatures(statusOutput))
+
+	assert.Empty(t, parseValidSignatures("error: no signature found\n"))
+}
+
+// Replaces the function that exports keys from GnuPG so it returns the given entity's public
+// key. Returns a function to restore it.
+func withTrustedEntity(t *testing.T, entity *openpgp.Entity) func() {
+	var buf bytes.Buffer
+	writer, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, entity.Serialize(writer))
+	assert.Nil(t, writer.Close())
+
+	originalExport := exportPublicKeys
+	exportPublicKeys = func(fingerprints []string) (string, error) {
+		return buf.String(), nil
+	}
+
+	return func() {
+		exportPublicKeys = originalExport
+	}
+}
+
+func TestGoGitVerify(t *testing.T) {
+	trustedEntity, err := openpgp.NewEntity("trusted", "", "trusted@example.com", nil)
+	assert.Nil(t, err)
+	untrustedEntity, err := openpgp.NewEntity("untrusted", "", "untrusted@example.com", nil)
+	assert.Nil(t, err)
+
+	restore := withTrustedEntity(t, trustedEntity)
+	defer restore()
+
+	remote, cleanup := newTestRemote(t)
+	defer cleanup()
+
+	signature := &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}
+
+	assert.Nil(t, os.MkdirAll(filepath.Join(remote.workDir, "kapps/wordpress"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(remote.workDir, "kapps/wordpress/sugarkube.yaml"),
+		[]byte("version: 1"), 0644))
+	_, err = remote.worktree.Add("kapps/wordpress/sugarkube.yaml")
+	assert.Nil(t, err)
+
+	signedHash, err := remote.worktree.Commit("signed", &git.CommitOptions{
+		Author:  signature,
+		SignKey: untrustedEntity,
+	})
+	assert.Nil(t, err)
+
+	// annotated tags are verified using their own signature
+	_, err = remote.repo.CreateTag("signed-tag", signedHash, &git.CreateTagOptions{
+		Tagger:  signature,
+		Message: "signed tag",
+		SignKey: trustedEntity,
+	})
+	assert.Nil(t, err)
+
+	_, err = remote.repo.CreateTag("unsigned-tag", signedHash, &git.CreateTagOptions{
+		Tagger:  signature,
+		Message: "unsigned tag",
+	})
+	assert.Nil(t, err)
+
+	trustedHash, err := remote.worktree.Commit("trusted", &git.CommitOptions{
+		Author:            signature,
+		SignKey:           trustedEntity,
+		AllowEmptyCommits: true,
+	})
+	assert.Nil(t, err)
+	remote.push(t)
+
+	trustedKey := strings.ToUpper(hex.EncodeToString(trustedEntity.PrimaryKey.Fingerprint))
+
+	tests := []struct {
+		name        string
+		branch      string
+		expectError bool
+	}{
+		{
+			name:   "signed_tag",
+			branch: "signed-tag",
+		},
+		{
+			name:        "unsigned_tag",
+			branch:      "unsigned-tag",
+			expectError: true,
+		},
+		{
+			name:        "untrusted_commit",
+			branch:      signedHash.String(),
+			expectError: true,
+		},
+		{
+			name:   "trusted_commit",
+			branch: trustedHash.String(),
+		},
+		{
+			name:   "trusted_branch",
+			branch: "master",
+		},
+	}
+
+	for _, test := range tests {
+		tmpDir, err := ioutil.TempDir("", "gogit-verify-")
+		assert.Nil(t, err)
+		defer os.RemoveAll(tmpDir)
+		dest := filepath.Join(tmpDir, "source")
+
+		acquirerObj, err := New(structs.Source{
+			Uri: "file://" + remote.bareDir + "//kapps/wordpress#" + test.branch,
+			Options: map[string]interface{}{
+				AcquirerKey: GoGitAcquirerName,
+				VerifyKey: map[string]interface{}{
+					TrustedKeysKey: []interface{}{trustedKey},
+				},
+			},
+		})
+		assert.Nil(t, err)
+
+		err = Acquire(acquirerObj, dest)
+		if test.expectError {
+			assert.NotNil(t, err, "Expected an error in test '%s'", test.name)
+
+			// untrusted sources mustn't be cached
+			_, err = os.Stat(dest)
+			assert.True(t, os.IsNotExist(err), "Untrusted source cached in test '%s'", test.name)
+		} else {
+			assert.Nil(t, err, "Unexpected error in test '%s'", test.name)
+			assert.FileExists(t, filepath.Join(dest, "kapps/wordpress/sugarkube.yaml"))
+		}
+	}
+}
@@ -0,0 +1,651 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	BundleInfoFileName     = "sugarkube-bundle.yaml"
+	BundleChecksumFileName = "SHA256SUMS"
+	BundleCacheDir         = "cache"     // kapps are under this directory in bundles
+	BundleStackDir         = "stack"     // stack files, manifests, vars, etc. are under this directory
+	BundleManifestsDir     = "manifests" // remote manifests are under this directory
+)
+
+// Describes the contents of a bundle
+type BundleInfo struct {
+	StackName   string            `yaml:"stack_name"`
+	StackFile   string            `yaml:"stack_file"`          // path to the stack file in the stack directory
+	StrippedGit bool              `yaml:"stripped_git"`        // whether `.git` directories were left out of kapp sources
+	Manifests   map[string]string `yaml:"manifests,omitempty"` // directories of remote manifests keyed by source URI
+}
+
+// Writes files to a bundle, recording their checksums
+type bundleWriter struct {
+	tarWriter *tar.Writer
+	checksums map[string]string
+	written   map[string]bool
+}
+
+// Packages the kapps in a cache into a gzipped tarball along with the stack file, a lock file
+// for the cached revisions, local manifests, vars and template directories and the sources of
+// remote manifests. Every kapp must have been fully acquired. If `stripGit` is true `.git`
+// directories are left out of kapp sources, which makes the bundle smaller but means the
+// sources can't be updated or locked.
+func ExportBundle(bundlePath string, stackFile string, stackConfig interfaces.IStackConfig,
+	rootCacheDir string, stripGit bool) (*BundleInfo, error) {
+
+	absRootCacheDir, err := filepath.Abs(rootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	absStackFile, err := filepath.Abs(stackFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	installables := make([]interfaces.IInstallable, 0)
+	for _, manifest := range stackConfig.Manifests() {
+		installables = append(installables, manifest.Installables()...)
+	}
+
+	err = checkFullyAcquired(installables, absRootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	lockData, err := bundleLockFile(stackFile, stackConfig, installables, absRootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stackPaths, err := bundleStackPaths(absStackFile, stackConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stackRoot := commonDir(stackPaths)
+
+	relativeStackFile, err := filepath.Rel(stackRoot, absStackFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	info := &BundleInfo{
+		StackName:   stackConfig.GetName(),
+		StackFile:   filepath.ToSlash(relativeStackFile),
+		StrippedGit: stripGit,
+		Manifests:   map[string]string{},
+	}
+
+	file, err := os.Create(bundlePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error creating bundle '%s'", bundlePath)
+	}
+
+	err = writeBundle(file, info, installables, absRootCacheDir, stackRoot, stackPaths,
+		lockData, stackConfig.Manifests())
+
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		removeErr := os.Remove(bundlePath)
+		if removeErr != nil {
+			log.Logger.Warnf("Failed to remove partial bundle '%s': %s", bundlePath, removeErr)
+		}
+		return nil, errors.Wrapf(err, "Error writing bundle '%s'", bundlePath)
+	}
+
+	return info, nil
+}
+
+// Returns an error if any kapps or sources haven't been acquired
+func checkFullyAcquired(installables []interfaces.IInstallable, absRootCacheDir string) error {
+	differences, err := DiffCache(installables, absRootCacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	missing := make([]string, 0)
+	for _, difference := range differences {
+		if difference.Kind == DiffMissingKapp || difference.Kind == DiffMissingSource {
+			missing = append(missing, difference.String())
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.New(fmt.Sprintf("The cache hasn't been fully acquired. Run `cache "+
+			"create` first:\n  %s", strings.Join(missing, "\n  ")))
+	}
+
+	return nil
+}
+
+// Returns the contents of a lock file for the stack containing the revisions that have been cached
+func bundleLockFile(stackFile string, stackConfig interfaces.IStackConfig,
+	installables []interfaces.IInstallable, absRootCacheDir string) ([]byte, error) {
+
+	lockFile, err := LoadLockFile(LockFilePath(stackFile))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	stackLock := StackLock{}
+
+	for _, installableObj := range installables {
+		kappLock, err := CachedRevisions(installableObj, absRootCacheDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		stackLock[installableObj.FullyQualifiedId()] = kappLock
+	}
+
+	lockFile.Stacks[stackConfig.GetName()] = stackLock
+	lockFile.Manifests[stackConfig.GetName()] = LockManifests(stackConfig.Manifests())
+
+	return lockFile.Marshal()
+}
+
+// Returns the absolute paths of the files and directories the stack needs other than kapps and
+// remote manifests
+func bundleStackPaths(absStackFile string, stackConfig interfaces.IStackConfig) ([]string, error) {
+	paths := []string{absStackFile}
+	stackDir := filepath.Dir(absStackFile)
+
+	for _, manifest := range stackConfig.Manifests() {
+		if manifest.CacheDir() != "" {
+			continue
+		}
+
+		manifestPath, err := filepath.Abs(manifest.Uri())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		paths = append(paths, manifestPath)
+	}
+
+	dirs := append(append(append([]string{}, stackConfig.GetProviderVarsDirs()...),
+		stackConfig.KappVarsDirs()...), stackConfig.TemplateDirs()...)
+
+	for _, dir := range dirs {
+		dirPath := filepath.Join(stackDir, dir)
+
+		if _, err := os.Stat(dirPath); err != nil {
+			if os.IsNotExist(err) {
+				log.Logger.Debugf("Not bundling '%s' because it doesn't exist", dirPath)
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		paths = append(paths, dirPath)
+	}
+
+	return paths, nil
+}
+
+// Returns the deepest directory containing all the paths
+func commonDir(paths []string) string {
+	common := filepath.Dir(paths[0])
+
+	for _, path := range paths[1:] {
+		for !isWithinAny(path, []string{common}) {
+			parent := filepath.Dir(common)
+			if parent == common {
+				break
+			}
+			common = parent
+		}
+	}
+
+	return common
+}
+
+// Writes a bundle as a gzipped tarball
+func writeBundle(writer io.Writer, info *BundleInfo, installables []interfaces.IInstallable,
+	absRootCacheDir string, stackRoot string, stackPaths []string, lockData []byte,
+	manifests []interfaces.IManifest) error {
+
+	gzipWriter := gzip.NewWriter(writer)
+	bundle := &bundleWriter{
+		tarWriter: tar.NewWriter(gzipWriter),
+		checksums: map[string]string{},
+		written:   map[string]bool{},
+	}
+
+	for _, installableObj := range installables {
+		kappDir := installableObj.GetCacheDir()
+
+		relativeKappDir, err := filepath.Rel(absRootCacheDir, kappDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = bundle.addTree(kappDir, path.Join(BundleCacheDir, filepath.ToSlash(relativeKappDir)),
+			info.StrippedGit)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// add the lock file first so any existing lock file next to the stack file is ignored
+	relativeStackDir := path.Dir(info.StackFile)
+	err := bundle.addBytes(path.Join(BundleStackDir, relativeStackDir, LockFileName), lockData)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, stackPath := range stackPaths {
+		relativePath, err := filepath.Rel(stackRoot, stackPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = bundle.addTree(stackPath, path.Join(BundleStackDir, filepath.ToSlash(relativePath)), false)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// remote manifests keep their `.git` directories since their revisions are read when
+	// they're loaded
+	for _, manifest := range manifests {
+		if manifest.CacheDir() == "" {
+			continue
+		}
+
+		name := filepath.Base(manifest.CacheDir())
+		info.Manifests[manifest.Uri()] = name
+
+		err := bundle.addTree(manifest.CacheDir(), path.Join(BundleManifestsDir, name), false)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	infoData, err := yaml.Marshal(info)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = bundle.addBytes(BundleInfoFileName, infoData)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = bundle.addChecksums()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = bundle.tarWriter.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(gzipWriter.Close())
+}
+
+// Adds a file or directory tree to the bundle under the given name, optionally skipping `.git`
+// directories. Paths that have already been added are skipped. Relative symlinks are preserved.
+// Objects that `.git` directories borrow from git mirrors are bundled with them since they
+// wouldn't be available after importing the bundle.
+func (b *bundleWriter) addTree(src string, name string, skipGit bool) error {
+	return filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if skipGit && info.Name() == ".git" {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		entryName := path.Join(name, filepath.ToSlash(relativePath))
+		if b.written[entryName] {
+			return nil
+		}
+
+		if info.IsDir() && info.Name() == ".git" {
+			staged, err := b.addStagedGitDir(filePath, entryName)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if staged {
+				return filepath.SkipDir
+			}
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(filePath)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			// absolute symlinks (e.g. to sources acquired from local paths) wouldn't resolve
+			// where the bundle's imported, so bundle what they point to instead
+			if filepath.IsAbs(link) {
+				return b.addTree(link, entryName, skipGit)
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			log.Logger.Warnf("Not bundling '%s' because it isn't a regular file", filePath)
+			return nil
+		}
+
+		b.written[entryName] = true
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		header.Name = entryName
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		// ownership won't mean anything where the bundle's imported
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+
+		err = b.tarWriter.WriteHeader(header)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer file.Close()
+
+		hash := sha256.New()
+		_, err = io.Copy(io.MultiWriter(b.tarWriter, hash), file)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		b.checksums[entryName] = hex.EncodeToString(hash.Sum(nil))
+
+		return nil
+	})
+}
+
+// Adds a copy of a `.git` directory that includes the objects it borrows from a git mirror to
+// the bundle under the given name. The cache isn't modified. Returns false without adding
+// anything if the directory doesn't borrow objects.
+func (b *bundleWriter) addStagedGitDir(gitDir string, name string) (bool, error) {
+	stagingDir, err := ioutil.TempDir("", "sugarkube-bundle-")
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	copied, err := acquirer.CopyGitDirWithoutMirror(filepath.Dir(gitDir), stagingDir)
+	if err != nil || !copied {
+		return false, errors.WithStack(err)
+	}
+
+	return true, b.addTree(filepath.Join(stagingDir, ".git"), name, false)
+}
+
+// Adds a file with the given contents to the bundle
+func (b *bundleWriter) addBytes(name string, data []byte) error {
+	err := b.tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = b.tarWriter.Write(data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	hash := sha256.Sum256(data)
+	b.checksums[name] = hex.EncodeToString(hash[:])
+	b.written[name] = true
+
+	return nil
+}
+
+// Adds the checksums of every file in the bundle in the format used by `sha256sum`
+func (b *bundleWriter) addChecksums() error {
+	names := make([]string, 0, len(b.checksums))
+	for name := range b.checksums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for _, name := range names {
+		builder.WriteString(fmt.Sprintf("%s  %s\n", b.checksums[name], name))
+	}
+
+	return b.addBytes(BundleChecksumFileName, []byte(builder.String()))
+}
+
+// Extracts a bundle into a directory that must be empty or not exist, then verifies the
+// checksums of its contents. Remote manifests are copied into `manifestCacheRoot` unless
+// they've already been cached there. The directory is removed if the bundle can't be verified.
+func ImportBundle(bundlePath string, dest string, manifestCacheRoot string) (*BundleInfo, error) {
+	entries, err := ioutil.ReadDir(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(entries) > 0 {
+		return nil, errors.New(fmt.Sprintf("Can't import bundle into '%s' because it isn't "+
+			"empty", dest))
+	}
+
+	log.Logger.Infof("Extracting bundle '%s' into '%s'", bundlePath, dest)
+
+	err = os.MkdirAll(dest, 0755)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = acquirer.ExtractTarGz(bundlePath, dest)
+	if err == nil {
+		err = VerifyBundle(dest)
+	}
+
+	if err != nil {
+		removeErr := os.RemoveAll(dest)
+		if removeErr != nil {
+			log.Logger.Warnf("Failed to remove '%s': %s", dest, removeErr)
+		}
+		return nil, errors.Wrapf(err, "Error importing bundle '%s'", bundlePath)
+	}
+
+	info, err := LoadBundleInfo(dest)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for uri, name := range info.Manifests {
+		manifestDir := filepath.Join(manifestCacheRoot, name)
+
+		if _, err := os.Stat(manifestDir); err == nil {
+			log.Logger.Infof("Not importing manifest '%s' because it's already cached in '%s'",
+				uri, manifestDir)
+			continue
+		}
+
+		log.Logger.Infof("Importing manifest '%s' into '%s'", uri, manifestDir)
+
+		err = utils.CopyTree(filepath.Join(dest, BundleManifestsDir, name), manifestDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error importing manifest '%s'", uri)
+		}
+	}
+
+	return info, nil
+}
+
+// Loads the description of a bundle that's been extracted into a directory
+func LoadBundleInfo(dir string) (*BundleInfo, error) {
+	infoPath := filepath.Join(dir, BundleInfoFileName)
+
+	data, err := ioutil.ReadFile(infoPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error reading bundle info '%s'", infoPath)
+	}
+
+	info := &BundleInfo{}
+	err = yaml.Unmarshal(data, info)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing bundle info '%s'", infoPath)
+	}
+
+	// manifest directories are joined with the manifest cache when importing, so a crafted
+	// bundle could otherwise write anywhere
+	for uri, name := range info.Manifests {
+		if !isPathComponent(name) {
+			return nil, errors.New(fmt.Sprintf("Invalid directory '%s' for manifest '%s' in "+
+				"bundle info '%s'. It must be a single path component.", name, uri, infoPath))
+		}
+	}
+
+	return info, nil
+}
+
+// Returns whether a name is a single path component that can't refer to a parent directory
+func isPathComponent(name string) bool {
+	return name != "" && name != "." && name != ".." &&
+		!strings.ContainsAny(name, `/\`) && filepath.Base(name) == name
+}
+
+// Verifies that the files in a directory a bundle has been extracted into match the bundle's
+// checksums, and that there aren't any files missing from the checksums
+func VerifyBundle(dir string) error {
+	checksumPath := filepath.Join(dir, BundleChecksumFileName)
+
+	file, err := os.Open(checksumPath)
+	if err != nil {
+		return errors.Wrapf(err, "Error opening checksums '%s'", checksumPath)
+	}
+	defer file.Close()
+
+	expected := map[string]string{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(fields) != 2 {
+			return errors.New(fmt.Sprintf("Invalid line in checksums '%s': %s", checksumPath,
+				scanner.Text()))
+		}
+		expected[filepath.FromSlash(fields[1])] = fields[0]
+	}
+	if err = scanner.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	problems := make([]string, 0)
+
+	err = filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !info.Mode().IsRegular() || filePath == checksumPath {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(dir, filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		checksum, ok := expected[relativePath]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("'%s' isn't in the checksums", relativePath))
+			return nil
+		}
+		delete(expected, relativePath)
+
+		actual, err := utils.FileSha256(filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if actual != checksum {
+			problems = append(problems, fmt.Sprintf("'%s' has checksum %s instead of %s",
+				relativePath, actual, checksum))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for relativePath := range expected {
+		problems = append(problems, fmt.Sprintf("'%s' is missing", relativePath))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(fmt.Sprintf("The bundle in '%s' is corrupt:\n  %s", dir,
+			strings.Join(problems, "\n  ")))
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// A stack config with manifests
+type bundleTestConfig struct {
+	mock.Config
+	manifests []interfaces.IManifest
+}
+
+func (c bundleTestConfig) Manifests() []interfaces.IManifest {
+	return c.manifests
+}
+
+func TestExportImportBundle(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-bundle-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	// the stack's manifest and vars are next to the stack file
+	stackDir := filepath.Join(tmpDir, "stacks")
+	cacheDir := filepath.Join(tmpDir, "cache")
+	kappDir := filepath.Join(cacheDir, "web", "wordpress")
+	sourceDir := filepath.Join(kappDir, CacheDir, "wordpress")
+
+	files := map[string]string{
+		filepath.Join(stackDir, "stacks.yaml"):          "dev: {}",
+		filepath.Join(stackDir, "manifests/web.yaml"):   "kapps: []",
+		filepath.Join(stackDir, "providers/local.yaml"): "region: local",
+		filepath.Join(sourceDir, "sugarkube.yaml"):      "version: 1",
+		filepath.Join(sourceDir, ".git/HEAD"):           "ref: refs/heads/master",
+		filepath.Join(tmpDir, "charts/wordpress.yaml"):  "chart: wordpress",
+	}
+
+	for path, contents := range files {
+		assert.Nil(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.Nil(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	}
+
+	assert.Nil(t, os.Symlink(filepath.Join(CacheDir, "wordpress"), filepath.Join(kappDir, "wordpress")))
+	assert.Nil(t, os.Symlink(filepath.Join(tmpDir, "charts"), filepath.Join(sourceDir, "charts")))
+
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id: "wordpress",
+			Sources: map[string]structs.Source{
+				"wordpress": {Uri: "file:///kapps/wordpress"},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	stackConfig := bundleTestConfig{
+		Config: mock.Config{
+			Name:             "dev",
+			ProviderVarsDirs: []string{"providers", "missing"},
+		},
+		manifests: []interfaces.IManifest{
+			testManifest{
+				id:           "web",
+				uri:          filepath.Join(stackDir, "manifests/web.yaml"),
+				installables: []interfaces.IInstallable{installableObj},
+			},
+		},
+	}
+
+	bundlePath := filepath.Join(tmpDir, "bundle.tar.gz")
+
+	info, err := ExportBundle(bundlePath, filepath.Join(stackDir, "stacks.yaml"), stackConfig,
+		cacheDir, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "stacks.yaml", info.StackFile)
+
+	importDir := filepath.Join(tmpDir, "import")
+
+	info, err = ImportBundle(bundlePath, importDir, filepath.Join(tmpDir, "manifest-cache"))
+	assert.Nil(t, err)
+	assert.Equal(t, &BundleInfo{
+		StackName:   "dev",
+		StackFile:   "stacks.yaml",
+		StrippedGit: true,
+	}, info)
+
+	// relative symlinks are kept but absolute ones are replaced by what they point to
+	importedKappDir := filepath.Join(importDir, BundleCacheDir, "web", "wordpress")
+
+	contents, err := ioutil.ReadFile(filepath.Join(importedKappDir, "wordpress/sugarkube.yaml"))
+	assert.Nil(t, err)
+	assert.Equal(t, "version: 1", string(contents))
+
+	link, err := os.Readlink(filepath.Join(importedKappDir, "wordpress"))
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(CacheDir, "wordpress"), link)
+
+	assert.FileExists(t, filepath.Join(importedKappDir, CacheDir, "wordpress/charts/wordpress.yaml"))
+
+	_, err = os.Stat(filepath.Join(importedKappDir, CacheDir, "wordpress/.git"))
+	assert.True(t, os.IsNotExist(err))
+
+	for _, path := range []string{"stacks.yaml", LockFileName, "manifests/web.yaml",
+		"providers/local.yaml"} {
+		assert.FileExists(t, filepath.Join(importDir, BundleStackDir, path))
+	}
+
+	lockFile, err := LoadLockFile(filepath.Join(importDir, BundleStackDir, LockFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, StackLock{"web:wordpress": KappLock{}}, lockFile.Stacks["dev"])
+
+	// bundles can only be imported into empty directories
+	_, err = ImportBundle(bundlePath, importDir, filepath.Join(tmpDir, "manifest-cache"))
+	assert.NotNil(t, err)
+
+	// tampering is detected
+	assert.Nil(t, VerifyBundle(importDir))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(importDir, BundleStackDir, "stacks.yaml"),
+		[]byte("dev: {provider: aws}"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(importDir, "extra.yaml"), []byte{}, 0644))
+
+	err = VerifyBundle(importDir)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "'stack/stacks.yaml' has checksum")
+	assert.Contains(t, err.Error(), "'extra.yaml' isn't in the checksums")
+}
+
+func TestExportBundleIncompleteCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-bundle-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{Id: "wordpress"},
+	})
+	assert.Nil(t, err)
+
+	stackConfig := bundleTestConfig{
+		Config: mock.Config{Name: "dev"},
+		manifests: []interfaces.IManifest{
+			testManifest{id: "web", installables: []interfaces.IInstallable{installableObj}},
+		},
+	}
+
+	bundlePath := filepath.Join(tmpDir, "bundle.tar.gz")
+
+	_, err = ExportBundle(bundlePath, filepath.Join(tmpDir, "stacks.yaml"), stackConfig,
+		filepath.Join(tmpDir, "cache"), false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "hasn't been fully acquired")
+
+	_, err = os.Stat(bundlePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCommonDir(t *testing.T) {
+	assert.Equal(t, "/stacks", commonDir([]string{"/stacks/stacks.yaml", "/stacks/providers"}))
+	assert.Equal(t, "/", commonDir([]string{"/stacks/stacks.yaml", "/manifests/web.yaml"}))
+	assert.Equal(t, "/work", commonDir([]string{"/work/stacks/stacks.yaml", "/work/manifests"}))
+}
+
+func TestExportBundleWithMirrors(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-bundle-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	originalConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{
+		GitMirrors:   true,
+		GitMirrorDir: filepath.Join(tmpDir, "mirrors"),
+	}
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+
+	git := func(dir string, args ...string) {
+		var stdoutBuf, stderrBuf bytes.Buffer
+		assert.Nil(t, utils.ExecCommand(acquirer.GitPath, append([]string{"-c", "user.name=test",
+			"-c", "user.email=test@localhost"}, args...), map[string]string{},
+			&stdoutBuf, &stderrBuf, dir, 10, false))
+	}
+
+	remoteDir := filepath.Join(tmpDir, "remote")
+	assert.Nil(t, os.MkdirAll(filepath.Join(remoteDir, "kapps/wordpress"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(remoteDir, "kapps/wordpress/sugarkube.yaml"),
+		[]byte("version: 1"), 0644))
+	git(remoteDir, "init")
+	git(remoteDir, "add", ".")
+	git(remoteDir, "commit", "-m", "initial")
+	git(remoteDir, "branch", "-M", "master")
+	git(tmpDir, "clone", "--bare", remoteDir, "remote.git")
+
+	source := structs.Source{Uri: fmt.Sprintf("file://%s/remote.git//kapps/wordpress#master", tmpDir)}
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{Id: "wordpress", Sources: map[string]structs.Source{"wordpress": source}},
+	})
+	assert.Nil(t, err)
+
+	cacheDir := filepath.Join(tmpDir, "cache")
+	kappDir := filepath.Join(cacheDir, "web", "wordpress")
+	assert.Nil(t, installableObj.SetTopLevelCacheDir(cacheDir))
+
+	acquirerObj, err := acquirer.New(source)
+	assert.Nil(t, err)
+	sourceDir, err := sourceCacheDir(kappDir, acquirerObj)
+	assert.Nil(t, err)
+	assert.Nil(t, acquirer.Acquire(acquirerObj, sourceDir))
+
+	alternatesPath := filepath.Join(sourceDir, ".git/objects/info/alternates")
+	assert.FileExists(t, alternatesPath)
+
+	stackDir := filepath.Join(tmpDir, "stacks")
+	assert.Nil(t, os.MkdirAll(filepath.Join(stackDir, "manifests"), 0755))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(stackDir, "stacks.yaml"), []byte("dev: {}"), 0644))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(stackDir, "manifests/web.yaml"),
+		[]byte("kapps: []"), 0644))
+
+	stackConfig := bundleTestConfig{
+		Config: mock.Config{Name: "dev"},
+		manifests: []interfaces.IManifest{
+			testManifest{
+				id:           "web",
+				uri:          filepath.Join(stackDir, "manifests/web.yaml"),
+				installables: []interfaces.IInstallable{installableObj},
+			},
+		},
+	}
+
+	bundlePath := filepath.Join(tmpDir, "bundle.tar.gz")
+	_, err = ExportBundle(bundlePath, filepath.Join(stackDir, "stacks.yaml"), stackConfig,
+		cacheDir, false)
+	assert.Nil(t, err)
+
+	// exporting doesn't modify the cache
+	assert.FileExists(t, alternatesPath)
+
+	importDir := filepath.Join(tmpDir, "import")
+	_, err = ImportBundle(bundlePath, importDir, filepath.Join(tmpDir, "manifest-cache"))
+	assert.Nil(t, err)
+
+	importedSourceDir, err := sourceCacheDir(filepath.Join(importDir, BundleCacheDir, "web",
+		"wordpress"), acquirerObj)
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(importedSourceDir, ".git/objects/info/alternates"))
+	assert.True(t, os.IsNotExist(err))
+
+	// the imported source has the objects that were borrowed from the mirror
+	assert.Nil(t, os.RemoveAll(filepath.Join(tmpDir, "mirrors")))
+	git(importedSourceDir, "fsck", "--no-dangling")
+}
+
+func TestImportBundleManifestTraversal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-bundle-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	manifestCacheRoot := filepath.Join(tmpDir, "home", "manifest-cache")
+
+	for i, name := range []string{"../../.ssh", "..", ".", "", "a/b", filepath.Join(tmpDir, "abs")} {
+		// checksums don't help since whoever crafted the bundle wrote them too
+		bundlePath := filepath.Join(tmpDir, fmt.Sprintf("bundle-%d.tar.gz", i))
+		file, err := os.Create(bundlePath)
+		assert.Nil(t, err)
+
+		gzipWriter := gzip.NewWriter(file)
+		bundle := &bundleWriter{
+			tarWriter: tar.NewWriter(gzipWriter),
+			checksums: map[string]string{},
+			written:   map[string]bool{},
+		}
+
+		info, err := yaml.Marshal(&BundleInfo{
+			StackName: "dev",
+			StackFile: "stacks.yaml",
+			Manifests: map[string]string{"git@github.com:org/repo.git//web.yaml#master": name},
+		})
+		assert.Nil(t, err)
+
+		assert.Nil(t, bundle.addBytes(path.Join(BundleManifestsDir, name, "authorized_keys"),
+			[]byte("ssh-rsa attacker")))
+		assert.Nil(t, bundle.addBytes(BundleInfoFileName, info))
+		assert.Nil(t, bundle.addChecksums())
+		assert.Nil(t, bundle.tarWriter.Close())
+		assert.Nil(t, gzipWriter.Close())
+		assert.Nil(t, file.Close())
+
+		_, err = ImportBundle(bundlePath, filepath.Join(tmpDir, fmt.Sprintf("import-%d", i)),
+			manifestCacheRoot)
+		assert.NotNil(t, err, "Expected an error importing a manifest named '%s'", name)
+	}
+
+	_, err = os.Stat(filepath.Join(tmpDir, ".ssh"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(tmpDir, "home"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -17,170 +17,276 @@
 package cacher
 
 import (
+	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 const CacheDir = ".sugarkube"
 
-type Ider interface {
-	Id() string
-}
+// Number of sources to acquire concurrently if it isn't configured
+const defaultCacheWorkers = 10
 
-type CacheGrouper interface {
-	Ider
-	Installables() []interfaces.IInstallable
+// A source of an installable to acquire into the cache
+type sourceJob struct {
+	installableObj interfaces.IInstallable
+	sourceKey      string
+	acquirer       acquirer.Acquirer
 }
 
-// Cache a group of cacheable objects under a root directory
-func CacheManifest(cacheGroup CacheGrouper, rootCacheDir string, dryRun bool) error {
+// The outcome of acquiring a source
+type sourceResult struct {
+	job          sourceJob
+	lockedSource *LockedSource
+	conflict     *SourceConflict
+	err          error
+}
 
-	// create a directory to cache all kapps in this cacheGroup in
-	groupCacheDir := filepath.Join(rootCacheDir, cacheGroup.Id())
+// Returns the number of sources to acquire concurrently. A positive value overrides the
+// 'cache-workers' setting.
+func cacheWorkers(numWorkers int) int {
+	if numWorkers > 0 {
+		return numWorkers
+	}
 
-	err := createDirectoryIfMissing(groupCacheDir)
-	if err != nil {
-		return errors.WithStack(err)
+	if config.CurrentConfig != nil && config.CurrentConfig.CacheWorkers > 0 {
+		return config.CurrentConfig.CacheWorkers
 	}
 
-	// acquire each kapp and cache it
-	for _, installableObj := range cacheGroup.Installables() {
+	return defaultCacheWorkers
+}
+
+// Acquires the sources of all the installables into a cache under a root directory using a
+// pool of `numWorkers` workers (if zero, the configured number is used). A line is written to
+// `progress` as each source finishes. Returns the revisions each source was acquired at keyed
+// by the fully qualified ID of each installable, and any sources whose local modifications
+// conflicted with updating them. Sources that weren't updated because of conflicts aren't
+// locked. If any sources can't be acquired an error listing all of them is returned along with
+// the conflicts of the other sources so they can still be reported.
+func CacheInstallables(installables []interfaces.IInstallable, rootCacheDir string,
+	onConflict acquirer.ConflictPolicy, numWorkers int, progress io.Writer,
+	dryRun bool) (StackLock, []SourceConflict, error) {
+
+	stackLock := StackLock{}
+	jobs := make([]sourceJob, 0)
+
+	for _, installableObj := range installables {
 		log.Logger.Infof("Caching kapp '%s'", installableObj.FullyQualifiedId())
 		log.Logger.Debugf("Kapp to cache: %#v", installableObj)
 
 		err := installableObj.SetTopLevelCacheDir(rootCacheDir)
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
 
 		acquirers, err := installableObj.Acquirers()
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
 		}
 
-		err = acquireSources(cacheGroup.Id(), acquirers, installableObj.GetCacheDir(),
-			dryRun)
+		// build a directory path for the kapp's .sugarkube cache directory
+		err = createDirectoryIfMissing(filepath.Join(installableObj.GetCacheDir(), CacheDir))
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, nil, errors.WithStack(err)
+		}
+
+		stackLock[installableObj.FullyQualifiedId()] = KappLock{}
+
+		for sourceKey, acquirerImpl := range acquirers {
+			jobs = append(jobs, sourceJob{
+				installableObj: installableObj,
+				sourceKey:      sourceKey,
+				acquirer:       acquirerImpl,
+			})
 		}
 	}
 
-	return nil
-}
+	numWorkers = cacheWorkers(numWorkers)
 
-// Acquires each source and symlinks it to the target path in the cache directory.
-// Runs all acquirers in parallel.
-func acquireSources(manifestId string, acquirers map[string]acquirer.Acquirer, kappTopLevelCacheDir string,
-	dryRun bool) error {
+	log.Logger.Infof("Acquiring %d source(s) for %d kapp(s) with %d worker(s)", len(jobs),
+		len(installables), numWorkers)
 
-	// build a directory path for the kapp's .sugarkube cache directory
-	kappHiddenCacheDir := filepath.Join(kappTopLevelCacheDir, CacheDir)
+	// both channels are big enough for every job so nothing ever blocks
+	jobCh := make(chan sourceJob, len(jobs))
+	resultCh := make(chan sourceResult, len(jobs))
 
-	err := createDirectoryIfMissing(kappHiddenCacheDir)
-	if err != nil {
-		return errors.WithStack(err)
+	for w := 0; w < numWorkers; w++ {
+		go sourceWorker(jobCh, resultCh, onConflict, dryRun)
 	}
 
-	doneCh := make(chan bool)
-	errCh := make(chan error)
+	for _, job := range jobs {
+		jobCh <- job
+	}
+	close(jobCh)
+
+	conflicts := make([]SourceConflict, 0)
+	failures := make([]string, 0)
+
+	for finished := 1; finished <= len(jobs); finished++ {
+		result := <-resultCh
+		kappId := result.job.installableObj.FullyQualifiedId()
+		outcome := "acquired"
+
+		if result.err != nil {
+			log.Logger.Warnf("Error acquiring source '%s' of kapp '%s': %+v", result.job.sourceKey,
+				kappId, result.err)
+			failures = append(failures, fmt.Sprintf("%s (source '%s'): %s", kappId,
+				result.job.sourceKey, result.err))
+			outcome = "failed"
+		}
 
-	log.Logger.Infof("Acquiring sources for manifest '%s'", manifestId)
+		if result.conflict != nil {
+			conflicts = append(conflicts, *result.conflict)
+			outcome = fmt.Sprintf("%s, %s", outcome, conflictOutcomes[result.conflict.Resolution])
+		}
 
-	for _, acquirerImpl := range acquirers {
-		go func(a acquirer.Acquirer) {
-			acquirerId, err := a.FullyQualifiedId()
+		if result.lockedSource != nil {
+			stackLock[kappId][result.job.sourceKey] = *result.lockedSource
+		}
+
+		if progress != nil {
+			_, err := fmt.Fprintf(progress, "[%d/%d] %s: source '%s' %s\n", finished, len(jobs),
+				kappId, result.job.sourceKey, outcome)
 			if err != nil {
-				errCh <- errors.Wrap(err, "Invalid acquirer ID")
-				return
+				return nil, nil, errors.WithStack(err)
 			}
+		}
+	}
 
-			// todo - the no-op file acquirer doesn't actually cache files, so we need some object whose job it is
-			// to create cache paths per-acquirer (or a method on each acquirer type)
-			sourceDest := filepath.Join(kappHiddenCacheDir, acquirerId)
-
-			if dryRun {
-				log.Logger.Debugf("Dry run: Would acquire source into '%s'", sourceDest)
-			} else {
-				err := acquirer.Acquire(a, sourceDest)
-				if err != nil {
-					errCh <- errors.WithStack(err)
-					return
-				}
-			}
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return nil, conflicts, errors.New(fmt.Sprintf("Error acquiring %d source(s):\n  %s",
+			len(failures), strings.Join(failures, "\n  ")))
+	}
 
-			// todo - fix creating symlinks when the path is just '/'
-			sourcePath := filepath.Join(sourceDest, a.Path())
-			sourcePath = strings.TrimPrefix(sourcePath, kappTopLevelCacheDir)
-			sourcePath = strings.TrimPrefix(sourcePath, "/")
-
-			var symLinkTarget string
-			if a.Id() != "" {
-				symLinkTarget = filepath.Join(kappTopLevelCacheDir, a.Id())
-			} else {
-				fqId, err := a.FullyQualifiedId()
-				if err != nil {
-					errCh <- errors.WithStack(err)
-					return
-				}
-				symLinkTarget = filepath.Join(kappTopLevelCacheDir, fqId)
-			}
+	log.Logger.Infof("Finished acquiring sources for %d kapp(s)", len(installables))
+
+	return stackLock, conflicts, nil
+}
+
+// Acquires sources until the job channel is closed, sending one result per job
+func sourceWorker(jobCh <-chan sourceJob, resultCh chan<- sourceResult,
+	onConflict acquirer.ConflictPolicy, dryRun bool) {
+	for job := range jobCh {
+		resultCh <- acquireSource(job, onConflict, dryRun)
+	}
+}
+
+// Returns the directory a source is acquired into under a kapp's cache directory
+func sourceCacheDir(kappTopLevelCacheDir string, a acquirer.Acquirer) (string, error) {
+	acquirerId, err := a.FullyQualifiedId()
+	if err != nil {
+		return "", errors.Wrap(err, "Invalid acquirer ID")
+	}
+
+	return filepath.Join(kappTopLevelCacheDir, CacheDir, acquirerId), nil
+}
+
+// Acquires a source and symlinks it to the target path in the kapp's cache directory. The
+// revision is returned if the acquirer supports locking.
+func acquireSource(job sourceJob, onConflict acquirer.ConflictPolicy, dryRun bool) sourceResult {
+	result := sourceResult{job: job}
+	a := job.acquirer
+	kappTopLevelCacheDir := job.installableObj.GetCacheDir()
 
-			var symLinksExist bool
-
-			if _, err := os.Stat(symLinkTarget); err != nil {
-				if os.IsNotExist(err) {
-					log.Logger.Debugf("Symlinks don't exist at '%s'. Will create...", symLinkTarget)
-					symLinksExist = false
-				} else {
-					errCh <- errors.WithStack(err)
-					return
-				}
-			} else {
-				log.Logger.Debugf("Symlinks already exist at '%s'", symLinkTarget)
-				symLinksExist = true
+	// todo - the no-op file acquirer doesn't actually cache files, so we need some object whose job it is
+	// to create cache paths per-acquirer (or a method on each acquirer type)
+	sourceDest, err := sourceCacheDir(kappTopLevelCacheDir, a)
+	if err != nil {
+		result.err = errors.WithStack(err)
+		return result
+	}
+
+	if dryRun {
+		log.Logger.Debugf("Dry run: Would acquire source into '%s'", sourceDest)
+	} else {
+		conflict, err := acquirer.AcquireWithPolicy(a, sourceDest, onConflict)
+		if conflict != nil {
+			result.conflict = &SourceConflict{
+				KappId:    job.installableObj.FullyQualifiedId(),
+				SourceKey: job.sourceKey,
+				Conflict:  *conflict,
 			}
+		}
 
-			if !symLinksExist {
-				if dryRun {
-					log.Logger.Debugf("Dry run. Would symlink cached source %s to %s", sourcePath, symLinkTarget)
-				} else {
-					if _, err := os.Stat(filepath.Join(kappTopLevelCacheDir, sourcePath)); err != nil {
-						errCh <- errors.Wrapf(err, "Symlink source '%s' doesn't exist", sourcePath)
-					}
-
-					log.Logger.Debugf("Symlinking cached source %s to %s", sourcePath, symLinkTarget)
-					err := os.Symlink(sourcePath, symLinkTarget)
-					if err != nil {
-						errCh <- errors.Wrapf(err, "Error symlinking source")
-					}
-				}
+		// conflicts are reported together once everything else has been acquired
+		if _, ok := errors.Cause(err).(*acquirer.ConflictError); ok {
+			err = nil
+		}
+		if err != nil {
+			result.err = errors.WithStack(err)
+			return result
+		}
+
+		updated := conflict == nil || (conflict.Resolution != acquirer.ConflictSkip &&
+			conflict.Resolution != acquirer.ConflictFail)
+
+		if lockable, ok := a.(acquirer.Lockable); ok && updated {
+			revision, err := lockable.Revision(sourceDest)
+			if err != nil {
+				result.err = errors.WithStack(err)
+				return result
 			}
 
-			doneCh <- true
-		}(acquirerImpl)
+			result.lockedSource = &LockedSource{
+				Uri:      a.Uri(),
+				Revision: revision,
+			}
+		}
 	}
 
-	for success := 0; success < len(acquirers); success++ {
-		select {
-		case err := <-errCh:
-			close(doneCh)
-			log.Logger.Warnf("Error in acquirer goroutines: %s", err)
-			return errors.Wrapf(err, "Error running acquirer in goroutine "+
-				"for manifest '%s'", manifestId)
-		case <-doneCh:
-			log.Logger.Infof("%d acquirer(s) successfully completed for manifest '%s'",
-				success+1, manifestId)
+	// todo - fix creating symlinks when the path is just '/'
+	sourcePath := filepath.Join(sourceDest, a.Path())
+	sourcePath = strings.TrimPrefix(sourcePath, kappTopLevelCacheDir)
+	sourcePath = strings.TrimPrefix(sourcePath, "/")
+
+	var symLinkTarget string
+	if a.Id() != "" {
+		symLinkTarget = filepath.Join(kappTopLevelCacheDir, a.Id())
+	} else {
+		fqId, err := a.FullyQualifiedId()
+		if err != nil {
+			result.err = errors.WithStack(err)
+			return result
 		}
+		symLinkTarget = filepath.Join(kappTopLevelCacheDir, fqId)
 	}
 
-	log.Logger.Infof("Finished acquiring sources for manifest '%s'", manifestId)
+	if _, err := os.Stat(symLinkTarget); err == nil {
+		log.Logger.Debugf("Symlinks already exist at '%s'", symLinkTarget)
+		return result
+	} else if !os.IsNotExist(err) {
+		result.err = errors.WithStack(err)
+		return result
+	}
 
-	return nil
+	log.Logger.Debugf("Symlinks don't exist at '%s'. Will create...", symLinkTarget)
+
+	if dryRun {
+		log.Logger.Debugf("Dry run. Would symlink cached source %s to %s", sourcePath, symLinkTarget)
+		return result
+	}
+
+	if _, err := os.Stat(filepath.Join(kappTopLevelCacheDir, sourcePath)); err != nil {
+		result.err = errors.Wrapf(err, "Symlink source '%s' doesn't exist", sourcePath)
+		return result
+	}
+
+	log.Logger.Debugf("Symlinking cached source %s to %s", sourcePath, symLinkTarget)
+	err = os.Symlink(sourcePath, symLinkTarget)
+	if err != nil {
+		result.err = errors.Wrapf(err, "Error symlinking source")
+	}
+
+	return result
 }
 
 // Creates a directory if it doesn't exist
@@ -199,8 +305,3 @@ func createDirectoryIfMissing(path string) error {
 
 	return nil
 }
-
-// Diffs a set of manifests against a cache directory and reports any differences
-//func DiffCache(manifests []kapp.Manifest, cacheDir string) (???, error) {
-// todo - implement
-//}
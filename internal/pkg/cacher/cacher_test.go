@@ -0,0 +1,102 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCacheWorkers(t *testing.T) {
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+
+	config.CurrentConfig = nil
+	assert.Equal(t, defaultCacheWorkers, cacheWorkers(0))
+
+	config.CurrentConfig = &config.Config{CacheWorkers: 3}
+	assert.Equal(t, 3, cacheWorkers(0))
+	assert.Equal(t, 7, cacheWorkers(7))
+}
+
+func TestCacheInstallables(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cacher-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{Id: "nginx"},
+	})
+	assert.Nil(t, err)
+
+	var progress bytes.Buffer
+
+	stackLock, conflicts, err := CacheInstallables([]interfaces.IInstallable{installableObj},
+		tmpDir, acquirer.ConflictFail, 2, &progress, false)
+	assert.Nil(t, err)
+	assert.Empty(t, conflicts)
+	assert.Equal(t, StackLock{"web:nginx": KappLock{}}, stackLock)
+	assert.DirExists(t, installableObj.GetCacheDir())
+}
+
+func TestCacheInstallablesCollectsErrors(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cacher-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	installables := make([]interfaces.IInstallable, 0)
+
+	// file sources that don't exist can't be symlinked into the cache
+	for _, kappId := range []string{"wordpress", "mysql"} {
+		installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+			{
+				Id: kappId,
+				Sources: map[string]structs.Source{
+					kappId: {Uri: "file:///missing/" + kappId},
+				},
+			},
+		})
+		assert.Nil(t, err)
+		installables = append(installables, installableObj)
+	}
+
+	var progress bytes.Buffer
+
+	// every error is reported, not just the first
+	_, conflicts, err := CacheInstallables(installables, tmpDir, acquirer.ConflictFail, 1, &progress, false)
+	assert.NotNil(t, err)
+	assert.NotNil(t, conflicts, "conflicts should be returned with errors so they can be reported")
+	assert.Contains(t, err.Error(), "web:wordpress")
+	assert.Contains(t, err.Error(), "web:mysql")
+
+	lines := strings.Split(strings.TrimSpace(progress.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "[1/2] "))
+	assert.True(t, strings.HasPrefix(lines[1], "[2/2] "))
+	assert.Contains(t, lines[1], "failed")
+}
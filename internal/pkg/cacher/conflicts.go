@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A source in the cache with local modifications that conflicted with updating it
+type SourceConflict struct {
+	KappId    string // fully qualified ID of the kapp
+	SourceKey string
+	acquirer.Conflict
+}
+
+// Describes what happened to local modifications for each resolution policy
+var conflictOutcomes = map[acquirer.ConflictPolicy]string{
+	acquirer.ConflictFail:    "not updated",
+	acquirer.ConflictSkip:    "skipped",
+	acquirer.ConflictStash:   "modifications stashed",
+	acquirer.ConflictDiscard: "modifications discarded",
+}
+
+// Writes a report of sources with local modifications, grouped by kapp
+func WriteConflictReport(out io.Writer, conflicts []SourceConflict) error {
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	sorted := make([]SourceConflict, len(conflicts))
+	copy(sorted, conflicts)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].KappId != sorted[j].KappId {
+			return sorted[i].KappId < sorted[j].KappId
+		}
+		return sorted[i].SourceKey < sorted[j].SourceKey
+	})
+
+	_, err := fmt.Fprintln(out, "Sources with local modifications:")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	previousKappId := ""
+
+	for _, conflict := range sorted {
+		if conflict.KappId != previousKappId {
+			_, err = fmt.Fprintf(out, "  %s:\n", conflict.KappId)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			previousKappId = conflict.KappId
+		}
+
+		_, err = fmt.Fprintf(out, "    %s (%s -> %s): %s\n      %s\n", conflict.SourceKey,
+			conflict.Current, conflict.Wanted, conflictOutcomes[conflict.Resolution],
+			strings.Join(conflict.Modified, "\n      "))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Returns an error if any sources weren't updated because the policy was to fail
+func UnresolvedConflicts(conflicts []SourceConflict) error {
+	unresolved := make([]string, 0)
+
+	for _, conflict := range conflicts {
+		if conflict.Resolution == acquirer.ConflictFail {
+			unresolved = append(unresolved, fmt.Sprintf("%s (source '%s')", conflict.KappId,
+				conflict.SourceKey))
+		}
+	}
+
+	if len(unresolved) == 0 {
+		return nil
+	}
+
+	sort.Strings(unresolved)
+
+	return errors.New(fmt.Sprintf("Local modifications prevented updating sources of kapps: "+
+		"%s. Rerun with '--on-conflict' to skip or stash them, or with '--force' to discard them.",
+		strings.Join(unresolved, ", ")))
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"testing"
+)
+
+func newTestConflict(kappId string, sourceKey string, resolution acquirer.ConflictPolicy) SourceConflict {
+	return SourceConflict{
+		KappId:    kappId,
+		SourceKey: sourceKey,
+		Conflict: acquirer.Conflict{
+			Dest:       "/cache/" + sourceKey,
+			Current:    "master",
+			Wanted:     "develop",
+			Modified:   []string{"values.yaml"},
+			Resolution: resolution,
+		},
+	}
+}
+
+func TestWriteConflictReport(t *testing.T) {
+	var out bytes.Buffer
+
+	assert.Nil(t, WriteConflictReport(&out, []SourceConflict{}))
+	assert.Empty(t, out.String())
+
+	assert.Nil(t, WriteConflictReport(&out, []SourceConflict{
+		newTestConflict("web:wordpress", "wordpress", acquirer.ConflictStash),
+		newTestConflict("db:mysql", "mysql", acquirer.ConflictFail),
+		newTestConflict("web:wordpress", "chart", acquirer.ConflictSkip),
+	}))
+
+	expected := `Sources with local modifications:
+  db:mysql:
+    mysql (master -> develop): not updated
+      values.yaml
+  web:wordpress:
+    chart (master -> develop): skipped
+      values.yaml
+    wordpress (master -> develop): modifications stashed
+      values.yaml
+`
+	assert.Equal(t, expected, out.String())
+}
+
+func TestUnresolvedConflicts(t *testing.T) {
+	assert.Nil(t, UnresolvedConflicts([]SourceConflict{
+		newTestConflict("web:wordpress", "wordpress", acquirer.ConflictSkip),
+		newTestConflict("web:wordpress", "chart", acquirer.ConflictDiscard),
+	}))
+
+	assert.NotNil(t, UnresolvedConflicts([]SourceConflict{
+		newTestConflict("web:wordpress", "wordpress", acquirer.ConflictSkip),
+		newTestConflict("db:mysql", "mysql", acquirer.ConflictFail),
+	}))
+}
+
+func TestStackLockKeepSkipped(t *testing.T) {
+	previous := StackLock{
+		"web:wordpress": KappLock{
+			"wordpress": {Uri: testSourceUri, Revision: testRevision},
+		},
+	}
+
+	stackLock := StackLock{"web:wordpress": KappLock{}}
+	stackLock.KeepSkipped(previous, []SourceConflict{
+		newTestConflict("web:wordpress", "wordpress", acquirer.ConflictSkip),
+	})
+	assert.Equal(t, previous, stackLock)
+
+	// stashed sources were updated so their new revisions are locked
+	stackLock = StackLock{"web:wordpress": KappLock{}}
+	stackLock.KeepSkipped(previous, []SourceConflict{
+		newTestConflict("web:wordpress", "wordpress", acquirer.ConflictStash),
+	})
+	assert.Empty(t, stackLock["web:wordpress"])
+}
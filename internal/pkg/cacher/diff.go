@@ -0,0 +1,253 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of difference between a cache and the manifests it should contain
+type DiffKind string
+
+const (
+	DiffMissingKapp   DiffKind = "missing-kapp"   // a kapp in a manifest isn't in the cache
+	DiffExtraKapp     DiffKind = "extra-kapp"     // a kapp in the cache isn't in any manifest
+	DiffMissingSource DiffKind = "missing-source" // a source of a cached kapp hasn't been acquired
+	DiffWrongRevision DiffKind = "wrong-revision" // a source has a different branch, tag or commit checked out
+	DiffModified      DiffKind = "modified"       // a source has uncommitted changes
+	DiffUnpushed      DiffKind = "unpushed"       // a source has commits that haven't been pushed
+)
+
+// A difference between a cache and the manifests it should contain
+type Difference struct {
+	Kind      DiffKind `json:"kind"`
+	KappId    string   `json:"kapp"`
+	SourceKey string   `json:"source,omitempty"`
+	Path      string   `json:"path"`
+	Wanted    string   `json:"wanted,omitempty"`
+	Current   string   `json:"current,omitempty"`
+	Files     []string `json:"files,omitempty"`
+	Commits   int      `json:"commits,omitempty"`
+}
+
+// Returns a human-readable description of the difference
+func (d Difference) String() string {
+	switch d.Kind {
+	case DiffMissingKapp:
+		return fmt.Sprintf("%s: not in the cache", d.KappId)
+	case DiffExtraKapp:
+		return fmt.Sprintf("%s: in the cache at '%s' but not in any manifest", d.KappId, d.Path)
+	case DiffMissingSource:
+		return fmt.Sprintf("%s: source '%s' hasn't been acquired", d.KappId, d.SourceKey)
+	case DiffWrongRevision:
+		return fmt.Sprintf("%s: source '%s' has '%s' checked out instead of '%s'", d.KappId,
+			d.SourceKey, d.Current, d.Wanted)
+	case DiffModified:
+		return fmt.Sprintf("%s: source '%s' has uncommitted changes to: %s", d.KappId,
+			d.SourceKey, strings.Join(d.Files, ", "))
+	case DiffUnpushed:
+		return fmt.Sprintf("%s: source '%s' has %d unpushed commit(s) on '%s'", d.KappId,
+			d.SourceKey, d.Commits, d.Current)
+	}
+
+	return fmt.Sprintf("%s: %s", d.KappId, d.Kind)
+}
+
+// Compares a cache against the installables that should be in it. Sources are inspected as
+// they were when they were last acquired, so remotes aren't contacted.
+func DiffCache(installables []interfaces.IInstallable, rootCacheDir string) ([]Difference, error) {
+	differences := make([]Difference, 0)
+	declared := map[string]bool{}
+
+	for _, installableObj := range installables {
+		kappDifferences, err := diffInstallable(installableObj, rootCacheDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		differences = append(differences, kappDifferences...)
+		declared[installableObj.GetCacheDir()] = true
+	}
+
+	// kapp cache dirs are absolute
+	absRootCacheDir, err := filepath.Abs(rootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	kappDirs, err := cachedKappDirs(absRootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for kappId, kappDir := range kappDirs {
+		if !declared[kappDir] {
+			differences = append(differences, Difference{
+				Kind:   DiffExtraKapp,
+				KappId: kappId,
+				Path:   kappDir,
+			})
+		}
+	}
+
+	sort.SliceStable(differences, func(i, j int) bool {
+		if differences[i].KappId != differences[j].KappId {
+			return differences[i].KappId < differences[j].KappId
+		}
+		if differences[i].SourceKey != differences[j].SourceKey {
+			return differences[i].SourceKey < differences[j].SourceKey
+		}
+		return differences[i].Kind < differences[j].Kind
+	})
+
+	return differences, nil
+}
+
+// Returns the differences between an installable and its sources in the cache
+func diffInstallable(installableObj interfaces.IInstallable, rootCacheDir string) ([]Difference, error) {
+	differences := make([]Difference, 0)
+	kappId := installableObj.FullyQualifiedId()
+
+	err := installableObj.SetTopLevelCacheDir(rootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	kappDir := installableObj.GetCacheDir()
+
+	if _, err := os.Stat(kappDir); err != nil {
+		if os.IsNotExist(err) {
+			return append(differences, Difference{
+				Kind:   DiffMissingKapp,
+				KappId: kappId,
+				Path:   kappDir,
+			}), nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	acquirers, err := installableObj.Acquirers()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for sourceKey, acquirerObj := range acquirers {
+		sourceDest, err := sourceCacheDir(kappDir, acquirerObj)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		difference := Difference{
+			KappId:    kappId,
+			SourceKey: sourceKey,
+			Path:      sourceDest,
+		}
+
+		if _, err := os.Stat(sourceDest); err != nil {
+			if os.IsNotExist(err) {
+				difference.Kind = DiffMissingSource
+				differences = append(differences, difference)
+				continue
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		inspectable, ok := acquirerObj.(acquirer.Inspectable)
+		if !ok {
+			log.Logger.Debugf("Can't inspect source '%s' of kapp '%s'", sourceKey, kappId)
+			continue
+		}
+
+		state, err := inspectable.Inspect(sourceDest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error inspecting source '%s' of kapp '%s'",
+				sourceKey, kappId)
+		}
+
+		difference.Wanted = state.Wanted
+		difference.Current = state.Current
+
+		if !state.IsWanted {
+			difference.Kind = DiffWrongRevision
+			differences = append(differences, difference)
+		}
+
+		if len(state.Modified) > 0 {
+			difference.Kind = DiffModified
+			difference.Files = state.Modified
+			differences = append(differences, difference)
+			difference.Files = nil
+		}
+
+		if state.Ahead > 0 {
+			difference.Kind = DiffUnpushed
+			difference.Commits = state.Ahead
+			differences = append(differences, difference)
+		}
+	}
+
+	return differences, nil
+}
+
+// Returns the directories of kapps in a cache keyed by their fully qualified IDs. Caches
+// contain a directory per manifest containing a directory per kapp.
+func cachedKappDirs(rootCacheDir string) (map[string]string, error) {
+	kappDirs := map[string]string{}
+
+	manifestDirs, err := ioutil.ReadDir(rootCacheDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return kappDirs, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	for _, manifestDir := range manifestDirs {
+		if !manifestDir.IsDir() || strings.HasPrefix(manifestDir.Name(), ".") {
+			continue
+		}
+
+		manifestPath := filepath.Join(rootCacheDir, manifestDir.Name())
+
+		entries, err := ioutil.ReadDir(manifestPath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, entry := range entries {
+			if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			kappId := strings.Join([]string{manifestDir.Name(), entry.Name()},
+				constants.NamespaceSeparator)
+			kappDirs[kappId] = filepath.Join(manifestPath, entry.Name())
+		}
+	}
+
+	return kappDirs, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiffCache(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-diff-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	installables := make([]interfaces.IInstallable, 0)
+
+	for _, kappId := range []string{"wordpress", "mysql"} {
+		installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+			{
+				Id: kappId,
+				Sources: map[string]structs.Source{
+					kappId: {Uri: "file:///kapps/" + kappId},
+				},
+			},
+		})
+		assert.Nil(t, err)
+		installables = append(installables, installableObj)
+	}
+
+	// wordpress is cached but its source isn't, mysql isn't cached at all and nginx has been
+	// removed from the manifest
+	assert.Nil(t, os.MkdirAll(filepath.Join(tmpDir, "web", "wordpress", CacheDir), 0755))
+	assert.Nil(t, os.MkdirAll(filepath.Join(tmpDir, "web", "nginx"), 0755))
+
+	differences, err := DiffCache(installables, tmpDir)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []Difference{
+		{
+			Kind:   DiffMissingKapp,
+			KappId: "web:mysql",
+			Path:   filepath.Join(tmpDir, "web", "mysql"),
+		},
+		{
+			Kind:   DiffExtraKapp,
+			KappId: "web:nginx",
+			Path:   filepath.Join(tmpDir, "web", "nginx"),
+		},
+		{
+			Kind:      DiffMissingSource,
+			KappId:    "web:wordpress",
+			SourceKey: "wordpress",
+			Path:      filepath.Join(tmpDir, "web", "wordpress", CacheDir, "wordpress"),
+		},
+	}, differences)
+
+	assert.Equal(t, "web:wordpress: source 'wordpress' hasn't been acquired",
+		differences[2].String())
+}
+
+func TestDifferenceString(t *testing.T) {
+	difference := Difference{
+		Kind:      DiffWrongRevision,
+		KappId:    "web:wordpress",
+		SourceKey: "wordpress",
+		Wanted:    "master",
+		Current:   "develop",
+	}
+	assert.Equal(t, "web:wordpress: source 'wordpress' has 'develop' checked out instead of "+
+		"'master'", difference.String())
+
+	difference.Kind = DiffUnpushed
+	difference.Commits = 2
+	assert.Equal(t, "web:wordpress: source 'wordpress' has 2 unpushed commit(s) on 'develop'",
+		difference.String())
+}
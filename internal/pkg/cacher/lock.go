@@ -0,0 +1,322 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const LockFileName = "sugarkube.lock"
+
+const lockFileHeader = "# This file is generated by sugarkube. Update it with `sugarkube cache lock --update`.\n"
+
+// The exact revision a source was acquired at
+type LockedSource struct {
+	Uri      string `yaml:"uri"`
+	Revision string `yaml:"revision"`
+}
+
+// Locked sources for a kapp keyed by source ID
+type KappLock map[string]LockedSource
+
+// Locked kapps keyed by their fully qualified IDs
+type StackLock map[string]KappLock
+
+// Locked remote manifests keyed by manifest ID
+type ManifestLock map[string]LockedSource
+
+// Locked stacks and their remote manifests keyed by stack name
+type LockFile struct {
+	Stacks    map[string]StackLock    `yaml:"stacks"`
+	Manifests map[string]ManifestLock `yaml:"manifests,omitempty"`
+}
+
+// Returns the path to the lock file for a stack file. Lock files are stored next to the stack file.
+func LockFilePath(stackFile string) string {
+	return filepath.Join(filepath.Dir(stackFile), LockFileName)
+}
+
+// Loads a lock file. An empty lock file is returned if it doesn't exist.
+func LoadLockFile(path string) (*LockFile, error) {
+	lockFile := &LockFile{
+		Stacks:    map[string]StackLock{},
+		Manifests: map[string]ManifestLock{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Logger.Debugf("Lock file '%s' doesn't exist", path)
+			return lockFile, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	err = yaml.Unmarshal(data, lockFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing lock file '%s'", path)
+	}
+
+	if lockFile.Stacks == nil {
+		lockFile.Stacks = map[string]StackLock{}
+	}
+
+	if lockFile.Manifests == nil {
+		lockFile.Manifests = map[string]ManifestLock{}
+	}
+
+	return lockFile, nil
+}
+
+// Returns the contents of the lock file
+func (l *LockFile) Marshal() ([]byte, error) {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return append([]byte(lockFileHeader), data...), nil
+}
+
+// Writes the lock file to the given path
+func (l *LockFile) Save(path string) error {
+	data, err := l.Marshal()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Logger.Infof("Writing lock file to '%s'", path)
+
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "Error writing lock file '%s'", path)
+	}
+
+	return nil
+}
+
+// Updates the locked revisions for kapps in a stack, creating the stack if necessary
+func (l *LockFile) Update(stackName string, stackLock StackLock) {
+	existing, ok := l.Stacks[stackName]
+	if !ok {
+		existing = StackLock{}
+		l.Stacks[stackName] = existing
+	}
+
+	for kappId, kappLock := range stackLock {
+		existing[kappId] = kappLock
+	}
+}
+
+// Records the revisions of the remote manifests in a stack. Unless `relock` is true, manifests
+// that are already locked keep their locked revisions and only new manifests are added, so
+// locking some kapps doesn't move the revisions the others were locked with.
+func (l *LockFile) UpdateManifests(stackName string, manifestLock ManifestLock, relock bool) {
+	existing, ok := l.Manifests[stackName]
+	if relock || !ok {
+		l.Manifests[stackName] = manifestLock
+		return
+	}
+
+	for manifestId, lockedSource := range manifestLock {
+		if previous, ok := existing[manifestId]; ok && previous.Uri == lockedSource.Uri {
+			continue
+		}
+		existing[manifestId] = lockedSource
+	}
+}
+
+// Copies the previously locked revisions of sources that were skipped because of conflicts
+// with local modifications since they weren't updated
+func (s StackLock) KeepSkipped(previous StackLock, conflicts []SourceConflict) {
+	for _, conflict := range conflicts {
+		if conflict.Resolution != acquirer.ConflictSkip {
+			continue
+		}
+
+		lockedSource, ok := previous[conflict.KappId][conflict.SourceKey]
+		if !ok {
+			continue
+		}
+
+		if _, ok := s[conflict.KappId]; !ok {
+			s[conflict.KappId] = KappLock{}
+		}
+		s[conflict.KappId][conflict.SourceKey] = lockedSource
+	}
+}
+
+// Returns the locked revisions of the remote manifests in a stack keyed by their source URIs
+func (l *LockFile) ManifestRevisions(stackName string) map[string]string {
+	revisions := map[string]string{}
+
+	for _, lockedSource := range l.Manifests[stackName] {
+		revisions[lockedSource.Uri] = lockedSource.Revision
+	}
+
+	return revisions
+}
+
+// Returns the revisions of the remote manifests in a stack
+func LockManifests(manifests []interfaces.IManifest) ManifestLock {
+	manifestLock := ManifestLock{}
+
+	for _, manifest := range manifests {
+		if manifest.Revision() == "" {
+			continue
+		}
+
+		manifestLock[manifest.Id()] = LockedSource{
+			Uri:      manifest.Uri(),
+			Revision: manifest.Revision(),
+		}
+	}
+
+	return manifestLock
+}
+
+// Pins the sources of each installable in the given manifests to the revisions in the lock
+// file. Returns an error if the lock file is missing a source or if a source's URI has changed
+// since it was locked since that means the lock file is out of date. Remote manifests must
+// already have been acquired at their locked revisions.
+func (l *LockFile) Apply(stackName string, manifests []interfaces.IManifest) error {
+	stackLock, ok := l.Stacks[stackName]
+	if !ok {
+		return errors.New(fmt.Sprintf("Stack '%s' isn't in the lock file. Run "+
+			"`cache lock --update` to lock it", stackName))
+	}
+
+	manifestLock := l.Manifests[stackName]
+
+	for _, manifest := range manifests {
+		// remote manifests should already have been acquired at their locked revisions
+		if manifest.Revision() != "" {
+			lockedSource, ok := manifestLock[manifest.Id()]
+			if !ok || lockedSource.Uri != manifest.Uri() || lockedSource.Revision != manifest.Revision() {
+				return errors.New(fmt.Sprintf("The lock file is out of date for manifest '%s'. "+
+					"Run `cache lock --update` to update it", manifest.Id()))
+			}
+		}
+
+		for _, installableObj := range manifest.Installables() {
+			err := stackLock.apply(installableObj)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Adds a descriptor to the installable pinning each lockable source to its locked revision
+func (s StackLock) apply(installableObj interfaces.IInstallable) error {
+	acquirers, err := installableObj.Acquirers()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	kappLock := s[installableObj.FullyQualifiedId()]
+
+	sources := map[string]structs.Source{}
+
+	for sourceKey, acquirerObj := range acquirers {
+		if _, ok := acquirerObj.(acquirer.Lockable); !ok {
+			continue
+		}
+
+		lockedSource, ok := kappLock[sourceKey]
+		if !ok {
+			return errors.New(fmt.Sprintf("Source '%s' of kapp '%s' isn't in the lock file. "+
+				"Run `cache lock --update` to lock it", sourceKey, installableObj.FullyQualifiedId()))
+		}
+
+		if lockedSource.Uri != acquirerObj.Uri() {
+			return errors.New(fmt.Sprintf("The lock file is out of date. Source '%s' of kapp "+
+				"'%s' was locked for '%s' but is now '%s'. Run `cache lock --update` to update "+
+				"it", sourceKey, installableObj.FullyQualifiedId(), lockedSource.Uri,
+				acquirerObj.Uri()))
+		}
+
+		log.Logger.Debugf("Pinning source '%s' of kapp '%s' to locked revision '%s'",
+			sourceKey, installableObj.FullyQualifiedId(), lockedSource.Revision)
+
+		sources[sourceKey] = structs.Source{
+			Options: map[string]interface{}{
+				acquirer.RevisionKey: lockedSource.Revision,
+			},
+		}
+	}
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	return installableObj.AddDescriptor(structs.KappDescriptorWithMaps{
+		Sources:    sources,
+		DeclaredIn: fmt.Sprintf("the locked revisions in %s", LockFileName),
+	}, false)
+}
+
+// Returns the revisions of the sources of an installable that have already been cached
+func CachedRevisions(installableObj interfaces.IInstallable, rootCacheDir string) (KappLock, error) {
+	err := installableObj.SetTopLevelCacheDir(rootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	acquirers, err := installableObj.Acquirers()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	kappLock := KappLock{}
+
+	for sourceKey, acquirerObj := range acquirers {
+		lockable, ok := acquirerObj.(acquirer.Lockable)
+		if !ok {
+			continue
+		}
+
+		sourceDest, err := sourceCacheDir(installableObj.GetCacheDir(), acquirerObj)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		revision, err := lockable.Revision(sourceDest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error getting the revision of source '%s' of "+
+				"kapp '%s'. Has it been cached?", sourceKey, installableObj.FullyQualifiedId())
+		}
+
+		kappLock[sourceKey] = LockedSource{
+			Uri:      acquirerObj.Uri(),
+			Revision: revision,
+		}
+	}
+
+	return kappLock, nil
+}
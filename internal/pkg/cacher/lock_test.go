@@ -0,0 +1,211 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSourceUri = "git@github.com:sugarkube/kapps.git//incubator/wordpress#master"
+const testRevision = "0123456789abcdef0123456789abcdef01234567"
+
+func init() {
+	log.ConfigureLogger("debug", false)
+}
+
+func newTestInstallable(t *testing.T) interfaces.IInstallable {
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id: "wordpress",
+			Sources: map[string]structs.Source{
+				"wordpress": {Uri: testSourceUri},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	return installableObj
+}
+
+func TestLockFileRoundTrip(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "lock-file-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	path := LockFilePath(filepath.Join(tmpDir, "stacks.yaml"))
+	assert.Equal(t, filepath.Join(tmpDir, LockFileName), path)
+
+	// missing lock files are empty
+	lockFile, err := LoadLockFile(path)
+	assert.Nil(t, err)
+	assert.Empty(t, lockFile.Stacks)
+
+	lockFile.Update("dev", StackLock{
+		"web:wordpress": KappLock{
+			"wordpress": {Uri: testSourceUri, Revision: testRevision},
+		},
+	})
+	lockFile.Update("dev", StackLock{
+		"web:nginx": KappLock{},
+	})
+	assert.Nil(t, lockFile.Save(path))
+
+	loaded, err := LoadLockFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, lockFile, loaded)
+	assert.Len(t, loaded.Stacks["dev"], 2)
+}
+
+func TestStackLockApply(t *testing.T) {
+	installableObj := newTestInstallable(t)
+
+	stackLock := StackLock{
+		"web:wordpress": KappLock{
+			"wordpress": {Uri: testSourceUri, Revision: testRevision},
+		},
+	}
+	assert.Nil(t, stackLock.apply(installableObj))
+
+	source := installableObj.GetDescriptor().Sources["wordpress"]
+	assert.Equal(t, testSourceUri, source.Uri)
+	assert.Equal(t, testRevision, source.Options[acquirer.RevisionKey])
+}
+
+func TestStackLockApplyErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		stackLock StackLock
+	}{
+		{
+			name:      "missing_kapp",
+			stackLock: StackLock{},
+		},
+		{
+			name: "missing_source",
+			stackLock: StackLock{
+				"web:wordpress": KappLock{},
+			},
+		},
+		{
+			name: "changed_uri",
+			stackLock: StackLock{
+				"web:wordpress": KappLock{
+					"wordpress": {
+						Uri:      "git@github.com:sugarkube/kapps.git//incubator/wordpress#develop",
+						Revision: testRevision,
+					},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		err := test.stackLock.apply(newTestInstallable(t))
+		assert.NotNil(t, err, "Expected an error in test '%s'", test.name)
+	}
+}
+
+type testManifest struct {
+	id           string
+	uri          string
+	revision     string
+	cacheDir     string
+	installables []interfaces.IInstallable
+}
+
+func (m testManifest) Id() string                              { return m.id }
+func (m testManifest) Installables() []interfaces.IInstallable { return m.installables }
+func (m testManifest) IsSequential() bool                      { return false }
+func (m testManifest) Uri() string                             { return m.uri }
+func (m testManifest) Revision() string                        { return m.revision }
+func (m testManifest) CacheDir() string                        { return m.cacheDir }
+
+func TestLockManifests(t *testing.T) {
+	remoteManifest := testManifest{
+		id:       "web",
+		uri:      "git@github.com:sugarkube/kapps.git//manifests#master",
+		revision: testRevision,
+	}
+
+	manifests := []interfaces.IManifest{
+		remoteManifest,
+		testManifest{id: "local", uri: "/stacks/manifests/local.yaml"},
+	}
+
+	// local manifests aren't locked
+	manifestLock := LockManifests(manifests)
+	assert.Equal(t, ManifestLock{
+		"web": {Uri: remoteManifest.uri, Revision: testRevision},
+	}, manifestLock)
+
+	lockFile := &LockFile{
+		Stacks:    map[string]StackLock{"dev": {}},
+		Manifests: map[string]ManifestLock{"dev": manifestLock},
+	}
+	assert.Equal(t, map[string]string{remoteManifest.uri: testRevision},
+		lockFile.ManifestRevisions("dev"))
+	assert.Nil(t, lockFile.Apply("dev", manifests))
+
+	// manifests acquired at a different revision mean the lock file is out of date
+	remoteManifest.revision = "fedcba9876543210fedcba9876543210fedcba98"
+	assert.NotNil(t, lockFile.Apply("dev", []interfaces.IManifest{remoteManifest}))
+}
+
+func TestLockFileUpdateManifests(t *testing.T) {
+	newRevision := "fedcba9876543210fedcba9876543210fedcba98"
+	webUri := "git@github.com:sugarkube/kapps.git//manifests/web.yaml#master"
+
+	lockFile := &LockFile{Manifests: map[string]ManifestLock{}}
+
+	// the first lock records every manifest
+	lockFile.UpdateManifests("dev", ManifestLock{
+		"web": {Uri: webUri, Revision: testRevision},
+	}, false)
+	assert.Equal(t, map[string]string{webUri: testRevision}, lockFile.ManifestRevisions("dev"))
+
+	// e.g. locking a single kapp keeps locked manifests at their revisions but adds new ones
+	dataUri := "git@github.com:sugarkube/kapps.git//manifests/data.yaml#master"
+	lockFile.UpdateManifests("dev", ManifestLock{
+		"web":  {Uri: webUri, Revision: newRevision},
+		"data": {Uri: dataUri, Revision: newRevision},
+	}, false)
+	assert.Equal(t, map[string]string{webUri: testRevision, dataUri: newRevision},
+		lockFile.ManifestRevisions("dev"))
+
+	// manifests whose URI changed are relocked
+	webUri = "git@github.com:sugarkube/kapps.git//manifests/web.yaml#stable"
+	lockFile.UpdateManifests("dev", ManifestLock{
+		"web": {Uri: webUri, Revision: newRevision},
+	}, false)
+	assert.Equal(t, map[string]string{webUri: newRevision, dataUri: newRevision},
+		lockFile.ManifestRevisions("dev"))
+
+	// relocking replaces everything
+	lockFile.UpdateManifests("dev", ManifestLock{
+		"web": {Uri: webUri, Revision: testRevision},
+	}, true)
+	assert.Equal(t, map[string]string{webUri: testRevision}, lockFile.ManifestRevisions("dev"))
+}
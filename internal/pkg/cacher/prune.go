@@ -0,0 +1,297 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Kinds of things in a cache that can be pruned
+type PruneKind string
+
+const (
+	PruneKapp    PruneKind = "kapp"    // a kapp directory for a kapp that's no longer in the stack
+	PruneSource  PruneKind = "source"  // a source that's no longer used by its kapp
+	PruneSymlink PruneKind = "symlink" // a symlink to a source that doesn't exist
+)
+
+// Something in a cache that's no longer referenced by the stack
+type PruneCandidate struct {
+	Kind      PruneKind
+	KappId    string
+	Path      string
+	LocalWork []string // descriptions of uncommitted work that prevent removing the path
+}
+
+// Returns a human-readable description of the candidate
+func (p PruneCandidate) String() string {
+	switch p.Kind {
+	case PruneKapp:
+		return fmt.Sprintf("kapp '%s' at '%s'", p.KappId, p.Path)
+	case PruneSource:
+		return fmt.Sprintf("unused source of kapp '%s' at '%s'", p.KappId, p.Path)
+	}
+
+	return fmt.Sprintf("dangling symlink in kapp '%s' at '%s'", p.KappId, p.Path)
+}
+
+// Returns kapp directories, sources and symlinks in a cache that aren't referenced by the
+// given installables. Candidates containing git checkouts with uncommitted work have their
+// `LocalWork` set.
+func FindPrunable(installables []interfaces.IInstallable, rootCacheDir string) ([]PruneCandidate, error) {
+	candidates := make([]PruneCandidate, 0)
+	declared := map[string]bool{}
+
+	for _, installableObj := range installables {
+		kappCandidates, err := findPrunableInKapp(installableObj, rootCacheDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		candidates = append(candidates, kappCandidates...)
+		declared[installableObj.GetCacheDir()] = true
+	}
+
+	absRootCacheDir, err := filepath.Abs(rootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	kappDirs, err := cachedKappDirs(absRootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for kappId, kappDir := range kappDirs {
+		if declared[kappDir] {
+			continue
+		}
+
+		candidate := PruneCandidate{
+			Kind:   PruneKapp,
+			KappId: kappId,
+			Path:   kappDir,
+		}
+
+		sourceDirs, err := subdirectories(filepath.Join(kappDir, CacheDir))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, sourceDir := range sourceDirs {
+			localWork, err := acquirer.LocalWork(sourceDir)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error checking '%s' for local work", sourceDir)
+			}
+
+			for _, work := range localWork {
+				candidate.LocalWork = append(candidate.LocalWork, fmt.Sprintf("%s: %s",
+					filepath.Base(sourceDir), work))
+			}
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Path < candidates[j].Path
+	})
+
+	return candidates, nil
+}
+
+// Returns the sources and symlinks in an installable's cache directory that aren't used
+func findPrunableInKapp(installableObj interfaces.IInstallable, rootCacheDir string) ([]PruneCandidate, error) {
+	candidates := make([]PruneCandidate, 0)
+	kappId := installableObj.FullyQualifiedId()
+
+	err := installableObj.SetTopLevelCacheDir(rootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	kappDir := installableObj.GetCacheDir()
+
+	acquirers, err := installableObj.Acquirers()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	used := map[string]bool{}
+	for _, acquirerObj := range acquirers {
+		sourceDest, err := sourceCacheDir(kappDir, acquirerObj)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		used[sourceDest] = true
+	}
+
+	sourceDirs, err := subdirectories(filepath.Join(kappDir, CacheDir))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	unused := make([]string, 0)
+
+	for _, sourceDir := range sourceDirs {
+		if used[sourceDir] {
+			continue
+		}
+
+		localWork, err := acquirer.LocalWork(sourceDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error checking '%s' for local work", sourceDir)
+		}
+
+		candidates = append(candidates, PruneCandidate{
+			Kind:      PruneSource,
+			KappId:    kappId,
+			Path:      sourceDir,
+			LocalWork: localWork,
+		})
+
+		if len(localWork) == 0 {
+			unused = append(unused, sourceDir)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(kappDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return candidates, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	// symlinks are relative to the kapp's cache dir
+	for _, entry := range entries {
+		if entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		path := filepath.Join(kappDir, entry.Name())
+
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(kappDir, target)
+		}
+
+		dangling := isWithinAny(target, unused)
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			dangling = true
+		}
+
+		if dangling {
+			candidates = append(candidates, PruneCandidate{
+				Kind:   PruneSymlink,
+				KappId: kappId,
+				Path:   path,
+			})
+		}
+	}
+
+	return candidates, nil
+}
+
+// Removes candidates that don't contain local work, then any manifest directories left empty.
+// Returns the candidates that were removed.
+func Prune(candidates []PruneCandidate, rootCacheDir string) ([]PruneCandidate, error) {
+	removed := make([]PruneCandidate, 0)
+
+	for _, candidate := range candidates {
+		if len(candidate.LocalWork) > 0 {
+			log.Logger.Warnf("Not removing %s because it contains local work", candidate)
+			continue
+		}
+
+		log.Logger.Infof("Removing %s", candidate)
+
+		err := os.RemoveAll(candidate.Path)
+		if err != nil {
+			return removed, errors.Wrapf(err, "Error removing '%s'", candidate.Path)
+		}
+
+		removed = append(removed, candidate)
+	}
+
+	manifestDirs, err := subdirectories(rootCacheDir)
+	if err != nil {
+		return removed, errors.WithStack(err)
+	}
+
+	for _, manifestDir := range manifestDirs {
+		entries, err := ioutil.ReadDir(manifestDir)
+		if err != nil {
+			return removed, errors.WithStack(err)
+		}
+
+		if len(entries) == 0 {
+			log.Logger.Infof("Removing empty manifest directory '%s'", manifestDir)
+			err = os.Remove(manifestDir)
+			if err != nil {
+				return removed, errors.WithStack(err)
+			}
+		}
+	}
+
+	return removed, nil
+}
+
+// Returns the paths of the directories in a directory, or nothing if it doesn't exist
+func subdirectories(dir string) ([]string, error) {
+	subdirs := make([]string, 0)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return subdirs, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			subdirs = append(subdirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return subdirs, nil
+}
+
+// Returns whether a path is any of the directories or inside one of them
+func isWithinAny(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
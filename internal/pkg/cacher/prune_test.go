@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrune(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-prune-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id: "wordpress",
+			Sources: map[string]structs.Source{
+				"wordpress": {Uri: "file:///kapps/wordpress"},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	kappDir := filepath.Join(tmpDir, "web", "wordpress")
+	usedSource := filepath.Join(kappDir, CacheDir, "wordpress")
+	unusedSource := filepath.Join(kappDir, CacheDir, "old")
+	dirtySource := filepath.Join(kappDir, CacheDir, "dirty")
+	cleanArchive := filepath.Join(kappDir, CacheDir, "clean-archive")
+	editedArchive := filepath.Join(kappDir, CacheDir, "edited-archive")
+
+	for _, dir := range []string{usedSource, unusedSource, dirtySource, cleanArchive, editedArchive,
+		filepath.Join(tmpDir, "web", "nginx"), filepath.Join(tmpDir, "old", "mysql")} {
+		assert.Nil(t, os.MkdirAll(dir, 0755))
+	}
+
+	// the dirty source is a git checkout with an untracked file
+	var stdoutBuf, stderrBuf bytes.Buffer
+	assert.Nil(t, utils.ExecCommand(acquirer.GitPath, []string{"init"}, map[string]string{},
+		&stdoutBuf, &stderrBuf, dirtySource, 5, false))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dirtySource, "work.txt"), []byte("work"), 0644))
+
+	// archives record the digests of the files extracted from them
+	for _, dir := range []string{cleanArchive, editedArchive} {
+		valuesPath := filepath.Join(dir, "values.yaml")
+		assert.Nil(t, ioutil.WriteFile(valuesPath, []byte("replicas: 1"), 0644))
+		digest, err := utils.FileSha256(valuesPath)
+		assert.Nil(t, err)
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, acquirer.ArchiveFilesFile),
+			[]byte(digest+"  values.yaml\n"), 0644))
+	}
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(editedArchive, "values.yaml"),
+		[]byte("replicas: 3"), 0644))
+
+	assert.Nil(t, os.Symlink(".sugarkube/wordpress", filepath.Join(kappDir, "wordpress")))
+	assert.Nil(t, os.Symlink(".sugarkube/old", filepath.Join(kappDir, "old")))
+	assert.Nil(t, os.Symlink(".sugarkube/missing", filepath.Join(kappDir, "missing")))
+
+	candidates, err := FindPrunable([]interfaces.IInstallable{installableObj}, tmpDir)
+	assert.Nil(t, err)
+
+	paths := map[string]PruneCandidate{}
+	for _, candidate := range candidates {
+		paths[candidate.Path] = candidate
+	}
+
+	assert.Len(t, candidates, 8)
+	assert.Equal(t, PruneKapp, paths[filepath.Join(tmpDir, "web", "nginx")].Kind)
+	assert.Equal(t, PruneKapp, paths[filepath.Join(tmpDir, "old", "mysql")].Kind)
+	assert.Equal(t, PruneSource, paths[unusedSource].Kind)
+	assert.Equal(t, PruneSymlink, paths[filepath.Join(kappDir, "old")].Kind)
+	assert.Equal(t, PruneSymlink, paths[filepath.Join(kappDir, "missing")].Kind)
+	assert.Equal(t, []string{"uncommitted changes to 'work.txt'"}, paths[dirtySource].LocalWork)
+	assert.Empty(t, paths[cleanArchive].LocalWork)
+	assert.Equal(t, []string{"changes to 'values.yaml' since it was extracted"},
+		paths[editedArchive].LocalWork)
+
+	// sources are inspected with the configured git acquirer
+	originalConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{GitAcquirer: acquirer.GoGitAcquirerName}
+	goGitCandidates, err := FindPrunable([]interfaces.IInstallable{installableObj}, tmpDir)
+	config.CurrentConfig = originalConfig
+	assert.Nil(t, err)
+	assert.Equal(t, candidates, goGitCandidates)
+
+	removed, err := Prune(candidates, tmpDir)
+	assert.Nil(t, err)
+	assert.Len(t, removed, 6)
+
+	// work is never lost
+	assert.FileExists(t, filepath.Join(dirtySource, "work.txt"))
+	assert.FileExists(t, filepath.Join(editedArchive, "values.yaml"))
+
+	_, err = os.Stat(cleanArchive)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = os.Stat(unusedSource)
+	assert.True(t, os.IsNotExist(err))
+
+	// the manifest directory is removed once it's empty
+	_, err = os.Stat(filepath.Join(tmpDir, "old"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.DirExists(t, usedSource)
+	_, err = os.Lstat(filepath.Join(kappDir, "wordpress"))
+	assert.Nil(t, err)
+}
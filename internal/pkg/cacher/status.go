@@ -0,0 +1,303 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Returns the vars to render an installable's templates with
+type TemplateVarsFunc func(installableObj interfaces.IInstallable) (map[string]interface{}, error)
+
+// The status of a source in a cache
+type SourceStatus struct {
+	SourceKey  string     `json:"source"`
+	Path       string     `json:"path"`
+	Acquired   bool       `json:"acquired"`
+	Declared   string     `json:"declared,omitempty"` // the branch, tag or commit in the kapp's config
+	Current    string     `json:"current,omitempty"`  // the branch checked out, or the commit if HEAD is detached
+	Revision   string     `json:"revision,omitempty"` // the SHA or digest of what's been acquired
+	Dirty      bool       `json:"dirty"`
+	Ahead      int        `json:"ahead"`
+	Behind     int        `json:"behind"`
+	CommitDate *time.Time `json:"commitDate,omitempty"`
+	inspected  bool
+}
+
+// The status of a kapp in a cache
+type KappStatus struct {
+	KappId         string         `json:"kapp"`
+	Path           string         `json:"path"`
+	Cached         bool           `json:"cached"`
+	Sources        []SourceStatus `json:"sources"`
+	StaleTemplates []string       `json:"staleTemplates"`
+	TemplateError  string         `json:"templateError,omitempty"` // why stale templates couldn't be found
+}
+
+// Returns the status of each installable's sources in a cache. Sources are inspected as they
+// were when they were last acquired, so remotes aren't contacted. If `templateVars` is given
+// templates of cached kapps are checked to see whether they're stale.
+func CacheStatus(installables []interfaces.IInstallable, rootCacheDir string,
+	stackConfig interfaces.IStackConfig, templateVars TemplateVarsFunc) ([]KappStatus, error) {
+
+	statuses := make([]KappStatus, 0, len(installables))
+
+	for _, installableObj := range installables {
+		status, err := kappStatus(installableObj, rootCacheDir)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if status.Cached && templateVars != nil {
+			status.StaleTemplates, err = staleTemplates(installableObj, rootCacheDir, stackConfig,
+				templateVars)
+			if err != nil {
+				log.Logger.Warnf("Couldn't check templates of kapp '%s': %s", status.KappId, err)
+				status.TemplateError = errors.Cause(err).Error()
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].KappId < statuses[j].KappId
+	})
+
+	return statuses, nil
+}
+
+// Returns the status of an installable's sources
+func kappStatus(installableObj interfaces.IInstallable, rootCacheDir string) (KappStatus, error) {
+	err := installableObj.SetTopLevelCacheDir(rootCacheDir)
+	if err != nil {
+		return KappStatus{}, errors.WithStack(err)
+	}
+
+	status := KappStatus{
+		KappId:         installableObj.FullyQualifiedId(),
+		Path:           installableObj.GetCacheDir(),
+		Sources:        make([]SourceStatus, 0),
+		StaleTemplates: make([]string, 0),
+	}
+
+	if _, err := os.Stat(status.Path); err == nil {
+		status.Cached = true
+	} else if !os.IsNotExist(err) {
+		return status, errors.WithStack(err)
+	}
+
+	acquirers, err := installableObj.Acquirers()
+	if err != nil {
+		return status, errors.WithStack(err)
+	}
+
+	for sourceKey, acquirerObj := range acquirers {
+		sourceDest, err := sourceCacheDir(status.Path, acquirerObj)
+		if err != nil {
+			return status, errors.WithStack(err)
+		}
+
+		sourceStatus := SourceStatus{
+			SourceKey: sourceKey,
+			Path:      sourceDest,
+		}
+
+		if _, err := os.Stat(sourceDest); err == nil {
+			sourceStatus.Acquired = true
+		} else if !os.IsNotExist(err) {
+			return status, errors.WithStack(err)
+		}
+
+		if sourceStatus.Acquired {
+			err = inspectSource(&sourceStatus, acquirerObj)
+			if err != nil {
+				return status, errors.Wrapf(err, "Error inspecting source '%s' of kapp '%s'",
+					sourceKey, status.KappId)
+			}
+		}
+
+		status.Sources = append(status.Sources, sourceStatus)
+	}
+
+	sort.Slice(status.Sources, func(i, j int) bool {
+		return status.Sources[i].SourceKey < status.Sources[j].SourceKey
+	})
+
+	return status, nil
+}
+
+// Fills in the status of an acquired source from its acquirer
+func inspectSource(sourceStatus *SourceStatus, acquirerObj acquirer.Acquirer) error {
+	if inspectable, ok := acquirerObj.(acquirer.Inspectable); ok {
+		state, err := inspectable.Inspect(sourceStatus.Path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		sourceStatus.inspected = true
+		sourceStatus.Declared = state.Wanted
+		sourceStatus.Current = state.Current
+		sourceStatus.Revision = state.Revision
+		sourceStatus.Dirty = len(state.Modified) > 0
+		sourceStatus.Ahead = state.Ahead
+		sourceStatus.Behind = state.Behind
+		sourceStatus.CommitDate = &state.CommitDate
+		return nil
+	}
+
+	if lockable, ok := acquirerObj.(acquirer.Lockable); ok {
+		revision, err := lockable.Revision(sourceStatus.Path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		sourceStatus.Revision = revision
+	}
+
+	return nil
+}
+
+// Returns the paths of an installable's templates that are stale
+func staleTemplates(installableObj interfaces.IInstallable, rootCacheDir string,
+	stackConfig interfaces.IStackConfig, templateVars TemplateVarsFunc) ([]string, error) {
+
+	// templates are declared in the kapp's config file
+	err := installableObj.LoadConfigFile(rootCacheDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	vars, err := templateVars(installableObj)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return installableObj.StaleTemplates(vars, stackConfig)
+}
+
+// Writes the statuses as a table with a row per source
+func WriteStatusTable(out io.Writer, statuses []KappStatus) error {
+	writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+
+	_, err := fmt.Fprintln(writer, "KAPP\tSOURCE\tDECLARED\tCHECKED OUT\tDIRTY\tAHEAD/BEHIND\tLAST COMMIT\tSTALE TEMPLATES")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, status := range statuses {
+		templates := fmt.Sprintf("%d", len(status.StaleTemplates))
+		if !status.Cached {
+			templates = "-"
+		} else if status.TemplateError != "" {
+			templates = "?"
+		}
+
+		sources := status.Sources
+		if len(sources) == 0 {
+			sources = []SourceStatus{{SourceKey: "-", Acquired: status.Cached, inspected: false}}
+		}
+
+		for _, source := range sources {
+			_, err = fmt.Fprintf(writer, "%s\t%s\t%s\n", status.KappId, source.SourceKey,
+				strings.Join(append(sourceColumns(source, status.Cached), templates), "\t"))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, status := range statuses {
+		if len(status.StaleTemplates) > 0 {
+			_, err = fmt.Fprintf(out, "\nStale templates for kapp '%s':\n  %s\n", status.KappId,
+				strings.Join(status.StaleTemplates, "\n  "))
+		} else if status.TemplateError != "" {
+			_, err = fmt.Fprintf(out, "\nCouldn't check templates for kapp '%s': %s\n", status.KappId,
+				status.TemplateError)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the declared, checked out, dirty, ahead/behind and last commit columns for a source
+func sourceColumns(source SourceStatus, kappCached bool) []string {
+	if !kappCached {
+		return []string{"-", "kapp not cached", "-", "-", "-"}
+	}
+
+	if !source.Acquired {
+		return []string{orDash(source.Declared), "not acquired", "-", "-", "-"}
+	}
+
+	if !source.inspected {
+		return []string{"-", orDash(shortRevision(source.Revision)), "-", "-", "-"}
+	}
+
+	checkedOut := source.Current
+	if source.Current != source.Revision {
+		checkedOut = fmt.Sprintf("%s (%s)", source.Current, shortRevision(source.Revision))
+	}
+
+	dirty := "no"
+	if source.Dirty {
+		dirty = "yes"
+	}
+
+	return []string{
+		source.Declared,
+		shortRevision(checkedOut),
+		dirty,
+		fmt.Sprintf("%d/%d", source.Ahead, source.Behind),
+		source.CommitDate.Format("2006-01-02 15:04"),
+	}
+}
+
+// Abbreviates full git SHAs
+func shortRevision(revision string) string {
+	if len(revision) == 40 && strings.Trim(revision, "0123456789abcdef") == "" {
+		return revision[:7]
+	}
+
+	return revision
+}
+
+// Returns a dash for empty values
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+
+	return value
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cacher
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheStatus(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "cache-status-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	installables := make([]interfaces.IInstallable, 0)
+
+	for _, kappId := range []string{"wordpress", "mysql"} {
+		installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+			{
+				Id: kappId,
+				Sources: map[string]structs.Source{
+					kappId: {Uri: "file:///kapps/" + kappId},
+				},
+			},
+		})
+		assert.Nil(t, err)
+		installables = append(installables, installableObj)
+	}
+
+	// wordpress is cached with one template that's been rendered and one that hasn't, but
+	// mysql isn't cached at all
+	sourceDir := filepath.Join(tmpDir, "web", "wordpress", CacheDir, "wordpress")
+	assert.Nil(t, os.MkdirAll(sourceDir, 0755))
+
+	files := map[string]string{
+		"sugarkube.yaml": `templates:
+- source: backend.tf.tpl
+  dest: backend.tf
+- source: values.yaml.tpl
+  dest: '{{ .env }}-values.yaml'
+`,
+		"backend.tf.tpl":  "bucket = \"state\"",
+		"values.yaml.tpl": "replicas: 1",
+		"backend.tf":      "bucket = \"state\"",
+	}
+	for name, contents := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(sourceDir, name), []byte(contents), 0644))
+	}
+
+	// the rendered template is newer than its source
+	past := time.Now().Add(-time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(sourceDir, "backend.tf.tpl"), past, past))
+
+	templateVars := func(installableObj interfaces.IInstallable) (map[string]interface{}, error) {
+		return map[string]interface{}{"env": "dev"}, nil
+	}
+
+	statuses, err := CacheStatus(installables, tmpDir, mock.Config{}, templateVars)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []KappStatus{
+		{
+			KappId: "web:mysql",
+			Path:   filepath.Join(tmpDir, "web", "mysql"),
+			Sources: []SourceStatus{
+				{
+					SourceKey: "mysql",
+					Path:      filepath.Join(tmpDir, "web", "mysql", CacheDir, "mysql"),
+				},
+			},
+			StaleTemplates: []string{},
+		},
+		{
+			KappId: "web:wordpress",
+			Path:   filepath.Join(tmpDir, "web", "wordpress"),
+			Cached: true,
+			Sources: []SourceStatus{
+				{
+					SourceKey: "wordpress",
+					Path:      sourceDir,
+					Acquired:  true,
+				},
+			},
+			StaleTemplates: []string{filepath.Join(sourceDir, "dev-values.yaml")},
+		},
+	}, statuses)
+
+	out := bytes.Buffer{}
+	assert.Nil(t, WriteStatusTable(&out, statuses))
+	assert.Contains(t, out.String(), "kapp not cached")
+	assert.Contains(t, out.String(), "Stale templates for kapp 'web:wordpress':\n  "+
+		filepath.Join(sourceDir, "dev-values.yaml"))
+}
+
+func TestSourceColumns(t *testing.T) {
+	commitDate := time.Date(2019, 5, 1, 12, 30, 0, 0, time.UTC)
+	revision := "0123456789abcdef0123456789abcdef01234567"
+
+	assert.Equal(t, []string{"master", "master (0123456)", "yes", "1/2", "2019-05-01 12:30"},
+		sourceColumns(SourceStatus{
+			Acquired:   true,
+			Declared:   "master",
+			Current:    "master",
+			Revision:   revision,
+			Dirty:      true,
+			Ahead:      1,
+			Behind:     2,
+			CommitDate: &commitDate,
+			inspected:  true,
+		}, true))
+
+	// detached heads are shown by their SHA
+	assert.Equal(t, "0123456", sourceColumns(SourceStatus{
+		Acquired:   true,
+		Declared:   revision,
+		Current:    revision,
+		Revision:   revision,
+		CommitDate: &commitDate,
+		inspected:  true,
+	}, true)[1])
+
+	assert.Equal(t, "not acquired", sourceColumns(SourceStatus{}, true)[1])
+}
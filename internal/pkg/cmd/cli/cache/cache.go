@@ -33,6 +33,11 @@ func NewCacheCmds(out io.Writer) *cobra.Command {
 	cmd.AddCommand(
 		newCreateCmd(out),
 		newDiffCmd(out),
+		newExportCmd(out),
+		newImportCmd(out),
+		newLockCmd(out),
+		newPruneCmd(out),
+		newStatusCmd(out),
 	)
 
 	return cmd
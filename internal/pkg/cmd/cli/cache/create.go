@@ -20,9 +20,11 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
 	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
 	"github.com/sugarkube/sugarkube/internal/pkg/cmd/cli/kapps"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
@@ -43,6 +45,10 @@ type createCmd struct {
 	region          string
 	cacheDir        string
 	renderTemplates bool
+	locked          bool
+	force           bool
+	onConflict      string
+	numWorkers      int
 }
 
 func newCreateCmd(out io.Writer) *cobra.Command {
@@ -54,7 +60,14 @@ func newCreateCmd(out io.Writer) *cobra.Command {
 		Use:   "create [flags] [stack-file] [stack-name] [cache-dir]",
 		Short: fmt.Sprintf("Create kapp caches"),
 		Long: `Create/update a local kapps cache for a given manifest(s), and renders any 
-templates defined by kapps.`,
+templates defined by kapps.
+
+The revision each source is acquired at is recorded in a '` + cacher.LockFileName + `' file next to 
+the stack file. Pass '--locked' to acquire exactly the revisions in the lock file instead.
+
+Sources already in the cache are switched to a different branch, tag or commit if the manifest 
+changes. Sources with local modifications are reported and left alone unless '--on-conflict' 
+says to skip or stash them, or '--force' is given to discard the modifications.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 3 {
 				return errors.New("some required arguments are missing")
@@ -64,6 +77,9 @@ templates defined by kapps.`,
 			c.stackFile = args[0]
 			c.stackName = args[1]
 			c.cacheDir = args[2]
+			if c.force && cmd.Flags().Changed("on-conflict") {
+				return errors.New("--force and --on-conflict can't be used together")
+			}
 			return c.run()
 		},
 	}
@@ -71,6 +87,13 @@ templates defined by kapps.`,
 	f := cmd.Flags()
 	f.BoolVarP(&c.dryRun, "dry-run", "n", false, "show what would happen but don't create a cluster")
 	f.BoolVarP(&c.renderTemplates, "template", "t", false, "render templates for kapps ignoring any errors")
+	f.BoolVar(&c.locked, "locked", false, fmt.Sprintf("acquire exactly the revisions recorded in the "+
+		"%s file, failing if it's out of date", cacher.LockFileName))
+	f.BoolVarP(&c.force, "force", "f", false, "discard local modifications to sources that need updating")
+	f.StringVar(&c.onConflict, "on-conflict", string(acquirer.ConflictFail), fmt.Sprintf(
+		"what to do with sources with local modifications that need updating: '%s', '%s' or '%s'",
+		acquirer.ConflictSkip, acquirer.ConflictFail, acquirer.ConflictStash))
+	f.IntVarP(&c.numWorkers, "workers", "w", 0, "number of sources to acquire concurrently (defaults to the 'cache-workers' setting)")
 	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
 	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
 	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
@@ -85,6 +108,15 @@ func (c *createCmd) run() error {
 
 	log.Logger.Debugf("Got CLI args: %#v", c)
 
+	onConflict := acquirer.ConflictDiscard
+	if !c.force {
+		var err error
+		onConflict, err = acquirer.ParseConflictPolicy(c.onConflict)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	// CLI args override configured args, so merge them in
 	cliStackConfig := &structs.StackFile{
 		Provider:    c.provider,
@@ -95,6 +127,20 @@ func (c *createCmd) run() error {
 		Account:     c.account,
 	}
 
+	lockFilePath := cacher.LockFilePath(c.stackFile)
+
+	lockFile, err := cacher.LoadLockFile(lockFilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// remote manifests are refreshed when creating caches unless they're locked
+	if c.locked {
+		cliStackConfig.ManifestRevisions = lockFile.ManifestRevisions(c.stackName)
+	} else {
+		cliStackConfig.UpdateManifests = true
+	}
+
 	stackObj, err := stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, c.out)
 	if err != nil {
 		return errors.WithStack(err)
@@ -112,6 +158,15 @@ func (c *createCmd) run() error {
 
 	log.Logger.Debugf("Manifests validated.")
 
+	if c.locked {
+		log.Logger.Infof("Pinning sources to the revisions in '%s'", lockFilePath)
+
+		err = lockFile.Apply(c.stackName, stackObj.GetConfig().Manifests())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	absRootCacheDir, err := filepath.Abs(c.cacheDir)
 	if err != nil {
 		return errors.WithStack(err)
@@ -125,19 +180,38 @@ func (c *createCmd) run() error {
 		return errors.WithStack(err)
 	}
 
+	installables := make([]interfaces.IInstallable, 0)
 	for _, manifest := range stackObj.GetConfig().Manifests() {
-		err := cacher.CacheManifest(manifest, absRootCacheDir, c.dryRun)
+		installables = append(installables, manifest.Installables()...)
+	}
+
+	stackLock, conflicts, err := cacher.CacheInstallables(installables, absRootCacheDir,
+		onConflict, c.numWorkers, c.out, c.dryRun)
+	if err != nil {
+		// report what happened to sources with local changes that were stashed or discarded
+		reportErr := cacher.WriteConflictReport(c.out, conflicts)
+		if reportErr != nil {
+			log.Logger.Warnf("Error writing conflict report: %+v", reportErr)
+		}
+		return errors.WithStack(err)
+	}
+
+	// reload each installable now its been cached so we can render templates
+	for _, installableObj := range installables {
+		err := installableObj.LoadConfigFile(absRootCacheDir)
 		if err != nil {
 			return errors.WithStack(err)
 		}
+	}
 
-		// reload each installable now its been cached so we can render templates
-		for _, installableObj := range manifest.Installables() {
-			err := installableObj.LoadConfigFile(absRootCacheDir)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-		}
+	err = cacher.WriteConflictReport(c.out, conflicts)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = cacher.UnresolvedConflicts(conflicts)
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
 	_, err = fmt.Fprintln(c.out, "Kapps successfully cached")
@@ -145,6 +219,26 @@ func (c *createCmd) run() error {
 		return errors.WithStack(err)
 	}
 
+	// the lock file already contains these revisions if we're in locked mode
+	if !c.locked && !c.dryRun {
+		// skipped sources weren't updated so keep their previous revisions
+		stackLock.KeepSkipped(lockFile.Stacks[c.stackName], conflicts)
+
+		// replace the whole stack so kapps that have been removed from it are dropped
+		lockFile.Stacks[c.stackName] = stackLock
+		lockFile.Manifests[c.stackName] = cacher.LockManifests(stackObj.GetConfig().Manifests())
+
+		err = lockFile.Save(lockFilePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = fmt.Fprintf(c.out, "Revisions of sources written to '%s'\n", lockFilePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
 	log.Logger.Infof("Manifests cached to: %s", absRootCacheDir)
 
 	if c.renderTemplates {
@@ -17,13 +17,38 @@
 package cache
 
 import (
+	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/cmd"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"io"
+	"io/ioutil"
 )
 
+// exit code when the cache differs from the stack so CI jobs can tell differences from errors
+const DiffExitCode = 2
+
+const outputText = "text"
+const outputJson = "json"
+
 type diffCmd struct {
-	out io.Writer
+	out         io.Writer
+	output      string
+	stackName   string
+	stackFile   string
+	provider    string
+	provisioner string
+	profile     string
+	account     string
+	cluster     string
+	region      string
+	cacheDir    string
 }
 
 func newDiffCmd(out io.Writer) *cobra.Command {
@@ -31,25 +56,128 @@ func newDiffCmd(out io.Writer) *cobra.Command {
 		out: out,
 	}
 
-	cmd := &cobra.Command{
-		Use:   "diff [flags]",
+	command := &cobra.Command{
+		Use:   "diff [flags] [stack-file] [stack-name] [cache-dir]",
 		Short: fmt.Sprintf("Diff a local kapp cache against manifests"),
-		Long: `Diffs a local kapp cache directory against kapps defined in a
-manifest(s). This is the difference between the current/actual state of the cache
+		Long: fmt.Sprintf(`Diffs a local kapp cache directory against kapps defined in the
+manifests of a stack. This is the difference between the current/actual state of the cache
 vs the desired state. This command will print out any differences such as:
-  * The cache containing kapps checked out at different versions to the those specified 
-    in manifests
-  * Any changed/modified files in any kapps (as reported by the acquirer)
+  * Kapps in manifests that are missing from the cache, or sources that haven't been acquired
+  * Kapps in the cache that are no longer in any manifest
+  * Sources checked out at different branches, tags or SHAs to those specified in manifests
+  * Sources with uncommitted changes or unpushed commits
+
+Sources are compared as they were when they were last acquired, so remotes aren't contacted. 
+Pass '--output json' for machine-readable output. The exit code is %d if there are any 
+differences, 1 if an error occurs and 0 otherwise.
+`, DiffExitCode),
+		RunE: func(command *cobra.Command, args []string) error {
+			if len(args) < 3 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 3 {
+				return errors.New("too many arguments supplied")
+			}
+			c.stackFile = args[0]
+			c.stackName = args[1]
+			c.cacheDir = args[2]
 
-The manifests can either defined in a stack config file or as command line
-arguments.
-`,
-		RunE: c.run,
+			err := c.run()
+			if _, ok := err.(cmd.ExitCodeError); ok {
+				// the differences have already been printed
+				command.SilenceErrors = true
+			}
+			return err
+		},
 	}
 
-	return cmd
+	f := command.Flags()
+	f.StringVarP(&c.output, "output", "o", outputText, fmt.Sprintf("output format, either '%s' or '%s'",
+		outputText, outputJson))
+	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
+	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
+	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
+	f.StringVarP(&c.cluster, "cluster", "c", "", "name of cluster to launch, e.g. dev1, dev2, etc.")
+	f.StringVarP(&c.account, "account", "a", "", "string identifier for the account to launch in (for providers that support it)")
+	f.StringVarP(&c.region, "region", "r", "", "name of region (for providers that support it)")
+
+	return command
 }
 
-func (c *diffCmd) run(cmd *cobra.Command, args []string) error {
-	panic("Not implemented")
+func (c *diffCmd) run() error {
+
+	log.Logger.Debugf("Got CLI args: %#v", c)
+
+	if c.output != outputText && c.output != outputJson {
+		return errors.New(fmt.Sprintf("Invalid output format '%s'. Valid values are '%s' and '%s'",
+			c.output, outputText, outputJson))
+	}
+
+	// CLI args override configured args, so merge them in
+	cliStackConfig := &structs.StackFile{
+		Provider:    c.provider,
+		Provisioner: c.provisioner,
+		Profile:     c.profile,
+		Cluster:     c.cluster,
+		Region:      c.region,
+		Account:     c.account,
+	}
+
+	// keep JSON output parseable
+	stackOut := c.out
+	if c.output == outputJson {
+		stackOut = ioutil.Discard
+	}
+
+	stackObj, err := stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, stackOut)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	installables := make([]interfaces.IInstallable, 0)
+	for _, manifest := range stackObj.GetConfig().Manifests() {
+		installables = append(installables, manifest.Installables()...)
+	}
+
+	differences, err := cacher.DiffCache(installables, c.cacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.output == outputJson {
+		jsonBytes, err := json.MarshalIndent(differences, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = fmt.Fprintln(c.out, string(jsonBytes))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	} else if len(differences) == 0 {
+		_, err = fmt.Fprintf(c.out, "The cache at '%s' matches the stack\n", c.cacheDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	} else {
+		_, err = fmt.Fprintf(c.out, "The cache at '%s' differs from the stack:\n", c.cacheDir)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, difference := range differences {
+			_, err = fmt.Fprintf(c.out, "  %s\n", difference)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	if len(differences) > 0 {
+		return cmd.ExitCodeError{
+			Code:    DiffExitCode,
+			Message: fmt.Sprintf("%d difference(s) found", len(differences)),
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io"
+)
+
+type exportCmd struct {
+	out         io.Writer
+	stripGit    bool
+	stackName   string
+	stackFile   string
+	provider    string
+	provisioner string
+	profile     string
+	account     string
+	cluster     string
+	region      string
+	cacheDir    string
+	bundlePath  string
+}
+
+func newExportCmd(out io.Writer) *cobra.Command {
+	c := &exportCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "export [flags] [stack-file] [stack-name] [cache-dir] [bundle]",
+		Short: fmt.Sprintf("Package a cache into a bundle that can be used without network access"),
+		Long: `Packages the kapps in a fully acquired cache into a gzipped tarball along with the stack file, 
+a lock file for the cached revisions, local manifests, vars and template directories, the sources 
+of remote manifests and the checksums of every file. 
+
+Bundles can be unpacked with 'cache import' so kapps can be installed somewhere without network 
+access. Pass '--strip-git' to leave '.git' directories out of kapp sources to make the bundle 
+smaller.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 4 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 4 {
+				return errors.New("too many arguments supplied")
+			}
+			c.stackFile = args[0]
+			c.stackName = args[1]
+			c.cacheDir = args[2]
+			c.bundlePath = args[3]
+			return c.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVar(&c.stripGit, "strip-git", false, "leave '.git' directories out of kapp sources")
+	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
+	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
+	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
+	f.StringVarP(&c.cluster, "cluster", "c", "", "name of cluster to launch, e.g. dev1, dev2, etc.")
+	f.StringVarP(&c.account, "account", "a", "", "string identifier for the account to launch in (for providers that support it)")
+	f.StringVarP(&c.region, "region", "r", "", "name of region (for providers that support it)")
+
+	return cmd
+}
+
+func (c *exportCmd) run() error {
+
+	log.Logger.Debugf("Got CLI args: %#v", c)
+
+	// CLI args override configured args, so merge them in
+	cliStackConfig := &structs.StackFile{
+		Provider:    c.provider,
+		Provisioner: c.provisioner,
+		Profile:     c.profile,
+		Cluster:     c.cluster,
+		Region:      c.region,
+		Account:     c.account,
+	}
+
+	stackObj, err := stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, c.out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	info, err := cacher.ExportBundle(c.bundlePath, c.stackFile, stackObj.GetConfig(), c.cacheDir,
+		c.stripGit)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(c.out, "Exported stack '%s' from '%s' to bundle '%s'\n", info.StackName,
+		c.cacheDir, c.bundlePath)
+	return errors.WithStack(err)
+}
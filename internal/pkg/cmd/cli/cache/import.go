@@ -0,0 +1,82 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"io"
+	"path/filepath"
+)
+
+type importCmd struct {
+	out        io.Writer
+	bundlePath string
+	dir        string
+}
+
+func newImportCmd(out io.Writer) *cobra.Command {
+	c := &importCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "import [flags] [bundle] [dir]",
+		Short: fmt.Sprintf("Unpack and verify a bundle created by 'cache export'"),
+		Long: fmt.Sprintf(`Unpacks a bundle into a directory that must be empty or not exist and verifies the checksums 
+of its contents. Kapps are unpacked under '%s' and the stack file, manifests, etc. under '%s'. 
+Remote manifests are copied into the manifest cache so kapps can be installed without network access.`,
+			cacher.BundleCacheDir, cacher.BundleStackDir),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 2 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 2 {
+				return errors.New("too many arguments supplied")
+			}
+			c.bundlePath = args[0]
+			c.dir = args[1]
+			return c.run()
+		},
+	}
+
+	return cmd
+}
+
+func (c *importCmd) run() error {
+
+	log.Logger.Debugf("Got CLI args: %#v", c)
+
+	manifestCacheRoot, err := stack.ManifestCacheRoot()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	info, err := cacher.ImportBundle(c.bundlePath, c.dir, manifestCacheRoot)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(c.out, "Imported and verified bundle '%s'. Install its kapps with:\n"+
+		"  sugarkube kapps install %s %s %s\n", c.bundlePath,
+		filepath.Join(c.dir, cacher.BundleStackDir, filepath.FromSlash(info.StackFile)),
+		info.StackName, filepath.Join(c.dir, cacher.BundleCacheDir))
+	return errors.WithStack(err)
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io"
+	"path/filepath"
+)
+
+type lockCmd struct {
+	out             io.Writer
+	update          bool
+	numWorkers      int
+	stackName       string
+	stackFile       string
+	provider        string
+	provisioner     string
+	profile         string
+	account         string
+	cluster         string
+	region          string
+	cacheDir        string
+	includeSelector []string
+	excludeSelector []string
+}
+
+func newLockCmd(out io.Writer) *cobra.Command {
+	c := &lockCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "lock [flags] [stack-file] [stack-name] [cache-dir] [kapp]",
+		Short: fmt.Sprintf("Lock sources to the revisions in a cache"),
+		Long: fmt.Sprintf(`Records the revisions of the sources of kapps in a cache and of any remote manifests in 
+the '%s' file next to the stack file. Pass '--update' to update the sources and manifests to the 
+latest revisions of their branches, tags, etc. first.
+
+To only lock (or update) a single kapp, pass its ID formatted 'manifest-id:kapp-id' as the last 
+argument, e.g. 'sugarkube cache lock -u stacks.yaml dev ./cache web:wordpress'. Locked revisions of 
+other kapps are left as they are. Several kapps can be selected with '--include' and '--exclude'. 
+Remote manifests that are already locked are only updated and relocked with '--update' when no kapps 
+are selected.`,
+			cacher.LockFileName),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 3 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 4 {
+				return errors.New("too many arguments supplied")
+			}
+			c.stackFile = args[0]
+			c.stackName = args[1]
+			c.cacheDir = args[2]
+			if len(args) == 4 {
+				c.includeSelector = append(c.includeSelector, args[3])
+			}
+			return c.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&c.update, "update", "u", false, "update sources in the cache before locking them")
+	f.IntVarP(&c.numWorkers, "workers", "w", 0, "number of sources to acquire concurrently with --update (defaults to the 'cache-workers' setting)")
+	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
+	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
+	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
+	f.StringVarP(&c.cluster, "cluster", "c", "", "name of cluster to launch, e.g. dev1, dev2, etc.")
+	f.StringVarP(&c.account, "account", "a", "", "string identifier for the account to launch in (for providers that support it)")
+	f.StringVarP(&c.region, "region", "r", "", "name of region (for providers that support it)")
+	f.StringArrayVarP(&c.includeSelector, "include", "i", []string{},
+		fmt.Sprintf("only lock individual kapps (can specify multiple, formatted 'manifest-id:kapp-id' or 'manifest-id:%s' for all)",
+			constants.WildcardCharacter))
+	f.StringArrayVarP(&c.excludeSelector, "exclude", "x", []string{},
+		fmt.Sprintf("exclude individual kapps (can specify multiple, formatted 'manifest-id:kapp-id' or 'manifest-id:%s' for all)",
+			constants.WildcardCharacter))
+
+	return cmd
+}
+
+func (c *lockCmd) run() error {
+
+	log.Logger.Debugf("Got CLI args: %#v", c)
+
+	// remote manifests are only updated and relocked when everything is, otherwise locking
+	// some kapps would move the revisions the others were locked with
+	relockManifests := c.update && len(c.includeSelector) == 0 && len(c.excludeSelector) == 0
+
+	// CLI args override configured args, so merge them in
+	cliStackConfig := &structs.StackFile{
+		Provider:        c.provider,
+		Provisioner:     c.provisioner,
+		Profile:         c.profile,
+		Cluster:         c.cluster,
+		Region:          c.region,
+		Account:         c.account,
+		UpdateManifests: relockManifests,
+	}
+
+	stackObj, err := stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, c.out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	selectedInstallables, err := stack.SelectInstallables(stackObj.GetConfig().Manifests(),
+		c.includeSelector, c.excludeSelector)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	absRootCacheDir, err := filepath.Abs(c.cacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	lockFilePath := cacher.LockFilePath(c.stackFile)
+
+	lockFile, err := cacher.LoadLockFile(lockFilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	stackLock := cacher.StackLock{}
+
+	if c.update {
+		var conflicts []cacher.SourceConflict
+
+		// never lock sources that couldn't be updated
+		stackLock, conflicts, err = cacher.CacheInstallables(selectedInstallables, absRootCacheDir,
+			acquirer.ConflictFail, c.numWorkers, c.out, false)
+		if err != nil {
+			reportErr := cacher.WriteConflictReport(c.out, conflicts)
+			if reportErr != nil {
+				log.Logger.Warnf("Error writing conflict report: %+v", reportErr)
+			}
+			return errors.WithStack(err)
+		}
+
+		err = cacher.WriteConflictReport(c.out, conflicts)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = cacher.UnresolvedConflicts(conflicts)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, installableObj := range selectedInstallables {
+		if !c.update {
+			kappLock, err := cacher.CachedRevisions(installableObj, absRootCacheDir)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			stackLock[installableObj.FullyQualifiedId()] = kappLock
+		}
+
+		kappLock := stackLock[installableObj.FullyQualifiedId()]
+
+		for sourceKey, lockedSource := range kappLock {
+			_, err = fmt.Fprintf(c.out, "Locked source '%s' of kapp '%s' to %s\n", sourceKey,
+				installableObj.FullyQualifiedId(), lockedSource.Revision)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	lockFile.Update(c.stackName, stackLock)
+	lockFile.UpdateManifests(c.stackName, cacher.LockManifests(stackObj.GetConfig().Manifests()),
+		relockManifests)
+
+	err = lockFile.Save(lockFilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(c.out, "Lock file written to '%s'\n", lockFilePath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io"
+	"strings"
+)
+
+type pruneCmd struct {
+	out         io.Writer
+	approved    bool
+	stackName   string
+	stackFile   string
+	provider    string
+	provisioner string
+	profile     string
+	account     string
+	cluster     string
+	region      string
+	cacheDir    string
+}
+
+func newPruneCmd(out io.Writer) *cobra.Command {
+	c := &pruneCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "prune [flags] [stack-file] [stack-name] [cache-dir]",
+		Short: fmt.Sprintf("Remove kapps and sources from a cache that are no longer in the stack"),
+		Long: `Finds kapp directories and sources in a cache that aren't used by the manifests in a stack 
+any more (e.g. because kapps have been removed or moved to a different manifest), along with 
+dangling symlinks to sources.
+
+Nothing is removed unless '--yes' is given. Sources containing uncommitted changes, unpushed 
+commits or stashes are never removed.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 3 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 3 {
+				return errors.New("too many arguments supplied")
+			}
+			c.stackFile = args[0]
+			c.stackName = args[1]
+			c.cacheDir = args[2]
+			return c.run()
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVarP(&c.approved, "yes", "y", false, "actually remove unused kapps and sources. If false, they'll just be listed")
+	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
+	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
+	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
+	f.StringVarP(&c.cluster, "cluster", "c", "", "name of cluster to launch, e.g. dev1, dev2, etc.")
+	f.StringVarP(&c.account, "account", "a", "", "string identifier for the account to launch in (for providers that support it)")
+	f.StringVarP(&c.region, "region", "r", "", "name of region (for providers that support it)")
+
+	return cmd
+}
+
+func (c *pruneCmd) run() error {
+
+	log.Logger.Debugf("Got CLI args: %#v", c)
+
+	// CLI args override configured args, so merge them in
+	cliStackConfig := &structs.StackFile{
+		Provider:    c.provider,
+		Provisioner: c.provisioner,
+		Profile:     c.profile,
+		Cluster:     c.cluster,
+		Region:      c.region,
+		Account:     c.account,
+	}
+
+	stackObj, err := stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, c.out)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	installables := make([]interfaces.IInstallable, 0)
+	for _, manifest := range stackObj.GetConfig().Manifests() {
+		installables = append(installables, manifest.Installables()...)
+	}
+
+	candidates, err := cacher.FindPrunable(installables, c.cacheDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(candidates) == 0 {
+		_, err = fmt.Fprintf(c.out, "Nothing to prune in '%s'\n", c.cacheDir)
+		return errors.WithStack(err)
+	}
+
+	refused := make([]string, 0)
+
+	for _, candidate := range candidates {
+		if len(candidate.LocalWork) > 0 {
+			refused = append(refused, candidate.Path)
+			_, err = fmt.Fprintf(c.out, "Won't remove %s because it contains local work:\n  %s\n",
+				candidate, strings.Join(candidate.LocalWork, "\n  "))
+		} else if !c.approved {
+			_, err = fmt.Fprintf(c.out, "Would remove %s\n", candidate)
+		}
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	if !c.approved {
+		_, err = fmt.Fprintln(c.out, "Rerun with '--yes' to remove them")
+		return errors.WithStack(err)
+	}
+
+	removed, err := cacher.Prune(candidates, c.cacheDir)
+	for _, candidate := range removed {
+		_, printErr := fmt.Fprintf(c.out, "Removed %s\n", candidate)
+		if printErr != nil {
+			return errors.WithStack(printErr)
+		}
+	}
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(refused) > 0 {
+		return errors.New(fmt.Sprintf("Refused to remove paths containing local work: %s. "+
+			"Commit and push or discard the work then rerun this command.",
+			strings.Join(refused, ", ")))
+	}
+
+	return nil
+}
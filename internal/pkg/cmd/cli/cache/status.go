@@ -0,0 +1,157 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/cacher"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installer"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io"
+	"io/ioutil"
+)
+
+type statusCmd struct {
+	out         io.Writer
+	output      string
+	stackName   string
+	stackFile   string
+	provider    string
+	provisioner string
+	profile     string
+	account     string
+	cluster     string
+	region      string
+	cacheDir    string
+}
+
+func newStatusCmd(out io.Writer) *cobra.Command {
+	c := &statusCmd{
+		out: out,
+	}
+
+	command := &cobra.Command{
+		Use:   "status [flags] [stack-file] [stack-name] [cache-dir]",
+		Short: fmt.Sprintf("Show the status of each kapp source in a cache"),
+		Long: `Prints a table with a row for each source of each kapp in a stack showing:
+  * The branch, tag or commit declared in the kapp's config
+  * The branch or SHA that's checked out
+  * Whether there are uncommitted changes
+  * How many commits it's ahead/behind its remote tracking branch
+  * The date of the last commit
+  * How many of the kapp's templates are stale, i.e. missing or rendered before their 
+    source changed
+
+Sources are inspected as they were when they were last acquired, so remotes aren't contacted. 
+Pass '--output json' for machine-readable output.
+`,
+		RunE: func(command *cobra.Command, args []string) error {
+			if len(args) < 3 {
+				return errors.New("some required arguments are missing")
+			} else if len(args) > 3 {
+				return errors.New("too many arguments supplied")
+			}
+			c.stackFile = args[0]
+			c.stackName = args[1]
+			c.cacheDir = args[2]
+			return c.run()
+		},
+	}
+
+	f := command.Flags()
+	f.StringVarP(&c.output, "output", "o", outputText, fmt.Sprintf("output format, either '%s' or '%s'",
+		outputText, outputJson))
+	f.StringVar(&c.provider, "provider", "", "name of provider, e.g. aws, local, etc.")
+	f.StringVar(&c.provisioner, "provisioner", "", "name of provisioner, e.g. kops, minikube, etc.")
+	f.StringVar(&c.profile, "profile", "", "launch profile, e.g. dev, test, prod, etc.")
+	f.StringVarP(&c.cluster, "cluster", "c", "", "name of cluster to launch, e.g. dev1, dev2, etc.")
+	f.StringVarP(&c.account, "account", "a", "", "string identifier for the account to launch in (for providers that support it)")
+	f.StringVarP(&c.region, "region", "r", "", "name of region (for providers that support it)")
+
+	return command
+}
+
+func (c *statusCmd) run() error {
+
+	log.Logger.Debugf("Got CLI args: %#v", c)
+
+	if c.output != outputText && c.output != outputJson {
+		return errors.New(fmt.Sprintf("Invalid output format '%s'. Valid values are '%s' and '%s'",
+			c.output, outputText, outputJson))
+	}
+
+	// CLI args override configured args, so merge them in
+	cliStackConfig := &structs.StackFile{
+		Provider:    c.provider,
+		Provisioner: c.provisioner,
+		Profile:     c.profile,
+		Cluster:     c.cluster,
+		Region:      c.region,
+		Account:     c.account,
+	}
+
+	// keep JSON output parseable
+	stackOut := c.out
+	if c.output == outputJson {
+		stackOut = ioutil.Discard
+	}
+
+	stackObj, err := stack.BuildStack(c.stackName, c.stackFile, cliStackConfig, stackOut)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	installables := make([]interfaces.IInstallable, 0)
+	for _, manifest := range stackObj.GetConfig().Manifests() {
+		installables = append(installables, manifest.Installables()...)
+	}
+
+	installerImpl, err := installer.New(installer.MAKE, stackObj.GetProvider())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// template paths are rendered with the same vars as when running 'kapps template'
+	templateVars := func(installableObj interfaces.IInstallable) (map[string]interface{}, error) {
+		return stackObj.GetTemplatedVars(installableObj,
+			installerImpl.GetVars(constants.DagActionTemplate, false))
+	}
+
+	statuses, err := cacher.CacheStatus(installables, c.cacheDir, stackObj.GetConfig(), templateVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c.output == outputJson {
+		jsonBytes, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		_, err = fmt.Fprintln(c.out, string(jsonBytes))
+		return errors.WithStack(err)
+	}
+
+	return cacher.WriteStatusTable(c.out, statuses)
+}
@@ -21,6 +21,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/secrets"
 	"io"
 	"os"
 	"os/signal"
@@ -44,6 +45,7 @@ func NewClusterCmds(out io.Writer) *cobra.Command {
 			go func() {
 				<-signals
 				log.Logger.Info("Caught termination signal. Will try to gracefully terminate...")
+				secrets.DeleteSecretFiles()
 				if stackObj != nil {
 					err2 := stackObj.GetProvisioner().Close()
 					if err2 != nil {
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
@@ -249,8 +250,16 @@ func BuildDagForSelected(stackObj interfaces.IStack, cacheDir string, includeSel
 		}
 	}
 
+	implicitDependencies := map[string][]string{}
+	if config.CurrentConfig != nil && config.CurrentConfig.ImplicitKappDependencies {
+		implicitDependencies, err = stack.FindKappReferences(stackObj)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
 	dagObj, err := plan.Create(stackObj.GetConfig().Manifests(), filteredInstallableIds,
-		includeParents)
+		includeParents, implicitDependencies)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
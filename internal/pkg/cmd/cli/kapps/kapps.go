@@ -19,8 +19,10 @@ package kapps
 import (
 	"fmt"
 	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/secrets"
 	"io"
 	"os"
 	"os/signal"
@@ -43,6 +45,8 @@ func NewKappsCmds(out io.Writer) *cobra.Command {
 			go func() {
 				<-signals
 				log.Logger.Info("Caught termination signal. Will try to gracefully terminate...")
+				installable.DeleteRenderedSensitiveTemplates()
+				secrets.DeleteSecretFiles()
 				if stackObj != nil {
 					err2 := stackObj.GetProvisioner().Close()
 					if err2 != nil {
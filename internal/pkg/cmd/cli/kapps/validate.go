@@ -5,7 +5,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installer"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/output"
 	"github.com/sugarkube/sugarkube/internal/pkg/stack"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"io"
@@ -26,6 +29,7 @@ type validateConfig struct {
 	region          string
 	includeSelector []string
 	excludeSelector []string
+	outputs         bool
 }
 
 func newValidateCmd(out io.Writer) *cobra.Command {
@@ -36,7 +40,10 @@ func newValidateCmd(out io.Writer) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "validate [flags] [stack-file] [stack-name] [cache-dir]",
 		Short: fmt.Sprintf("Validate you have all the required binaries required by each kapp"),
-		Long:  `Loads all kapps and makes sure the binaries they declare in their 'requires' blocks are in your path`,
+		Long: `Loads all kapps and makes sure the binaries they declare in their 'requires' blocks are in your path.
+
+With --outputs, instead checks the output files that currently exist for each kapp parse and match the
+schemas declared for them.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 3 {
 				return errors.New("some required arguments are missing")
@@ -63,6 +70,7 @@ func newValidateCmd(out io.Writer) *cobra.Command {
 	f.StringArrayVarP(&c.excludeSelector, "exclude", "x", []string{},
 		fmt.Sprintf("exclude individual kapps (can specify multiple, formatted manifest-id:kapp-id or 'manifest-id:%s' for all)",
 			constants.WildcardCharacter))
+	f.BoolVar(&c.outputs, "outputs", false, "validate existing kapp outputs against their schemas instead of checking requirements")
 	return cmd
 }
 
@@ -89,6 +97,10 @@ func (c *validateConfig) run() error {
 		return errors.WithStack(err)
 	}
 
+	if c.outputs {
+		return validateOutputs(stackObj, dagObj.GetInstallables(), c.out)
+	}
+
 	_, err = fmt.Fprintf(c.out, "Validating requirements for kapps...\n")
 
 	numMissing := 0
@@ -137,3 +149,80 @@ func (c *validateConfig) run() error {
 
 	return nil
 }
+
+// Checks the outputs that currently exist for each kapp parse and match their schemas
+func validateOutputs(stackObj interfaces.IStack, installables []interfaces.IInstallable, out io.Writer) error {
+	installerImpl, err := installer.New(installer.MAKE, stackObj.GetProvider())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(out, "Validating outputs for kapps...\n")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	numInvalid := 0
+
+	for _, installableObj := range installables {
+		if !installableObj.HasOutputs() {
+			continue
+		}
+
+		// render the descriptor so templated output paths are resolved
+		templatedVars, err := stackObj.GetTemplatedVars(installableObj,
+			installerImpl.GetVars(constants.DagActionTemplate, false))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = installableObj.TemplateDescriptor(templatedVars)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		results, err := installableObj.ValidateOutputs()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, outputDef := range installableObj.GetDescriptor().Outputs {
+			var line string
+
+			problem, checked := results[outputDef.Id]
+			if !checked && outputDef.Source == output.SourceStdout {
+				line = fmt.Sprintf("  ➖ Skipped output '%s' of %s because it's read from %s\n",
+					outputDef.Id, installableObj.FullyQualifiedId(), output.SourceStdout)
+			} else if !checked {
+				line = fmt.Sprintf("  ➖ Skipped output '%s' of %s because it's sensitive and "+
+					"has been deleted after being loaded\n", outputDef.Id, installableObj.FullyQualifiedId())
+			} else if problem != nil {
+				numInvalid++
+				line = fmt.Sprintf("  ❌ Output '%s' of %s is invalid: %s\n", outputDef.Id,
+					installableObj.FullyQualifiedId(), problem)
+				log.Logger.Errorf("Output '%s' of kapp '%s' is invalid: %s", outputDef.Id,
+					installableObj.FullyQualifiedId(), problem)
+			} else {
+				line = fmt.Sprintf("  ✅ Output '%s' of %s is valid\n", outputDef.Id,
+					installableObj.FullyQualifiedId())
+			}
+
+			_, err = fmt.Fprint(out, line)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	if numInvalid > 0 {
+		_, err = fmt.Fprintf(out, "Summary: %d output(s) invalid\n", numInvalid)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return errors.New(fmt.Sprintf("%d output(s) are invalid", numInvalid))
+	}
+
+	_, err = fmt.Fprint(out, "Summary: All outputs valid\n")
+	return errors.WithStack(err)
+}
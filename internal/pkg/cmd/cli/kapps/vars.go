@@ -26,6 +26,7 @@ type varsConfig struct {
 	includeSelector []string
 	excludeSelector []string
 	suppress        []string
+	explain         string
 }
 
 func newVarsCmd(out io.Writer) *cobra.Command {
@@ -37,7 +38,11 @@ func newVarsCmd(out io.Writer) *cobra.Command {
 		Use:   "vars [flags] [stack-file] [stack-name] [cache-dir]",
 		Short: fmt.Sprintf("Display all variables available for a kapp"),
 		Long: `Merges variables from all sources and displays them along with each kapp's 
-templated sugarkube.yaml file.`,
+templated sugarkube.yaml file.
+
+Pass '--explain' to show which vars file or descriptor layer set each variable 
+instead, along with the values it overrode. Pass a path to only explain the 
+variables under it, e.g. '--explain=kapp.vars.hostname'.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 3 {
 				return errors.New("some required arguments are missing")
@@ -68,6 +73,9 @@ templated sugarkube.yaml file.`,
 			constants.WildcardCharacter))
 	f.StringArrayVarP(&c.suppress, "suppress", "s", []string{},
 		"paths to variables to suppress from the output to simplify it (e.g. 'provision.specs')")
+	f.StringVar(&c.explain, "explain", "", "show where variables under a path were set instead of "+
+		"their values (e.g. '--explain=kapp.vars.hostname'). Explains all variables if no path is given")
+	f.Lookup("explain").NoOptDefVal = "."
 	return cmd
 }
 
@@ -94,7 +102,8 @@ func (c *varsConfig) run() error {
 		return errors.WithStack(err)
 	}
 
-	err = dagObj.ExecuteGetVars(constants.DagActionVars, stackObj, !c.skipOutputs, c.suppress)
+	err = dagObj.ExecuteGetVars(constants.DagActionVars, stackObj, !c.skipOutputs, c.suppress,
+		c.explain)
 	if err != nil {
 		return errors.WithStack(err)
 	}
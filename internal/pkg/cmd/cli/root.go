@@ -25,6 +25,7 @@ import (
 	"github.com/sugarkube/sugarkube/internal/pkg/cmd/cli/cache"
 	"github.com/sugarkube/sugarkube/internal/pkg/cmd/cli/cluster"
 	"github.com/sugarkube/sugarkube/internal/pkg/cmd/cli/kapps"
+	"github.com/sugarkube/sugarkube/internal/pkg/cmd/cli/secrets"
 	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"os"
@@ -92,6 +93,7 @@ var rootCmd = &cobra.Command{
 
 var logLevel string
 var configFile string
+var lenientTemplates bool
 
 func NewCommand(name string) *cobra.Command {
 
@@ -106,6 +108,7 @@ func NewCommand(name string) *cobra.Command {
 		cluster.NewClusterCmds(out),
 		kapps.NewKappsCmds(out),
 		cache.NewCacheCmds(out),
+		secrets.NewSecretsCmds(out),
 	)
 
 	return rootCmd
@@ -166,11 +169,13 @@ func init() {
 		fmt.Sprintf("path to a config file. If not given, default paths "+
 			"will be searched for a file called '%s.(yaml|json)'", config.ConfigFileName))
 	rootCmd.PersistentFlags().BoolVarP(&jsonLogs, "json-logs", "j", false, "whether to emit JSON-formatted logs")
+	rootCmd.PersistentFlags().BoolVar(&lenientTemplates, "lenient", false, "render references to missing keys in templates as '<no value>' even if strict templating is enabled")
 
 	// bind viper to CLI args
 	bindings := map[string]string{
-		"log-level": "log-level",
-		"json-logs": "json-logs",
+		"log-level":         "log-level",
+		"json-logs":         "json-logs",
+		"lenient-templates": "lenient",
 	}
 
 	viperConfig := config.ViperConfig
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/secrets"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io"
+	"path/filepath"
+)
+
+type editCmd struct {
+	out  io.Writer
+	path string
+}
+
+func newEditCmd(out io.Writer) *cobra.Command {
+	c := &editCmd{
+		out: out,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "edit [flags] [file]",
+		Short: fmt.Sprintf("Create or edit an encrypted vars file"),
+		Long: fmt.Sprintf(`Opens a SOPS-encrypted vars file in your editor, decrypting it first and 
+encrypting it again when you're done. New files are encrypted for the age recipients and PGP 
+fingerprints in the 'sops' setting. Files must be named like 'values%s.yaml' to be decrypted when 
+vars are merged.`, utils.EncryptedFileMarker),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return errors.New("the path to the file to edit is required")
+			} else if len(args) > 1 {
+				return errors.New("too many arguments supplied")
+			}
+			c.path = args[0]
+			return c.run()
+		},
+	}
+
+	return cmd
+}
+
+func (c *editCmd) run() error {
+	absPath, err := filepath.Abs(c.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !utils.IsEncryptedFile(absPath) {
+		log.Logger.Warnf("'%s' won't be decrypted when merging vars because its name "+
+			"doesn't end with '%s.yaml'", absPath, utils.EncryptedFileMarker)
+	}
+
+	err = secrets.Edit(absPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Fprintf(c.out, "Saved '%s'\n", absPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+func NewSecretsCmds(out io.Writer) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "secrets [command]",
+		Short: fmt.Sprintf("Work with encrypted vars files"),
+		Long:  `Create and edit SOPS-encrypted vars files`,
+	}
+
+	cmd.AddCommand(
+		newEditCmd(out),
+	)
+
+	return cmd
+}
@@ -40,9 +40,24 @@ import (
 	"os"
 )
 
+// Returned by commands that ran successfully but need to exit with a non-zero code, e.g. to
+// fail CI builds. Commands should print any output themselves since the message isn't printed.
+type ExitCodeError struct {
+	Code    int
+	Message string
+}
+
+func (e ExitCodeError) Error() string {
+	return e.Message
+}
+
 // CheckError prints err to stderr and exits with code 1 if err is not nil. Otherwise, it is a
-// no-op.
+// no-op. ExitCodeErrors aren't printed and exit with their own code.
 func CheckError(err error) {
+	if exitCodeErr, ok := err.(ExitCodeError); ok {
+		os.Exit(exitCodeErr.Code)
+	}
+
 	if err != nil {
 		if err != context.Canceled {
 			var err2 error
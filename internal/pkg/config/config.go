@@ -51,6 +51,8 @@ func initViper(appName string) *viper.Viper {
 	v.SetDefault("log-level", "info")
 	v.SetDefault("num-workers", "5")
 	v.SetDefault("overwrite-merged-lists", false)
+	v.SetDefault("git-acquirer", "git")
+	v.SetDefault("git-mirrors", false)
 
 	v.SetConfigName(ConfigFileName)
 
@@ -37,6 +37,7 @@ func TestLoadConfig(t *testing.T) {
 		LogLevel:             "warn",
 		NumWorkers:           5,
 		OverwriteMergedLists: false,
+		GitAcquirer:          "git",
 		Programs: map[string]structs.KappConfig{
 			"helm": {
 				EnvVars: map[string]interface{}{
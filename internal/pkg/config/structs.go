@@ -26,4 +26,60 @@ type Config struct {
 	// values from lists being merged in will be appended to the existing list
 	OverwriteMergedLists bool                          `mapstructure:"overwrite-merged-lists"`
 	Programs             map[string]structs.KappConfig `mapstructure:"programs"`
+	GitAcquirer          string                        `mapstructure:"git-acquirer"`       // either 'git' to shell out to git, or 'go-git'. Sources can override this with the 'acquirer' option
+	TrustedKeys          []string                      `mapstructure:"trusted-keys"`       // GPG fingerprints. If set, all git sources must be signed by one of these keys
+	ArchiveCacheDir      string                        `mapstructure:"archive-cache-dir"`  // where downloaded archives are cached. Defaults to a directory under the user's cache dir
+	ManifestCacheDir     string                        `mapstructure:"manifest-cache-dir"` // where remote manifests are cached. Defaults to a directory under the user's cache dir
+	GitMirrors           bool                          `mapstructure:"git-mirrors"`        // if true, git sources share a bare mirror of each remote. Checkouts then depend on the mirror
+	GitMirrorDir         string                        `mapstructure:"git-mirror-dir"`     // where git mirrors are kept. Defaults to ~/.sugarkube/git-mirrors
+	CacheWorkers         int                           `mapstructure:"cache-workers"`      // max number of sources to acquire concurrently when creating caches. Defaults to 10
+	OutputConflicts      string                        `mapstructure:"output-conflicts"`   // what to do when kapps store outputs under the same registry path: 'error' (the default), 'last-writer-wins' or 'merge'
+	// if true, kapps whose vars refer to other kapps under the `kapps` namespace depend on them
+	ImplicitKappDependencies bool `mapstructure:"implicit-kapp-dependencies"`
+	// how to decrypt and edit SOPS-encrypted vars files (e.g. `values.enc.yaml`)
+	Sops SopsConfig `mapstructure:"sops"`
+	// where the `secret` and `secretFile` template functions fetch secrets from
+	Secrets SecretsConfig `mapstructure:"secrets"`
+	// if true, templates referring to missing keys are errors instead of rendering `<no value>`
+	StrictTemplates bool `mapstructure:"strict-templates"`
+	// if true, templates are never strict regardless of other settings (set with `--lenient`)
+	LenientTemplates bool `mapstructure:"lenient-templates"`
+}
+
+type SopsConfig struct {
+	Binary          string   `mapstructure:"binary"`           // path to the sops binary. Defaults to 'sops'
+	AgeKeyFile      string   `mapstructure:"age-key-file"`     // file containing age private keys for decrypting
+	AgeRecipients   []string `mapstructure:"age-recipients"`   // age public keys to encrypt new files for
+	PgpFingerprints []string `mapstructure:"pgp-fingerprints"` // PGP fingerprints to encrypt new files for
+	GnupgHome       string   `mapstructure:"gnupg-home"`       // GnuPG home dir containing PGP private keys
+}
+
+type SecretsConfig struct {
+	Backend string             `mapstructure:"backend"` // backend for references without a 'backend:' prefix. Defaults to 'env'
+	Vault   VaultSecretsConfig `mapstructure:"vault"`
+	Env     EnvSecretsConfig   `mapstructure:"env"`
+	File    FileSecretsConfig  `mapstructure:"file"`
+	Exec    ExecSecretsConfig  `mapstructure:"exec"`
+}
+
+type VaultSecretsConfig struct {
+	Address   string `mapstructure:"address"`    // defaults to $VAULT_ADDR
+	TokenFile string `mapstructure:"token-file"` // defaults to $VAULT_TOKEN, then ~/.vault-token
+	Mount     string `mapstructure:"mount"`      // path the KV v2 engine is mounted at. Defaults to 'secret'
+	Namespace string `mapstructure:"namespace"`  // Vault Enterprise namespace
+	Timeout   int    `mapstructure:"timeout"`    // seconds. Defaults to 30
+}
+
+type EnvSecretsConfig struct {
+	Prefix string `mapstructure:"prefix"` // prepended to env var names
+}
+
+type FileSecretsConfig struct {
+	Dir string `mapstructure:"dir"` // relative secret paths are resolved against this dir
+}
+
+type ExecSecretsConfig struct {
+	Command string   `mapstructure:"command"` // run with the args followed by the secret's path
+	Args    []string `mapstructure:"args"`
+	Timeout int      `mapstructure:"timeout"` // seconds. Defaults to 30
 }
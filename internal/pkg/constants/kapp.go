@@ -20,3 +20,4 @@ const KappConfigFileName = "sugarkube.yaml"
 const KappVarsKappKey = "kapp"
 const KappVarsVarsKey = "vars"
 const KappVarsTemplatesKey = "templates"
+const KappVarsKappsKey = "kapps" // namespace containing the vars of all kapps
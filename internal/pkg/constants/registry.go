@@ -21,3 +21,8 @@ const RegistryFieldSeparator = "."
 const RegistryKeyOutputs = "outputs"
 const RegistryKeyKubeConfig = "kubeconfig"
 const RegistryKeyThis = "this"
+
+// Policies for when more than one kapp stores an output under the same registry path
+const RegistryConflictError = "error"
+const RegistryConflictLastWriterWins = "last-writer-wins"
+const RegistryConflictMerge = "merge"
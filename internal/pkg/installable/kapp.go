@@ -18,7 +18,6 @@ package installable
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
@@ -27,6 +26,7 @@ import (
 	"github.com/sugarkube/sugarkube/internal/pkg/convert"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/output"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"github.com/sugarkube/sugarkube/internal/pkg/templater"
 	"github.com/sugarkube/sugarkube/internal/pkg/utils"
@@ -43,6 +43,7 @@ type Kapp struct {
 	configFileDir    string                           // path to the directory containing the kapp's sugarkube.yaml file
 	mergedDescriptor structs.KappDescriptorWithMaps   // the final descriptor after merging all the descriptor layers. This is a template until its rendered by TemplateDescriptor
 	descriptorLayers []structs.KappDescriptorWithMaps // config templates where values from later configs will take precedence over earlier ones
+	layerSources     []string                         // where each descriptor layer came from
 	kappCacheDir     string                           // the top-level directory for this kapp in the cache, i.e. the directory containing the kapp's .sugarkube directory
 	localRegistry    interfaces.IRegistry             // a registry local to the kapp that contains the results of merging
 	// each of its parents' registries, tailored depending on whether parent was in the same manifest
@@ -134,14 +135,22 @@ func (k *Kapp) AddDescriptor(config structs.KappDescriptorWithMaps, prepend bool
 		return errors.WithStack(err)
 	}
 
+	source := config.DeclaredIn
+	if source == "" {
+		source = fmt.Sprintf("a descriptor for kapp '%s'", k.FullyQualifiedId())
+	}
+
 	if prepend {
 		k.descriptorLayers = append([]structs.KappDescriptorWithMaps{configCopy}, configLayers...)
+		k.layerSources = append([]string{source}, k.layerSources...)
 	} else {
 		// until https://github.com/imdario/mergo/issues/90 is resolved we need to manually propagate
-		// non-empty fields for maps to later layers
+		// non-empty fields for maps to later layers. Values are taken from the merged descriptor
+		// because the last layer may only contain some of the sources, e.g. when a stack pins the
+		// versions of several sources there's one layer per version.
 		// todo -  remove this once https://github.com/imdario/mergo/issues/90 is merged
 		if len(k.descriptorLayers) > 0 {
-			previousLayer := k.descriptorLayers[len(k.descriptorLayers)-1]
+			previousLayer := k.mergedDescriptor
 
 			for key, previousSource := range previousLayer.Sources {
 				currentSource, ok := configCopy.Sources[key]
@@ -181,6 +190,7 @@ func (k *Kapp) AddDescriptor(config structs.KappDescriptorWithMaps, prepend bool
 		}
 
 		k.descriptorLayers = append(configLayers, configCopy)
+		k.layerSources = append(k.layerSources, source)
 	}
 
 	return k.mergeDescriptorLayers()
@@ -323,6 +333,7 @@ func (k *Kapp) LoadConfigFile(cacheDir string) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	descriptorWithMaps.DeclaredIn = configFilePath
 
 	log.Logger.Tracef("Adding descriptor to kapp '%s' for its %s file", k.FullyQualifiedId(),
 		constants.KappConfigFileName)
@@ -345,6 +356,7 @@ func (k *Kapp) LoadConfigFile(cacheDir string) error {
 
 			descriptor := structs.KappDescriptorWithMaps{
 				KappConfig: programDescriptor,
+				DeclaredIn: fmt.Sprintf("program '%s' in the sugarkube config", requirement),
 			}
 
 			log.Logger.Tracef("Adding descriptor to kapp '%s' for requirement '%s'",
@@ -378,6 +390,36 @@ func (k *Kapp) SetLocalRegistry(registry interfaces.IRegistry) {
 	k.localRegistry = registry
 }
 
+// Returns a copy of the kapp with an untemplated descriptor that isn't affected by later changes
+// to the kapp, e.g. so other kapps can read its vars while its descriptor is being templated
+func (k Kapp) Snapshot() (interfaces.IInstallable, error) {
+	snapshot := &Kapp{
+		manifestId:    k.manifestId,
+		configFileDir: k.configFileDir,
+		layerSources:  append([]string{}, k.layerSources...),
+		kappCacheDir:  k.kappCacheDir,
+	}
+
+	err := utils.DeepCopy(k.descriptorLayers, &snapshot.descriptorLayers)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if k.localRegistry != nil {
+		snapshot.localRegistry, err = k.localRegistry.Copy()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	err = snapshot.mergeDescriptorLayers()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return snapshot, nil
+}
+
 // Templates the kapp's merged descriptor
 func (k *Kapp) TemplateDescriptor(templateVars map[string]interface{}) error {
 
@@ -387,36 +429,147 @@ func (k *Kapp) TemplateDescriptor(templateVars map[string]interface{}) error {
 		return errors.WithStack(err)
 	}
 
-	configTemplate, err := yaml.Marshal(k.mergedDescriptor)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	templateString := string(configTemplate[:])
+	// vars templates are rendered separately by VarsWithProvenance
+	varsTemplate := k.mergedDescriptor.VarsTemplate
+	k.mergedDescriptor.VarsTemplate = ""
 
-	var outBuf bytes.Buffer
-	err = templater.TemplateString(templateString, &outBuf, templateVars)
+	rendered, err := templater.RenderYaml(fmt.Sprintf("the descriptor of kapp '%s'",
+		k.FullyQualifiedId()), k.mergedDescriptor, templateVars, k.StrictTemplates())
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	log.Logger.Tracef("Rendered merged kapp descriptor\n%#v\nto:\n%s",
-		templateString, outBuf.String())
+		k.mergedDescriptor, rendered)
 
 	configObj := structs.KappDescriptorWithMaps{}
-	err = yaml.Unmarshal(outBuf.Bytes(), &configObj)
+	err = yaml.Unmarshal([]byte(rendered), &configObj)
 	if err != nil {
 		return errors.Wrapf(err, "Error unmarshalling rendered merged kapp descriptor: %s",
-			outBuf.String())
+			rendered)
 	}
 
+	configObj.VarsTemplate = varsTemplate
 	k.mergedDescriptor = configObj
 	return nil
 }
 
-// Returns a map of all variables for the kapp
+// Returns whether the kapp's templates should fail if they refer to missing keys
+func (k Kapp) StrictTemplates() bool {
+	return templater.IsStrict(k.mergedDescriptor.StrictTemplates)
+}
+
+// Returns the vars templates declared by each descriptor layer, in order of precedence
+func (k Kapp) VarsTemplates() []string {
+	varsTemplates := make([]string, 0)
+	for _, layer := range k.descriptorLayers {
+		if layer.VarsTemplate != "" {
+			varsTemplates = append(varsTemplates, layer.VarsTemplate)
+		}
+	}
+
+	return varsTemplates
+}
+
+// Returns a description of where a descriptor layer came from
+func (k Kapp) layerSource(i int) string {
+	if i < len(k.layerSources) {
+		return k.layerSources[i]
+	}
+
+	return fmt.Sprintf("a descriptor for kapp '%s'", k.FullyQualifiedId())
+}
+
+// Returns a map of all variables for the kapp. Vars templates aren't rendered.
 func (k Kapp) Vars(stack interfaces.IStack) (map[string]interface{}, error) {
-	kappVars, err := k.getVarsFromFiles(stack.GetConfig())
+	return k.vars(stack, nil, nil)
+}
+
+// Returns a map of all variables for the kapp after rendering the vars template of each
+// descriptor layer with the given vars (unless they're nil), parsing them as YAML and merging
+// them with that layer's vars. Later layers take precedence. The vars file or descriptor layer
+// that set each value is recorded in the given provenance (if any).
+func (k Kapp) VarsWithProvenance(stack interfaces.IStack, templateVars map[string]interface{},
+	provenance *vars.Provenance) (map[string]interface{}, error) {
+	return k.vars(stack, templateVars, provenance)
+}
+
+// Returns the vars declared by the kapp's descriptor layers. Vars templates are only rendered if
+// template vars are given.
+func (k Kapp) declaredVars(templateVars map[string]interface{},
+	provenance *vars.Provenance) (map[string]interface{}, error) {
+	if templateVars == nil || len(k.VarsTemplates()) == 0 {
+		for i, layer := range k.descriptorLayers {
+			provenance.Record(k.layerSource(i), layer.Vars)
+		}
+		return k.mergedDescriptor.Vars, nil
+	}
+
+	declaredVars := map[string]interface{}{}
+
+	for i, layer := range k.descriptorLayers {
+		provenance.Record(k.layerSource(i), layer.Vars)
+
+		// copy the layer's vars so merging doesn't mutate nested maps in the layer
+		layerVars := map[string]interface{}{}
+		err := utils.DeepCopy(layer.Vars, &layerVars)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		err = vars.MergeWithStrategy(&declaredVars, layerVars)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if layer.VarsTemplate == "" {
+			continue
+		}
+
+		var outBuf bytes.Buffer
+		err = templater.TemplateString(fmt.Sprintf("the vars_template of kapp '%s'",
+			k.FullyQualifiedId()), layer.VarsTemplate, &outBuf, templateVars, k.StrictTemplates())
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error rendering vars_template for kapp '%s'",
+				k.FullyQualifiedId())
+		}
+
+		generatedVars := map[string]interface{}{}
+		err = yaml.Unmarshal(outBuf.Bytes(), &generatedVars)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error parsing rendered vars_template for kapp '%s' "+
+				"as YAML:\n%s", k.FullyQualifiedId(), outBuf.String())
+		}
+
+		log.Logger.Tracef("Rendered vars_template for kapp '%s' to: %#v", k.FullyQualifiedId(),
+			generatedVars)
+
+		provenance.Record(fmt.Sprintf("the vars_template of %s", k.layerSource(i)), generatedVars)
+
+		err = vars.MergeWithStrategy(&declaredVars, generatedVars)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return declaredVars, nil
+}
+
+func (k Kapp) vars(stack interfaces.IStack, templateVars map[string]interface{},
+	provenance *vars.Provenance) (map[string]interface{}, error) {
+
+	kappProvenance := provenance.Under(constants.KappVarsKappKey)
+	kappProvenance.Record(fmt.Sprintf("the intrinsic data of kapp '%s'", k.FullyQualifiedId()),
+		convert.MapStringStringToMapStringInterface(k.getIntrinsicData()))
+
+	kappVarsProvenance := kappProvenance.Under(constants.KappVarsVarsKey)
+
+	kappVars, err := k.getVarsFromFiles(stack.GetConfig(), kappVarsProvenance)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	declaredVars, err := k.declaredVars(templateVars, kappVarsProvenance)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -427,7 +580,7 @@ func (k Kapp) Vars(stack interfaces.IStack) (map[string]interface{}, error) {
 	kappIntrinsicDataConverted = convert.MapStringStringToMapStringInterface(kappIntrinsicData)
 
 	// merge kapp.Vars with the vars from files so kapp.Vars take precedence. Todo - document the order of precedence
-	err = vars.MergeWithStrategy(&kappVars, k.mergedDescriptor.Vars)
+	err = vars.MergeWithStrategy(&kappVars, declaredVars)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -455,6 +608,9 @@ func (k Kapp) Vars(stack interfaces.IStack) (map[string]interface{}, error) {
 		log.Logger.Tracef("Merging local registry for kapp '%s' with kapp vars. Local registry is: %#v",
 			k.FullyQualifiedId(), k.localRegistry)
 
+		provenance.Record(fmt.Sprintf("the outputs of the parents of kapp '%s'", k.FullyQualifiedId()),
+			k.localRegistry.AsMap())
+
 		err = vars.MergeWithStrategy(&namespacedKappMap, k.localRegistry.AsMap())
 		if err != nil {
 			return nil, errors.WithStack(err)
@@ -477,7 +633,8 @@ func (k Kapp) getIntrinsicData() map[string]string {
 
 // Finds all vars files for the given kapp and returns the result of merging
 // all the data.
-func (k Kapp) getVarsFromFiles(stackConfig interfaces.IStackConfig) (map[string]interface{}, error) {
+func (k Kapp) getVarsFromFiles(stackConfig interfaces.IStackConfig,
+	provenance *vars.Provenance) (map[string]interface{}, error) {
 	dirs, err := k.findVarsFiles(stackConfig)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -485,7 +642,7 @@ func (k Kapp) getVarsFromFiles(stackConfig interfaces.IStackConfig) (map[string]
 
 	values := map[string]interface{}{}
 
-	err = vars.MergePaths(&values, dirs...)
+	err = vars.MergePathsWithProvenance(&values, provenance, dirs...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -577,9 +734,25 @@ func (k Kapp) findVarsFiles(stackConfig interfaces.IStackConfig) ([]string, erro
 	return paths, nil
 }
 
-// Renders templates for the kapp and returns the paths they were written to
+// Renders templates for the kapp and returns the paths they were written to. Sensitive templates
+// aren't rendered until just before the kapp is executed, but their paths are still returned
+// so the positions of paths don't depend on whether templates are sensitive.
 func (k *Kapp) RenderTemplates(templateVars map[string]interface{}, stackConfig interfaces.IStackConfig,
 	dryRun bool) ([]string, error) {
+	return k.renderTemplates(templateVars, stackConfig, false, dryRun)
+}
+
+// Renders the kapp's sensitive templates and returns the paths they were written to. They're
+// only readable by the current user and must be deleted with DeleteSensitiveTemplates as soon
+// as the kapp has been executed.
+func (k *Kapp) RenderSensitiveTemplates(templateVars map[string]interface{},
+	stackConfig interfaces.IStackConfig, dryRun bool) ([]string, error) {
+	return k.renderTemplates(templateVars, stackConfig, true, dryRun)
+}
+
+// Renders either the sensitive or non-sensitive templates for the kapp
+func (k *Kapp) renderTemplates(templateVars map[string]interface{}, stackConfig interfaces.IStackConfig,
+	sensitive bool, dryRun bool) ([]string, error) {
 
 	dryRunPrefix := ""
 	if dryRun {
@@ -599,77 +772,43 @@ func (k *Kapp) RenderTemplates(templateVars map[string]interface{}, stackConfig
 		return renderedPaths, nil
 	}
 
-	log.Logger.Infof("%sRendering templates for kapp '%s'", dryRunPrefix, k.FullyQualifiedId())
-
-	for _, templateDefinition := range k.mergedDescriptor.Templates {
-		rawTemplateSource := templateDefinition.Source
+	templateKind := "templates"
+	if sensitive {
+		templateKind = "sensitive templates"
+	}
 
-		if rawTemplateSource == "" {
-			return nil, errors.New(fmt.Sprintf("Template has an empty source: %+v", templateDefinition))
-		}
+	log.Logger.Infof("%sRendering %s for kapp '%s'", dryRunPrefix, templateKind, k.FullyQualifiedId())
 
+	for _, templateDefinition := range k.mergedDescriptor.Templates {
 		log.Logger.Debugf("Template definition: %+v", templateDefinition)
 
-		// run the source path through the templater in case it contains variables
-		templateSource, err := templater.RenderTemplate(rawTemplateSource, templateVars)
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		if !filepath.IsAbs(templateSource) {
-			foundTemplate := false
-
-			// see whether the template is in the kapp itself
-			possibleSource := filepath.Join(k.configFileDir, templateSource)
-			log.Logger.Debugf("Searching for kapp template '%s' in '%s'", templateSource, possibleSource)
-			_, err := os.Stat(possibleSource)
-			if err == nil {
-				templateSource = possibleSource
-				foundTemplate = true
+		if templateDefinition.Sensitive != sensitive {
+			if sensitive {
+				continue
 			}
 
-			if !foundTemplate {
-				// search each template directory defined in the stack config
-				for _, templateDir := range stackConfig.TemplateDirs() {
-					possibleSource := filepath.Join(stackConfig.GetDir(), templateDir, templateSource)
-					log.Logger.Debugf("Searching for kapp template in '%s'", possibleSource)
-					_, err := os.Stat(possibleSource)
-					if err == nil {
-						templateSource = possibleSource
-						foundTemplate = true
-						break
-					}
-				}
+			destPath, err := k.templateDestPath(templateDefinition, templateVars)
+			if err != nil {
+				return renderedPaths, errors.WithStack(err)
 			}
 
-			if foundTemplate {
-				log.Logger.Debugf("Found template at %s", templateSource)
-			} else {
-				return renderedPaths, errors.New(fmt.Sprintf("Failed to find template '%s' "+
-					"in any of the defined template directories: %s", templateSource,
-					strings.Join(stackConfig.TemplateDirs(), ", ")))
-			}
+			log.Logger.Debugf("Not rendering sensitive template '%s' for kapp '%s' until it's "+
+				"executed", destPath, k.FullyQualifiedId())
+			renderedPaths = append(renderedPaths, destPath)
+			continue
 		}
 
-		if !filepath.IsAbs(templateSource) {
-			templateSource, err = filepath.Abs(templateSource)
-			if err != nil {
-				return renderedPaths, errors.WithStack(err)
-			}
+		templateSource, err := k.templateSourcePath(templateDefinition, templateVars, stackConfig)
+		if err != nil {
+			return renderedPaths, errors.WithStack(err)
 		}
 
 		log.Logger.Debugf("%sTemplating file '%s' with vars: %#v", dryRunPrefix,
 			templateSource, templateVars)
 
-		rawDestPath := templateDefinition.Dest
-		// run the dest path through the templater in case it contains variables
-		destPath, err := templater.RenderTemplate(rawDestPath, templateVars)
+		destPath, err := k.templateDestPath(templateDefinition, templateVars)
 		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		if !filepath.IsAbs(destPath) {
-			destPath = filepath.Join(k.configFileDir, destPath)
+			return renderedPaths, errors.WithStack(err)
 		}
 
 		// check whether the dest path exists
@@ -688,17 +827,24 @@ func (k *Kapp) RenderTemplates(templateVars map[string]interface{}, stackConfig
 
 		var outBuf bytes.Buffer
 
-		err = templater.TemplateFile(templateSource, &outBuf, templateVars)
+		err = templater.TemplateFile(templateSource, &outBuf, templateVars, k.StrictTemplates())
 		if err != nil {
 			return renderedPaths, errors.WithStack(err)
 		}
 
 		log.Logger.Infof("%sWriting rendered template '%s' for kapp "+
 			"'%s' to '%s'", dryRunPrefix, templateSource, k.FullyQualifiedId(), destPath)
-		log.Logger.Tracef("%sTemplate rendered as:\n%s", dryRunPrefix, outBuf.String())
+
+		if !sensitive {
+			log.Logger.Tracef("%sTemplate rendered as:\n%s", dryRunPrefix, outBuf.String())
+		}
 
 		if !dryRun {
-			err := ioutil.WriteFile(destPath, outBuf.Bytes(), 0644)
+			if sensitive {
+				err = k.writeSensitiveTemplate(destPath, outBuf.Bytes())
+			} else {
+				err = ioutil.WriteFile(destPath, outBuf.Bytes(), 0644)
+			}
 			if err != nil {
 				return renderedPaths, errors.WithStack(err)
 			}
@@ -710,8 +856,134 @@ func (k *Kapp) RenderTemplates(templateVars map[string]interface{}, stackConfig
 	return renderedPaths, nil
 }
 
-// Loads outputs for the kapp, parses and returns them
-func (k Kapp) GetOutputs(ignoreMissing bool, dryRun bool) (map[string]interface{}, error) {
+// Returns the paths of the kapp's templates that haven't been rendered or were rendered before
+// their source was last modified. Sensitive templates are ignored since they're only rendered
+// while the kapp is executed.
+func (k *Kapp) StaleTemplates(templateVars map[string]interface{},
+	stackConfig interfaces.IStackConfig) ([]string, error) {
+
+	stale := make([]string, 0)
+
+	for _, templateDefinition := range k.mergedDescriptor.Templates {
+		if templateDefinition.Sensitive {
+			continue
+		}
+
+		templateSource, err := k.templateSourcePath(templateDefinition, templateVars, stackConfig)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		destPath, err := k.templateDestPath(templateDefinition, templateVars)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		sourceInfo, err := os.Stat(templateSource)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		destInfo, err := os.Stat(destPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.WithStack(err)
+		}
+
+		if destInfo == nil || destInfo.ModTime().Before(sourceInfo.ModTime()) {
+			stale = append(stale, destPath)
+		}
+	}
+
+	return stale, nil
+}
+
+// Returns the absolute path to the source of a template. Relative paths are searched for in the
+// kapp then in each template directory defined in the stack config.
+func (k *Kapp) templateSourcePath(templateDefinition structs.Template, templateVars map[string]interface{},
+	stackConfig interfaces.IStackConfig) (string, error) {
+
+	rawTemplateSource := templateDefinition.Source
+
+	if rawTemplateSource == "" {
+		return "", errors.New(fmt.Sprintf("Template has an empty source: %+v", templateDefinition))
+	}
+
+	// run the source path through the templater in case it contains variables
+	templateSource, err := templater.RenderNamedTemplate(fmt.Sprintf("the template source '%s' "+
+		"of kapp '%s'", rawTemplateSource, k.FullyQualifiedId()), rawTemplateSource, templateVars,
+		k.StrictTemplates())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if !filepath.IsAbs(templateSource) {
+		foundTemplate := false
+
+		// see whether the template is in the kapp itself
+		possibleSource := filepath.Join(k.configFileDir, templateSource)
+		log.Logger.Debugf("Searching for kapp template '%s' in '%s'", templateSource, possibleSource)
+		_, err := os.Stat(possibleSource)
+		if err == nil {
+			templateSource = possibleSource
+			foundTemplate = true
+		}
+
+		if !foundTemplate {
+			// search each template directory defined in the stack config
+			for _, templateDir := range stackConfig.TemplateDirs() {
+				possibleSource := filepath.Join(stackConfig.GetDir(), templateDir, templateSource)
+				log.Logger.Debugf("Searching for kapp template in '%s'", possibleSource)
+				_, err := os.Stat(possibleSource)
+				if err == nil {
+					templateSource = possibleSource
+					foundTemplate = true
+					break
+				}
+			}
+		}
+
+		if foundTemplate {
+			log.Logger.Debugf("Found template at %s", templateSource)
+		} else {
+			return "", errors.New(fmt.Sprintf("Failed to find template '%s' "+
+				"in any of the defined template directories: %s", templateSource,
+				strings.Join(stackConfig.TemplateDirs(), ", ")))
+		}
+	}
+
+	if !filepath.IsAbs(templateSource) {
+		templateSource, err = filepath.Abs(templateSource)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	return templateSource, nil
+}
+
+// Returns the path a template should be rendered to. Relative paths are relative to the
+// directory containing the kapp's sugarkube.yaml file.
+func (k *Kapp) templateDestPath(templateDefinition structs.Template,
+	templateVars map[string]interface{}) (string, error) {
+
+	// run the dest path through the templater in case it contains variables
+	destPath, err := templater.RenderNamedTemplate(fmt.Sprintf("the template dest '%s' of "+
+		"kapp '%s'", templateDefinition.Dest, k.FullyQualifiedId()), templateDefinition.Dest,
+		templateVars, k.StrictTemplates())
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if !filepath.IsAbs(destPath) {
+		destPath = filepath.Join(k.configFileDir, destPath)
+	}
+
+	return destPath, nil
+}
+
+// Loads outputs for the kapp from files or the stdout of its output target, then parses and
+// returns them
+func (k Kapp) GetOutputs(stdout []byte, ignoreMissing bool, dryRun bool) (map[string]interface{}, error) {
 	outputs := map[string]interface{}{}
 
 	dryRunPrefix := ""
@@ -719,72 +991,90 @@ func (k Kapp) GetOutputs(ignoreMissing bool, dryRun bool) (map[string]interface{
 		dryRunPrefix = "[Dry run] "
 	}
 
-	for _, output := range k.mergedDescriptor.Outputs {
-		// if the output exists, parse it as the declared type and put it in the map
-		path, err := filepath.Abs(filepath.Join(k.configFileDir, output.Path))
-		if err != nil {
-			return nil, errors.WithStack(err)
+	for _, outputDef := range k.mergedDescriptor.Outputs {
+		if !output.IsSupported(outputDef.Format) {
+			return nil, errors.New(fmt.Sprintf("Unsupported output format '%s' for kapp '%s'. "+
+				"Supported formats are: %s", outputDef.Format, k.FullyQualifiedId(),
+				strings.Join(output.Formats(), ", ")))
 		}
 
-		if !dryRun {
-			if _, err = os.Stat(path); err != nil {
+		var data []byte
+		var path string
+		var err error
+
+		switch outputDef.Source {
+		case output.SourceStdout:
+			if !dryRun && len(bytes.TrimSpace(stdout)) == 0 {
 				if ignoreMissing {
-					log.Logger.Infof("Ignoring missing output '%s'", path)
-					outputs[output.Id] = nil
+					log.Logger.Infof("Ignoring missing output '%s' because kapp '%s' didn't write "+
+						"anything to stdout", outputDef.Id, k.FullyQualifiedId())
+					outputs[outputDef.Id] = nil
 					continue
 				} else {
-					return nil, errors.WithStack(err)
+					return nil, errors.New(fmt.Sprintf("Kapp '%s' didn't write anything to stdout "+
+						"for output '%s'", k.FullyQualifiedId(), outputDef.Id))
 				}
 			}
-		}
 
-		log.Logger.Infof("%sLoading output '%s' from kapp '%s' at '%s' as %s", dryRunPrefix,
-			output.Id, k.FullyQualifiedId(), path, output.Format)
-
-		var parsedOutput interface{}
+			log.Logger.Infof("%sLoading output '%s' from the stdout of kapp '%s' as %s", dryRunPrefix,
+				outputDef.Id, k.FullyQualifiedId(), outputDef.Format)
+			data = stdout
+		case "", output.SourceFile:
+			// if the output exists, parse it as the declared type and put it in the map
+			path, err = filepath.Abs(filepath.Join(k.configFileDir, outputDef.Path))
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
 
-		switch strings.ToLower(output.Format) {
-		case "json":
 			if !dryRun {
-				rawJson, err := ioutil.ReadFile(path)
-				if err != nil {
-					return nil, errors.WithStack(err)
-				}
-
-				err = json.Unmarshal(rawJson, &parsedOutput)
-				if err != nil {
-					return nil, errors.WithStack(err)
+				if _, err = os.Stat(path); err != nil {
+					if ignoreMissing {
+						log.Logger.Infof("Ignoring missing output '%s'", path)
+						outputs[outputDef.Id] = nil
+						continue
+					} else {
+						return nil, errors.WithStack(err)
+					}
 				}
 			}
-		case "yaml":
-			if !dryRun {
-				err = utils.LoadYamlFile(path, &parsedOutput)
-				if err != nil {
-					return nil, errors.WithStack(err)
-				}
 
-				parsedOutput, err = convert.MapInterfaceInterfaceToMapStringInterface(parsedOutput.(map[interface{}]interface{}))
-				if err != nil {
-					return nil, errors.WithStack(err)
-				}
-			}
-		case "text":
+			log.Logger.Infof("%sLoading output '%s' from kapp '%s' at '%s' as %s", dryRunPrefix,
+				outputDef.Id, k.FullyQualifiedId(), path, outputDef.Format)
+
 			if !dryRun {
-				byteOutput, err := ioutil.ReadFile(path)
+				data, err = ioutil.ReadFile(path)
 				if err != nil {
 					return nil, errors.WithStack(err)
 				}
-				parsedOutput = string(byteOutput)
 			}
 		default:
-			return nil, errors.New(fmt.Sprintf("Unsupported output format '%s' for kapp '%s'",
-				output.Format, k.FullyQualifiedId()))
+			return nil, errors.New(fmt.Sprintf("Unsupported source '%s' for output '%s' of kapp '%s'. "+
+				"Valid values are '%s' and '%s'", outputDef.Source, outputDef.Id, k.FullyQualifiedId(),
+				output.SourceFile, output.SourceStdout))
 		}
 
-		outputs[output.Id] = parsedOutput
+		var parsedOutput interface{}
+		sensitive := outputDef.Sensitive
+
+		if !dryRun {
+			var sensitiveData bool
+			parsedOutput, sensitiveData, err = output.Extract(outputDef, data)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error parsing output '%s' of kapp '%s'",
+					outputDef.Id, k.FullyQualifiedId())
+			}
 
-		// if it's sensitive, delete it
-		if output.Sensitive {
+			if sensitiveData && !sensitive {
+				log.Logger.Infof("Treating output '%s' of kapp '%s' as sensitive because its "+
+					"data contains sensitive values", outputDef.Id, k.FullyQualifiedId())
+				sensitive = true
+			}
+		}
+
+		outputs[outputDef.Id] = parsedOutput
+
+		// if it's sensitive, delete it. Outputs read from stdout never touch the disk.
+		if sensitive && path != "" {
 			log.Logger.Infof("%sDeleting sensitive output file: %s", dryRunPrefix, path)
 			if !dryRun {
 				err = os.Remove(path)
@@ -793,7 +1083,59 @@ func (k Kapp) GetOutputs(ignoreMissing bool, dryRun bool) (map[string]interface{
 				}
 			}
 		}
+
+		// validate after deleting sensitive files so they don't linger if validation fails
+		if !dryRun {
+			err = output.Validate(parsedOutput, outputDef.Schema)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Output '%s' of kapp '%s' doesn't match its schema",
+					outputDef.Id, k.FullyQualifiedId())
+			}
+		}
 	}
 
 	return outputs, nil
 }
+
+// Checks the output files that currently exist for the kapp parse and match their schemas. Unlike
+// GetOutputs, sensitive files aren't deleted. Returns a problem (or nil) keyed by output ID.
+// Outputs read from stdout are skipped since they only exist while the kapp runs, as are
+// sensitive output files that don't exist because they're deleted after they're loaded.
+func (k Kapp) ValidateOutputs() (map[string]error, error) {
+	results := map[string]error{}
+
+	for _, outputDef := range k.mergedDescriptor.Outputs {
+		switch outputDef.Source {
+		case output.SourceStdout:
+			continue
+		case "", output.SourceFile:
+		default:
+			results[outputDef.Id] = errors.New(fmt.Sprintf("Unsupported source '%s'", outputDef.Source))
+			continue
+		}
+
+		path, err := filepath.Abs(filepath.Join(k.configFileDir, outputDef.Path))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			if outputDef.Sensitive && os.IsNotExist(err) {
+				continue
+			}
+			results[outputDef.Id] = errors.Wrapf(err, "Error reading output file")
+			continue
+		}
+
+		parsedOutput, _, err := output.Extract(outputDef, data)
+		if err != nil {
+			results[outputDef.Id] = errors.Wrapf(err, "Error parsing output as %s", outputDef.Format)
+			continue
+		}
+
+		results[outputDef.Id] = output.Validate(parsedOutput, outputDef.Schema)
+	}
+
+	return results, nil
+}
@@ -17,13 +17,184 @@
 package installable
 
 import (
+	"github.com/stretchr/testify/assert"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
 )
 
 func init() {
 	log.ConfigureLogger("debug", false)
 }
 
+// Tests outputs are parsed by format and that terraform outputs with sensitive values are deleted
+func TestGetOutputs(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kapp-outputs-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		"terraform.json": `{"password": {"sensitive": true, "type": "string", "value": "secret"}}`,
+		"db.env":         "DB_HOST=db.example.com",
+	}
+	for name, contents := range files {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(tmpDir, name), []byte(contents), 0644))
+	}
+
+	installableObj, err := New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id: "wordpress",
+			Outputs: map[string]structs.Output{
+				"tf":  {Id: "tf", Path: "terraform.json", Format: "terraform"},
+				"env": {Id: "env", Path: "db.env", Format: "dotenv"},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	kapp := installableObj.(*Kapp)
+	kapp.configFileDir = tmpDir
+
+	outputs, err := kapp.GetOutputs(nil, false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"tf":  map[string]interface{}{"password": "secret"},
+		"env": map[string]interface{}{"DB_HOST": "db.example.com"},
+	}, outputs)
+
+	_, err = os.Stat(filepath.Join(tmpDir, "terraform.json"))
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, filepath.Join(tmpDir, "db.env"))
+
+	// outputs can be parsed from stdout, in which case nothing's deleted
+	kapp.mergedDescriptor.Outputs = map[string]structs.Output{
+		"endpoint": {Id: "endpoint", Source: "stdout", Format: "text", Regex: `endpoint=(\S+)`,
+			Sensitive: true},
+		"env": {Id: "env", Path: "db.env", Format: "dotenv", JsonPath: "$.DB_HOST"},
+	}
+
+	outputs, err = kapp.GetOutputs([]byte("endpoint=db:5432\n"), false, false)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"endpoint": "db:5432",
+		"env":      "db.example.com",
+	}, outputs)
+
+	outputs, err = kapp.GetOutputs(nil, true, false)
+	assert.Nil(t, err)
+	assert.Nil(t, outputs["endpoint"])
+
+	_, err = kapp.GetOutputs(nil, false, false)
+	assert.NotNil(t, err)
+
+	kapp.mergedDescriptor.Outputs["xml"] = structs.Output{Id: "xml", Path: "db.env", Format: "xml"}
+	_, err = kapp.GetOutputs(nil, false, true)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unsupported output format 'xml'")
+}
+
+func TestOutputSchemas(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kapp-outputs-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(tmpDir, "vpc.json"),
+		[]byte(`{"vpc": {"cidr": "10.0.0.0/16"}}`), 0644))
+
+	schema := &structs.OutputSchema{
+		Type: "object",
+		Properties: map[string]structs.OutputSchema{
+			"vpc": {Type: "object", Required: []string{"id", "cidr"}},
+		},
+	}
+
+	installableObj, err := New("network", []structs.KappDescriptorWithMaps{
+		{
+			Id: "vpc",
+			Outputs: map[string]structs.Output{
+				"vpc":    {Id: "vpc", Path: "vpc.json", Format: "json", Schema: schema, Sensitive: true},
+				"stdout": {Id: "stdout", Source: "stdout", Format: "text"},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	kapp := installableObj.(*Kapp)
+	kapp.configFileDir = tmpDir
+
+	// validating existing outputs doesn't delete sensitive files
+	results, err := kapp.ValidateOutputs()
+	assert.Nil(t, err)
+	assert.Len(t, results, 1)
+	assert.EqualError(t, results["vpc"], "$.vpc.id: required key is missing")
+	assert.FileExists(t, filepath.Join(tmpDir, "vpc.json"))
+
+	_, err = kapp.GetOutputs([]byte("done"), false, false)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Output 'vpc' of kapp 'network:vpc' doesn't match its schema: "+
+		"$.vpc.id: required key is missing")
+
+	// the sensitive file is deleted even though it's invalid
+	_, err = os.Stat(filepath.Join(tmpDir, "vpc.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	// sensitive files that have been deleted are skipped
+	results, err = kapp.ValidateOutputs()
+	assert.Nil(t, err)
+	_, checked := results["vpc"]
+	assert.False(t, checked)
+}
+
+func TestStrictTemplates(t *testing.T) {
+	strict := true
+	lenient := false
+
+	templateVars := map[string]interface{}{
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{"hostname": "example.com"},
+		},
+	}
+
+	installableObj, err := New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id: "wordpress",
+			KappConfig: structs.KappConfig{
+				EnvVars:         map[string]interface{}{"HOST": "{{ .kapp.vars.hostnme }}"},
+				StrictTemplates: &lenient,
+			},
+		},
+		{
+			Id:         "wordpress",
+			KappConfig: structs.KappConfig{StrictTemplates: &strict},
+		},
+	})
+	assert.Nil(t, err)
+	assert.True(t, installableObj.StrictTemplates(), "later layers should take precedence")
+
+	err = installableObj.TemplateDescriptor(templateVars)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Field 'env_vars.HOST' of the descriptor of kapp 'web:wordpress' "+
+		"refers to '.kapp.vars.hostnme' on line 1 but it doesn't exist. Did you mean '.kapp.vars.hostname'?")
+
+	installableObj, err = New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id: "wordpress",
+			KappConfig: structs.KappConfig{
+				EnvVars: map[string]interface{}{"HOST": "{{ .kapp.vars.hostnme }}"},
+			},
+		},
+	})
+	assert.Nil(t, err)
+	assert.False(t, installableObj.StrictTemplates())
+
+	err = installableObj.TemplateDescriptor(templateVars)
+	assert.Nil(t, err)
+	assert.Equal(t, "<no value>", installableObj.GetDescriptor().EnvVars["HOST"])
+}
+
 // todo - test adding and merging config layers
 
 //func TestFindKappVarsFiles(t *testing.T) {
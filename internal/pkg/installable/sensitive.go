@@ -0,0 +1,134 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installable
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Paths of rendered sensitive templates are recorded in this file in the kapp's cache dir
+// before they're written, so they can be deleted by a later run if this one crashes
+const sensitiveTemplatesFile = ".sensitive-templates"
+
+// Kapps that currently have sensitive templates rendered, keyed by fully qualified ID
+var renderedSensitive = struct {
+	sync.Mutex
+	kapps map[string]*Kapp
+}{
+	kapps: map[string]*Kapp{},
+}
+
+// Writes a sensitive template so it's only readable by the current user, recording its path first
+func (k *Kapp) writeSensitiveTemplate(destPath string, data []byte) error {
+	recordedPath := destPath
+	if relativePath, err := filepath.Rel(k.GetCacheDir(), destPath); err == nil &&
+		!strings.HasPrefix(relativePath, "..") {
+		recordedPath = relativePath
+	}
+
+	renderedSensitive.Lock()
+	renderedSensitive.kapps[k.FullyQualifiedId()] = k
+	renderedSensitive.Unlock()
+
+	err := utils.AppendToFile(filepath.Join(k.GetCacheDir(), sensitiveTemplatesFile), recordedPath+"\n")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// remove any existing file since its permissions wouldn't be changed by overwriting it
+	err = os.Remove(destPath)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	file, err := os.OpenFile(destPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return errors.WithStack(err)
+}
+
+// Deletes any sensitive templates that have been rendered for the kapp, including by previous
+// runs that didn't exit cleanly
+func (k *Kapp) DeleteSensitiveTemplates() error {
+	recordPath := filepath.Join(k.GetCacheDir(), sensitiveTemplatesFile)
+
+	data, err := ioutil.ReadFile(recordPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
+	for _, path := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if path == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(k.GetCacheDir(), path)
+		}
+
+		log.Logger.Infof("Deleting sensitive template '%s' for kapp '%s'", path, k.FullyQualifiedId())
+
+		err = os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "Error deleting sensitive template '%s'", path)
+		}
+	}
+
+	err = os.Remove(recordPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	renderedSensitive.Lock()
+	delete(renderedSensitive.kapps, k.FullyQualifiedId())
+	renderedSensitive.Unlock()
+
+	return nil
+}
+
+// Deletes the sensitive templates of all kapps that have them rendered. This is for use when
+// the process is terminated by a signal.
+func DeleteRenderedSensitiveTemplates() {
+	renderedSensitive.Lock()
+	kapps := make([]*Kapp, 0, len(renderedSensitive.kapps))
+	for _, kapp := range renderedSensitive.kapps {
+		kapps = append(kapps, kapp)
+	}
+	renderedSensitive.Unlock()
+
+	for _, kapp := range kapps {
+		err := kapp.DeleteSensitiveTemplates()
+		if err != nil {
+			log.Logger.Errorf("Failed to delete sensitive templates for kapp '%s': %s",
+				kapp.FullyQualifiedId(), err)
+		}
+	}
+}
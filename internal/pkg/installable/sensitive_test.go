@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package installable
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/mock"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSensitiveTemplates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sensitive-templates-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	configFileDir := filepath.Join(tmpDir, "web", "wordpress", ".sugarkube", "wordpress")
+	assert.Nil(t, os.MkdirAll(configFileDir, 0755))
+
+	for name, contents := range map[string]string{
+		"backend.tf.tpl": "bucket = \"{{ .bucket }}\"",
+		"secrets.tf.tpl": "password = \"{{ .password }}\"",
+	} {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(configFileDir, name), []byte(contents), 0644))
+	}
+
+	// an existing copy of the sensitive template is readable by everyone
+	secretsPath := filepath.Join(configFileDir, "secrets.tf")
+	assert.Nil(t, ioutil.WriteFile(secretsPath, []byte("old"), 0644))
+
+	installableObj, err := New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id: "wordpress",
+			KappConfig: structs.KappConfig{
+				Templates: []structs.Template{
+					{Source: "secrets.tf.tpl", Dest: "secrets.tf", Sensitive: true},
+					{Source: "backend.tf.tpl", Dest: "backend.tf"},
+				},
+			},
+		},
+	})
+	assert.Nil(t, err)
+
+	kapp := installableObj.(*Kapp)
+	kapp.kappCacheDir = filepath.Join(tmpDir, "web", "wordpress")
+	kapp.configFileDir = configFileDir
+
+	templateVars := map[string]interface{}{"bucket": "state", "password": "secret"}
+	backendPath := filepath.Join(configFileDir, "backend.tf")
+
+	// sensitive templates aren't rendered with the others but keep their position
+	paths, err := kapp.RenderTemplates(templateVars, mock.Config{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{secretsPath, backendPath}, paths)
+	assert.FileExists(t, backendPath)
+
+	contents, err := ioutil.ReadFile(secretsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "old", string(contents))
+
+	paths, err = kapp.RenderSensitiveTemplates(templateVars, mock.Config{}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{secretsPath}, paths)
+
+	contents, err = ioutil.ReadFile(secretsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "password = \"secret\"", string(contents))
+
+	info, err := os.Stat(secretsPath)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// a new instance of the kapp can delete templates left by a run that crashed
+	leftOver := &Kapp{
+		mergedDescriptor: kapp.mergedDescriptor,
+		manifestId:       "web",
+		kappCacheDir:     kapp.kappCacheDir,
+	}
+	assert.Nil(t, leftOver.DeleteSensitiveTemplates())
+
+	_, err = os.Stat(secretsPath)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(kapp.kappCacheDir, sensitiveTemplatesFile))
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, backendPath)
+
+	// nothing happens if there's nothing to delete
+	assert.Nil(t, kapp.DeleteSensitiveTemplates())
+}
+
+func TestDeleteRenderedSensitiveTemplates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "sensitive-templates-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	installableObj, err := New("web", []structs.KappDescriptorWithMaps{{Id: "wordpress"}})
+	assert.Nil(t, err)
+
+	kapp := installableObj.(*Kapp)
+	kapp.kappCacheDir = tmpDir
+
+	// templates outside the kapp's cache dir are recorded by their absolute paths
+	outsidePath := filepath.Join(os.TempDir(), filepath.Base(tmpDir)+"-kubeconfig")
+	defer os.Remove(outsidePath)
+
+	assert.Nil(t, kapp.writeSensitiveTemplate(outsidePath, []byte("token")))
+	assert.FileExists(t, outsidePath)
+
+	DeleteRenderedSensitiveTemplates()
+
+	_, err = os.Stat(outsidePath)
+	assert.True(t, os.IsNotExist(err))
+	assert.Empty(t, renderedSensitive.kapps)
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/output"
 	"github.com/sugarkube/sugarkube/internal/pkg/utils"
 	"path/filepath"
 	"strings"
@@ -43,20 +44,21 @@ func (i MakeInstaller) Name() string {
 	return "make"
 }
 
-// Run the given make target
+// Runs a make target, returning its stdout. If `silent` is true make won't echo commands and
+// stdout isn't logged, so it can be parsed and can contain secrets.
 func (i MakeInstaller) run(makeTarget string, installable interfaces.IInstallable, stack interfaces.IStack,
-	approved bool, dryRun bool) error {
+	approved bool, silent bool, dryRun bool) ([]byte, error) {
 
 	// search for the Makefile
 	makefilePaths, err := utils.FindFilesByPattern(installable.GetCacheDir(), "Makefile",
 		true, false)
 	if err != nil {
-		return errors.Wrapf(err, "Error finding Makefile in '%s'",
+		return nil, errors.Wrapf(err, "Error finding Makefile in '%s'",
 			installable.GetCacheDir())
 	}
 
 	if len(makefilePaths) == 0 {
-		return errors.New(fmt.Sprintf("No makefile found for kapp '%s' "+
+		return nil, errors.New(fmt.Sprintf("No makefile found for kapp '%s' "+
 			"in '%s'", installable.Id(), installable.GetCacheDir()))
 	}
 	if len(makefilePaths) > 1 {
@@ -86,7 +88,7 @@ func (i MakeInstaller) run(makeTarget string, installable interfaces.IInstallabl
 	// add all kapp vars as env vars
 	installableVars, err := installable.Vars(stack)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
 	kappAllVars, ok := installableVars[constants.KappVarsKappKey]
@@ -109,6 +111,9 @@ func (i MakeInstaller) run(makeTarget string, installable interfaces.IInstallabl
 	}
 
 	cliArgs := []string{makeTarget}
+	if silent {
+		cliArgs = append([]string{"--silent"}, cliArgs...)
+	}
 
 	targetArgs := installable.GetCliArgs(i.Name(), makeTarget)
 	log.Logger.Debugf("Kapp '%s' has args for %s %s (approved=%v): %#v",
@@ -120,7 +125,7 @@ func (i MakeInstaller) run(makeTarget string, installable interfaces.IInstallabl
 
 	makefilePath, err := filepath.Abs(makefilePaths[0])
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, errors.WithStack(err)
 	}
 
 	log.Logger.Infof("Running 'make %s' on kapp '%s' with APPROVED=%v...", makeTarget,
@@ -130,18 +135,28 @@ func (i MakeInstaller) run(makeTarget string, installable interfaces.IInstallabl
 	err = utils.ExecCommand("make", cliArgs, envVars, &stdoutBuf,
 		&stderrBuf, filepath.Dir(makefilePath), 0, dryRun)
 
-	log.Logger.Infof("Stdout: %s", stdoutBuf.String())
+	if silent {
+		log.Logger.Infof("Stdout: <%d bytes not logged>", stdoutBuf.Len())
+	} else {
+		log.Logger.Infof("Stdout: %s", stdoutBuf.String())
+	}
 	log.Logger.Infof("Stderr: %s", stderrBuf.String())
 
 	// some commands write to stderr, so we can't just fail if that buffer is non-zero
 	if err != nil {
-		return errors.WithStack(err)
+		if silent {
+			// don't return the wrapped error because it includes stdout
+			return nil, errors.New(fmt.Sprintf("Error running 'make %s' for kapp '%s': %s",
+				strings.Join(cliArgs, " "), installable.FullyQualifiedId(),
+				strings.TrimSpace(stderrBuf.String())))
+		}
+		return nil, errors.WithStack(err)
 	}
 
 	log.Logger.Infof("Kapp '%s' successfully processed (approved=%v, dry run=%v)",
 		installable.FullyQualifiedId(), approved, dryRun)
 
-	return nil
+	return stdoutBuf.Bytes(), nil
 }
 
 // Install a kapp
@@ -149,7 +164,8 @@ func (i MakeInstaller) Install(installableObj interfaces.IInstallable, stack int
 	approved bool, dryRun bool) error {
 	log.Logger.Infof("Installing kapp '%s' (approved=%v, dry run=%v)...",
 		installableObj.FullyQualifiedId(), approved, dryRun)
-	return i.run(TargetInstall, installableObj, stack, approved, dryRun)
+	_, err := i.run(TargetInstall, installableObj, stack, approved, false, dryRun)
+	return err
 }
 
 // Delete a kapp
@@ -157,21 +173,32 @@ func (i MakeInstaller) Delete(installableObj interfaces.IInstallable, stack inte
 	approved bool, dryRun bool) error {
 	log.Logger.Infof("Deleting kapp '%s' (approved=%v, dry run=%v)...",
 		installableObj.FullyQualifiedId(), approved, dryRun)
-	return i.run(TargetDelete, installableObj, stack, approved, dryRun)
+	_, err := i.run(TargetDelete, installableObj, stack, approved, false, dryRun)
+	return err
 }
 
-// Get a kapp's outputs
+// Get a kapp's outputs, returning the stdout of the output target
 func (i MakeInstaller) Output(installableObj interfaces.IInstallable, stack interfaces.IStack,
-	dryRun bool) error {
+	dryRun bool) ([]byte, error) {
 	log.Logger.Infof("Getting output for kapp '%s'...", installableObj.FullyQualifiedId())
-	return i.run(TargetOutput, installableObj, stack, true, dryRun)
+
+	// outputs parsed from stdout mustn't be mixed up with echoed commands, and may be secret
+	silent := false
+	for _, outputObj := range installableObj.GetDescriptor().Outputs {
+		if outputObj.Source == output.SourceStdout {
+			silent = true
+		}
+	}
+
+	return i.run(TargetOutput, installableObj, stack, true, silent, dryRun)
 }
 
 // Clean a kapp
 func (i MakeInstaller) Clean(installableObj interfaces.IInstallable, stack interfaces.IStack,
 	dryRun bool) error {
 	log.Logger.Infof("Cleaning kapp '%s'...", installableObj.FullyQualifiedId())
-	return i.run(TargetClean, installableObj, stack, true, dryRun)
+	_, err := i.run(TargetClean, installableObj, stack, true, false, dryRun)
+	return err
 }
 
 func (i MakeInstaller) GetVars(action string, approved bool) map[string]interface{} {
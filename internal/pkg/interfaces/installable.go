@@ -19,6 +19,7 @@ package interfaces
 import (
 	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/vars"
 )
 
 // this encapsulates different package formats that sugarkube can install in
@@ -42,11 +43,21 @@ type IInstallable interface {
 	GetCliArgs(installerName string, command string) []string
 	GetEnvVars() map[string]interface{}
 	Vars(stack IStack) (map[string]interface{}, error)
+	VarsTemplates() []string
+	VarsWithProvenance(stack IStack, templateVars map[string]interface{},
+		provenance *vars.Provenance) (map[string]interface{}, error)
+	StrictTemplates() bool
 	AddDescriptor(config structs.KappDescriptorWithMaps, prepend bool) error
 	RenderTemplates(templateVars map[string]interface{}, stackConfig IStackConfig,
 		dryRun bool) ([]string, error)
-	GetOutputs(ignoreMissing bool, dryRun bool) (map[string]interface{}, error)
+	RenderSensitiveTemplates(templateVars map[string]interface{}, stackConfig IStackConfig,
+		dryRun bool) ([]string, error)
+	DeleteSensitiveTemplates() error
+	StaleTemplates(templateVars map[string]interface{}, stackConfig IStackConfig) ([]string, error)
+	GetOutputs(stdout []byte, ignoreMissing bool, dryRun bool) (map[string]interface{}, error)
+	ValidateOutputs() (map[string]error, error)
 	HasOutputs() bool
 	GetLocalRegistry() IRegistry
 	SetLocalRegistry(registry IRegistry)
+	Snapshot() (IInstallable, error)
 }
@@ -20,7 +20,7 @@ type IInstaller interface {
 	Install(installableObj IInstallable, stack IStack, approved bool, dryRun bool) error
 	Delete(installableObj IInstallable, stack IStack, approved bool, dryRun bool) error
 	Clean(installableObj IInstallable, stack IStack, dryRun bool) error
-	Output(installableObj IInstallable, stack IStack, dryRun bool) error
+	Output(installableObj IInstallable, stack IStack, dryRun bool) ([]byte, error) // returns stdout
 	Name() string
 	GetVars(action string, approved bool) map[string]interface{}
 }
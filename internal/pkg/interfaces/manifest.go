@@ -20,4 +20,7 @@ type IManifest interface {
 	Id() string
 	Installables() []IInstallable
 	IsSequential() bool
+	Uri() string
+	Revision() string
+	CacheDir() string
 }
@@ -16,6 +16,10 @@
 
 package interfaces
 
+import (
+	"github.com/sugarkube/sugarkube/internal/pkg/vars"
+)
+
 type IClusterStatus interface {
 	IsOnline() bool
 	SetIsOnline(bool)
@@ -56,6 +60,9 @@ type IStack interface {
 	GetRegistry() IRegistry
 	GetTemplatedVars(installableObj IInstallable,
 		installerVars map[string]interface{}) (map[string]interface{}, error)
+	ExplainVars(installableObj IInstallable,
+		installerVars map[string]interface{}) (map[string]interface{}, *vars.Provenance, error)
 	RefreshProviderVars() error
 	LoadInstallables(cacheDir string) error
+	SnapshotKapps() error
 }
@@ -46,6 +46,7 @@ func ConfigureLogger(logLevel string, jsonLogs bool) {
 func newLogger(logLevel string, jsonLogs bool) *logrus.Logger {
 	l := logrus.New()
 	l.AddHook(filename.NewHook())
+	l.AddHook(redactHook{})
 
 	// make the formatter include the current time
 	var formatter logrus.Formatter
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// What sensitive values are replaced with in log messages
+const Redacted = "<redacted>"
+
+// Values shorter than this aren't redacted because they'd mangle unrelated log messages (e.g.
+// values like 'true' or '1')
+const minSensitiveLength = 4
+
+// Values that are redacted from all log messages, e.g. decrypted secrets
+var sensitiveValues = struct {
+	sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}{
+	values: map[string]bool{},
+}
+
+// Marks values as sensitive so they're redacted from log messages
+func AddSensitiveValues(values ...string) {
+	sensitiveValues.Lock()
+	defer sensitiveValues.Unlock()
+
+	added := false
+	for _, value := range values {
+		if len(value) < minSensitiveLength || sensitiveValues.values[value] {
+			continue
+		}
+
+		sensitiveValues.values[value] = true
+		added = true
+	}
+
+	if !added {
+		return
+	}
+
+	// replace longer values first in case one value contains another
+	sorted := make([]string, 0, len(sensitiveValues.values))
+	for value := range sensitiveValues.values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	oldNew := make([]string, 0, len(sorted)*2)
+	for _, value := range sorted {
+		oldNew = append(oldNew, value, Redacted)
+	}
+
+	sensitiveValues.replacer = strings.NewReplacer(oldNew...)
+}
+
+// Replaces any sensitive values in a string
+func Redact(input string) string {
+	sensitiveValues.RLock()
+	defer sensitiveValues.RUnlock()
+
+	if sensitiveValues.replacer == nil {
+		return input
+	}
+
+	return sensitiveValues.replacer.Replace(input)
+}
+
+// Redacts sensitive values from log entries before they're written
+type redactHook struct{}
+
+func (h redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+
+	for key, value := range entry.Data {
+		if str, ok := value.(string); ok {
+			entry.Data[key] = Redact(str)
+		}
+	}
+
+	return nil
+}
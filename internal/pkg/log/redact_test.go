@@ -0,0 +1,43 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package log
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	AddSensitiveValues("s3cr3t-password", "s3cr3t", "abc")
+
+	assert.Equal(t, "password=<redacted> other=<redacted> abc",
+		Redact("password=s3cr3t-password other=s3cr3t abc"))
+}
+
+func TestRedactHook(t *testing.T) {
+	AddSensitiveValues("hunter22")
+
+	var buf bytes.Buffer
+	logger := newLogger("info", false)
+	logger.Out = &buf
+
+	logger.WithField("password", "hunter22").Infof("Logging in with %s", "hunter22")
+
+	assert.NotContains(t, buf.String(), "hunter22")
+	assert.Contains(t, buf.String(), "Logging in with <redacted>")
+}
@@ -18,6 +18,7 @@ package mock
 
 import (
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/vars"
 )
 
 type Config struct {
@@ -131,6 +132,11 @@ func (m MockStack) GetTemplatedVars(installableObj interfaces.IInstallable,
 	return m.TemplatedVars, nil
 }
 
+func (m MockStack) ExplainVars(installableObj interfaces.IInstallable,
+	installerVars map[string]interface{}) (map[string]interface{}, *vars.Provenance, error) {
+	return m.TemplatedVars, vars.NewProvenance(), nil
+}
+
 func (m *MockStack) RefreshProviderVars() error {
 	return nil
 }
@@ -138,3 +144,7 @@ func (m *MockStack) RefreshProviderVars() error {
 func (m *MockStack) LoadInstallables(cacheDir string) error {
 	return nil
 }
+
+func (m *MockStack) SnapshotKapps() error {
+	return nil
+}
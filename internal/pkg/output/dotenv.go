@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// Parses `KEY=value` lines as written to .env files. Blank lines and lines starting with '#'
+// are ignored, as is an `export ` prefix. Values may be single quoted to be taken literally, or
+// double quoted to allow escaped newlines, quotes and backslashes. Unquoted values have
+// trailing ` # comments` removed.
+func parseDotenv(data []byte) (interface{}, bool, error) {
+	values := map[string]interface{}{}
+
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		parts := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, false, errors.New(fmt.Sprintf("Invalid line %d. Expected 'KEY=value'", i+1))
+		}
+
+		value, err := dotenvValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "Invalid value for '%s' on line %d", key, i+1)
+		}
+
+		values[key] = value
+	}
+
+	return values, false, nil
+}
+
+// Unquotes a value from a .env file
+func dotenvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+
+	switch raw[0] {
+	case '\'':
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", errors.New("Unterminated single quote")
+		}
+		return raw[1 : len(raw)-1], nil
+	case '"':
+		return unquoteDotenv(raw)
+	default:
+		if index := strings.Index(raw, " #"); index >= 0 {
+			raw = strings.TrimSpace(raw[:index])
+		}
+		return raw, nil
+	}
+}
+
+// Unquotes a double quoted value, unescaping newlines, quotes and backslashes
+func unquoteDotenv(raw string) (string, error) {
+	var value strings.Builder
+
+	for i := 1; i < len(raw); i++ {
+		switch raw[i] {
+		case '"':
+			if i != len(raw)-1 {
+				return "", errors.New("Unexpected characters after closing double quote")
+			}
+			return value.String(), nil
+		case '\\':
+			// other escapes are kept as they are
+			if i+1 < len(raw) && raw[i+1] == 'n' {
+				value.WriteByte('\n')
+				i++
+			} else if i+1 < len(raw) && (raw[i+1] == '"' || raw[i+1] == '\\') {
+				value.WriteByte(raw[i+1])
+				i++
+			} else {
+				value.WriteByte(raw[i])
+			}
+		default:
+			value.WriteByte(raw[i])
+		}
+	}
+
+	return "", errors.New("Unterminated double quote")
+}
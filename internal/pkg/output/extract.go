@@ -0,0 +1,198 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Where outputs are read from
+const SourceFile = "file"
+const SourceStdout = "stdout"
+
+// Parses an output's data after applying its regex and JSONPath extractors, if any. Also
+// returns whether the data says it's sensitive.
+func Extract(outputDef structs.Output, data []byte) (interface{}, bool, error) {
+	var err error
+
+	if outputDef.Regex != "" {
+		data, err = ExtractRegex(data, outputDef.Regex)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+	}
+
+	value, sensitive, err := Parse(outputDef.Format, data)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+
+	if outputDef.JsonPath != "" {
+		value, err = ExtractJsonPath(value, outputDef.JsonPath)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+	}
+
+	return value, sensitive, nil
+}
+
+// Returns the part of the data matched by a regex. If the regex has capturing groups the first
+// group is returned, otherwise the whole match is.
+func ExtractRegex(data []byte, pattern string) ([]byte, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid regex '%s'", pattern)
+	}
+
+	match := re.FindSubmatch(data)
+	if match == nil {
+		return nil, errors.New(fmt.Sprintf("Regex '%s' didn't match", pattern))
+	}
+
+	if len(match) > 1 {
+		return match[1], nil
+	}
+
+	return match[0], nil
+}
+
+// Returns the element of a parsed value at a JSONPath. Only child (`.key` or `['key']`) and
+// index (`[0]`, or `[-1]` for the last element) operators are supported.
+func ExtractJsonPath(value interface{}, path string) (interface{}, error) {
+	steps, err := parseJsonPath(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	current := value
+	traversed := "$"
+
+	for _, step := range steps {
+		switch typed := current.(type) {
+		case map[string]interface{}:
+			key, ok := step.(string)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Can't index '%s' with %v because it's a map",
+					traversed, step))
+			}
+
+			current, ok = typed[key]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Key '%s' not found at '%s'", key, traversed))
+			}
+			traversed = fmt.Sprintf("%s.%s", traversed, key)
+		case map[interface{}]interface{}:
+			// nested maps parsed from YAML
+			key, ok := step.(string)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Can't index '%s' with %v because it's a map",
+					traversed, step))
+			}
+
+			current, ok = typed[key]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Key '%s' not found at '%s'", key, traversed))
+			}
+			traversed = fmt.Sprintf("%s.%s", traversed, key)
+		case []interface{}:
+			index, ok := step.(int)
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Can't get key '%v' from '%s' because it's a list",
+					step, traversed))
+			}
+
+			if index < 0 {
+				index += len(typed)
+			}
+			if index < 0 || index >= len(typed) {
+				return nil, errors.New(fmt.Sprintf("Index %d is out of range at '%s' which has "+
+					"%d elements", step, traversed, len(typed)))
+			}
+
+			current = typed[index]
+			traversed = fmt.Sprintf("%s[%d]", traversed, step)
+		default:
+			return nil, errors.New(fmt.Sprintf("Can't get '%v' from '%s' because it's a %T",
+				step, traversed, current))
+		}
+	}
+
+	return current, nil
+}
+
+// Splits a JSONPath into map keys (strings) and list indices (ints)
+func parseJsonPath(path string) ([]interface{}, error) {
+	invalid := func(reason string) error {
+		return errors.New(fmt.Sprintf("Invalid JSONPath '%s': %s", path, reason))
+	}
+
+	if !strings.HasPrefix(path, "$") {
+		return nil, invalid("it must start with '$'")
+	}
+
+	steps := make([]interface{}, 0)
+	remaining := path[1:]
+
+	for remaining != "" {
+		switch remaining[0] {
+		case '.':
+			remaining = remaining[1:]
+			end := strings.IndexAny(remaining, ".[")
+			if end < 0 {
+				end = len(remaining)
+			}
+
+			key := remaining[:end]
+			if key == "" || key == "*" {
+				return nil, invalid("expected a key after '.'")
+			}
+
+			steps = append(steps, key)
+			remaining = remaining[end:]
+		case '[':
+			end := strings.Index(remaining, "]")
+			if end < 0 {
+				return nil, invalid("unterminated '['")
+			}
+
+			selector := remaining[1:end]
+			remaining = remaining[end+1:]
+
+			if len(selector) >= 2 && (selector[0] == '\'' || selector[0] == '"') &&
+				selector[len(selector)-1] == selector[0] {
+				steps = append(steps, selector[1:len(selector)-1])
+				continue
+			}
+
+			index, err := strconv.Atoi(selector)
+			if err != nil {
+				return nil, invalid(fmt.Sprintf("'[%s]' isn't a quoted key or an index", selector))
+			}
+			steps = append(steps, index)
+		default:
+			return nil, invalid(fmt.Sprintf("unexpected '%c'", remaining[0]))
+		}
+	}
+
+	return steps, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"testing"
+)
+
+func TestExtractRegex(t *testing.T) {
+	stdout := []byte("Connecting...\nendpoint: db.example.com:5432\nDone\n")
+
+	match, err := ExtractRegex(stdout, `endpoint: (\S+)`)
+	assert.Nil(t, err)
+	assert.Equal(t, "db.example.com:5432", string(match))
+
+	match, err = ExtractRegex(stdout, `db\.\S+`)
+	assert.Nil(t, err)
+	assert.Equal(t, "db.example.com:5432", string(match))
+
+	_, err = ExtractRegex(stdout, `password: (\S+)`)
+	assert.NotNil(t, err)
+
+	_, err = ExtractRegex(stdout, `(`)
+	assert.NotNil(t, err)
+}
+
+func TestExtractJsonPath(t *testing.T) {
+	value := map[string]interface{}{
+		"cluster": map[string]interface{}{
+			"endpoints": []interface{}{"a", "b", "c"},
+			"tls.ca":    "cert",
+		},
+		"yaml": map[interface{}]interface{}{"nested": "value"},
+	}
+
+	tests := []struct {
+		path     string
+		expected interface{}
+	}{
+		{path: "$", expected: value},
+		{path: "$.cluster.endpoints[1]", expected: "b"},
+		{path: "$.cluster.endpoints[-1]", expected: "c"},
+		{path: "$['cluster']['tls.ca']", expected: "cert"},
+		{path: "$.yaml.nested", expected: "value"},
+	}
+
+	for _, test := range tests {
+		result, err := ExtractJsonPath(value, test.path)
+		assert.Nil(t, err, test.path)
+		assert.Equal(t, test.expected, result, test.path)
+	}
+
+	for _, path := range []string{"cluster", "$.missing", "$.cluster.endpoints[3]", "$.cluster[0]",
+		"$.cluster.endpoints.first", "$.cluster.endpoints[*]", "$..cluster", "$[0", "$.cluster.tls.ca.x"} {
+		_, err := ExtractJsonPath(value, path)
+		assert.NotNil(t, err, path)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	stdout := []byte(`Refreshing state...
+{"vpc": {"sensitive": true, "type": "map", "value": {"id": "vpc-1", "cidr": "10.0.0.0/16"}}}
+`)
+
+	value, sensitive, err := Extract(structs.Output{
+		Format:   TerraformFormat,
+		Regex:    `(?s)(\{.*\})`,
+		JsonPath: "$.vpc.id",
+	}, stdout)
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-1", value)
+	assert.True(t, sensitive)
+}
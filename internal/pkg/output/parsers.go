@@ -0,0 +1,144 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/convert"
+	"gopkg.in/yaml.v2"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const JsonFormat = "json"
+const YamlFormat = "yaml"
+const TextFormat = "text"
+const TerraformFormat = "terraform"
+const DotenvFormat = "dotenv"
+const HclFormat = "hcl"
+const TfvarsFormat = "tfvars"
+const TomlFormat = "toml"
+const PropertiesFormat = "properties"
+
+// Parses the contents of a kapp's output. Parsers return true for `sensitive` if the data
+// says it's sensitive, in which case the output is treated as sensitive even if it wasn't
+// declared to be.
+type Parser func(data []byte) (value interface{}, sensitive bool, err error)
+
+var parsers = struct {
+	sync.RWMutex
+	byFormat map[string]Parser
+}{
+	byFormat: map[string]Parser{},
+}
+
+func init() {
+	RegisterParser(parseJson, JsonFormat)
+	RegisterParser(parseYaml, YamlFormat)
+	RegisterParser(parseText, TextFormat)
+	RegisterParser(parseTerraform, TerraformFormat)
+	RegisterParser(parseDotenv, DotenvFormat)
+	RegisterParser(parseHcl, HclFormat, TfvarsFormat)
+	RegisterParser(parseToml, TomlFormat)
+	RegisterParser(parseProperties, PropertiesFormat)
+}
+
+// Registers a parser for one or more formats, replacing any existing parser for them
+func RegisterParser(parser Parser, formats ...string) {
+	parsers.Lock()
+	defer parsers.Unlock()
+
+	for _, format := range formats {
+		parsers.byFormat[strings.ToLower(format)] = parser
+	}
+}
+
+// Returns whether there's a parser for a format
+func IsSupported(format string) bool {
+	parsers.RLock()
+	defer parsers.RUnlock()
+
+	_, ok := parsers.byFormat[strings.ToLower(format)]
+	return ok
+}
+
+// Returns the names of all supported formats
+func Formats() []string {
+	parsers.RLock()
+	defer parsers.RUnlock()
+
+	formats := make([]string, 0, len(parsers.byFormat))
+	for format := range parsers.byFormat {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+
+	return formats
+}
+
+// Parses data in the given format
+func Parse(format string, data []byte) (interface{}, bool, error) {
+	parsers.RLock()
+	parser, ok := parsers.byFormat[strings.ToLower(format)]
+	parsers.RUnlock()
+
+	if !ok {
+		return nil, false, errors.New(fmt.Sprintf("Unsupported output format '%s'. Supported "+
+			"formats are: %s", format, strings.Join(Formats(), ", ")))
+	}
+
+	value, sensitive, err := parser(data)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Error parsing output as %s", format)
+	}
+
+	return value, sensitive, nil
+}
+
+func parseJson(data []byte) (interface{}, bool, error) {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+
+	return value, false, nil
+}
+
+func parseYaml(data []byte) (interface{}, bool, error) {
+	var value interface{}
+	err := yaml.Unmarshal(data, &value)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+
+	if valueMap, ok := value.(map[interface{}]interface{}); ok {
+		value, err = convert.MapInterfaceInterfaceToMapStringInterface(valueMap)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+	}
+
+	return value, false, nil
+}
+
+func parseText(data []byte) (interface{}, bool, error) {
+	return string(data), false, nil
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"testing"
+)
+
+func init() {
+	log.ConfigureLogger("debug", false)
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		input     string
+		expected  interface{}
+		sensitive bool
+	}{
+		{
+			name:     "json",
+			format:   "JSON",
+			input:    `{"host": "db", "ports": [5432]}`,
+			expected: map[string]interface{}{"host": "db", "ports": []interface{}{float64(5432)}},
+		},
+		{
+			name:     "yaml",
+			format:   YamlFormat,
+			input:    "host: db\nreplicas: 2",
+			expected: map[string]interface{}{"host": "db", "replicas": 2},
+		},
+		{
+			name:     "text",
+			format:   TextFormat,
+			input:    "some text\n",
+			expected: "some text\n",
+		},
+		{
+			name:   "terraform",
+			format: TerraformFormat,
+			input: `{
+  "vpc_id": {"sensitive": false, "type": "string", "value": "vpc-123"},
+  "db_password": {"sensitive": true, "type": "string", "value": "secret"},
+  "subnets": {"sensitive": false, "type": ["list", "string"], "value": ["a", "b"]},
+  "unset": {"sensitive": false, "type": "string", "value": null}
+}`,
+			expected: map[string]interface{}{
+				"vpc_id":      "vpc-123",
+				"db_password": "secret",
+				"subnets":     []interface{}{"a", "b"},
+				"unset":       nil,
+			},
+			sensitive: true,
+		},
+		{
+			name:     "terraform_not_sensitive",
+			format:   TerraformFormat,
+			input:    `{"vpc_id": {"sensitive": false, "type": "string", "value": "vpc-123"}}`,
+			expected: map[string]interface{}{"vpc_id": "vpc-123"},
+		},
+		{
+			name:   "dotenv",
+			format: DotenvFormat,
+			input: `# database settings
+DB_HOST=db.example.com # the host
+export DB_USER = admin
+DB_PASSWORD='p@ss #word'
+DB_OPTS="line1\nline2 \"quoted\" C:\\temp\d"
+EMPTY=
+`,
+			expected: map[string]interface{}{
+				"DB_HOST":     "db.example.com",
+				"DB_USER":     "admin",
+				"DB_PASSWORD": "p@ss #word",
+				"DB_OPTS":     "line1\nline2 \"quoted\" C:\\temp\\d",
+				"EMPTY":       "",
+			},
+		},
+		{
+			name:   "tfvars",
+			format: TfvarsFormat,
+			input: `region = "eu-west-1"
+instance_count = 3
+azs = ["a", "b"]
+tags = {
+  env = "dev"
+}
+`,
+			expected: map[string]interface{}{
+				"region":         "eu-west-1",
+				"instance_count": 3,
+				"azs":            []interface{}{"a", "b"},
+				"tags":           map[string]interface{}{"env": "dev"},
+			},
+		},
+		{
+			// lists of maps aren't confused with maps, even with a single element
+			name:   "tfvars_lists_of_maps",
+			format: TfvarsFormat,
+			input: `rules = [{port = 80}]
+subnets = [{cidr = "10.0.0.0/24"}, {cidr = "10.0.1.0/24"}]
+db {
+  port = 5432
+}
+`,
+			expected: map[string]interface{}{
+				"rules": []interface{}{map[string]interface{}{"port": 80}},
+				"subnets": []interface{}{
+					map[string]interface{}{"cidr": "10.0.0.0/24"},
+					map[string]interface{}{"cidr": "10.0.1.0/24"},
+				},
+				"db": map[string]interface{}{"port": 5432},
+			},
+		},
+		{
+			name:   "toml",
+			format: TomlFormat,
+			input: `region = "eu-west-1"
+
+[database]
+port = 5432
+
+[[servers]]
+name = "a"
+
+[[servers]]
+name = "b"
+`,
+			expected: map[string]interface{}{
+				"region":   "eu-west-1",
+				"database": map[string]interface{}{"port": int64(5432)},
+				"servers": []interface{}{
+					map[string]interface{}{"name": "a"},
+					map[string]interface{}{"name": "b"},
+				},
+			},
+		},
+		{
+			name:   "properties",
+			format: PropertiesFormat,
+			input: `# comment
+db.host = db.example.com
+db.url = jdbc:${db.host}
+`,
+			expected: map[string]interface{}{
+				"db.host": "db.example.com",
+				"db.url":  "jdbc:${db.host}",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, sensitive, err := Parse(test.format, []byte(test.input))
+			assert.Nil(t, err)
+			assert.Equal(t, test.expected, value)
+			assert.Equal(t, test.sensitive, sensitive)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{name: "unsupported", format: "xml", input: "<a/>"},
+		{name: "terraform_no_envelope", format: TerraformFormat, input: `{"vpc_id": {"id": "vpc-123"}}`},
+		{name: "dotenv_no_equals", format: DotenvFormat, input: "DB_HOST"},
+		{name: "dotenv_unterminated", format: DotenvFormat, input: `DB_HOST="db`},
+		{name: "dotenv_trailing", format: DotenvFormat, input: `DB_HOST="db" extra`},
+		{name: "hcl_invalid", format: HclFormat, input: "tags = {"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := Parse(test.format, []byte(test.input))
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestRegisterParser(t *testing.T) {
+	assert.False(t, IsSupported("upper"))
+
+	RegisterParser(func(data []byte) (interface{}, bool, error) {
+		return string(data) + "!", false, nil
+	}, "upper")
+	defer func() {
+		parsers.Lock()
+		delete(parsers.byFormat, "upper")
+		parsers.Unlock()
+	}()
+
+	assert.True(t, IsSupported("UPPER"))
+	assert.Contains(t, Formats(), "upper")
+
+	value, _, err := Parse("upper", []byte("hi"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hi!", value)
+}
@@ -0,0 +1,246 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Validates a parsed output against a schema. The returned error lists every path that doesn't
+// match. Values aren't included in messages since outputs may be sensitive.
+func Validate(value interface{}, schema *structs.OutputSchema) error {
+	if schema == nil {
+		return nil
+	}
+
+	problems := make([]string, 0)
+	err := validate(value, *schema, "$", &problems)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Recursively validates a value, appending problems. Errors are only returned for invalid schemas.
+func validate(value interface{}, schema structs.OutputSchema, path string, problems *[]string) error {
+	if schema.Type != "" {
+		matches, err := isType(value, schema.Type)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid schema at '%s'", path)
+		}
+
+		if !matches {
+			*problems = append(*problems, fmt.Sprintf("%s: expected %s but got %s", path,
+				schema.Type, typeName(value)))
+			return nil
+		}
+	}
+
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, allowed := range schema.Enum {
+			if valuesEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			*problems = append(*problems, fmt.Sprintf("%s: isn't one of the %d allowed values",
+				path, len(schema.Enum)))
+		}
+	}
+
+	if schema.Pattern != "" {
+		re, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid pattern in schema at '%s'", path)
+		}
+
+		if str, ok := value.(string); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a string to match pattern '%s' "+
+				"but got %s", path, schema.Pattern, typeName(value)))
+		} else if !re.MatchString(str) {
+			*problems = append(*problems, fmt.Sprintf("%s: doesn't match pattern '%s'", path,
+				schema.Pattern))
+		}
+	}
+
+	if len(schema.Required) > 0 || len(schema.Properties) > 0 {
+		object, ok := toObject(value)
+		if !ok {
+			// only report this if a type wasn't declared, otherwise it's already been reported
+			if schema.Type == "" {
+				*problems = append(*problems, fmt.Sprintf("%s: expected object but got %s", path,
+					typeName(value)))
+			}
+			return nil
+		}
+
+		for _, key := range schema.Required {
+			if _, ok := object[key]; !ok {
+				*problems = append(*problems, fmt.Sprintf("%s: required key is missing",
+					childPath(path, key)))
+			}
+		}
+
+		keys := make([]string, 0, len(schema.Properties))
+		for key := range schema.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			child, ok := object[key]
+			if !ok {
+				continue
+			}
+
+			err := validate(child, schema.Properties[key], childPath(path, key), problems)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	if schema.Items != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			if schema.Type == "" {
+				*problems = append(*problems, fmt.Sprintf("%s: expected array but got %s", path,
+					typeName(value)))
+			}
+			return nil
+		}
+
+		for i, item := range list {
+			err := validate(item, *schema.Items, fmt.Sprintf("%s[%d]", path, i), problems)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the JSONPath of a key in an object
+func childPath(path string, key string) string {
+	if strings.ContainsAny(key, ".[]' ") {
+		return fmt.Sprintf("%s['%s']", path, key)
+	}
+
+	return fmt.Sprintf("%s.%s", path, key)
+}
+
+// Returns whether a value has the named type
+func isType(value interface{}, typeName string) (bool, error) {
+	switch typeName {
+	case "object":
+		_, ok := toObject(value)
+		return ok, nil
+	case "array":
+		_, ok := value.([]interface{})
+		return ok, nil
+	case "string":
+		_, ok := value.(string)
+		return ok, nil
+	case "number":
+		_, ok := toFloat(value)
+		return ok, nil
+	case "integer":
+		number, ok := toFloat(value)
+		return ok && number == math.Trunc(number), nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok, nil
+	case "null":
+		return value == nil, nil
+	default:
+		return false, errors.New(fmt.Sprintf("Unknown type '%s'", typeName))
+	}
+}
+
+// Returns a description of a value's type for error messages
+func typeName(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+
+	for _, name := range []string{"object", "array", "string", "integer", "number", "boolean"} {
+		if matches, _ := isType(value, name); matches {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("%T", value)
+}
+
+// Returns a map with string keys, converting maps parsed from YAML
+func toObject(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(typed))
+		for k, v := range typed {
+			object[fmt.Sprintf("%v", k)] = v
+		}
+		return object, true
+	default:
+		return nil, false
+	}
+}
+
+// Converts any numeric type to a float
+func toFloat(value interface{}) (float64, bool) {
+	switch typed := value.(type) {
+	case int:
+		return float64(typed), true
+	case int64:
+		return float64(typed), true
+	case uint64:
+		return float64(typed), true
+	case float64:
+		return typed, true
+	default:
+		return 0, false
+	}
+}
+
+// Compares values, treating numbers parsed from different formats as equal
+func valuesEqual(a interface{}, b interface{}) bool {
+	aNumber, aIsNumber := toFloat(a)
+	bNumber, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		return aNumber == bNumber
+	}
+
+	return reflect.DeepEqual(a, b)
+}
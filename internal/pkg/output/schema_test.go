@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	schema := &structs.OutputSchema{
+		Type:     "object",
+		Required: []string{"cluster", "port"},
+		Properties: map[string]structs.OutputSchema{
+			"cluster": {
+				Type:     "object",
+				Required: []string{"endpoint"},
+				Properties: map[string]structs.OutputSchema{
+					"endpoint": {Type: "string", Pattern: `^https://`},
+				},
+			},
+			"port":  {Type: "integer"},
+			"tier":  {Enum: []interface{}{"dev", "prod"}},
+			"zones": {Type: "array", Items: &structs.OutputSchema{Type: "string"}},
+		},
+	}
+
+	valid := []interface{}{
+		map[string]interface{}{
+			"cluster": map[string]interface{}{"endpoint": "https://k8s"},
+			"port":    float64(443),
+			"zones":   []interface{}{"a", "b"},
+		},
+		// YAML maps and ints
+		map[interface{}]interface{}{
+			"cluster": map[interface{}]interface{}{"endpoint": "https://k8s"},
+			"port":    443,
+			"tier":    "prod",
+		},
+	}
+
+	for _, value := range valid {
+		assert.Nil(t, Validate(value, schema))
+	}
+
+	err := Validate(map[string]interface{}{
+		"cluster": map[string]interface{}{"endpoint": "http://k8s"},
+		"port":    "443",
+		"tier":    "test",
+		"zones":   []interface{}{"a", float64(2)},
+	}, schema)
+	assert.EqualError(t, err, "$.cluster.endpoint: doesn't match pattern '^https://'; "+
+		"$.port: expected integer but got string; "+
+		"$.tier: isn't one of the 2 allowed values; "+
+		"$.zones[1]: expected string but got integer")
+
+	err = Validate(map[string]interface{}{"cluster": map[string]interface{}{}}, schema)
+	assert.EqualError(t, err, "$.port: required key is missing; $.cluster.endpoint: required key is missing")
+
+	err = Validate(nil, schema)
+	assert.EqualError(t, err, "$: expected object but got null")
+
+	assert.Nil(t, Validate("anything", nil))
+
+	err = Validate("x", &structs.OutputSchema{Type: "text"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Unknown type 'text'")
+}
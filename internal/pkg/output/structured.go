@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"github.com/BurntSushi/toml"
+	"github.com/hashicorp/hcl"
+	"github.com/magiconair/properties"
+	"github.com/pkg/errors"
+)
+
+// Parses HCL, e.g. terraform .tfvars files. Only literal values are supported, not expressions.
+func parseHcl(data []byte) (interface{}, bool, error) {
+	values := map[string]interface{}{}
+	err := hcl.Unmarshal(data, &values)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+
+	return normaliseHcl(values), false, nil
+}
+
+// The HCL decoder returns each map and block as a []map[string]interface{} containing it because
+// blocks with the same name can be repeated, so unwrap those with a single element. Lists in the
+// HCL are decoded as []interface{} so they're kept even if they only contain a single map.
+func normaliseHcl(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case []map[string]interface{}:
+		if len(typed) == 1 {
+			return normaliseHcl(typed[0])
+		}
+
+		normalised := make([]interface{}, len(typed))
+		for i, item := range typed {
+			normalised[i] = normaliseHcl(item)
+		}
+		return normalised
+	case map[string]interface{}:
+		for k, v := range typed {
+			typed[k] = normaliseHcl(v)
+		}
+		return typed
+	case []interface{}:
+		for i, v := range typed {
+			typed[i] = normaliseHcl(v)
+		}
+		return typed
+	default:
+		return value
+	}
+}
+
+// Parses TOML
+func parseToml(data []byte) (interface{}, bool, error) {
+	values := map[string]interface{}{}
+	err := toml.Unmarshal(data, &values)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+
+	return normaliseToml(values), false, nil
+}
+
+// Converts arrays of tables to lists of maps so they can be merged like other lists
+func normaliseToml(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case []map[string]interface{}:
+		normalised := make([]interface{}, len(typed))
+		for i, item := range typed {
+			normalised[i] = normaliseToml(item)
+		}
+		return normalised
+	case map[string]interface{}:
+		for k, v := range typed {
+			typed[k] = normaliseToml(v)
+		}
+		return typed
+	case []interface{}:
+		for i, v := range typed {
+			typed[i] = normaliseToml(v)
+		}
+		return typed
+	default:
+		return value
+	}
+}
+
+// Parses Java properties files. Values are strings and `${key}` references aren't expanded.
+func parseProperties(data []byte) (interface{}, bool, error) {
+	loader := properties.Loader{
+		Encoding:         properties.UTF8,
+		DisableExpansion: true,
+	}
+
+	props, err := loader.LoadBytes(data)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+
+	values := make(map[string]interface{}, props.Len())
+	for k, v := range props.Map() {
+		values[k] = v
+	}
+
+	return values, false, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+)
+
+// A value in the output of `terraform output -json`
+type terraformOutput struct {
+	Sensitive bool
+	Type      interface{}
+	Value     json.RawMessage // nil if missing
+}
+
+// Parses the output of `terraform output -json`, unwrapping each value from its envelope. The
+// output is sensitive if any of the values are.
+func parseTerraform(data []byte) (interface{}, bool, error) {
+	envelopes := map[string]terraformOutput{}
+	err := json.Unmarshal(data, &envelopes)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "Expected the output of 'terraform output -json'")
+	}
+
+	values := make(map[string]interface{}, len(envelopes))
+	sensitive := false
+
+	for name, envelope := range envelopes {
+		if envelope.Value == nil {
+			return nil, false, errors.New(fmt.Sprintf("Terraform output '%s' has no value. "+
+				"Expected the output of 'terraform output -json'", name))
+		}
+
+		var value interface{}
+		err = json.Unmarshal(envelope.Value, &value)
+		if err != nil {
+			return nil, false, errors.WithStack(err)
+		}
+
+		values[name] = value
+		sensitive = sensitive || envelope.Sensitive
+	}
+
+	return values, sensitive, nil
+}
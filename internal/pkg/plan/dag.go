@@ -27,6 +27,7 @@ import (
 	"gonum.org/v1/gonum/graph/topo"
 	"io"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -42,7 +43,8 @@ const defaultSleepInterval = 5 // milliseconds
 // Wrapper around a directed graph so we can define our own methods on it
 type Dag struct {
 	graph         *simple.DirectedGraph
-	SleepInterval time.Duration // time to wait after reaching the end of the graph before doing another pass
+	SleepInterval time.Duration  // time to wait after reaching the end of the graph before doing another pass
+	registryPaths *registryPaths // custom registry paths kapps have stored outputs under
 }
 
 // Defines a node that should be created in the graph, along with parent dependencies. This is
@@ -73,8 +75,10 @@ type nodeStatus struct {
 
 // Creates a DAG for installables in the given manifests. If a list of selected installable IDs is
 // given a subgraph will be returned containing only those installables and their ancestors.
+// Implicit dependencies (keyed by fully-qualified installable ID) are added to those declared
+// in manifests.
 func Create(manifests []interfaces.IManifest, selectedInstallableIds []string,
-	includeParents bool) (*Dag, error) {
+	includeParents bool, implicitDependencies map[string][]string) (*Dag, error) {
 	manifestIds := make([]string, 0)
 	for _, manifest := range manifests {
 		manifestIds = append(manifestIds, manifest.Id())
@@ -83,6 +87,7 @@ func Create(manifests []interfaces.IManifest, selectedInstallableIds []string,
 	log.Logger.Debugf("Creating DAG for installables '%s' in manifests %s",
 		strings.Join(selectedInstallableIds, ", "), strings.Join(manifestIds, ", "))
 	descriptors := findDependencies(manifests)
+	addImplicitDependencies(descriptors, implicitDependencies)
 	dag, err := build(descriptors)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -145,6 +150,7 @@ func build(descriptors map[string]nodeDescriptor) (*Dag, error) {
 	dag := Dag{
 		graph:         graphObj,
 		SleepInterval: defaultSleepInterval * time.Millisecond,
+		registryPaths: newRegistryPaths(),
 	}
 
 	return &dag, nil
@@ -232,6 +238,7 @@ func (g *Dag) subGraph(nodeNames []string, includeParents bool) (*Dag, error) {
 	dag := Dag{
 		graph:         outputGraph,
 		SleepInterval: defaultSleepInterval * time.Millisecond,
+		registryPaths: newRegistryPaths(),
 	}
 
 	log.Logger.Debugf("Finished extracting sub-graph")
@@ -322,13 +329,18 @@ func (g *Dag) walk(down bool, processCh chan<- NamedNode, doneCh chan NamedNode)
 	numNodes := g.graph.Nodes().Len()
 	log.Logger.Debugf("Graph has %d nodes", numNodes)
 
+	// guards the node statuses, which are updated by both goroutines below
+	lock := &sync.Mutex{}
+
 	// spawn a goroutine to listen to the doneCh to update the statuses of completed nodes
 	go func() {
 		for namedNode := range doneCh {
 			log.Logger.Debugf("Worker informs the DAG it's finished processing node '%s'", namedNode.name)
+			lock.Lock()
 			nodeItem := nodeStatusesById[namedNode.node.ID()]
 			nodeItem.status = finished
 			nodeStatusesById[namedNode.node.ID()] = nodeItem
+			lock.Unlock()
 		}
 	}()
 
@@ -337,6 +349,11 @@ func (g *Dag) walk(down bool, processCh chan<- NamedNode, doneCh chan NamedNode)
 	go func() {
 		// loop until there are no nodes left which haven't been processed
 		for {
+			// nodes are queued after releasing the lock so workers can report finished nodes
+			// while we wait for them to accept new ones
+			queue := make([]NamedNode, 0)
+
+			lock.Lock()
 			for node, nodeStatus := range nodeStatusesById {
 				namedNode := nodeStatusesById[node]
 
@@ -362,13 +379,19 @@ func (g *Dag) walk(down bool, processCh chan<- NamedNode, doneCh chan NamedNode)
 					// update the status to running so we don't keep requeuing completed nodes
 					namedNode.status = running
 					nodeStatusesById[node] = namedNode
-					processCh <- namedNode.node
+					queue = append(queue, namedNode.node)
 				} else {
 					log.Logger.Tracef("Dependencies not satisfied for %s", namedNode.node.name)
 				}
 			}
+			done := allDone(nodeStatusesById)
+			lock.Unlock()
+
+			for _, namedNode := range queue {
+				processCh <- namedNode
+			}
 
-			if allDone(nodeStatusesById) {
+			if done {
 				log.Logger.Infof("DAG fully processed")
 				close(finishedCh)
 				close(doneCh)
@@ -141,6 +141,7 @@ func TestTraverse(t *testing.T) {
 			for node := range processCh {
 				log.Logger.Infof("Processing '%s' in goroutine...", node.name)
 
+				mutex.Lock()
 				// make sure the first node we process is one of those marked as being allowed to
 				// be processed first
 				if numProcessed == 0 {
@@ -148,8 +149,6 @@ func TestTraverse(t *testing.T) {
 				}
 
 				lastProcessedId = node.name
-
-				mutex.Lock()
 				numProcessed++
 				mutex.Unlock()
 
@@ -167,7 +166,9 @@ func TestTraverse(t *testing.T) {
 	}
 
 	// make sure the last to be processed is marked as being allowed to be last
+	mutex.Lock()
 	assert.True(t, utils.InStringArray(possibleLastNodes, lastProcessedId))
+	mutex.Unlock()
 }
 
 // Test we can extract subgraphs of the node
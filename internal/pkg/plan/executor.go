@@ -49,6 +49,12 @@ func (d *Dag) Execute(action string, stackObj interfaces.IStack, plan bool, appr
 		"skipPostActions=%v, ignoreErrors=%v, dryRun=%v", action, plan, approved, skipPostActions,
 		ignoreErrors, dryRun)
 
+	// kapps' vars can refer to other kapps, so snapshot them before workers start changing them
+	err := stackObj.SnapshotKapps()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
 	// create the worker pool
 	for w := int(0); w < numWorkers; w++ {
 		go worker(d, processCh, doneCh, errCh, action, stackObj, plan, approved, skipPreActions, skipPostActions,
@@ -88,8 +94,11 @@ func (d *Dag) Execute(action string, stackObj interfaces.IStack, plan bool, appr
 	}
 }
 
-// Traverses the DAG printing vars for all marked nodes, optionally suppressing output for certain keys
-func (d *Dag) ExecuteGetVars(action string, stackObj interfaces.IStack, loadOutputs bool, suppress []string) error {
+// Traverses the DAG printing vars for all marked nodes, optionally suppressing output for certain keys.
+// If a path to explain is given, where each var under it was set is printed instead ('.' explains
+// all vars).
+func (d *Dag) ExecuteGetVars(action string, stackObj interfaces.IStack, loadOutputs bool, suppress []string,
+	explain string) error {
 	numWorkers := config.CurrentConfig.NumWorkers
 
 	processCh := make(chan NamedNode, numWorkers)
@@ -98,9 +107,15 @@ func (d *Dag) ExecuteGetVars(action string, stackObj interfaces.IStack, loadOutp
 
 	log.Logger.Infof("Executing DAG with action=%s", action)
 
+	// kapps' vars can refer to other kapps, so snapshot them before workers start changing them
+	err := stackObj.SnapshotKapps()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
 	// create the worker pool
 	for w := int(0); w < numWorkers; w++ {
-		go varsWorker(processCh, doneCh, errCh, stackObj, suppress)
+		go varsWorker(processCh, doneCh, errCh, stackObj, suppress, explain)
 	}
 
 	var finishedCh <-chan bool
@@ -217,12 +232,12 @@ func registryWorker(dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- Named
 			return
 		}
 
-		addInstallableLocalRegistry(node, outputs, errCh)
+		addInstallableLocalRegistry(dagObj, node, outputs, errCh)
 
 		log.Logger.Tracef("Registry worker finished processing kapp '%s' (node=%#v)", installableObj.FullyQualifiedId(),
 			node)
 		doneCh <- node
-		log.Logger.Tracef("Registry worker end of loop for kapp '%s'", installableObj.FullyQualifiedId())
+		log.Logger.Tracef("Registry worker end of loop for kapp '%s'", node.name)
 	}
 }
 
@@ -293,7 +308,7 @@ func worker(dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- NamedNode, er
 					return
 				}
 
-				err = installerImpl.Output(installableObj, stackObj, dryRun)
+				_, err = installerImpl.Output(installableObj, stackObj, dryRun)
 				if err != nil {
 					errCh <- errors.Wrapf(err, "Error generating output for kapp '%s'", installableObj.Id())
 					return
@@ -337,7 +352,7 @@ func worker(dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- NamedNode, er
 				return
 			}
 
-			addInstallableLocalRegistry(node, outputs, errCh)
+			addInstallableLocalRegistry(dagObj, node, outputs, errCh)
 
 			// only template marked nodes
 			if node.marked {
@@ -357,13 +372,13 @@ func worker(dagObj *Dag, processCh <-chan NamedNode, doneCh chan<- NamedNode, er
 		log.Logger.Tracef("Worker finished processing kapp '%s' (node=%#v)", installableObj.FullyQualifiedId(),
 			node)
 		doneCh <- node
-		log.Logger.Tracef("Worker end of loop for kapp '%s'", installableObj.FullyQualifiedId())
+		log.Logger.Tracef("Worker end of loop for kapp '%s'", node.name)
 	}
 }
 
 // Prints out the variables for each marked node
 func varsWorker(processCh <-chan NamedNode, doneCh chan<- NamedNode, errCh chan error, stackObj interfaces.IStack,
-	suppress []string) {
+	suppress []string, explain string) {
 
 	for node := range processCh {
 		installableObj := node.installableObj
@@ -397,6 +412,17 @@ func varsWorker(processCh <-chan NamedNode, doneCh chan<- NamedNode, errCh chan
 
 		log.Logger.Debugf("Getting variables for kapp '%s'", installableObj.FullyQualifiedId())
 
+		if explain != "" {
+			err = explainVars(installableObj, installerImpl, stackObj, explain)
+			if err != nil {
+				errCh <- errors.WithStack(err)
+				return
+			}
+
+			doneCh <- node
+			continue
+		}
+
 		// template the kapp's descriptor, including the global registry
 		templatedVars, err := stackObj.GetTemplatedVars(installableObj,
 			installerImpl.GetVars("<action, e.g. install/delete>", false))
@@ -449,10 +475,31 @@ func varsWorker(processCh <-chan NamedNode, doneCh chan<- NamedNode, errCh chan
 		log.Logger.Tracef("Vars worker finished processing kapp '%s' (node=%#v)", installableObj.FullyQualifiedId(),
 			node)
 		doneCh <- node
-		log.Logger.Tracef("Vars worker end of loop for kapp '%s'", installableObj.FullyQualifiedId())
+		log.Logger.Tracef("Vars worker end of loop for kapp '%s'", node.name)
 	}
 }
 
+// Prints where each var under a path was set for a kapp
+func explainVars(installableObj interfaces.IInstallable, installerImpl interfaces.IInstaller,
+	stackObj interfaces.IStack, path string) error {
+
+	templatedVars, provenance, err := stackObj.ExplainVars(installableObj,
+		installerImpl.GetVars("<action, e.g. install/delete>", false))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = fmt.Printf("\n***** Start variable sources for kapp '%s' *****\n"+
+		"%s***** End variable sources for kapp '%s' *****\n",
+		installableObj.FullyQualifiedId(), provenance.Explain(path, templatedVars),
+		installableObj.FullyQualifiedId())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
 // Implements the install action. Nodes that should be processed are installed. All nodes load any outputs
 // and merge them with their parents' outputs.
 func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl interfaces.IInstaller,
@@ -485,6 +532,15 @@ func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl in
 
 	// only plan or process kapps that have been flagged for processing
 	if node.marked {
+		// sensitive templates only exist while the kapp's being executed
+		defer deleteSensitiveTemplates(installableObj)
+
+		err = renderSensitiveTemplates(stackObj, installableObj, installerVars, dryRun)
+		if err != nil {
+			errCh <- errors.WithStack(err)
+			return
+		}
+
 		if plan {
 			err = installerMethod(installableObj, stackObj, false, dryRun)
 			if err != nil {
@@ -543,8 +599,10 @@ func installOrDelete(install bool, dagObj *Dag, node NamedNode, installerImpl in
 		}
 	}
 
+	deleteSensitiveTemplates(installableObj)
+
 	// build the kapp's local registry
-	addInstallableLocalRegistry(node, outputs, errCh)
+	addInstallableLocalRegistry(dagObj, node, outputs, errCh)
 
 	// rerender templates so they can use kapp outputs (e.g. before adding the paths to rendered templates as provider vars)
 	err = renderKappTemplates(stackObj, installableObj, installerVars, dryRun)
@@ -570,14 +628,14 @@ func getOutputs(installableObj interfaces.IInstallable, stackObj interfaces.ISta
 
 	// try to load kapp outputs and fail if we can't (assume we only need to do this when installing)
 	if installableObj.HasOutputs() {
-		// run the output target to write outputs to files
-		err := installerImpl.Output(installableObj, stackObj, dryRun)
+		// run the output target to write outputs to files and/or stdout
+		stdout, err := installerImpl.Output(installableObj, stackObj, dryRun)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Error writing output for kapp '%s'", installableObj.Id())
 		}
 
 		// load and parse outputs
-		outputs, err = installableObj.GetOutputs(ignoreMissing, dryRun)
+		outputs, err = installableObj.GetOutputs(stdout, ignoreMissing, dryRun)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Error loading the output of kapp '%s'", installableObj.Id())
 		}
@@ -628,11 +686,18 @@ func addParentRegistries(dagObj *Dag, node NamedNode, errCh chan<- error) {
 		deleteSpecialThisOutput(localRegistry)
 	}
 
+	// parents in different branches of the DAG may have written to the same registry path
+	err := resolveRegistryPaths(localRegistry, dagObj.registryPaths)
+	if err != nil {
+		errCh <- errors.WithStack(err)
+		return
+	}
+
 	node.installableObj.SetLocalRegistry(localRegistry)
 }
 
 // Add outputs to the kapp's local registry
-func addInstallableLocalRegistry(node NamedNode, outputs map[string]interface{}, errCh chan<- error) {
+func addInstallableLocalRegistry(dagObj *Dag, node NamedNode, outputs map[string]interface{}, errCh chan<- error) {
 
 	localRegistry := node.installableObj.GetLocalRegistry()
 
@@ -643,6 +708,12 @@ func addInstallableLocalRegistry(node NamedNode, outputs map[string]interface{},
 			errCh <- errors.WithStack(err)
 			return
 		}
+
+		err = addOutputsToRegistryPaths(node.installableObj, outputs, localRegistry, dagObj.registryPaths)
+		if err != nil {
+			errCh <- errors.WithStack(err)
+			return
+		}
 	}
 
 	node.installableObj.SetLocalRegistry(localRegistry)
@@ -749,6 +820,29 @@ func addOutputsToRegistry(installableObj interfaces.IInstallable, outputs map[st
 	return nil
 }
 
+// Renders a kapp's sensitive templates just before it's executed
+func renderSensitiveTemplates(stackObj interfaces.IStack, installableObj interfaces.IInstallable,
+	installerVars map[string]interface{}, dryRun bool) error {
+
+	templatedVars, err := stackObj.GetTemplatedVars(installableObj, installerVars)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = installableObj.RenderSensitiveTemplates(templatedVars, stackObj.GetConfig(), dryRun)
+	return errors.WithStack(err)
+}
+
+// Deletes a kapp's sensitive templates, logging any error since this is called when
+// cleaning up after errors and panics
+func deleteSensitiveTemplates(installableObj interfaces.IInstallable) {
+	err := installableObj.DeleteSensitiveTemplates()
+	if err != nil {
+		log.Logger.Errorf("Failed to delete sensitive templates for kapp '%s': %s",
+			installableObj.FullyQualifiedId(), err)
+	}
+}
+
 // Renders templates for a kapp
 func renderKappTemplates(stackObj interfaces.IStack, installableObj interfaces.IInstallable,
 	installerVars map[string]interface{}, dryRun bool) error {
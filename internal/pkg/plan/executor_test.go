@@ -0,0 +1,76 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/stack"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// Kapps whose vars refer to each other are processed concurrently unless they depend on each
+// other. Run with -race to check they don't read each other's state while it's being changed.
+func TestExecuteGetVarsWithKappsReferences(t *testing.T) {
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+	config.CurrentConfig = &config.Config{NumWorkers: 4}
+
+	stackObj, err := stack.BuildStack("kapp-vars", "../../testdata/stacks.yaml",
+		&structs.StackFile{}, ioutil.Discard)
+	assert.Nil(t, err)
+
+	cacheDir, err := ioutil.TempDir("", "sugarkube-cache-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	var wordpress interfaces.IInstallable
+	installableIds := make([]string, 0)
+	for _, installableObj := range stackObj.GetConfig().Manifests()[0].Installables() {
+		err = installableObj.SetTopLevelCacheDir(cacheDir)
+		assert.Nil(t, err)
+		err = os.MkdirAll(installableObj.GetCacheDir(), 0755)
+		assert.Nil(t, err)
+
+		installableIds = append(installableIds, installableObj.FullyQualifiedId())
+		if installableObj.Id() == "wordpress" {
+			wordpress = installableObj
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		dagObj, err := Create(stackObj.GetConfig().Manifests(), installableIds, true, nil)
+		assert.Nil(t, err)
+
+		err = dagObj.ExecuteGetVars(constants.DagActionVars, stackObj, false, nil, "")
+		assert.Nil(t, err)
+	}
+
+	templatedVars, err := stackObj.GetTemplatedVars(wordpress, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	kappVars := templatedVars["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	assert.Equal(t, "db-database.dns.example.com", kappVars["db_host"])
+	assert.Equal(t, "https://dns.example.com", kappVars["proxy"])
+}
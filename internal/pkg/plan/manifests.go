@@ -20,6 +20,7 @@ import (
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
 	"strings"
 )
 
@@ -83,3 +84,24 @@ func findDependencies(manifests []interfaces.IManifest) map[string]nodeDescripto
 
 	return descriptors
 }
+
+// Adds implicit dependencies (e.g. on kapps whose vars a kapp refers to) to descriptors
+func addImplicitDependencies(descriptors map[string]nodeDescriptor, implicitDependencies map[string][]string) {
+	for installableId, dependencies := range implicitDependencies {
+		descriptor, ok := descriptors[installableId]
+		if !ok {
+			continue
+		}
+
+		for _, dependency := range dependencies {
+			if dependency == installableId || utils.InStringArray(descriptor.dependsOn, dependency) {
+				continue
+			}
+
+			log.Logger.Debugf("Adding implicit dependency of '%s' on '%s'", installableId, dependency)
+			descriptor.dependsOn = append(descriptor.dependsOn, dependency)
+		}
+
+		descriptors[installableId] = descriptor
+	}
+}
@@ -67,3 +67,22 @@ func TestFindDependencies(t *testing.T) {
 
 	assert.Equal(t, expected, descriptors)
 }
+
+func TestAddImplicitDependencies(t *testing.T) {
+	descriptors := map[string]nodeDescriptor{
+		"web:wordpress": {dependsOn: []string{"data:mysql"}},
+		"web:db-proxy":  {dependsOn: []string{}},
+		"data:mysql":    {dependsOn: []string{}},
+	}
+
+	addImplicitDependencies(descriptors, map[string][]string{
+		"web:wordpress": {"data:mysql", "web:db-proxy", "web:wordpress"},
+		"web:unknown":   {"data:mysql"},
+	})
+
+	assert.Equal(t, map[string]nodeDescriptor{
+		"web:wordpress": {dependsOn: []string{"data:mysql", "web:db-proxy"}},
+		"web:db-proxy":  {dependsOn: []string{}},
+		"data:mysql":    {dependsOn: []string{}},
+	}, descriptors)
+}
@@ -0,0 +1,285 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/vars"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Tracks which kapps have stored outputs under custom registry paths (i.e. `registry_path`s declared
+// on outputs) while a DAG is processed. Each kapp only has a local registry so this is needed to
+// find out whether kapps in different branches of the DAG write to the same path.
+type registryPaths struct {
+	sync.Mutex
+	writes map[string]*registryPathWrite
+}
+
+// The value at a registry path and the kapps that wrote it
+type registryPathWrite struct {
+	kappIds []string // fully qualified IDs, the last being the most recent writer
+	value   interface{}
+}
+
+func newRegistryPaths() *registryPaths {
+	return &registryPaths{
+		writes: map[string]*registryPathWrite{},
+	}
+}
+
+// Returns an error if a registry path may not be declared on an output
+func validateRegistryPath(path string) error {
+	if strings.TrimSpace(path) == "" {
+		return errors.New("Registry paths may not be empty")
+	}
+
+	for _, element := range strings.Split(path, constants.RegistryFieldSeparator) {
+		if element == "" {
+			return errors.New(fmt.Sprintf("Registry path '%s' contains an empty element", path))
+		}
+	}
+
+	// outputs are namespaced by kapp, and the others would overwrite intrinsic vars
+	protected := []string{constants.RegistryKeyOutputs, constants.KappVarsKappKey, constants.KappVarsKappsKey,
+		"stack", "sugarkube"}
+	root := strings.Split(path, constants.RegistryFieldSeparator)[0]
+
+	for _, key := range protected {
+		if root == key {
+			return errors.New(fmt.Sprintf("Registry path '%s' is invalid. Paths under '%s' are protected",
+				path, key))
+		}
+	}
+
+	return nil
+}
+
+// Returns the conflict policy for an output, falling back to the globally configured one
+func registryConflictPolicy(onConflict string) (string, error) {
+	policy := onConflict
+	if policy == "" && config.CurrentConfig != nil {
+		policy = config.CurrentConfig.OutputConflicts
+	}
+
+	switch policy {
+	case "":
+		return constants.RegistryConflictError, nil
+	case constants.RegistryConflictError, constants.RegistryConflictLastWriterWins,
+		constants.RegistryConflictMerge:
+		return policy, nil
+	default:
+		return "", errors.New(fmt.Sprintf("Invalid output conflict policy '%s'. Valid values are "+
+			"'%s', '%s' and '%s'", policy, constants.RegistryConflictError,
+			constants.RegistryConflictLastWriterWins, constants.RegistryConflictMerge))
+	}
+}
+
+// Records that a kapp wants to store a value at a registry path and returns the value that
+// should be stored there after applying the conflict policy. Kapps may rewrite their own values.
+func (r *registryPaths) write(path string, kappId string, value interface{}, policy string) (interface{}, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	existing, ok := r.writes[path]
+	if !ok {
+		r.writes[path] = &registryPathWrite{kappIds: []string{kappId}, value: value}
+		return value, nil
+	}
+
+	otherKappIds := make([]string, 0)
+	for _, existingId := range existing.kappIds {
+		if existingId != kappId {
+			otherKappIds = append(otherKappIds, existingId)
+		}
+	}
+
+	// either this kapp is the only writer, or it's rewriting the same value
+	if len(otherKappIds) == 0 || reflect.DeepEqual(existing.value, value) {
+		existing.kappIds = append(otherKappIds, kappId)
+		if len(otherKappIds) == 0 {
+			existing.value = value
+		}
+		return existing.value, nil
+	}
+
+	newValue := value
+
+	switch policy {
+	case constants.RegistryConflictError:
+		return nil, errors.New(fmt.Sprintf("Kapp '%s' can't store an output at registry path '%s' "+
+			"because it's already been written by %s. Set 'on_conflict' on the output or the "+
+			"'output-conflicts' setting to '%s' or '%s' to allow this", kappId, path,
+			quoteIds(otherKappIds), constants.RegistryConflictLastWriterWins,
+			constants.RegistryConflictMerge))
+	case constants.RegistryConflictLastWriterWins:
+		log.Logger.Warnf("Kapp '%s' is overwriting the value at registry path '%s' written by %s",
+			kappId, path, quoteIds(otherKappIds))
+	case constants.RegistryConflictMerge:
+		var err error
+		newValue, err = mergeRegistryValues(existing.value, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error merging the output of kapp '%s' into registry "+
+				"path '%s' written by %s", kappId, path, quoteIds(otherKappIds))
+		}
+		log.Logger.Infof("Merged the output of kapp '%s' into registry path '%s' written by %s",
+			kappId, path, quoteIds(otherKappIds))
+	}
+
+	existing.kappIds = append(otherKappIds, kappId)
+	existing.value = newValue
+
+	return newValue, nil
+}
+
+// Returns the values at all registry paths that have been written so far
+func (r *registryPaths) values() map[string]interface{} {
+	r.Lock()
+	defer r.Unlock()
+
+	values := make(map[string]interface{}, len(r.writes))
+	for path, write := range r.writes {
+		values[path] = write.value
+	}
+
+	return values
+}
+
+// Merges two values stored at the same registry path. Maps are merged recursively and lists
+// are combined according to the 'overwrite-merged-lists' setting. Other values can't be merged.
+func mergeRegistryValues(existing interface{}, value interface{}) (interface{}, error) {
+	existingMap, existingIsMap := existing.(map[string]interface{})
+	valueMap, valueIsMap := value.(map[string]interface{})
+
+	if existingIsMap && valueIsMap {
+		merged := map[string]interface{}{}
+		err := vars.MergeWithStrategy(&merged, existingMap)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		err = vars.MergeWithStrategy(&merged, valueMap)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return merged, nil
+	}
+
+	existingList, existingIsList := existing.([]interface{})
+	valueList, valueIsList := value.([]interface{})
+
+	if existingIsList && valueIsList {
+		if config.CurrentConfig != nil && config.CurrentConfig.OverwriteMergedLists {
+			return valueList, nil
+		}
+
+		merged := make([]interface{}, 0, len(existingList)+len(valueList))
+		merged = append(merged, existingList...)
+		return append(merged, valueList...), nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("Only maps or lists can be merged, not %T and %T",
+		existing, value))
+}
+
+// Stores outputs declaring registry paths in a kapp's local registry
+func addOutputsToRegistryPaths(installableObj interfaces.IInstallable, outputs map[string]interface{},
+	registry interfaces.IRegistry, paths *registryPaths) error {
+
+	outputIds := make([]string, 0)
+	for outputId := range outputs {
+		outputIds = append(outputIds, outputId)
+	}
+	sort.Strings(outputIds)
+
+	declaredOutputs := installableObj.GetDescriptor().Outputs
+
+	for _, outputId := range outputIds {
+		output, ok := declaredOutputs[outputId]
+		// outputs that were missing don't claim their registry paths
+		if !ok || output.RegistryPath == "" || outputs[outputId] == nil {
+			continue
+		}
+
+		err := validateRegistryPath(output.RegistryPath)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid registry path for output '%s' of kapp '%s'",
+				outputId, installableObj.FullyQualifiedId())
+		}
+
+		policy, err := registryConflictPolicy(output.OnConflict)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid conflict policy for output '%s' of kapp '%s'",
+				outputId, installableObj.FullyQualifiedId())
+		}
+
+		value, err := paths.write(output.RegistryPath, installableObj.FullyQualifiedId(),
+			outputs[outputId], policy)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		err = replaceRegistryValue(registry, output.RegistryPath, value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Replaces the value at registry paths that are in a local registry with the latest values
+// written to them. This means kapps with several parents see the same value at a path whichever
+// order their parents' registries were merged in.
+func resolveRegistryPaths(registry interfaces.IRegistry, paths *registryPaths) error {
+	for path, value := range paths.values() {
+		if _, ok := registry.Get(path); !ok {
+			continue
+		}
+
+		err := replaceRegistryValue(registry, path, value)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Sets a value in the registry, replacing instead of merging into any existing map
+func replaceRegistryValue(registry interfaces.IRegistry, path string, value interface{}) error {
+	registry.Delete(path)
+	return errors.WithStack(registry.Set(path, value))
+}
+
+// Formats kapp IDs for error messages
+func quoteIds(kappIds []string) string {
+	quoted := make([]string, len(kappIds))
+	for i, kappId := range kappIds {
+		quoted[i] = fmt.Sprintf("'%s'", kappId)
+	}
+
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package plan
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/registry"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"testing"
+)
+
+func TestValidateRegistryPath(t *testing.T) {
+	assert.Nil(t, validateRegistryPath("network.vpc_id"))
+	assert.Nil(t, validateRegistryPath("outputs_shared.vpc_id"))
+
+	for _, path := range []string{"", "outputs", "outputs.wordpress.db", "network..vpc_id", "network.",
+		"kapp.vars.region", "stack.name"} {
+		assert.NotNil(t, validateRegistryPath(path), path)
+	}
+}
+
+func TestRegistryConflictPolicy(t *testing.T) {
+	policy, err := registryConflictPolicy("")
+	assert.Nil(t, err)
+	assert.Equal(t, constants.RegistryConflictError, policy)
+
+	policy, err = registryConflictPolicy(constants.RegistryConflictMerge)
+	assert.Nil(t, err)
+	assert.Equal(t, constants.RegistryConflictMerge, policy)
+
+	_, err = registryConflictPolicy("first-writer-wins")
+	assert.NotNil(t, err)
+}
+
+func TestRegistryPathsWrite(t *testing.T) {
+	paths := newRegistryPaths()
+
+	value, err := paths.write("network.vpc_id", "net:vpc", "vpc-1", constants.RegistryConflictError)
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-1", value)
+
+	// kapps can rewrite their own values
+	value, err = paths.write("network.vpc_id", "net:vpc", "vpc-2", constants.RegistryConflictError)
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-2", value)
+
+	// other kapps can write the same value
+	_, err = paths.write("network.vpc_id", "net:peering", "vpc-2", constants.RegistryConflictError)
+	assert.Nil(t, err)
+
+	_, err = paths.write("network.vpc_id", "web:wordpress", "vpc-3", constants.RegistryConflictError)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already been written by 'net:vpc', 'net:peering'")
+
+	value, err = paths.write("network.vpc_id", "web:wordpress", "vpc-3", constants.RegistryConflictLastWriterWins)
+	assert.Nil(t, err)
+	assert.Equal(t, "vpc-3", value)
+
+	// maps and lists can be merged but other values can't
+	_, err = paths.write("network.subnets", "net:vpc", map[string]interface{}{
+		"public": []interface{}{"a"},
+	}, constants.RegistryConflictMerge)
+	assert.Nil(t, err)
+
+	value, err = paths.write("network.subnets", "net:extra", map[string]interface{}{
+		"public":  []interface{}{"b"},
+		"private": []interface{}{"c"},
+	}, constants.RegistryConflictMerge)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"public":  []interface{}{"a", "b"},
+		"private": []interface{}{"c"},
+	}, value)
+
+	_, err = paths.write("network.vpc_id", "net:vpc", "vpc-4", constants.RegistryConflictMerge)
+	assert.NotNil(t, err)
+
+	assert.Equal(t, map[string]interface{}{
+		"network.vpc_id": "vpc-3",
+		"network.subnets": map[string]interface{}{
+			"public":  []interface{}{"a", "b"},
+			"private": []interface{}{"c"},
+		},
+	}, paths.values())
+}
+
+func TestAddOutputsToRegistryPaths(t *testing.T) {
+	newKapp := func(kappId string, registryPath string) interfaces.IInstallable {
+		installableObj, err := installable.New("net", []structs.KappDescriptorWithMaps{
+			{
+				Id: kappId,
+				Outputs: map[string]structs.Output{
+					"vpc": {Id: "vpc", RegistryPath: registryPath, OnConflict: constants.RegistryConflictMerge},
+				},
+			},
+		})
+		assert.Nil(t, err)
+		return installableObj
+	}
+
+	paths := newRegistryPaths()
+	vpcRegistry := registry.New()
+	peeringRegistry := registry.New()
+
+	err := addOutputsToRegistryPaths(newKapp("vpc", "network"), map[string]interface{}{
+		"vpc":     map[string]interface{}{"vpc_id": "vpc-1", "cidr": "10.0.0.0/16"},
+		"ignored": "value",
+	}, vpcRegistry, paths)
+	assert.Nil(t, err)
+
+	err = addOutputsToRegistryPaths(newKapp("peering", "network"), map[string]interface{}{
+		"vpc": map[string]interface{}{"peering_id": "pcx-1"},
+	}, peeringRegistry, paths)
+	assert.Nil(t, err)
+
+	value, ok := peeringRegistry.Get("network")
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"vpc_id": "vpc-1", "cidr": "10.0.0.0/16",
+		"peering_id": "pcx-1"}, value)
+
+	// registries of kapps that depend on both kapps see the merged value
+	assert.Nil(t, resolveRegistryPaths(vpcRegistry, paths))
+	value, ok = vpcRegistry.Get("network.peering_id")
+	assert.True(t, ok)
+	assert.Equal(t, "pcx-1", value)
+
+	// missing outputs don't claim their registry path
+	err = addOutputsToRegistryPaths(newKapp("missing", "missing"), map[string]interface{}{"vpc": nil},
+		registry.New(), paths)
+	assert.Nil(t, err)
+	_, ok = paths.values()["missing"]
+	assert.False(t, ok)
+
+	err = addOutputsToRegistryPaths(newKapp("invalid", "outputs.vpc"), map[string]interface{}{
+		"vpc": "vpc-1",
+	}, registry.New(), paths)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "protected")
+}
@@ -68,6 +68,12 @@ func New(stackConfig interfaces.IStackConfig) (interfaces.IProvider, error) {
 // all the data.
 func GetVarsFromFiles(provider interfaces.IProvider,
 	stackConfig interfaces.IStackConfig) (map[string]interface{}, error) {
+	return GetVarsFromFilesWithProvenance(provider, stackConfig, nil)
+}
+
+// Like GetVarsFromFiles but records which file set each value in the given provenance (if any)
+func GetVarsFromFilesWithProvenance(provider interfaces.IProvider, stackConfig interfaces.IStackConfig,
+	provenance *vars.Provenance) (map[string]interface{}, error) {
 
 	var err error
 
@@ -86,7 +92,7 @@ func GetVarsFromFiles(provider interfaces.IProvider,
 
 	values := map[string]interface{}{}
 
-	err = vars.MergePaths(&values, dirs...)
+	err = vars.MergePathsWithProvenance(&values, provenance, dirs...)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Matches characters that can't be used in env var names
+var invalidEnvVarChars = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// Reads secrets from env vars. The path is upper-cased with other characters replaced by
+// underscores, e.g. 'db/prod' reads $DB_PROD as the 'value' field and env vars starting with
+// 'DB_PROD_' as other fields, so 'db/prod#password' reads $DB_PROD_PASSWORD.
+type envBackend struct {
+	config config.EnvSecretsConfig
+}
+
+func (e envBackend) fetch(path string) (map[string]interface{}, error) {
+	name := envVarName(e.config.Prefix + path)
+	fields := map[string]interface{}{}
+
+	for _, envVar := range os.Environ() {
+		parts := strings.SplitN(envVar, "=", 2)
+		if parts[0] == name {
+			fields[defaultKey] = parts[1]
+		} else if strings.HasPrefix(parts[0], name+"_") {
+			fields[strings.ToLower(strings.TrimPrefix(parts[0], name+"_"))] = parts[1]
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, errors.New(fmt.Sprintf("Env var '%s' isn't set", name))
+	}
+
+	return fields, nil
+}
+
+// Converts a secret path to an env var name
+func envVarName(path string) string {
+	return invalidEnvVarChars.ReplaceAllString(strings.ToUpper(path), "_")
+}
+
+// Reads secrets from files. YAML files containing maps have a field per key, other files have
+// their contents in the 'value' field. SOPS-encrypted files are decrypted.
+type fileBackend struct {
+	config config.FileSecretsConfig
+}
+
+func (f fileBackend) fetch(path string) (map[string]interface{}, error) {
+	path = resolvePath(f.config.Dir, path)
+
+	var data []byte
+	var err error
+
+	if utils.IsEncryptedFile(path) {
+		data, err = Decrypt(path)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return parseFields(data), nil
+}
+
+// Parses data as a YAML map of fields, or returns it as the 'value' field if it isn't one
+func parseFields(data []byte) map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	err := yaml.Unmarshal(data, &fields)
+	if err != nil || len(fields) == 0 {
+		return map[string]interface{}{
+			defaultKey: strings.TrimSpace(string(data)),
+		}
+	}
+
+	return fields
+}
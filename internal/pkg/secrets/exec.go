@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"strings"
+)
+
+// Runs a helper command with the secret's path as its last argument, e.g. 'pass show'. If
+// it prints a YAML or JSON map each key is a field, otherwise its output is the 'value' field.
+type execBackend struct {
+	config config.ExecSecretsConfig
+}
+
+func newExecBackend(execConfig config.ExecSecretsConfig) (*execBackend, error) {
+	if execConfig.Command == "" {
+		return nil, errors.New("No command configured for the exec secrets backend. " +
+			"Set the 'secrets.exec.command' setting")
+	}
+
+	if execConfig.Timeout == 0 {
+		execConfig.Timeout = defaultSecretsTimeout
+	}
+
+	return &execBackend{config: execConfig}, nil
+}
+
+func (e execBackend) fetch(path string) (map[string]interface{}, error) {
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	args := append(append([]string{}, e.config.Args...), path)
+
+	err := utils.ExecCommand(e.config.Command, args, map[string]string{}, &stdoutBuf,
+		&stderrBuf, "", e.config.Timeout, false)
+	if err != nil {
+		// don't return the wrapped error because it includes stdout
+		return nil, errors.New(fmt.Sprintf("Error running '%s %s': %s", e.config.Command,
+			strings.Join(args, " "), strings.TrimSpace(stderrBuf.String())))
+	}
+
+	return parseFields(stdoutBuf.Bytes()), nil
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	VaultBackend = "vault"
+	EnvBackend   = "env"
+	FileBackend  = "file"
+	ExecBackend  = "exec"
+)
+
+const defaultBackend = EnvBackend
+
+// Separates a secret's path from the key of the field to return
+const keySeparator = "#"
+
+// The field returned if a reference doesn't give a key and a secret has more than one field
+const defaultKey = "value"
+
+// Fetches all the fields of the secret at a path
+type backend interface {
+	fetch(path string) (map[string]interface{}, error)
+}
+
+// Secrets fetched during this run keyed by backend name and path, files secrets have been
+// written to keyed by reference and files decrypted with sops keyed by path
+var cache = struct {
+	sync.Mutex
+	secrets   map[string]map[string]interface{}
+	files     map[string]string
+	filesDir  string
+	decrypted map[string]decryptedFile
+}{
+	secrets:   map[string]map[string]interface{}{},
+	files:     map[string]string{},
+	decrypted: map[string]decryptedFile{},
+}
+
+// Returns the value of a secret given a reference of the form '[backend:]path[#key]', e.g.
+// 'vault:db/prod#password'. If no backend is given the configured default is used. Secrets
+// are only fetched once per run and their values are redacted from logs.
+func Secret(reference string) (string, error) {
+	backendName, path, key, err := parseReference(reference)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	fields, err := fetch(backendName, path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	value, err := selectField(fields, key)
+	if err != nil {
+		return "", errors.Wrapf(err, "Error getting secret '%s'", reference)
+	}
+
+	return value, nil
+}
+
+// Writes the value of a secret to a file that only the current user can read and returns its
+// path. The file is deleted by DeleteSecretFiles.
+func SecretFile(reference string) (string, error) {
+	cache.Lock()
+	path, ok := cache.files[reference]
+	cache.Unlock()
+	if ok {
+		return path, nil
+	}
+
+	value, err := Secret(reference)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.filesDir == "" {
+		cache.filesDir, err = ioutil.TempDir("", "sugarkube-secrets-")
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+	}
+
+	file, err := ioutil.TempFile(cache.filesDir, "secret-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer file.Close()
+
+	err = file.Chmod(0600)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	_, err = file.WriteString(value)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Wrote secret '%s' to '%s'", reference, file.Name())
+	cache.files[reference] = file.Name()
+
+	return file.Name(), nil
+}
+
+// Deletes files written by SecretFile. This should be called before exiting, including when
+// the process is terminated by a signal.
+func DeleteSecretFiles() {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if cache.filesDir == "" {
+		return
+	}
+
+	log.Logger.Debugf("Deleting secret files in '%s'", cache.filesDir)
+	err := os.RemoveAll(cache.filesDir)
+	if err != nil {
+		log.Logger.Errorf("Failed to delete secret files in '%s': %s", cache.filesDir, err)
+	}
+
+	cache.filesDir = ""
+	cache.files = map[string]string{}
+}
+
+// Returns the fields of a secret, fetching it from its backend if it hasn't been fetched yet
+func fetch(backendName string, path string) (map[string]interface{}, error) {
+	cacheKey := backendName + ":" + path
+
+	cache.Lock()
+	fields, ok := cache.secrets[cacheKey]
+	cache.Unlock()
+	if ok {
+		return fields, nil
+	}
+
+	backendImpl, err := newBackend(backendName)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	log.Logger.Debugf("Fetching secret '%s' from the %s backend", path, backendName)
+	fields, err = backendImpl.fetch(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error fetching secret '%s' from the %s backend",
+			path, backendName)
+	}
+
+	MarkSensitive(fields)
+
+	cache.Lock()
+	cache.secrets[cacheKey] = fields
+	cache.Unlock()
+
+	return fields, nil
+}
+
+// Instantiates a backend by name
+func newBackend(name string) (backend, error) {
+	secretsConfig := config.SecretsConfig{}
+	if config.CurrentConfig != nil {
+		secretsConfig = config.CurrentConfig.Secrets
+	}
+
+	switch name {
+	case VaultBackend:
+		return newVaultBackend(secretsConfig.Vault)
+	case EnvBackend:
+		return envBackend{config: secretsConfig.Env}, nil
+	case FileBackend:
+		return fileBackend{config: secretsConfig.File}, nil
+	case ExecBackend:
+		return newExecBackend(secretsConfig.Exec)
+	}
+
+	return nil, errors.New(fmt.Sprintf("Unknown secrets backend '%s'. Must be one of: %s", name,
+		strings.Join([]string{VaultBackend, EnvBackend, FileBackend, ExecBackend}, ", ")))
+}
+
+// Splits a reference of the form '[backend:]path[#key]' into its parts
+func parseReference(reference string) (string, string, string, error) {
+	backendName := defaultBackend
+	if config.CurrentConfig != nil && config.CurrentConfig.Secrets.Backend != "" {
+		backendName = config.CurrentConfig.Secrets.Backend
+	}
+
+	path := reference
+	for _, name := range []string{VaultBackend, EnvBackend, FileBackend, ExecBackend} {
+		if strings.HasPrefix(reference, name+":") {
+			backendName = name
+			path = strings.TrimPrefix(reference, name+":")
+			break
+		}
+	}
+
+	key := ""
+	if index := strings.LastIndex(path, keySeparator); index >= 0 {
+		key = path[index+1:]
+		path = path[:index]
+	}
+
+	if path == "" {
+		return "", "", "", errors.New(fmt.Sprintf("No path given in secret reference '%s'", reference))
+	}
+
+	return backendName, path, key, nil
+}
+
+// Returns a field of a secret as a string. If no key is given and the secret has more than
+// one field, the 'value' field is returned.
+func selectField(fields map[string]interface{}, key string) (string, error) {
+	if key == "" {
+		if len(fields) == 1 {
+			for _, value := range fields {
+				return stringify(value)
+			}
+		}
+		key = defaultKey
+	}
+
+	value, ok := fields[key]
+	if !ok {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		return "", errors.New(fmt.Sprintf("Secret has no key '%s'. Its keys are: %s", key,
+			strings.Join(keys, ", ")))
+	}
+
+	return stringify(value)
+}
+
+// Converts a secret value to a string, encoding maps and lists as JSON
+func stringify(value interface{}) (string, error) {
+	switch typed := value.(type) {
+	case string:
+		return typed, nil
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(typed)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		return string(data), nil
+	}
+
+	return fmt.Sprintf("%v", value), nil
+}
+
+// Resolves a secret path against a directory unless it's absolute
+func resolvePath(dir string, path string) string {
+	if filepath.IsAbs(path) || dir == "" {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// Clears secrets fetched during this run
+func resetCache() {
+	DeleteSecretFiles()
+
+	cache.Lock()
+	defer cache.Unlock()
+	cache.secrets = map[string]map[string]interface{}{}
+	cache.decrypted = map[string]decryptedFile{}
+}
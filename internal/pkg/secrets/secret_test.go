@@ -0,0 +1,215 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Sets the secrets config for a test, returning a function that restores the original
+func withSecretsConfig(secretsConfig config.SecretsConfig) func() {
+	originalConfig := config.CurrentConfig
+	config.CurrentConfig = &config.Config{Secrets: secretsConfig}
+	resetCache()
+
+	return func() {
+		resetCache()
+		config.CurrentConfig = originalConfig
+	}
+}
+
+func tmpDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "secrets-")
+	assert.Nil(t, err)
+	return dir
+}
+
+func TestParseReference(t *testing.T) {
+	defer withSecretsConfig(config.SecretsConfig{Backend: VaultBackend})()
+
+	tests := []struct {
+		reference string
+		backend   string
+		path      string
+		key       string
+	}{
+		{"db/prod#password", VaultBackend, "db/prod", "password"},
+		{"env:db/prod#password", EnvBackend, "db/prod", "password"},
+		{"file:/tmp/token", FileBackend, "/tmp/token", ""},
+		{"exec:db", ExecBackend, "db", ""},
+	}
+
+	for _, test := range tests {
+		backendName, path, key, err := parseReference(test.reference)
+		assert.Nil(t, err)
+		assert.Equal(t, test.backend, backendName, "unexpected backend for %s", test.reference)
+		assert.Equal(t, test.path, path, "unexpected path for %s", test.reference)
+		assert.Equal(t, test.key, key, "unexpected key for %s", test.reference)
+	}
+
+	_, _, _, err := parseReference("vault:#password")
+	assert.Error(t, err)
+}
+
+func TestVaultBackend(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "test-token", r.Header.Get("X-Vault-Token"))
+		assert.Equal(t, "team-a", r.Header.Get("X-Vault-Namespace"))
+
+		switch r.URL.Path {
+		case "/v1/kv/data/db/prod":
+			fmt.Fprint(w, `{"data": {"data": {"username": "admin", "password": "vault-password"}, `+
+				`"metadata": {"version": 3}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors": []}`)
+		}
+	}))
+	defer server.Close()
+
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+
+	tokenFile := filepath.Join(dir, "token")
+	assert.Nil(t, ioutil.WriteFile(tokenFile, []byte("test-token\n"), 0600))
+
+	defer withSecretsConfig(config.SecretsConfig{
+		Backend: VaultBackend,
+		Vault: config.VaultSecretsConfig{
+			Address:   server.URL,
+			TokenFile: tokenFile,
+			Mount:     "kv",
+			Namespace: "team-a",
+		},
+	})()
+
+	value, err := Secret("db/prod#password")
+	assert.Nil(t, err)
+	assert.Equal(t, "vault-password", value)
+
+	value, err = Secret("vault:db/prod#username")
+	assert.Nil(t, err)
+	assert.Equal(t, "admin", value)
+
+	// secrets are cached per run and redacted
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, "<redacted>", log.Redact("vault-password"))
+
+	_, err = Secret("db/prod")
+	assert.Error(t, err, "a key should be required for secrets with several fields")
+
+	_, err = Secret("db/prod#missing")
+	assert.Error(t, err)
+
+	_, err = Secret("db/staging#password")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "status 404")
+}
+
+func TestEnvBackend(t *testing.T) {
+	defer withSecretsConfig(config.SecretsConfig{
+		Env: config.EnvSecretsConfig{Prefix: "sktest_"},
+	})()
+
+	os.Setenv("SKTEST_API_TOKEN", "env-token")
+	os.Setenv("SKTEST_DB_PROD_PASSWORD", "env-password")
+	defer os.Unsetenv("SKTEST_API_TOKEN")
+	defer os.Unsetenv("SKTEST_DB_PROD_PASSWORD")
+
+	value, err := Secret("api-token")
+	assert.Nil(t, err)
+	assert.Equal(t, "env-token", value)
+
+	value, err = Secret("env:db/prod#password")
+	assert.Nil(t, err)
+	assert.Equal(t, "env-password", value)
+
+	_, err = Secret("env:missing")
+	assert.Error(t, err)
+}
+
+func TestFileBackend(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "token"), []byte("file-token\n"), 0600))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "db.yaml"),
+		[]byte("username: admin\npassword: file-password\n"), 0600))
+
+	defer withSecretsConfig(config.SecretsConfig{
+		File: config.FileSecretsConfig{Dir: dir},
+	})()
+
+	value, err := Secret("file:token")
+	assert.Nil(t, err)
+	assert.Equal(t, "file-token", value)
+
+	value, err = Secret("file:db.yaml#password")
+	assert.Nil(t, err)
+	assert.Equal(t, "file-password", value)
+}
+
+func TestExecBackend(t *testing.T) {
+	defer withSecretsConfig(config.SecretsConfig{
+		Exec: config.ExecSecretsConfig{
+			Command: "sh",
+			Args:    []string{"-c", `echo "password: exec-$0"`},
+		},
+	})()
+
+	value, err := Secret("exec:db#password")
+	assert.Nil(t, err)
+	assert.Equal(t, "exec-db", value)
+}
+
+func TestSecretFile(t *testing.T) {
+	defer withSecretsConfig(config.SecretsConfig{})()
+
+	os.Setenv("SKTEST_CERT", "file-contents")
+	defer os.Unsetenv("SKTEST_CERT")
+
+	path, err := SecretFile("sktest_cert")
+	assert.Nil(t, err)
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "file-contents", string(data))
+
+	samePath, err := SecretFile("sktest_cert")
+	assert.Nil(t, err)
+	assert.Equal(t, path, samePath)
+
+	DeleteSecretFiles()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const defaultSopsBinary = "sops"
+
+// Env vars sops reads key locations from
+const sopsAgeKeyFileEnvVar = "SOPS_AGE_KEY_FILE"
+const gnupgHomeEnvVar = "GNUPGHOME"
+
+// The plain text of a file decrypted with sops and the modification time it had then
+type decryptedFile struct {
+	modTime time.Time
+	content []byte
+}
+
+// Decrypts a SOPS-encrypted YAML file by shelling out to sops, returning the plain text. Files
+// are only decrypted once per run unless they're modified.
+func Decrypt(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error decrypting '%s' with sops", path)
+	}
+
+	cache.Lock()
+	decrypted, ok := cache.decrypted[path]
+	cache.Unlock()
+	if ok && decrypted.modTime.Equal(info.ModTime()) {
+		return decrypted.content, nil
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+
+	log.Logger.Debugf("Decrypting '%s' with sops", path)
+
+	args := []string{"--decrypt", "--input-type", "yaml", "--output-type", "yaml", path}
+
+	err = utils.ExecCommand(sopsBinary(), args, sopsEnvVars(), &stdoutBuf, &stderrBuf,
+		"", 0, false)
+	if err != nil {
+		// don't return the wrapped error because it includes stdout
+		return nil, errors.New(fmt.Sprintf("Error decrypting '%s' with sops: %s", path,
+			strings.TrimSpace(stderrBuf.String())))
+	}
+
+	cache.Lock()
+	cache.decrypted[path] = decryptedFile{modTime: info.ModTime(), content: stdoutBuf.Bytes()}
+	cache.Unlock()
+
+	return stdoutBuf.Bytes(), nil
+}
+
+// Opens an encrypted file in the user's editor with sops. New files are encrypted for the
+// configured age recipients and PGP fingerprints.
+func Edit(path string) error {
+	args := make([]string, 0)
+
+	if config.CurrentConfig != nil {
+		sopsConfig := config.CurrentConfig.Sops
+		if len(sopsConfig.AgeRecipients) > 0 {
+			args = append(args, "--age", strings.Join(sopsConfig.AgeRecipients, ","))
+		}
+		if len(sopsConfig.PgpFingerprints) > 0 {
+			args = append(args, "--pgp", strings.Join(sopsConfig.PgpFingerprints, ","))
+		}
+	}
+
+	args = append(args, path)
+
+	cmd := exec.Command(sopsBinary(), args...)
+	cmd.Env = os.Environ()
+	for k, v := range sopsEnvVars() {
+		cmd.Env = append(cmd.Env, strings.Join([]string{k, v}, "="))
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	log.Logger.Infof("Editing '%s' with: %s %s", path, sopsBinary(), strings.Join(args, " "))
+
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrapf(err, "Error editing '%s' with sops", path)
+	}
+
+	return nil
+}
+
+// Marks all strings and numbers in a value as sensitive so they're redacted from logs
+func MarkSensitive(value interface{}) {
+	switch typed := value.(type) {
+	case string:
+		log.AddSensitiveValues(typed)
+	case int, int64, float64:
+		log.AddSensitiveValues(fmt.Sprintf("%v", typed))
+	case map[string]interface{}:
+		for _, v := range typed {
+			MarkSensitive(v)
+		}
+	case map[interface{}]interface{}:
+		for _, v := range typed {
+			MarkSensitive(v)
+		}
+	case []interface{}:
+		for _, v := range typed {
+			MarkSensitive(v)
+		}
+	}
+}
+
+// Returns the path to the sops binary
+func sopsBinary() string {
+	if config.CurrentConfig != nil && config.CurrentConfig.Sops.Binary != "" {
+		return config.CurrentConfig.Sops.Binary
+	}
+
+	return defaultSopsBinary
+}
+
+// Returns env vars telling sops where to find keys
+func sopsEnvVars() map[string]string {
+	envVars := map[string]string{}
+
+	if config.CurrentConfig == nil {
+		return envVars
+	}
+
+	sopsConfig := config.CurrentConfig.Sops
+	if sopsConfig.AgeKeyFile != "" {
+		envVars[sopsAgeKeyFileEnvVar] = sopsConfig.AgeKeyFile
+	}
+	if sopsConfig.GnupgHome != "" {
+		envVars[gnupgHomeEnvVar] = sopsConfig.GnupgHome
+	}
+
+	return envVars
+}
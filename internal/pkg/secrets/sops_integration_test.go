@@ -0,0 +1,88 @@
+// +build integration
+
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// Encrypts a file with sops for a freshly generated age key and decrypts it again
+func TestDecryptWithAge(t *testing.T) {
+	for _, binary := range []string{"sops", "age-keygen"} {
+		if _, err := exec.LookPath(binary); err != nil {
+			t.Skipf("%s isn't on the path", binary)
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir("", "sops-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	keyFile := filepath.Join(tmpDir, "keys.txt")
+	err = exec.Command("age-keygen", "-o", keyFile).Run()
+	assert.Nil(t, err)
+
+	keyData, err := ioutil.ReadFile(keyFile)
+	assert.Nil(t, err)
+	recipient := regexp.MustCompile(`public key: (age1[a-z0-9]+)`).FindStringSubmatch(string(keyData))
+	assert.NotNil(t, recipient)
+
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+	config.CurrentConfig = &config.Config{
+		Sops: config.SopsConfig{
+			AgeKeyFile: keyFile,
+		},
+	}
+
+	plainFile := filepath.Join(tmpDir, "values.yaml")
+	err = ioutil.WriteFile(plainFile, []byte("db:\n  password: top-secret-value\n"), 0600)
+	assert.Nil(t, err)
+
+	encrypted, err := exec.Command("sops", "--encrypt", "--age", recipient[1], plainFile).Output()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(encrypted), "top-secret-value")
+
+	encryptedFile := filepath.Join(tmpDir, "values.enc.yaml")
+	err = ioutil.WriteFile(encryptedFile, encrypted, 0600)
+	assert.Nil(t, err)
+
+	decrypted, err := Decrypt(encryptedFile)
+	assert.Nil(t, err)
+
+	data := map[string]interface{}{}
+	err = yaml.Unmarshal(decrypted, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "top-secret-value",
+		data["db"].(map[interface{}]interface{})["password"])
+
+	MarkSensitive(data)
+	assert.Equal(t, "<redacted>", log.Redact("top-secret-value"))
+}
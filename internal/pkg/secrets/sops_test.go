@@ -0,0 +1,91 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	log.ConfigureLogger("debug", false)
+}
+
+func TestMarkSensitive(t *testing.T) {
+	MarkSensitive(map[interface{}]interface{}{
+		"db": map[interface{}]interface{}{
+			"password": "correct-horse",
+			"port":     54321,
+		},
+		"tokens": []interface{}{"token-one", "token-two"},
+	})
+
+	assert.Equal(t, "<redacted> <redacted> <redacted> <redacted>",
+		log.Redact("correct-horse 54321 token-one token-two"))
+}
+
+func TestDecryptCachesContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sugarkube-sops-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// a fake sops that records each call and prints the encrypted file
+	calls := filepath.Join(dir, "calls")
+	binary := filepath.Join(dir, "sops")
+	script := "#!/bin/sh\necho called >> " + calls + "\nfor last; do :; done\ncat \"$last\"\n"
+	assert.Nil(t, ioutil.WriteFile(binary, []byte(script), 0755))
+
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+		resetCache()
+	}()
+	config.CurrentConfig = &config.Config{Sops: config.SopsConfig{Binary: binary}}
+
+	path := filepath.Join(dir, "secrets.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("password: one\n"), 0644))
+
+	countCalls := func() int {
+		data, err := ioutil.ReadFile(calls)
+		assert.Nil(t, err)
+		return strings.Count(string(data), "called")
+	}
+
+	for i := 0; i < 2; i++ {
+		decrypted, err := Decrypt(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "password: one\n", string(decrypted))
+	}
+	assert.Equal(t, 1, countCalls())
+
+	// modified files are decrypted again
+	assert.Nil(t, ioutil.WriteFile(path, []byte("password: two\n"), 0644))
+	modTime := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path, modTime, modTime))
+
+	decrypted, err := Decrypt(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "password: two\n", string(decrypted))
+	assert.Equal(t, 2, countCalls())
+}
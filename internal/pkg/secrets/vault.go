@@ -0,0 +1,159 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultVaultMount = "secret"
+const defaultSecretsTimeout = 30
+
+const vaultAddrEnvVar = "VAULT_ADDR"
+const vaultTokenEnvVar = "VAULT_TOKEN"
+const vaultTokenFile = ".vault-token"
+
+// Fetches secrets from a Vault KV version 2 secrets engine over HTTP
+type vaultBackend struct {
+	address   string
+	token     string
+	mount     string
+	namespace string
+	client    *http.Client
+}
+
+func newVaultBackend(vaultConfig config.VaultSecretsConfig) (*vaultBackend, error) {
+	address := vaultConfig.Address
+	if address == "" {
+		address = os.Getenv(vaultAddrEnvVar)
+	}
+	if address == "" {
+		return nil, errors.New(fmt.Sprintf("No Vault address configured. Set the "+
+			"'secrets.vault.address' setting or the %s env var", vaultAddrEnvVar))
+	}
+
+	token, err := vaultToken(vaultConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	mount := vaultConfig.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+
+	timeout := vaultConfig.Timeout
+	if timeout == 0 {
+		timeout = defaultSecretsTimeout
+	}
+
+	return &vaultBackend{
+		address:   strings.TrimSuffix(address, "/"),
+		token:     token,
+		mount:     strings.Trim(mount, "/"),
+		namespace: vaultConfig.Namespace,
+		client:    &http.Client{Timeout: time.Duration(timeout) * time.Second},
+	}, nil
+}
+
+// Returns the token from the configured token file, the VAULT_TOKEN env var or the file the
+// vault CLI writes tokens to, in that order
+func vaultToken(vaultConfig config.VaultSecretsConfig) (string, error) {
+	tokenFile := vaultConfig.TokenFile
+
+	if tokenFile == "" {
+		if token := os.Getenv(vaultTokenEnvVar); token != "" {
+			return token, nil
+		}
+
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		tokenFile = filepath.Join(homeDir, vaultTokenFile)
+	}
+
+	data, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return "", errors.Wrapf(err, "No Vault token found. Set the 'secrets.vault.token-file' "+
+			"setting or the %s env var", vaultTokenEnvVar)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// The parts of a KV v2 read response we need
+type vaultResponse struct {
+	Data struct {
+		Data map[string]interface{} `json:"data"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (v vaultBackend) fetch(path string) (map[string]interface{}, error) {
+	url := fmt.Sprintf("%s/v1/%s/data/%s", v.address, v.mount, strings.TrimPrefix(path, "/"))
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	request.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		request.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	response, err := v.client.Do(request)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error requesting '%s' from Vault", url)
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var parsed vaultResponse
+	// error responses may not be JSON so only fail to parse successful ones
+	jsonErr := json.Unmarshal(body, &parsed)
+
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Vault returned status %d for '%s': %s",
+			response.StatusCode, url, strings.Join(parsed.Errors, "; ")))
+	}
+
+	if jsonErr != nil {
+		return nil, errors.Wrapf(jsonErr, "Error parsing the response from Vault for '%s'", url)
+	}
+
+	if parsed.Data.Data == nil {
+		return nil, errors.New(fmt.Sprintf("No data returned by Vault for '%s'. It may have "+
+			"been deleted", url))
+	}
+
+	return parsed.Data.Data, nil
+}
@@ -0,0 +1,405 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package stack
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Matches references to other kapps in templates, e.g. `.kapps.wordpress.vars.hostname` or
+// `index .kapps "wordpress" "vars" "hostname"`. The key is captured by the first or second group.
+var kappsReferenceRegex = regexp.MustCompile(`\.` + constants.KappVarsKappsKey +
+	`(?:\.([A-Za-z0-9_]+)|\s+["\x60]([A-Za-z0-9_]+)["\x60])`)
+
+// Snapshots of the kapps in a stack and the templated vars resolved from them, so kapps can
+// refer to each other's vars while they're being processed concurrently
+type kappVarsCache struct {
+	sync.Mutex
+	snapshots []interfaces.IInstallable // nil until the kapps are snapshotted
+	// templated vars keyed by the installer vars they were templated with (see installerVarsKey)
+	// then by fully-qualified kapp ID
+	resolved map[string]map[string]interface{}
+}
+
+func newKappVarsCache() *kappVarsCache {
+	return &kappVarsCache{
+		resolved: map[string]map[string]interface{}{},
+	}
+}
+
+// Snapshots the kapps in the stack so vars that refer to other kapps under the `kapps` namespace
+// are resolved from the state the kapps were in before any were processed. Vars resolved from
+// earlier snapshots are discarded.
+func (s *Stack) SnapshotKapps() error {
+	s.kappVars.Lock()
+	defer s.kappVars.Unlock()
+
+	return s.snapshotKapps()
+}
+
+func (s *Stack) snapshotKapps() error {
+	installables := allInstallables(s.GetConfig())
+	snapshots := make([]interfaces.IInstallable, 0, len(installables))
+
+	for _, installableObj := range installables {
+		snapshot, err := installableObj.Snapshot()
+		if err != nil {
+			return errors.Wrapf(err, "Error snapshotting kapp '%s'", installableObj.FullyQualifiedId())
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	s.kappVars.snapshots = snapshots
+	s.kappVars.resolved = map[string]map[string]interface{}{}
+	return nil
+}
+
+// Returns the `kapps` namespace for an installable containing the templated vars of the kapps
+// its vars refer to, and so on for the kapps their vars refer to. Kapps are snapshotted the first
+// time this is called unless they already have been, and the vars of each are only resolved once
+// per snapshot and set of installer vars.
+func (s *Stack) kappsNamespace(installableObj interfaces.IInstallable,
+	installerVars map[string]interface{}) (map[string]interface{}, error) {
+
+	// only hold the lock while reading the cache so kapps can be resolved concurrently
+	snapshots, resolved, err := s.kappSnapshots(installerVars)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// look in a snapshot because the installable's descriptor may already have been templated
+	var snapshot interfaces.IInstallable
+	for _, candidate := range snapshots {
+		if candidate.FullyQualifiedId() == installableObj.FullyQualifiedId() {
+			snapshot = candidate
+			break
+		}
+	}
+
+	if snapshot == nil {
+		snapshot, err = installableObj.Snapshot()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	resolver := &kappVarsResolver{
+		stack:         s,
+		installerVars: installerVars,
+		installables:  snapshots,
+		resolved:      resolved,
+		resolving:     make([]string, 0),
+	}
+
+	referenced, err := referencedKapps(snapshot, s, snapshots)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return resolver.namespace(installableObj, referenced)
+}
+
+// Returns the snapshots of the kapps in the stack and the vars resolved from them so far with the
+// given installer vars, snapshotting the kapps if they haven't been yet. The resolved vars must
+// only be accessed while the cache is locked.
+func (s *Stack) kappSnapshots(installerVars map[string]interface{}) ([]interfaces.IInstallable,
+	map[string]interface{}, error) {
+	s.kappVars.Lock()
+	defer s.kappVars.Unlock()
+
+	if s.kappVars.snapshots == nil {
+		err := s.snapshotKapps()
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+
+	key := installerVarsKey(installerVars)
+	resolved, ok := s.kappVars.resolved[key]
+	if !ok {
+		resolved = map[string]interface{}{}
+		s.kappVars.resolved[key] = resolved
+	}
+
+	return s.kappVars.snapshots, resolved, nil
+}
+
+// Returns a key identifying a set of installer vars. Kapps' vars can refer to installer vars
+// (under the `sugarkube` namespace) so vars resolved with different installer vars can't be
+// shared. Maps are formatted with sorted keys so equal vars give the same key. Default vars
+// are left out because they're added to the installer vars from the stack config while
+// templating.
+func installerVarsKey(installerVars map[string]interface{}) string {
+	keyVars := make(map[string]interface{}, len(installerVars))
+	for key, value := range installerVars {
+		if key != "defaultVars" {
+			keyVars[key] = value
+		}
+	}
+
+	return fmt.Sprintf("%#v", keyVars)
+}
+
+// Returns the vars of a kapp from a map of resolved vars
+func (c *kappVarsCache) get(resolved map[string]interface{}, id string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	kappVars, ok := resolved[id]
+	return kappVars, ok
+}
+
+// Stores the vars of a kapp in a map of resolved vars
+func (c *kappVarsCache) put(resolved map[string]interface{}, id string, kappVars interface{}) {
+	c.Lock()
+	defer c.Unlock()
+
+	resolved[id] = kappVars
+}
+
+// Resolves the vars of other kapps so they can be referenced under the `kapps` namespace. Each
+// kapp's vars are templated with its own vars (so e.g. `.kapp.id` is the ID of the kapp that
+// declared the var), which may in turn reference other kapps.
+type kappVarsResolver struct {
+	stack         *Stack
+	installerVars map[string]interface{}
+	installables  []interfaces.IInstallable // snapshots of the kapps in the stack
+	resolved      map[string]interface{}    // templated vars keyed by fully-qualified kapp ID
+	resolving     []string                  // fully-qualified IDs of kapps being resolved, to detect cycles
+}
+
+// Returns the `kapps` namespace for an installable containing the vars of the given kapps.
+// Like outputs, kapps in the same manifest can be referred to by their short ID, and all kapps
+// by their fully-qualified ID (with '__' instead of ':'). Hyphens are replaced with underscores.
+func (r *kappVarsResolver) namespace(installableObj interfaces.IInstallable,
+	kapps []interfaces.IInstallable) (map[string]interface{}, error) {
+
+	namespace := map[string]interface{}{}
+
+	for _, kappObj := range kapps {
+		kappVars, err := r.resolve(kappObj)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		entry := map[string]interface{}{
+			constants.KappVarsVarsKey: kappVars,
+		}
+
+		namespace[kappsKey(kappObj.FullyQualifiedId())] = entry
+		if kappObj.ManifestId() == installableObj.ManifestId() {
+			namespace[kappsKey(kappObj.Id())] = entry
+		}
+	}
+
+	return namespace, nil
+}
+
+// Returns the templated vars of a kapp
+func (r *kappVarsResolver) resolve(installableObj interfaces.IInstallable) (interface{}, error) {
+	id := installableObj.FullyQualifiedId()
+
+	if kappVars, ok := r.stack.kappVars.get(r.resolved, id); ok {
+		return kappVars, nil
+	}
+
+	for i, resolvingId := range r.resolving {
+		if resolvingId == id {
+			cycle := append(append([]string{}, r.resolving[i:]...), id)
+			return nil, errors.New(fmt.Sprintf("Cycle detected between the vars of kapps: %s",
+				strings.Join(cycle, " -> ")))
+		}
+	}
+
+	r.resolving = append(r.resolving, id)
+	defer func() {
+		r.resolving = r.resolving[:len(r.resolving)-1]
+	}()
+
+	referenced, err := referencedKapps(installableObj, r.stack, r.installables)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	namespace, err := r.namespace(installableObj, referenced)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	templatedVars, err := r.stack.templateVars(installableObj, r.installerVars, namespace, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error templating vars for kapp '%s'", id)
+	}
+
+	kappVars := kappVarsFrom(templatedVars)
+	r.stack.kappVars.put(r.resolved, id, kappVars)
+
+	return kappVars, nil
+}
+
+// Returns the other kapps an installable's vars refer to under the `kapps` namespace. It's an
+// error to refer to kapps that aren't in the stack. The installable should be a snapshot because
+// once its descriptor has been templated the references will have been replaced.
+func referencedKapps(installableObj interfaces.IInstallable, stackObj interfaces.IStack,
+	candidates []interfaces.IInstallable) ([]interfaces.IInstallable, error) {
+
+	installableVars, err := installableObj.Vars(stackObj)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	keys := map[string]bool{}
+	findKappsReferences(kappVarsFrom(installableVars), keys)
+	for _, varsTemplate := range installableObj.VarsTemplates() {
+		findKappsReferences(varsTemplate, keys)
+	}
+
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	referenced := make([]interfaces.IInstallable, 0)
+
+	for _, key := range sortedKeys {
+		var match interfaces.IInstallable
+		for _, candidate := range candidates {
+			if key == kappsKey(candidate.FullyQualifiedId()) ||
+				(key == kappsKey(candidate.Id()) && candidate.ManifestId() == installableObj.ManifestId()) {
+				match = candidate
+				break
+			}
+		}
+
+		if match == nil {
+			return nil, errors.New(fmt.Sprintf("The vars of kapp '%s' refer to '.%s.%s' but there's "+
+				"no kapp with that ID in its manifest or the stack", installableObj.FullyQualifiedId(),
+				constants.KappVarsKappsKey, key))
+		}
+
+		log.Logger.Debugf("Vars of kapp '%s' refer to kapp '%s'", installableObj.FullyQualifiedId(),
+			match.FullyQualifiedId())
+		referenced = append(referenced, match)
+	}
+
+	return referenced, nil
+}
+
+// Returns the fully-qualified IDs of other kapps that each kapp's vars refer to under the
+// `kapps` namespace, keyed by the fully-qualified ID of the referring kapp
+func FindKappReferences(stackObj interfaces.IStack) (map[string][]string, error) {
+	installables := allInstallables(stackObj.GetConfig())
+	references := map[string][]string{}
+
+	for _, installableObj := range installables {
+		snapshot, err := installableObj.Snapshot()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		referenced, err := referencedKapps(snapshot, stackObj, installables)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		for _, referencedObj := range referenced {
+			if referencedObj.FullyQualifiedId() == installableObj.FullyQualifiedId() {
+				continue
+			}
+
+			references[installableObj.FullyQualifiedId()] = append(
+				references[installableObj.FullyQualifiedId()], referencedObj.FullyQualifiedId())
+		}
+	}
+
+	return references, nil
+}
+
+// Recursively finds keys under the `kapps` namespace referred to by strings in a value
+func findKappsReferences(value interface{}, keys map[string]bool) {
+	switch typed := value.(type) {
+	case string:
+		for _, match := range kappsReferenceRegex.FindAllStringSubmatch(typed, -1) {
+			if match[1] != "" {
+				keys[match[1]] = true
+			} else {
+				keys[match[2]] = true
+			}
+		}
+	case map[string]interface{}:
+		for _, v := range typed {
+			findKappsReferences(v, keys)
+		}
+	case map[interface{}]interface{}:
+		for _, v := range typed {
+			findKappsReferences(v, keys)
+		}
+	case []interface{}:
+		for _, v := range typed {
+			findKappsReferences(v, keys)
+		}
+	}
+}
+
+// Returns the value of `kapp.vars` from a map of vars
+func kappVarsFrom(allVars map[string]interface{}) interface{} {
+	var kappVars interface{}
+
+	switch kappVals := allVars[constants.KappVarsKappKey].(type) {
+	case map[string]interface{}:
+		kappVars = kappVals[constants.KappVarsVarsKey]
+	case map[interface{}]interface{}:
+		kappVars = kappVals[constants.KappVarsVarsKey]
+	}
+
+	if kappVars == nil {
+		return map[string]interface{}{}
+	}
+
+	return kappVars
+}
+
+// Returns the key a kapp is stored under in the `kapps` namespace. Hyphens and colons are
+// replaced because Go templates can't handle them in map keys.
+func kappsKey(id string) string {
+	key := strings.Replace(id, "-", "_", -1)
+	return strings.Replace(key, constants.NamespaceSeparator, constants.TemplateNamespaceSeparator, -1)
+}
+
+// Returns all installables in all manifests in a stack
+func allInstallables(stackConfig interfaces.IStackConfig) []interfaces.IInstallable {
+	installables := make([]interfaces.IInstallable, 0)
+	if stackConfig == nil {
+		return installables
+	}
+
+	for _, manifest := range stackConfig.Manifests() {
+		installables = append(installables, manifest.Installables()...)
+	}
+
+	return installables
+}
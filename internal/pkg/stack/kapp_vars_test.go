@@ -0,0 +1,369 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package stack
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/installable"
+	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
+	"github.com/sugarkube/sugarkube/internal/pkg/registry"
+	"github.com/sugarkube/sugarkube/internal/pkg/structs"
+	"github.com/sugarkube/sugarkube/internal/pkg/vars"
+	"sync"
+	"testing"
+)
+
+// Returns a stack containing kapps with the given vars, keyed by manifest ID then kapp ID
+func stackWithKappVars(t *testing.T, kappVars map[string]map[string]map[string]interface{}) *Stack {
+	manifests := make([]interfaces.IManifest, 0)
+
+	for manifestId, kapps := range kappVars {
+		installables := make([]interfaces.IInstallable, 0)
+		for kappId, kappVars := range kapps {
+			installableObj, err := installable.New(manifestId, []structs.KappDescriptorWithMaps{
+				{Id: kappId, KappConfig: structs.KappConfig{Vars: kappVars}},
+			})
+			assert.Nil(t, err)
+			installables = append(installables, installableObj)
+		}
+
+		manifests = append(manifests, &Manifest{
+			descriptor:   structs.ManifestDescriptor{Id: manifestId},
+			installables: installables,
+		})
+	}
+
+	return &Stack{
+		config: &StackConfig{
+			stackFile: structs.StackFile{Region: "eu-west-1"},
+			manifests: manifests,
+		},
+		registry: registry.New(),
+		kappVars: newKappVarsCache(),
+	}
+}
+
+// Returns the installable with the given ID from a stack
+func findInstallable(stackObj *Stack, fullyQualifiedId string) interfaces.IInstallable {
+	for _, installableObj := range allInstallables(stackObj.GetConfig()) {
+		if installableObj.FullyQualifiedId() == fullyQualifiedId {
+			return installableObj
+		}
+	}
+
+	return nil
+}
+
+func TestKappsNamespace(t *testing.T) {
+	stackObj := stackWithKappVars(t, map[string]map[string]map[string]interface{}{
+		"web": {
+			"wordpress": {
+				"hostname": "{{ .kapps.db_proxy.vars.host }}",
+				"dsn":      "{{ .kapps.data__mysql.vars.dsn }}",
+			},
+			"db-proxy": {
+				"host": "proxy-{{ .kapp.id }}.{{ .stack.region }}",
+			},
+		},
+		"data": {
+			"mysql": {
+				"dsn": "mysql://{{ .kapp.id }}",
+			},
+			// only kapps that are referred to are templated, so errors in others don't matter
+			"broken": {
+				"a": "{{ .kapp.vars.b }}",
+				"b": "{{ .kapp.vars.a }}",
+			},
+		},
+	})
+
+	templatedVars, err := stackObj.GetTemplatedVars(findInstallable(stackObj, "web:wordpress"),
+		map[string]interface{}{})
+	assert.Nil(t, err)
+
+	kappVars := templatedVars["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	// vars are templated in the context of the kapp that declares them
+	assert.Equal(t, "proxy-db-proxy.eu-west-1", kappVars["hostname"])
+	assert.Equal(t, "mysql://mysql", kappVars["dsn"])
+
+	kappsVars := templatedVars["kapps"].(map[interface{}]interface{})
+	// short keys are only added for kapps in the same manifest
+	assert.Contains(t, kappsVars, "db_proxy")
+	assert.Contains(t, kappsVars, "web__db_proxy")
+	assert.Contains(t, kappsVars, "data__mysql")
+	assert.NotContains(t, kappsVars, "mysql")
+	assert.NotContains(t, kappsVars, "data__broken")
+
+	// vars are only resolved once per snapshot and set of installer vars
+	assert.Len(t, stackObj.kappVars.resolved, 1)
+	assert.Len(t, stackObj.kappVars.resolved[installerVarsKey(map[string]interface{}{})], 2)
+
+	references, err := FindKappReferences(stackObj)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"web:wordpress": {"data:mysql", "web:db-proxy"},
+	}, references)
+}
+
+func TestKappsNamespaceIndex(t *testing.T) {
+	stackObj := stackWithKappVars(t, map[string]map[string]map[string]interface{}{
+		"web": {
+			"wordpress": {
+				"hostname": `{{ index .kapps "db_proxy" "vars" "host" }}`,
+				"dsn":      "{{ (index $.kapps `data__mysql`).vars.dsn }}",
+			},
+			"db-proxy": {
+				"host": "proxy-{{ .kapp.id }}",
+			},
+		},
+		"data": {
+			"mysql": {
+				"dsn": "mysql://{{ .kapp.id }}",
+			},
+		},
+	})
+
+	templatedVars, err := stackObj.GetTemplatedVars(findInstallable(stackObj, "web:wordpress"),
+		map[string]interface{}{})
+	assert.Nil(t, err)
+
+	kappVars := templatedVars["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	assert.Equal(t, "proxy-db-proxy", kappVars["hostname"])
+	assert.Equal(t, "mysql://mysql", kappVars["dsn"])
+
+	references, err := FindKappReferences(stackObj)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string][]string{
+		"web:wordpress": {"data:mysql", "web:db-proxy"},
+	}, references)
+
+	stackObj = stackWithKappVars(t, map[string]map[string]map[string]interface{}{
+		"web": {
+			"a": {"x": `{{ index .kapps "b" "vars" "y" }}`},
+			"b": {"y": "{{ .kapps.a.vars.x }}"},
+		},
+	})
+
+	_, err = stackObj.GetTemplatedVars(findInstallable(stackObj, "web:a"), map[string]interface{}{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Cycle detected between the vars of kapps")
+}
+
+func TestKappsNamespaceInstallerVars(t *testing.T) {
+	stackObj := stackWithKappVars(t, map[string]map[string]map[string]interface{}{
+		"web": {
+			"a": {"x": "{{ .kapps.b.vars.y }}"},
+			"b": {"y": "{{ .sugarkube.action }}"},
+		},
+	})
+
+	for _, action := range []string{"install", "delete", "install"} {
+		templatedVars, err := stackObj.GetTemplatedVars(findInstallable(stackObj, "web:a"),
+			map[string]interface{}{"action": action})
+		assert.Nil(t, err)
+
+		kappVars := templatedVars["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+		// vars of other kapps are templated with the installer vars they're referenced with
+		assert.Equal(t, action, kappVars["x"])
+	}
+
+	assert.Len(t, stackObj.kappVars.resolved, 2)
+}
+
+func TestKappsNamespaceConcurrently(t *testing.T) {
+	stackObj := stackWithKappVars(t, map[string]map[string]map[string]interface{}{
+		"web": {
+			"a": {"x": "{{ .kapps.c.vars.z }}-a"},
+			"b": {"y": "{{ .kapps.c.vars.z }}-b"},
+			"c": {"z": "{{ .kapp.id }}"},
+		},
+	})
+
+	expected := map[string]string{"web:a": "c-a", "web:b": "c-b"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for id, expectedValue := range expected {
+			wg.Add(1)
+			go func(id string, expectedValue string) {
+				defer wg.Done()
+
+				templatedVars, err := stackObj.GetTemplatedVars(findInstallable(stackObj, id),
+					map[string]interface{}{})
+				assert.Nil(t, err)
+
+				kappVars := templatedVars["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+				for _, value := range kappVars {
+					assert.Equal(t, expectedValue, value)
+				}
+			}(id, expectedValue)
+		}
+	}
+	wg.Wait()
+
+	assert.Len(t, stackObj.kappVars.resolved, 1)
+}
+
+func TestKappsNamespaceErrors(t *testing.T) {
+	stackObj := stackWithKappVars(t, map[string]map[string]map[string]interface{}{
+		"web": {
+			"a": {"x": "{{ .kapps.b.vars.y }}"},
+			"b": {"y": "{{ .kapps.c.vars.z }}"},
+			"c": {"z": "{{ .kapps.a.vars.x }}"},
+		},
+	})
+
+	_, err := stackObj.GetTemplatedVars(findInstallable(stackObj, "web:a"), map[string]interface{}{})
+	assert.NotNil(t, err)
+	// the cycle is reported from whichever kapp was resolved first
+	assert.Regexp(t, `Cycle detected between the vars of kapps: (web:a -> web:b -> web:c -> web:a|`+
+		`web:b -> web:c -> web:a -> web:b|web:c -> web:a -> web:b -> web:c)`, err.Error())
+
+	stackObj = stackWithKappVars(t, map[string]map[string]map[string]interface{}{
+		"web": {
+			"a": {"x": "1"},
+		},
+		"data": {
+			// short keys only work within a manifest
+			"b": {"y": "{{ .kapps.a.vars.x }}"},
+		},
+	})
+
+	_, err = stackObj.GetTemplatedVars(findInstallable(stackObj, "data:b"), map[string]interface{}{})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "The vars of kapp 'data:b' refer to '.kapps.a'")
+}
+
+func TestVarsTemplates(t *testing.T) {
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{
+			// e.g. from a sugarkube.yaml file
+			Id: "ingress",
+			KappConfig: structs.KappConfig{
+				Vars: map[string]interface{}{
+					"clusters": []interface{}{"dev1", "dev2"},
+					"domain":   "example.com",
+					"tls":      "{{ index .kapp.vars.hosts 0 }}",
+				},
+				VarsTemplate: `hosts:
+{{- range .kapp.vars.clusters }}
+- {{ . }}.{{ $.kapp.vars.domain }}
+{{- end }}
+replicas: 1`,
+			},
+		},
+		{
+			// e.g. a stack override. Its vars take precedence over the earlier layer's template
+			KappConfig: structs.KappConfig{
+				Vars:         map[string]interface{}{"replicas": 2},
+				VarsTemplate: `region: {{ .stack.region }}`,
+			},
+		},
+	})
+	assert.Nil(t, err)
+	assert.Len(t, installableObj.VarsTemplates(), 2)
+
+	stackObj := &Stack{
+		config: &StackConfig{
+			stackFile: structs.StackFile{Region: "eu-west-1"},
+			manifests: []interfaces.IManifest{&Manifest{
+				descriptor:   structs.ManifestDescriptor{Id: "web"},
+				installables: []interfaces.IInstallable{installableObj},
+			}},
+		},
+		registry: registry.New(),
+		kappVars: newKappVarsCache(),
+	}
+
+	templatedVars, err := stackObj.GetTemplatedVars(installableObj, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	kappVars := templatedVars["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	assert.Equal(t, []interface{}{"dev1.example.com", "dev2.example.com"}, kappVars["hosts"])
+	assert.Equal(t, "dev1.example.com", kappVars["tls"])
+	assert.Equal(t, 2, kappVars["replicas"])
+	assert.Equal(t, "eu-west-1", kappVars["region"])
+
+	// vars templates aren't rendered into the descriptor
+	err = installableObj.TemplateDescriptor(templatedVars)
+	assert.Nil(t, err)
+	assert.Equal(t, "region: {{ .stack.region }}", installableObj.GetDescriptor().VarsTemplate)
+}
+
+func TestExplainVars(t *testing.T) {
+	installableObj, err := installable.New("web", []structs.KappDescriptorWithMaps{
+		{
+			Id:         "wordpress",
+			KappConfig: structs.KappConfig{Vars: map[string]interface{}{"replicas": 1, "port": 80}},
+			DeclaredIn: "kapp 'wordpress' in manifest 'web.yaml'",
+		},
+	})
+	assert.Nil(t, err)
+
+	err = installableObj.AddDescriptor(structs.KappDescriptorWithMaps{
+		KappConfig: structs.KappConfig{Vars: map[string]interface{}{
+			"replicas": 3,
+			"url":      "{{ .stack.region }}:{{ .kapp.vars.port }}",
+		}},
+		DeclaredIn: "the overrides for kapp 'wordpress' in the stack",
+	}, false)
+	assert.Nil(t, err)
+
+	localRegistry := registry.New()
+	err = localRegistry.Set("outputs.web:db.host", "db.local")
+	assert.Nil(t, err)
+	installableObj.SetLocalRegistry(localRegistry)
+
+	stackConfig := &StackConfig{
+		stackFile: structs.StackFile{Name: "dev", Region: "eu-west-1"},
+		manifests: []interfaces.IManifest{&Manifest{
+			descriptor:   structs.ManifestDescriptor{Id: "web"},
+			installables: []interfaces.IInstallable{installableObj},
+		}},
+	}
+	providerVars := map[string]interface{}{"domain": "example.com"}
+	stackConfig.SetProviderVars(providerVars)
+
+	stackObj := &Stack{
+		config:                 stackConfig,
+		registry:               registry.New(),
+		providerVarsProvenance: vars.ProvenanceOf("providers/values.yaml", providerVars),
+		kappVars:               newKappVarsCache(),
+	}
+
+	templatedVars, provenance, err := stackObj.ExplainVars(installableObj, map[string]interface{}{})
+	assert.Nil(t, err)
+
+	assert.Equal(t, `kapp.vars.port: 80
+  set by kapp 'wordpress' in manifest 'web.yaml': 80
+kapp.vars.replicas: 3
+  set by the overrides for kapp 'wordpress' in the stack: 3
+  overrides kapp 'wordpress' in manifest 'web.yaml': 1
+kapp.vars.url: 'eu-west-1:80'
+  set by the overrides for kapp 'wordpress' in the stack: '{{ .stack.region }}:{{ .kapp.vars.port }}'
+`, provenance.Explain("kapp.vars", templatedVars))
+
+	origins := provenance.Origins("stack.region")
+	assert.Equal(t, "the config of stack 'dev'", origins[len(origins)-1].Source)
+
+	// provider vars are attributed to the files they were loaded from
+	assert.Equal(t, []vars.Origin{{Source: "providers/values.yaml", Value: "example.com"}},
+		provenance.Origins("domain"))
+
+	assert.Equal(t, []vars.Origin{{Source: "the outputs of the parents of kapp 'web:wordpress'",
+		Value: "db.local"}}, provenance.Origins("outputs.web:db.host"))
+}
@@ -17,9 +17,12 @@
 package stack
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/convert"
 	"github.com/sugarkube/sugarkube/internal/pkg/installable"
@@ -27,6 +30,8 @@ import (
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"github.com/sugarkube/sugarkube/internal/pkg/utils"
+	"os"
+	"path"
 	"path/filepath"
 	"strings"
 )
@@ -35,6 +40,9 @@ type Manifest struct {
 	descriptor   structs.ManifestDescriptor
 	manifestFile structs.ManifestFile
 	installables []interfaces.IInstallable
+	sourceUri    string // the URI of the source remote manifests were acquired from
+	revision     string // the revision remote manifests were acquired at
+	cacheDir     string // the directory remote manifests were acquired into
 }
 
 // Sets fields to default values
@@ -52,6 +60,27 @@ func (m *Manifest) Installables() []interfaces.IInstallable {
 	return m.installables
 }
 
+// Returns the URI of the source a remote manifest was acquired from, or the path to a local manifest
+func (m Manifest) Uri() string {
+	if m.sourceUri != "" {
+		return m.sourceUri
+	}
+
+	return m.descriptor.Uri
+}
+
+// Returns the revision a remote manifest was acquired at. This is empty for local manifests or
+// if the acquirer can't report revisions.
+func (m Manifest) Revision() string {
+	return m.revision
+}
+
+// Returns the directory the source of a remote manifest was acquired into. This is empty for
+// local manifests.
+func (m Manifest) CacheDir() string {
+	return m.cacheDir
+}
+
 // Return whether the manifest is sequential, i.e. whether each kapp in the manifest depends on the previous one
 func (m Manifest) IsSequential() bool {
 	return m.manifestFile.Options.IsSequential
@@ -66,6 +95,7 @@ func instantiateInstallables(manifestId string, manifest Manifest) ([]interfaces
 
 	manfestDefaults := structs.KappDescriptorWithMaps{
 		KappConfig: manifestFile.Defaults,
+		DeclaredIn: fmt.Sprintf("the defaults in manifest '%s'", manifest.Uri()),
 	}
 
 	for i, kappDescriptor := range manifestFile.KappDescriptor {
@@ -74,6 +104,8 @@ func instantiateInstallables(manifestId string, manifest Manifest) ([]interfaces
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		kappDescriptorWithMap.DeclaredIn = fmt.Sprintf("kapp '%s' in manifest '%s'",
+			kappDescriptor.Id, manifest.Uri())
 
 		// need to merge structs for kapp descriptors (in order of lowest to highest precedence):
 		//   * values from the sugarkube-conf.yaml file (if any are specified for
@@ -103,16 +135,18 @@ func instantiateInstallables(manifestId string, manifest Manifest) ([]interfaces
 				continue
 			}
 
+			// use the right option for the type of acquirer the source will use
+			sourceUri := installableObj.GetDescriptor().Sources[splitKey[1]].Uri
+
 			descriptor := structs.KappDescriptorWithMaps{
 				Sources: map[string]structs.Source{
 					splitKey[1]: {
 						Options: map[string]interface{}{
-							// todo - use the right key depending on the acquirer (this
-							//  currently assumes it's always a git acquirer)
-							acquirer.BranchKey: version,
+							acquirer.VersionOptionKey(sourceUri): version,
 						},
 					},
 				},
+				DeclaredIn: fmt.Sprintf("the versions in manifest '%s'", manifest.Uri()),
 			}
 
 			err = installableObj.AddDescriptor(descriptor, false)
@@ -125,6 +159,8 @@ func instantiateInstallables(manifestId string, manifest Manifest) ([]interfaces
 		// the descriptor to the list
 		stackOverrides, ok := manifest.descriptor.Overrides[installableObj.Id()]
 		if ok {
+			stackOverrides.DeclaredIn = fmt.Sprintf("the overrides for kapp '%s' in the stack",
+				installableObj.Id())
 			err = installableObj.AddDescriptor(stackOverrides, false)
 			if err != nil {
 				return nil, errors.WithStack(err)
@@ -324,7 +360,15 @@ func acquireManifests(stackObj structs.StackFile) ([]interfaces.IManifest, error
 	manifests := make([]interfaces.IManifest, len(stackObj.ManifestDescriptors))
 
 	for i, manifestDescriptor := range stackObj.ManifestDescriptors {
-		manifest, err := acquireManifest(filepath.Dir(stackObj.FilePath), manifestDescriptor)
+		var manifest interfaces.IManifest
+		var err error
+
+		if isRemoteManifest(manifestDescriptor.Uri) {
+			manifest, err = acquireRemoteManifest(manifestDescriptor, stackObj.UpdateManifests,
+				stackObj.ManifestRevisions)
+		} else {
+			manifest, err = acquireManifest(filepath.Dir(stackObj.FilePath), manifestDescriptor)
+		}
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -335,8 +379,7 @@ func acquireManifests(stackObj structs.StackFile) ([]interfaces.IManifest, error
 	return manifests, nil
 }
 
-// Acquires a manifest.
-// todo - refactor to use an acquirer
+// Acquires a manifest from a local path
 func acquireManifest(stackConfigFileDir string, manifestDescriptor structs.ManifestDescriptor) (interfaces.IManifest, error) {
 
 	// The file acquirer needs to convert relative paths to absolute.
@@ -346,7 +389,6 @@ func acquireManifest(stackConfigFileDir string, manifestDescriptor structs.Manif
 		log.Logger.Debugf("Fiddling manifest URI to '%s' (joined with stack file dir '%s')", uri, stackConfigFileDir)
 	}
 
-	// todo - get rid of this once we've switched to an acquirer and can pull the path from a cache manager
 	manifestDescriptor.Uri = uri
 
 	manifestFilePath := uri
@@ -359,3 +401,166 @@ func acquireManifest(stackConfigFileDir string, manifestDescriptor structs.Manif
 
 	return manifest, nil
 }
+
+// Remote manifests are referenced by source URIs containing a path to the manifest file, e.g.
+// `git@github.com:org/repo.git//manifests/web.yaml#master`. Plain file paths are local manifests.
+func isRemoteManifest(uri string) bool {
+	return strings.Contains(uri, acquirer.PathSeparator)
+}
+
+// Splits the URI of a remote manifest into the URI of the source for the directory containing
+// the manifest file and the name of the manifest file
+func splitManifestUri(uri string) (string, string, error) {
+	separatorIndex := strings.LastIndex(uri, acquirer.PathSeparator) + len(acquirer.PathSeparator)
+	pathAndSuffix := uri[separatorIndex:]
+
+	// the path may be followed by a branch or query string
+	pathEnd := strings.IndexAny(pathAndSuffix, acquirer.BranchSeparator+"?")
+	if pathEnd < 0 {
+		pathEnd = len(pathAndSuffix)
+	}
+
+	manifestPath := pathAndSuffix[:pathEnd]
+
+	extension := path.Ext(manifestPath)
+	if extension != ".yaml" && extension != ".yml" {
+		return "", "", errors.New(fmt.Sprintf("The path in remote manifest URI '%s' must be "+
+			"to a YAML file", uri))
+	}
+
+	manifestDir := path.Dir(strings.Trim(manifestPath, "/"))
+	if manifestDir == "." {
+		return "", "", errors.New(fmt.Sprintf("Remote manifests must be in a subdirectory "+
+			"of their source but '%s' isn't", uri))
+	}
+
+	sourceUri := uri[:separatorIndex] + manifestDir + pathAndSuffix[pathEnd:]
+
+	return sourceUri, path.Base(manifestPath), nil
+}
+
+// Acquires a remote manifest into the manifest cache. Manifests that have already been cached
+// are only updated if requested or if they need to be moved to a different locked revision.
+func acquireRemoteManifest(manifestDescriptor structs.ManifestDescriptor, update bool,
+	revisions map[string]string) (interfaces.IManifest, error) {
+
+	sourceUri, manifestFileName, err := splitManifestUri(manifestDescriptor.Uri)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	source := structs.Source{
+		Uri:     sourceUri,
+		Options: map[string]interface{}{},
+	}
+
+	for key, value := range manifestDescriptor.Options {
+		source.Options[key] = value
+	}
+
+	acquirerObj, err := acquirer.New(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// pin the manifest if it's been locked. The URI may have been normalised by the acquirer.
+	revision, locked := revisions[acquirerObj.Uri()]
+	if locked {
+		log.Logger.Debugf("Pinning manifest '%s' to locked revision '%s'",
+			manifestDescriptor.Uri, revision)
+		source.Options[acquirer.RevisionKey] = revision
+
+		acquirerObj, err = acquirer.New(source)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	dest, err := manifestCacheDir(acquirerObj, locked)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	lockable, isLockable := acquirerObj.(acquirer.Lockable)
+
+	acquire := update
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		acquire = true
+	} else if locked && isLockable {
+		currentRevision, err := lockable.Revision(dest)
+		acquire = acquire || err != nil || currentRevision != revision
+	}
+
+	if acquire {
+		log.Logger.Infof("Acquiring manifest '%s' into '%s'", manifestDescriptor.Uri, dest)
+
+		err = acquirer.Acquire(acquirerObj, dest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error acquiring manifest '%s'", manifestDescriptor.Uri)
+		}
+	} else {
+		log.Logger.Debugf("Using cached manifest '%s' from '%s'", manifestDescriptor.Uri, dest)
+	}
+
+	// default the ID to the name of the manifest file without its extension
+	if manifestDescriptor.Id == "" {
+		manifestDescriptor.Id = strings.TrimSuffix(manifestFileName, path.Ext(manifestFileName))
+	}
+
+	manifestObj, err := ParseManifestFile(filepath.Join(dest, acquirerObj.Path(), manifestFileName),
+		manifestDescriptor)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	manifest := manifestObj.(*Manifest)
+	manifest.sourceUri = acquirerObj.Uri()
+	manifest.cacheDir = dest
+
+	if isLockable {
+		manifest.revision, err = lockable.Revision(dest)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return manifest, nil
+}
+
+// Returns the directory a remote manifest's source is cached in. Sources are cached per URI so
+// different branches of the same repo don't clash. Locked sources are cached separately because
+// they're checked out at detached revisions instead of at the head of their branches.
+func manifestCacheDir(acquirerObj acquirer.Acquirer, locked bool) (string, error) {
+	cacheDir, err := ManifestCacheRoot()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	fullyQualifiedId, err := acquirerObj.FullyQualifiedId()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	uriHash := sha256.Sum256([]byte(acquirerObj.Uri()))
+	dirName := fmt.Sprintf("%s-%s", fullyQualifiedId, hex.EncodeToString(uriHash[:])[:8])
+
+	if locked {
+		dirName += "-locked"
+	}
+
+	return filepath.Join(cacheDir, dirName), nil
+}
+
+// Returns the directory remote manifests are cached under
+func ManifestCacheRoot() (string, error) {
+	if config.CurrentConfig != nil && config.CurrentConfig.ManifestCacheDir != "" {
+		return config.CurrentConfig.ManifestCacheDir, nil
+	}
+
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return filepath.Join(userCacheDir, "sugarkube", "manifests"), nil
+}
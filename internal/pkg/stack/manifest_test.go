@@ -17,15 +17,23 @@
 package stack
 
 import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/acquirer"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/installable"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
 	"github.com/sugarkube/sugarkube/internal/pkg/structs"
 	"gopkg.in/yaml.v2"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func init() {
@@ -362,6 +370,26 @@ func TestManifestOverrides(t *testing.T) {
 		acquirers["pathA"].Uri())
 }
 
+// Test that versions in stacks pin sources using the right option for each acquirer
+func TestManifestVersions(t *testing.T) {
+
+	stackConfig, err := BuildStack("pinned-charts", "../../testdata/stack-pinned.yaml", &structs.StackFile{}, os.Stdout)
+	assert.Nil(t, err)
+	assert.NotNil(t, stackConfig)
+
+	installableObj := stackConfig.GetConfig().Manifests()[0].Installables()[0]
+	assert.Equal(t, "kappC", installableObj.Id())
+
+	acquirers, err := installableObj.Acquirers()
+	assert.Nil(t, err)
+
+	// all pinned sources keep their URIs, not just the first
+	assert.Equal(t, "git@github.com:sugarkube/kapps-C.git//some/pathC#1.2.3",
+		acquirers["pathC"].Uri())
+	assert.Equal(t, "helm://charts.example.com/nginx-ingress?version=~1.2",
+		acquirers["nginx-ingress"].Uri())
+}
+
 // Test that kapps with no overrides are correctly instantiated
 func TestManifestOverridesNil(t *testing.T) {
 
@@ -402,3 +430,125 @@ func TestManifestOverridesNil(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expectedDescriptor, actualDescriptor)
 }
+
+func TestSplitManifestUri(t *testing.T) {
+	tests := []struct {
+		name           string
+		uri            string
+		expectedSource string
+		expectedFile   string
+		expectedError  bool
+	}{
+		{
+			name:           "git",
+			uri:            "git@github.com:sugarkube/kapps.git//manifests/web.yaml#master",
+			expectedSource: "git@github.com:sugarkube/kapps.git//manifests#master",
+			expectedFile:   "web.yaml",
+		},
+		{
+			name:           "archive",
+			uri:            "https://example.com/manifests.tar.gz//stacks/web/manifest.yml",
+			expectedSource: "https://example.com/manifests.tar.gz//stacks/web",
+			expectedFile:   "manifest.yml",
+		},
+		{
+			name:          "not_yaml",
+			uri:           "git@github.com:sugarkube/kapps.git//manifests#master",
+			expectedError: true,
+		},
+		{
+			name:          "root",
+			uri:           "git@github.com:sugarkube/kapps.git//web.yaml#master",
+			expectedError: true,
+		},
+	}
+
+	for _, test := range tests {
+		source, file, err := splitManifestUri(test.uri)
+		if test.expectedError {
+			assert.NotNil(t, err, "Expected an error in test '%s'", test.name)
+			continue
+		}
+
+		assert.Nil(t, err, "Unexpected error in test '%s'", test.name)
+		assert.Equal(t, test.expectedSource, source, "Unexpected source in test '%s'", test.name)
+		assert.Equal(t, test.expectedFile, file, "Unexpected file in test '%s'", test.name)
+	}
+
+	assert.True(t, isRemoteManifest("git@github.com:sugarkube/kapps.git//manifests/web.yaml#master"))
+	assert.False(t, isRemoteManifest("manifests/manifest1.yaml"))
+}
+
+// Creates a git repo containing a manifest and returns a function to commit new versions of it
+func newManifestRepo(t *testing.T, repoDir string) func(manifest string) plumbing.Hash {
+	repo, err := git.PlainInit(repoDir, false)
+	assert.Nil(t, err)
+	worktree, err := repo.Worktree()
+	assert.Nil(t, err)
+	assert.Nil(t, os.MkdirAll(filepath.Join(repoDir, "manifests"), 0755))
+
+	return func(manifest string) plumbing.Hash {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(repoDir, "manifests/web.yaml"),
+			[]byte(manifest), 0644))
+		_, err := worktree.Add("manifests/web.yaml")
+		assert.Nil(t, err)
+
+		hash, err := worktree.Commit("update manifest", &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		assert.Nil(t, err)
+
+		return hash
+	}
+}
+
+func TestAcquireRemoteManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "remote-manifest-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+	config.CurrentConfig = &config.Config{
+		ManifestCacheDir: filepath.Join(tmpDir, "cache"),
+	}
+
+	commit := newManifestRepo(t, filepath.Join(tmpDir, "repo"))
+	firstHash := commit("kapps:\n- id: wordpress\n")
+	secondHash := commit("kapps:\n- id: wordpress\n- id: mysql\n")
+
+	descriptor := structs.ManifestDescriptor{
+		Uri: "file://" + filepath.Join(tmpDir, "repo", ".git") + "//manifests/web.yaml#master",
+		Options: map[string]interface{}{
+			acquirer.AcquirerKey: acquirer.GoGitAcquirerName,
+		},
+	}
+
+	manifest, err := acquireRemoteManifest(descriptor, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "web", manifest.Id())
+	assert.Equal(t, secondHash.String(), manifest.Revision())
+	assert.Len(t, manifest.Installables(), 2)
+
+	revisions := map[string]string{manifest.Uri(): firstHash.String()}
+
+	// locked manifests are moved to the locked revision
+	manifest, err = acquireRemoteManifest(descriptor, false, revisions)
+	assert.Nil(t, err)
+	assert.Equal(t, firstHash.String(), manifest.Revision())
+	assert.Len(t, manifest.Installables(), 1)
+
+	// cached manifests aren't updated unless requested
+	thirdHash := commit("kapps:\n- id: wordpress\n- id: mysql\n- id: redis\n")
+
+	manifest, err = acquireRemoteManifest(descriptor, false, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, secondHash.String(), manifest.Revision())
+
+	manifest, err = acquireRemoteManifest(descriptor, true, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, thirdHash.String(), manifest.Revision())
+	assert.Len(t, manifest.Installables(), 3)
+}
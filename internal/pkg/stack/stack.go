@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/clustersot"
+	"github.com/sugarkube/sugarkube/internal/pkg/constants"
 	"github.com/sugarkube/sugarkube/internal/pkg/convert"
 	"github.com/sugarkube/sugarkube/internal/pkg/interfaces"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
@@ -40,6 +41,10 @@ type Stack struct {
 	provisioner interfaces.IProvisioner
 	status      *ClusterStatus
 	registry    interfaces.IRegistry
+	// which file set each provider var
+	providerVarsProvenance *vars.Provenance
+	// snapshots of kapps and their vars for kapps that refer to them under the `kapps` namespace
+	kappVars *kappVarsCache
 }
 
 // Creates a new Stack
@@ -56,6 +61,7 @@ func newStack(config interfaces.IStackConfig, provider interfaces.IProvider, reg
 			startedThisRun:        false,
 		},
 		registry: registry,
+		kappVars: newKappVarsCache(),
 	}
 
 	err := stack.RefreshProviderVars()
@@ -103,18 +109,61 @@ func (s Stack) GetRegistry() interfaces.IRegistry {
 // otherwise only stack-specific variables will be returned.
 func (s *Stack) GetTemplatedVars(installableObj interfaces.IInstallable,
 	installerVars map[string]interface{}) (map[string]interface{}, error) {
+	return s.getTemplatedVars(installableObj, installerVars, nil)
+}
+
+// Like GetTemplatedVars but also returns where each value was set, before it was templated
+func (s *Stack) ExplainVars(installableObj interfaces.IInstallable,
+	installerVars map[string]interface{}) (map[string]interface{}, *vars.Provenance, error) {
+
+	provenance := vars.NewProvenance()
+
+	templatedVars, err := s.getTemplatedVars(installableObj, installerVars, provenance)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	return templatedVars, provenance, nil
+}
+
+func (s *Stack) getTemplatedVars(installableObj interfaces.IInstallable,
+	installerVars map[string]interface{}, provenance *vars.Provenance) (map[string]interface{}, error) {
+
+	kappsVars := map[string]interface{}{}
+
+	if installableObj != nil {
+		// make the vars of the kapps the installable refers to available under the `kapps` namespace
+		var err error
+		kappsVars, err = s.kappsNamespace(installableObj, installerVars)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	return s.templateVars(installableObj, installerVars, kappsVars, provenance)
+}
+
+// Merges and templates vars for an installable (if given) with the given vars of other kapps
+// stored under the `kapps` namespace. Where each value was set is recorded in the provenance
+// if one's given.
+func (s *Stack) templateVars(installableObj interfaces.IInstallable, installerVars map[string]interface{},
+	kappsVars map[string]interface{}, provenance *vars.Provenance) (map[string]interface{}, error) {
 
 	stackConfig := s.config
 
 	// build an array of config fragments that should all be merged together,
-	// with later values overriding earlier ones.
+	// with later values overriding earlier ones, and where the values in each came from
 	configFragments := make([]map[string]interface{}, 0)
+	fragmentProvenances := make([]*vars.Provenance, 0)
 
 	stackIntrinsicData := stackConfig.GetIntrinsicData()
 	// convert the map to the appropriate type and namespace it
 	configFragments = append(configFragments, map[string]interface{}{
 		"stack": convert.MapStringStringToMapStringInterface(stackIntrinsicData),
 	})
+	fragmentProvenances = append(fragmentProvenances, provenanceOf(provenance,
+		fmt.Sprintf("the config of stack '%s'", stackConfig.GetName()),
+		configFragments[len(configFragments)-1]))
 
 	// store additional runtime values under the "sugarkube" key
 	installerVars["defaultVars"] = []string{
@@ -129,29 +178,87 @@ func (s *Stack) GetTemplatedVars(installableObj interfaces.IInstallable,
 	configFragments = append(configFragments, map[string]interface{}{
 		"sugarkube": installerVars,
 	})
+	fragmentProvenances = append(fragmentProvenances, provenanceOf(provenance, "the installer",
+		configFragments[len(configFragments)-1]))
 
 	configFragments = append(configFragments, stackConfig.GetProviderVars())
+	if s.providerVarsProvenance != nil {
+		fragmentProvenances = append(fragmentProvenances, s.providerVarsProvenance)
+	} else {
+		fragmentProvenances = append(fragmentProvenances, provenanceOf(provenance,
+			"the provider vars", configFragments[len(configFragments)-1]))
+	}
 
 	// merge in values from the registry
 	log.Logger.Tracef("Merging stack vars with global registry: %v", s.registry)
 	configFragments = append(configFragments, s.registry.AsMap())
+	fragmentProvenances = append(fragmentProvenances, provenanceOf(provenance, "the registry",
+		configFragments[len(configFragments)-1]))
+
+	if len(kappsVars) > 0 {
+		configFragments = append(configFragments, map[string]interface{}{
+			constants.KappVarsKappsKey: kappsVars,
+		})
+
+		kappsProvenance := newProvenance(provenance)
+		for key, kappVars := range kappsVars {
+			kappsProvenance.Under(constants.KappVarsKappsKey).Record(
+				fmt.Sprintf("the templated vars of kapp '%s'", key),
+				map[string]interface{}{key: kappVars})
+		}
+		fragmentProvenances = append(fragmentProvenances, kappsProvenance)
+	}
+
+	var installableVars map[string]interface{}
+	var err error
+
+	installableProvenance := newProvenance(provenance)
+	hasVarsTemplates := installableObj != nil && len(installableObj.VarsTemplates()) > 0
 
 	if installableObj != nil {
-		installableVars, err := installableObj.Vars(s)
+		installableVars, err = installableObj.VarsWithProvenance(s, nil, installableProvenance)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+	}
 
-		configFragments = append(configFragments, installableVars)
+	// values are only recorded when they're merged for the last time
+	mergeProvenance := provenance
+	if hasVarsTemplates {
+		mergeProvenance = nil
 	}
 
-	mergedVars := map[string]interface{}{}
-	err := vars.MergeFragments(&mergedVars, configFragments...)
+	mergedVars, err := mergeVars(configFragments, fragmentProvenances, installableVars,
+		installableProvenance, mergeProvenance)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	templatedVars, err := templater.IterativelyTemplate(mergedVars)
+	// vars templates are rendered with the merged vars before they're templated (so other vars can
+	// use the values they generate). Any templates in generated values are rendered below.
+	if hasVarsTemplates {
+		installableProvenance = newProvenance(provenance)
+
+		installableVars, err = installableObj.VarsWithProvenance(s, mergedVars, installableProvenance)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		mergedVars, err = mergeVars(configFragments, fragmentProvenances, installableVars,
+			installableProvenance, provenance)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	name := "the stack vars"
+	strict := templater.IsStrict(nil)
+	if installableObj != nil {
+		name = fmt.Sprintf("the vars of kapp '%s'", installableObj.FullyQualifiedId())
+		strict = installableObj.StrictTemplates()
+	}
+
+	templatedVars, err := templater.ResolveVars(name, mergedVars, strict)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -165,9 +272,55 @@ func (s *Stack) GetTemplatedVars(installableObj interfaces.IInstallable,
 	return templatedVars, nil
 }
 
+// Merges config fragments with an installable's vars (if any), recording where each value was
+// set in the provenance if one's given
+func mergeVars(configFragments []map[string]interface{}, fragmentProvenances []*vars.Provenance,
+	installableVars map[string]interface{}, installableProvenance *vars.Provenance,
+	provenance *vars.Provenance) (map[string]interface{}, error) {
+
+	fragments := configFragments
+	if installableVars != nil {
+		fragments = append(append([]map[string]interface{}{}, configFragments...), installableVars)
+		fragmentProvenances = append(append([]*vars.Provenance{}, fragmentProvenances...),
+			installableProvenance)
+	}
+
+	mergedVars := map[string]interface{}{}
+	err := vars.MergeFragmentsWithProvenance(&mergedVars, provenance, fragmentProvenances, fragments...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return mergedVars, nil
+}
+
+// Returns a new provenance if values are being recorded in the given one
+func newProvenance(provenance *vars.Provenance) *vars.Provenance {
+	if provenance == nil {
+		return nil
+	}
+
+	return vars.NewProvenance()
+}
+
+// Returns a provenance recording that a source set a fragment if values are being recorded in
+// the given one
+func provenanceOf(provenance *vars.Provenance, source string,
+	fragment map[string]interface{}) *vars.Provenance {
+	if provenance == nil {
+		return nil
+	}
+
+	return vars.ProvenanceOf(source, fragment)
+}
+
 // Reload provider vars
 func (s *Stack) RefreshProviderVars() error {
-	providerVars, err := provider.GetVarsFromFiles(s.GetProvider(), s.GetConfig())
+	// record which file set each provider var so explaining vars doesn't need to reload them
+	providerVarsProvenance := vars.NewProvenance()
+
+	providerVars, err := provider.GetVarsFromFilesWithProvenance(s.GetProvider(), s.GetConfig(),
+		providerVarsProvenance)
 	if err != nil {
 		log.Logger.Warn("Error loading provider variables")
 		return errors.WithStack(err)
@@ -181,6 +334,7 @@ func (s *Stack) RefreshProviderVars() error {
 	}
 
 	s.GetConfig().SetProviderVars(providerVars)
+	s.providerVarsProvenance = providerVarsProvenance
 	return nil
 }
 
@@ -213,6 +367,12 @@ func (s *Stack) LoadInstallables(cacheDir string) error {
 			if err != nil {
 				return errors.WithStack(err)
 			}
+
+			// delete sensitive templates left behind if a previous run didn't exit cleanly
+			err = installableObj.DeleteSensitiveTemplates()
+			if err != nil {
+				return errors.WithStack(err)
+			}
 		}
 	}
 
@@ -96,6 +96,7 @@ func TestTemplatedVarsWithRegistry(t *testing.T) {
 			startedThisRun:        false,
 		},
 		registry: registryObj,
+		kappVars: newKappVarsCache(),
 	}
 
 	templatedVars, err := stackObj.GetTemplatedVars(nil, map[string]interface{}{})
@@ -28,15 +28,30 @@ type Template struct {
 
 // Outputs generated by a kapp that should be parsed and added to the registry
 type Output struct {
-	Id   string
-	Path string
-	// todo - implement - this allows multiple kapps to set the same key. Don't allow kapps to overwrite the outputs under `outputs.kapp` though
-	//RegistryPath      string // Path to store the output in the registry (some paths are protected and may not be specified)
-	Format    string
-	Sensitive bool // sensitive outputs will be deleted after adding the data to the registry to try to prevent
+	Id           string
+	Source       string // either 'file' (the default) to read the output from `Path`, or 'stdout' to parse the stdout of the output target
+	Path         string
+	Regex        string // optional. Only the part of the output matched by this (or its first capturing group) is parsed
+	JsonPath     string // optional. Only the element at this path in the parsed output is used
+	RegistryPath string `yaml:"registry_path"` // extra path to store the output under in the registry so other kapps can
+	// use it without knowing which kapp created it. Paths under `outputs` are protected and may not be specified.
+	OnConflict string `yaml:"on_conflict"` // what to do if another kapp has already written to `RegistryPath`. Overrides the global setting
+	Format     string
+	Schema     *OutputSchema // optional. Outputs that don't match this are rejected
+	Sensitive  bool          // sensitive outputs will be deleted after adding the data to the registry to try to prevent
 	// secrets lingering on disk
 }
 
+// A JSON-Schema-like description of the data in an output
+type OutputSchema struct {
+	Type       string                  // one of 'object', 'array', 'string', 'number', 'integer', 'boolean' or 'null'
+	Required   []string                // keys objects must contain
+	Properties map[string]OutputSchema // schemas for the values of keys in objects
+	Items      *OutputSchema           // schema for each element of arrays
+	Pattern    string                  // regex strings must match
+	Enum       []interface{}           // values must be one of these
+}
+
 type Source struct {
 	Id      string
 	Uri     string
@@ -64,8 +79,10 @@ type KappConfig struct {
 	Vars                 map[string]interface{}
 	DependsOn            []string `yaml:"depends_on"`             // fully qualified IDs of other kapps this depends on
 	IgnoreGlobalDefaults bool     `yaml:"ignore_global_defaults"` // don't add globally configured defaults for each requirement
-	// todo - implement
-	//VarsTemplate string		// this will be read as a string, templated then converted to YAML and merged with the Vars map
+	// this will be read as a string, templated then converted to YAML and merged with the Vars map
+	VarsTemplate string `yaml:"vars_template" mapstructure:"vars_template"`
+	// whether templates referring to missing keys are errors. Overrides the global setting if set
+	StrictTemplates *bool `yaml:"strict_templates" mapstructure:"strict_templates"`
 }
 
 // KappDescriptors describe where to find a kapp plus some other data, but isn't the kapp itself.
@@ -85,4 +102,5 @@ type KappDescriptorWithMaps struct {
 	KappConfig `yaml:",inline"`
 	Sources    map[string]Source // keys are object IDs so values for individual objects can be overridden
 	Outputs    map[string]Output // keys are object IDs so values for individual objects can be overridden
+	DeclaredIn string            `yaml:"-" json:"-" mapstructure:"-"` // where the descriptor came from, used to explain where vars are set
 }
@@ -20,11 +20,9 @@ package structs
 
 // Describes where to find the manifest plus some other data, but isn't the manifest itself
 type ManifestDescriptor struct {
-	Id  string // a default will be used if not explicitly set. Used to namespace cache entries
-	Uri string
-
-	// todo - we should get rid of the Id and Uri fields and just use a Source and acquirers:
-	//Source `yaml:",inline"`
+	Id      string                 // a default will be used if not explicitly set. Used to namespace cache entries
+	Uri     string                 // a path relative to the stack file, or a source URI for remote manifests
+	Options map[string]interface{} // options for the acquirer for remote manifests, e.g. a branch
 
 	Versions  map[string]string                 // for overriding git branches/package versions without a load of nesting
 	Overrides map[string]KappDescriptorWithMaps // the map key is the kappDescriptor ID
@@ -46,4 +44,8 @@ type StackFile struct {
 	KappVarsDirs        []string             `yaml:"kapp_vars_dirs"`
 	ManifestDescriptors []ManifestDescriptor `yaml:"manifests"` // this struct should be immutable, so don't store pointers
 	TemplateDirs        []string             `yaml:"template_dirs"`
+
+	// these aren't in the YAML but can be set by CLI commands
+	UpdateManifests   bool              `yaml:"-"` // if true remote manifests will be updated even if they've already been cached
+	ManifestRevisions map[string]string `yaml:"-"` // revisions to acquire remote manifests at keyed by their source URIs
 }
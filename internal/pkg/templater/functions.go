@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/secrets"
 	"github.com/sugarkube/sugarkube/internal/pkg/utils"
 	"text/template"
 )
@@ -30,6 +31,8 @@ var CustomFunctions = template.FuncMap{
 	"listString":  listString,
 	"isSet":       isSet,
 	"removeEmpty": removeEmpty,
+	"secret":      secrets.Secret,
+	"secretFile":  secrets.SecretFile,
 }
 
 // Turn separate string parameters into a single []string array
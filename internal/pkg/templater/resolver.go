@@ -0,0 +1,480 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package templater
+
+import (
+	"fmt"
+	"github.com/Masterminds/sprig"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"gopkg.in/yaml.v2"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// A string value in the vars that contains a template
+type varNode struct {
+	path        []string    // keys (and list indices) leading to the value
+	container   interface{} // the map or list holding the value
+	key         interface{} // the value's key or index in its container
+	template    string      // the unrendered value
+	tree        *parse.Tree // the parsed template
+	references  [][]string  // paths of vars the template refers to
+	refersToAll bool        // whether the template refers to the root of the vars (e.g. `{{ toYaml . }}`)
+}
+
+func (n varNode) id() string {
+	return strings.Join(n.path, ".")
+}
+
+// Renders templates in vars, e.g. setting `cluster_name` to '{{ .stack.region }}-{{ .stack.account }}'
+// lets other vars just use '{{ .kapp.vars.cluster_name }}'. The vars each template refers to
+// are rendered before it, and cycles between vars are errors. Values that aren't templates keep
+// their types, as do templates that only refer to a single var (e.g. '{{ .kapp.vars.port }}'),
+// which are replaced by that var's value. Other templates render to strings. Each template is
+// only rendered once, so a value that renders to another template (e.g. '{{ "{{ .x }}" }}') is
+// left as it is. The name is used in errors. See RenderNamedTemplate for strict mode.
+func ResolveVars(name string, vars map[string]interface{}, strict bool) (map[string]interface{}, error) {
+	// copy the vars so we don't modify maps shared with e.g. the registry
+	resolved := map[string]interface{}{}
+	for k, v := range vars {
+		resolved[k] = normalise(v)
+	}
+
+	nodes := make([]*varNode, 0)
+	err := findVarNodes(resolved, []string{}, &nodes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error parsing templates in %s", name)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].id() < nodes[j].id()
+	})
+
+	ordered, err := orderVarNodes(nodes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error resolving %s", name)
+	}
+
+	log.Logger.Tracef("Resolving %d templated vars in %s", len(ordered), name)
+
+	for _, node := range ordered {
+		value, err := resolveVarNode(name, node, resolved, strict)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		setValue(node.container, node.key, value)
+	}
+
+	return resolved, nil
+}
+
+// Renders a templated var. Templates that only refer to a single var that exists are replaced
+// by its value so its type is kept.
+func resolveVarNode(name string, node *varNode, vars map[string]interface{}, strict bool) (interface{}, error) {
+	fieldName := fmt.Sprintf("field '%s' of %s", node.id(), name)
+
+	if path, ok := singleReference(node.tree); ok {
+		if value, exists := lookup(vars, path); exists {
+			return normalise(value), nil
+		}
+	}
+
+	rendered, err := RenderNamedTemplate(fieldName, node.template, vars, strict)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return rendered, nil
+}
+
+// Returns the nodes in an order where each node comes after the nodes it refers to, or an
+// error describing the first cycle found
+func orderVarNodes(nodes []*varNode) ([]*varNode, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[*varNode]int, len(nodes))
+	ordered := make([]*varNode, 0, len(nodes))
+	stack := make([]*varNode, 0)
+
+	var visit func(node *varNode) error
+	visit = func(node *varNode) error {
+		switch state[node] {
+		case visited:
+			return nil
+		case visiting:
+			chain := make([]string, 0)
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] == node {
+					for _, n := range stack[i:] {
+						chain = append(chain, n.id())
+					}
+					break
+				}
+			}
+			chain = append(chain, node.id())
+			return errors.New(fmt.Sprintf("Cycle detected between vars: %s",
+				strings.Join(chain, " -> ")))
+		}
+
+		state[node] = visiting
+		stack = append(stack, node)
+
+		for _, dependency := range nodes {
+			if dependency != node && node.dependsOn(dependency) {
+				if err := visit(dependency); err != nil {
+					return err
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[node] = visited
+		ordered = append(ordered, node)
+		return nil
+	}
+
+	for _, node := range nodes {
+		if err := visit(node); err != nil {
+			return nil, err
+		}
+	}
+
+	return ordered, nil
+}
+
+// Returns whether a node's template refers to another node's value, either directly or by
+// referring to a map or list containing it. Templates that refer to all vars depend on every
+// other template except ones that also refer to all vars, which they see unrendered, otherwise
+// any two of them would be a cycle.
+func (n varNode) dependsOn(other *varNode) bool {
+	if n.refersToAll {
+		return !other.refersToAll
+	}
+
+	for _, reference := range n.references {
+		if isPrefix(reference, other.path) || isPrefix(other.path, reference) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isPrefix(prefix []string, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Recursively finds string values containing templates and parses them
+func findVarNodes(value interface{}, path []string, nodes *[]*varNode) error {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for k, v := range typed {
+			if err := visitVarNode(typed, k, v, append(copyPath(path), k), nodes); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range typed {
+			key := fmt.Sprintf("%v", k)
+			if err := visitVarNode(typed, k, v, append(copyPath(path), key), nodes); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, v := range typed {
+			if err := visitVarNode(typed, i, v, append(copyPath(path), strconv.Itoa(i)), nodes); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func visitVarNode(container interface{}, key interface{}, value interface{}, path []string,
+	nodes *[]*varNode) error {
+
+	str, ok := value.(string)
+	if !ok {
+		return findVarNodes(value, path, nodes)
+	}
+
+	if !strings.Contains(str, "{{") {
+		return nil
+	}
+
+	tpl, err := template.New(strings.Join(path, ".")).Funcs(sprig.TxtFuncMap()).Funcs(CustomFunctions).Parse(str)
+	if err != nil {
+		return errors.Wrapf(err, "Error parsing the template in '%s'", strings.Join(path, "."))
+	}
+
+	node := &varNode{
+		path:      path,
+		container: container,
+		key:       key,
+		template:  str,
+		tree:      tpl.Tree,
+	}
+	node.findReferences(tpl.Tree.Root, true)
+
+	*nodes = append(*nodes, node)
+	return nil
+}
+
+// Records the paths of vars a template node refers to. Fields inside `range` and `with` blocks
+// are relative to the value being iterated over, which is already recorded as a reference.
+func (n *varNode) findReferences(node parse.Node, dotIsRoot bool) {
+	switch typed := node.(type) {
+	case *parse.ListNode:
+		if typed == nil {
+			return
+		}
+		for _, child := range typed.Nodes {
+			n.findReferences(child, dotIsRoot)
+		}
+	case *parse.ActionNode:
+		n.findReferences(typed.Pipe, dotIsRoot)
+	case *parse.IfNode:
+		n.findReferences(typed.Pipe, dotIsRoot)
+		n.findReferences(typed.List, dotIsRoot)
+		n.findReferences(typed.ElseList, dotIsRoot)
+	case *parse.RangeNode:
+		n.findReferences(typed.Pipe, dotIsRoot)
+		n.findReferences(typed.List, false)
+		n.findReferences(typed.ElseList, dotIsRoot)
+	case *parse.WithNode:
+		n.findReferences(typed.Pipe, dotIsRoot)
+		n.findReferences(typed.List, false)
+		n.findReferences(typed.ElseList, dotIsRoot)
+	case *parse.TemplateNode:
+		n.findReferences(typed.Pipe, dotIsRoot)
+	case *parse.PipeNode:
+		if typed == nil {
+			return
+		}
+		for _, cmd := range typed.Cmds {
+			n.findReferences(cmd, dotIsRoot)
+		}
+	case *parse.CommandNode:
+		// refer to exactly the key looked up with e.g. `index .kapp.vars "port"`
+		if path, ok := indexReference(typed, dotIsRoot); ok {
+			n.references = append(n.references, path)
+			return
+		}
+		for _, arg := range typed.Args {
+			n.findReferences(arg, dotIsRoot)
+		}
+	case *parse.ChainNode:
+		n.findReferences(typed.Node, dotIsRoot)
+	case *parse.FieldNode:
+		if dotIsRoot {
+			n.references = append(n.references, typed.Ident)
+		}
+	case *parse.VariableNode:
+		// only `$` refers to the root. Other variables are declared in the template
+		if typed.Ident[0] == "$" {
+			if len(typed.Ident) == 1 {
+				n.refersToAll = true
+			} else {
+				n.references = append(n.references, typed.Ident[1:])
+			}
+		}
+	case *parse.DotNode:
+		if dotIsRoot {
+			n.refersToAll = true
+		}
+	}
+}
+
+// Returns the path looked up by an `index` command with string keys
+func indexReference(cmd *parse.CommandNode, dotIsRoot bool) ([]string, bool) {
+	if len(cmd.Args) < 3 {
+		return nil, false
+	}
+
+	identifier, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok || identifier.Ident != "index" {
+		return nil, false
+	}
+
+	path, ok := rootPath(cmd.Args[1], dotIsRoot)
+	if !ok {
+		return nil, false
+	}
+
+	for _, arg := range cmd.Args[2:] {
+		key, ok := arg.(*parse.StringNode)
+		if !ok {
+			return nil, false
+		}
+		path = append(path, key.Text)
+	}
+
+	return path, true
+}
+
+// Returns the path of a field relative to the root of the vars
+func rootPath(node parse.Node, dotIsRoot bool) ([]string, bool) {
+	switch typed := node.(type) {
+	case *parse.FieldNode:
+		if dotIsRoot {
+			return copyPath(typed.Ident), true
+		}
+	case *parse.VariableNode:
+		if typed.Ident[0] == "$" {
+			return copyPath(typed.Ident[1:]), true
+		}
+	}
+
+	return nil, false
+}
+
+// Returns the path of the var if a template consists only of a reference to it, e.g.
+// '{{ .kapp.vars.port }}'
+func singleReference(tree *parse.Tree) ([]string, bool) {
+	if len(tree.Root.Nodes) != 1 {
+		return nil, false
+	}
+
+	action, ok := tree.Root.Nodes[0].(*parse.ActionNode)
+	if !ok || len(action.Pipe.Decl) > 0 || len(action.Pipe.Cmds) != 1 ||
+		len(action.Pipe.Cmds[0].Args) != 1 {
+		return nil, false
+	}
+
+	return rootPath(action.Pipe.Cmds[0].Args[0], true)
+}
+
+// Returns the value at a path in the vars
+func lookup(vars map[string]interface{}, path []string) (interface{}, bool) {
+	var current interface{} = vars
+	for _, segment := range path {
+		children, ok := childKeys(current)
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = children[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func setValue(container interface{}, key interface{}, value interface{}) {
+	switch typed := container.(type) {
+	case map[string]interface{}:
+		typed[key.(string)] = value
+	case map[interface{}]interface{}:
+		typed[key] = value
+	case []interface{}:
+		typed[key.(int)] = value
+	}
+}
+
+func copyPath(path []string) []string {
+	return append(make([]string, 0, len(path)+1), path...)
+}
+
+// Returns a deep copy of a value with maps converted to map[interface{}]interface{} and lists
+// to []interface{} as if they'd been unmarshalled from YAML. Scalars keep their types except
+// whole floats, which become ints.
+func normalise(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case nil, string, bool, int, int64:
+		return value
+	case float64:
+		// numbers in vars are deep copied through JSON, so restore integers as YAML would
+		if typed == math.Trunc(typed) && math.Abs(typed) < 1e15 {
+			return int(typed)
+		}
+		return value
+	case map[interface{}]interface{}:
+		normalised := make(map[interface{}]interface{}, len(typed))
+		for k, v := range typed {
+			normalised[k] = normalise(v)
+		}
+		return normalised
+	case map[string]interface{}:
+		normalised := make(map[interface{}]interface{}, len(typed))
+		for k, v := range typed {
+			normalised[k] = normalise(v)
+		}
+		return normalised
+	case []interface{}:
+		normalised := make([]interface{}, len(typed))
+		for i, v := range typed {
+			normalised[i] = normalise(v)
+		}
+		return normalised
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Map:
+		normalised := make(map[interface{}]interface{}, reflected.Len())
+		for _, k := range reflected.MapKeys() {
+			normalised[k.Interface()] = normalise(reflected.MapIndex(k).Interface())
+		}
+		return normalised
+	case reflect.Slice, reflect.Array:
+		if reflected.Type().Elem().Kind() == reflect.Uint8 {
+			return value
+		}
+		normalised := make([]interface{}, reflected.Len())
+		for i := 0; i < reflected.Len(); i++ {
+			normalised[i] = normalise(reflected.Index(i).Interface())
+		}
+		return normalised
+	case reflect.Struct, reflect.Ptr:
+		// convert structs to maps the same way they'd be written to YAML
+		yamlData, err := yaml.Marshal(value)
+		if err != nil {
+			return value
+		}
+		var unmarshalled interface{}
+		if yaml.Unmarshal(yamlData, &unmarshalled) != nil {
+			return value
+		}
+		return unmarshalled
+	}
+
+	return value
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package templater
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestResolveVars(t *testing.T) {
+	vars := map[string]interface{}{
+		"stack": map[string]interface{}{
+			"region":  "eu-west-1",
+			"account": "dev",
+		},
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{
+				// refers to a var that's itself a template
+				"url":          "https://{{ .kapp.vars.hostname }}:{{ .kapp.vars.port }}",
+				"hostname":     "{{ .kapp.vars.cluster_name }}.example.com",
+				"cluster_name": "{{ .stack.region }}-{{ .stack.account }}",
+				"port":         8080,
+				"proxy_port":   "{{ .kapp.vars.port }}",
+				"enabled":      true,
+				"ratio":        1.5,
+				"replicas":     []string{"a", "{{ .kapp.vars.hostname }}"},
+				"first":        "{{ index .kapp.vars.replicas 1 }}",
+				"settings":     "{{ .kapp.vars.tls }}",
+				"tls": map[string]interface{}{
+					"enabled": "{{ .kapp.vars.enabled }}",
+				},
+			},
+		},
+	}
+
+	resolved, err := ResolveVars("test", vars, true)
+	assert.Nil(t, err)
+
+	kappVars := resolved["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	assert.Equal(t, "eu-west-1-dev", kappVars["cluster_name"])
+	assert.Equal(t, "eu-west-1-dev.example.com", kappVars["hostname"])
+	assert.Equal(t, "https://eu-west-1-dev.example.com:8080", kappVars["url"])
+	assert.Equal(t, []interface{}{"a", "eu-west-1-dev.example.com"}, kappVars["replicas"])
+	assert.Equal(t, "eu-west-1-dev.example.com", kappVars["first"])
+
+	// types are kept
+	assert.Equal(t, 8080, kappVars["port"])
+	assert.Equal(t, 8080, kappVars["proxy_port"])
+	assert.Equal(t, 1.5, kappVars["ratio"])
+	assert.Equal(t, map[interface{}]interface{}{"enabled": true}, kappVars["settings"])
+
+	// the input isn't modified
+	assert.Equal(t, "{{ .kapp.vars.port }}",
+		vars["kapp"].(map[string]interface{})["vars"].(map[string]interface{})["proxy_port"])
+}
+
+func TestResolveVarsCycles(t *testing.T) {
+	vars := map[string]interface{}{
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{
+				"a": "{{ .kapp.vars.b }}",
+				"b": "x-{{ .kapp.vars.c }}",
+				"c": "{{ index .kapp.vars \"a\" }}",
+				"d": "{{ .kapp.vars.a }}",
+			},
+		},
+	}
+
+	_, err := ResolveVars("the vars of kapp 'wordpress'", vars, false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Cycle detected between vars: "+
+		"kapp.vars.a -> kapp.vars.b -> kapp.vars.c -> kapp.vars.a")
+}
+
+func TestResolveVarsReferences(t *testing.T) {
+	vars := map[string]interface{}{
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{
+				// fields inside `range` are relative to each item so aren't dependencies
+				"names": "{{ range .kapp.vars.items }}{{ .name }},{{ end }}",
+				"items": []interface{}{
+					map[string]interface{}{"name": "{{ $.kapp.vars.prefix }}-a"},
+				},
+				"prefix": "{{ .kapp.vars.base }}",
+				"base":   "app",
+			},
+		},
+	}
+
+	resolved, err := ResolveVars("test", vars, true)
+	assert.Nil(t, err)
+
+	kappVars := resolved["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	assert.Equal(t, "app-a,", kappVars["names"])
+}
+
+func TestResolveVarsReferencesToAll(t *testing.T) {
+	vars := map[string]interface{}{
+		"stack": map[string]interface{}{
+			"region": "eu-west-1",
+		},
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{
+				// both refer to all vars but don't depend on each other
+				"count":   "{{ len . }}",
+				"summary": "{{ $.kapp.vars.name }}-{{ if . }}set{{ end }}",
+				"name":    "{{ .stack.region }}",
+			},
+		},
+	}
+
+	resolved, err := ResolveVars("test", vars, true)
+	assert.Nil(t, err)
+
+	kappVars := resolved["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	assert.Equal(t, "2", kappVars["count"])
+	assert.Equal(t, "eu-west-1-set", kappVars["summary"])
+}
+
+func TestResolveVarsRendersOnce(t *testing.T) {
+	vars := map[string]interface{}{
+		"stack": map[string]interface{}{
+			"region": "eu-west-1",
+		},
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{
+				// values that render to templates aren't rendered again
+				"literal": `{{ "{{ .stack.region }}" }}`,
+				"alias":   "{{ .kapp.vars.literal }}",
+				"prefix":  "x-{{ .kapp.vars.literal }}",
+			},
+		},
+	}
+
+	resolved, err := ResolveVars("test", vars, true)
+	assert.Nil(t, err)
+
+	kappVars := resolved["kapp"].(map[interface{}]interface{})["vars"].(map[interface{}]interface{})
+	assert.Equal(t, "{{ .stack.region }}", kappVars["literal"])
+	assert.Equal(t, "{{ .stack.region }}", kappVars["alias"])
+	assert.Equal(t, "x-{{ .stack.region }}", kappVars["prefix"])
+}
+
+func TestResolveVarsParseErrors(t *testing.T) {
+	vars := map[string]interface{}{
+		"kapp": map[string]interface{}{
+			"vars": map[string]interface{}{
+				"broken": "{{ .kapp.vars.a ",
+			},
+		},
+	}
+
+	_, err := ResolveVars("test", vars, true)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "'kapp.vars.broken'")
+}
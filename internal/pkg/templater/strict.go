@@ -0,0 +1,296 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package templater
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Maximum number of similar paths to suggest for a missing key
+const maxSuggestions = 3
+
+// Returned when a template refers to a key that doesn't exist in strict mode
+type MissingKeyError struct {
+	Template    string   // the template file or field containing the reference
+	Line        int      // line of the reference in the template
+	Path        string   // path to the missing key, e.g. `.kapp.vars.hostname`
+	Suggestions []string // existing paths similar to the missing one
+}
+
+func (e MissingKeyError) Error() string {
+	message := fmt.Sprintf("%s refers to '%s' on line %d but it doesn't exist", e.Template,
+		e.Path, e.Line)
+
+	// names may start with e.g. 'the' since they're used mid-sentence when naming fields
+	message = strings.ToUpper(message[:1]) + message[1:]
+
+	if len(e.Suggestions) > 0 {
+		message = fmt.Sprintf("%s. Did you mean '%s'?", message,
+			strings.Join(e.Suggestions, "' or '"))
+	}
+
+	return message
+}
+
+// Returns whether templates should be rendered in strict mode, where references to missing keys
+// are errors. Lenient mode (i.e. the `--lenient` flag) takes precedence, then the given kapp
+// setting (if it's set), then the global `strict-templates` setting.
+func IsStrict(kappSetting *bool) bool {
+	if config.CurrentConfig != nil && config.CurrentConfig.LenientTemplates {
+		return false
+	}
+
+	if kappSetting != nil {
+		return *kappSetting
+	}
+
+	return config.CurrentConfig != nil && config.CurrentConfig.StrictTemplates
+}
+
+// Converts an error from executing a template in strict mode to a MissingKeyError, or returns
+// nil if it wasn't caused by a missing key
+func newMissingKeyError(name string, err error, vars map[string]interface{}) *MissingKeyError {
+	pattern := regexp.MustCompile(`^template: ` + regexp.QuoteMeta(name) +
+		`:(\d+):\d+: executing ".*" at <(.+)>: map has no entry for key "(.*)"$`)
+
+	matches := pattern.FindStringSubmatch(err.Error())
+	if matches == nil {
+		return nil
+	}
+
+	line, _ := strconv.Atoi(matches[1])
+	path, suggestions := missingPath(matches[2], matches[3], vars)
+
+	return &MissingKeyError{
+		Template:    name,
+		Line:        line,
+		Path:        path,
+		Suggestions: suggestions,
+	}
+}
+
+// Returns the path to a missing key in an expression and similar paths that exist. The path
+// can only be found in the vars if the expression is relative to the root (i.e. not inside a
+// `range` or `with` block), otherwise it's truncated at the key without any suggestions.
+func missingPath(expression string, key string, vars map[string]interface{}) (string, []string) {
+	segments := strings.Split(strings.TrimPrefix(strings.TrimPrefix(expression, "$"), "."), ".")
+
+	var current interface{} = vars
+	for i, segment := range segments {
+		children, ok := childKeys(current)
+		if !ok {
+			break
+		}
+
+		value, exists := children[segment]
+		if !exists {
+			if segment != key {
+				break
+			}
+
+			parentPath := "." + strings.Join(segments[:i], ".")
+			if i == 0 {
+				parentPath = ""
+			}
+
+			suggestions := make([]string, 0)
+			for _, similar := range similarKeys(key, children) {
+				suggestions = append(suggestions, parentPath+"."+similar)
+			}
+
+			return parentPath + "." + key, suggestions
+		}
+
+		current = value
+	}
+
+	for i, segment := range segments {
+		if segment == key {
+			return "." + strings.Join(segments[:i+1], "."), []string{}
+		}
+	}
+
+	return "." + key, []string{}
+}
+
+// Returns the values in a map keyed by string
+func childKeys(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case map[interface{}]interface{}:
+		children := map[string]interface{}{}
+		for k, v := range typed {
+			children[fmt.Sprintf("%v", k)] = v
+		}
+		return children, true
+	}
+
+	return nil, false
+}
+
+// Returns the keys closest to the given one, ignoring any that are too different to be typos
+func similarKeys(key string, candidates map[string]interface{}) []string {
+	type scored struct {
+		key      string
+		distance int
+	}
+
+	lowerKey := strings.ToLower(key)
+
+	matches := make([]scored, 0)
+	for candidate := range candidates {
+		lowerCandidate := strings.ToLower(candidate)
+		distance := editDistance(lowerKey, lowerCandidate)
+
+		// also suggest keys that contain the missing one or vice versa, e.g. 'port' and 'db_port'
+		contains := len(lowerKey) >= 3 && len(lowerCandidate) >= 3 &&
+			(strings.Contains(lowerCandidate, lowerKey) || strings.Contains(lowerKey, lowerCandidate))
+
+		if distance <= 2 || distance <= len(key)/3 || contains {
+			matches = append(matches, scored{key: candidate, distance: distance})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].distance == matches[j].distance {
+			return matches[i].key < matches[j].key
+		}
+		return matches[i].distance < matches[j].distance
+	})
+
+	similar := make([]string, 0)
+	for i := 0; i < len(matches) && i < maxSuggestions; i++ {
+		similar = append(similar, matches[i].key)
+	}
+
+	return similar
+}
+
+// Returns the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous = current
+	}
+
+	return previous[len(b)]
+}
+
+func minInt(first int, others ...int) int {
+	result := first
+	for _, other := range others {
+		if other < result {
+			result = other
+		}
+	}
+
+	return result
+}
+
+// Renders each string in some data separately to find the one that refers to a missing key,
+// returning an error naming the field, or the original error if none of them do
+func findMissingKeyField(name string, data interface{}, vars map[string]interface{},
+	original *MissingKeyError) error {
+
+	var found *MissingKeyError
+
+	walkStrings(data, "", func(fieldPath string, value string) bool {
+		if !strings.Contains(value, "{{") {
+			return true
+		}
+
+		_, err := RenderNamedTemplate(fieldPath, value, vars, true)
+		if missingKeyErr, ok := errors.Cause(err).(*MissingKeyError); ok {
+			missingKeyErr.Template = fmt.Sprintf("field '%s' of %s", fieldPath, name)
+
+			// prefer the field Go stopped at
+			if found == nil || missingKeyErr.Path == original.Path {
+				found = missingKeyErr
+			}
+			return missingKeyErr.Path != original.Path
+		}
+
+		return true
+	})
+
+	if found != nil {
+		return found
+	}
+
+	return original
+}
+
+// Calls a function with the path to and value of each string in some data, in the order they'd
+// be marshalled to YAML, until the function returns false
+func walkStrings(data interface{}, path string, fn func(string, string) bool) bool {
+	switch typed := data.(type) {
+	case string:
+		return fn(path, typed)
+	case []interface{}:
+		for i, v := range typed {
+			if !walkStrings(v, fmt.Sprintf("%s[%d]", path, i), fn) {
+				return false
+			}
+		}
+	default:
+		children, ok := childKeys(data)
+		if !ok {
+			return true
+		}
+
+		keys := make([]string, 0, len(children))
+		for k := range children {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			childPath := k
+			if path != "" {
+				childPath = path + "." + k
+			}
+			if !walkStrings(children[k], childPath, fn) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
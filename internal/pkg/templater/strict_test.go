@@ -0,0 +1,141 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package templater
+
+import (
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"testing"
+)
+
+func strictTestVars() map[string]interface{} {
+	return map[string]interface{}{
+		"kapp": map[string]interface{}{
+			"id": "wordpress",
+			"vars": map[interface{}]interface{}{
+				"hostname":  "example.com",
+				"host_port": 8080,
+			},
+		},
+	}
+}
+
+func TestRenderNamedTemplateStrict(t *testing.T) {
+	vars := strictTestVars()
+
+	_, err := RenderNamedTemplate("template 'values.yaml'",
+		"id: {{ .kapp.id }}\nhost: {{ .kapp.vars.hostnme }}\n", vars, true)
+	assert.Error(t, err)
+
+	missingKeyErr, ok := errors.Cause(err).(*MissingKeyError)
+	assert.True(t, ok)
+	assert.Equal(t, "template 'values.yaml'", missingKeyErr.Template)
+	assert.Equal(t, 2, missingKeyErr.Line)
+	assert.Equal(t, ".kapp.vars.hostnme", missingKeyErr.Path)
+	assert.Equal(t, []string{".kapp.vars.hostname"}, missingKeyErr.Suggestions)
+	assert.Equal(t, "Template 'values.yaml' refers to '.kapp.vars.hostnme' on line 2 but it "+
+		"doesn't exist. Did you mean '.kapp.vars.hostname'?", missingKeyErr.Error())
+
+	// the missing key is the first one that doesn't exist, not the end of the expression
+	_, err = RenderNamedTemplate("test", "{{ .kap.vars.hostname }}", vars, true)
+	missingKeyErr, ok = errors.Cause(err).(*MissingKeyError)
+	assert.True(t, ok)
+	assert.Equal(t, ".kap", missingKeyErr.Path)
+	assert.Equal(t, []string{".kapp"}, missingKeyErr.Suggestions)
+
+	// keys in `range` blocks can't be resolved from the root
+	_, err = RenderNamedTemplate("test", "{{ range .kapp.vars }}{{ .nope }}{{ end }}",
+		map[string]interface{}{
+			"kapp": map[string]interface{}{
+				"vars": []interface{}{map[interface{}]interface{}{"name": "a"}},
+			},
+		}, true)
+	missingKeyErr, ok = errors.Cause(err).(*MissingKeyError)
+	assert.True(t, ok)
+	assert.Equal(t, ".nope", missingKeyErr.Path)
+	assert.Empty(t, missingKeyErr.Suggestions)
+}
+
+func TestRenderNamedTemplateLenient(t *testing.T) {
+	output, err := RenderNamedTemplate("test", "host: {{ .kapp.vars.hostnme }}", strictTestVars(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, "host: <no value>", output)
+}
+
+func TestRenderYamlNamesFields(t *testing.T) {
+	descriptor := map[string]interface{}{
+		"env_vars": map[string]interface{}{
+			"HOST": "{{ .kapp.vars.hostname }}",
+			"PORT": "{{ .kapp.vars.hostport }}",
+		},
+	}
+
+	_, err := RenderYaml("the descriptor of kapp 'wordpress'", descriptor, strictTestVars(), true)
+	assert.Error(t, err)
+
+	missingKeyErr, ok := errors.Cause(err).(*MissingKeyError)
+	assert.True(t, ok)
+	assert.Equal(t, "field 'env_vars.PORT' of the descriptor of kapp 'wordpress'", missingKeyErr.Template)
+	assert.Equal(t, 1, missingKeyErr.Line)
+	assert.Equal(t, ".kapp.vars.hostport", missingKeyErr.Path)
+	assert.Equal(t, []string{".kapp.vars.host_port"}, missingKeyErr.Suggestions)
+}
+
+func TestResolveVarsStrict(t *testing.T) {
+	vars := strictTestVars()
+	vars["kapp"].(map[string]interface{})["vars"].(map[interface{}]interface{})["url"] =
+		"https://{{ .kapp.vars.hostname }}:{{ .kapp.vars.port }}"
+
+	_, err := ResolveVars("the vars of kapp 'wordpress'", vars, true)
+	assert.Error(t, err)
+
+	missingKeyErr, ok := errors.Cause(err).(*MissingKeyError)
+	assert.True(t, ok)
+	assert.Equal(t, "field 'kapp.vars.url' of the vars of kapp 'wordpress'", missingKeyErr.Template)
+	assert.Equal(t, ".kapp.vars.port", missingKeyErr.Path)
+	assert.Equal(t, []string{".kapp.vars.host_port"}, missingKeyErr.Suggestions)
+}
+
+func TestIsStrict(t *testing.T) {
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+
+	yes := true
+	no := false
+
+	config.CurrentConfig = nil
+	assert.False(t, IsStrict(nil))
+	assert.True(t, IsStrict(&yes))
+
+	config.CurrentConfig = &config.Config{StrictTemplates: true}
+	assert.True(t, IsStrict(nil))
+	assert.False(t, IsStrict(&no), "kapps should be able to opt out of strict mode")
+
+	config.CurrentConfig = &config.Config{StrictTemplates: true, LenientTemplates: true}
+	assert.False(t, IsStrict(nil))
+	assert.False(t, IsStrict(&yes), "--lenient should override kapp settings")
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, editDistance("hostname", "hostname"))
+	assert.Equal(t, 1, editDistance("hostnme", "hostname"))
+	assert.Equal(t, 2, editDistance("db_hots", "db_host"))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+}
@@ -18,31 +18,77 @@ package templater
 
 import (
 	"bytes"
+	"fmt"
 	"github.com/Masterminds/sprig"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
 	"text/template"
 )
 
+// Name of templates in errors if they aren't given one
+const defaultTemplateName = "gotpl"
+
 // Returns a template rendered with the given input variables
 func RenderTemplate(inputTemplate string, vars map[string]interface{}) (string, error) {
-	tpl := template.Must(
-		template.New("gotpl").Funcs(
-			sprig.TxtFuncMap()).Funcs(CustomFunctions).Parse(inputTemplate))
+	return RenderNamedTemplate(defaultTemplateName, inputTemplate, vars, IsStrict(nil))
+}
+
+// Returns a template rendered with the given input variables. The name is used in errors. In
+// strict mode references to missing keys return a MissingKeyError instead of rendering as
+// `<no value>`.
+func RenderNamedTemplate(name string, inputTemplate string, vars map[string]interface{},
+	strict bool) (string, error) {
+
+	tpl := template.New(name).Funcs(sprig.TxtFuncMap()).Funcs(CustomFunctions)
+	if strict {
+		tpl = tpl.Option("missingkey=error")
+	}
+	tpl = template.Must(tpl.Parse(inputTemplate))
 
 	buf := bytes.NewBuffer(nil)
 	err := tpl.Execute(buf, vars)
 	if err != nil {
+		if missingKeyErr := newMissingKeyError(name, err, vars); missingKeyErr != nil {
+			return "", errors.WithStack(missingKeyErr)
+		}
 		return "", errors.Wrapf(err, "Error executing template %s with vars %#v", inputTemplate, vars)
 	}
 
 	return buf.String(), nil
 }
 
+// Renders some data marshalled to YAML as a template. Errors about missing keys name the field
+// containing the reference instead of a line in the YAML.
+func RenderYaml(name string, data interface{}, vars map[string]interface{}, strict bool) (string, error) {
+	yamlData, err := yaml.Marshal(data)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return renderYaml(name, yamlData, vars, strict)
+}
+
+// Renders YAML as a template, finding the field containing any reference to a missing key
+func renderYaml(name string, yamlData []byte, vars map[string]interface{}, strict bool) (string, error) {
+	rendered, err := RenderNamedTemplate(name, string(yamlData), vars, strict)
+	if err != nil {
+		if missingKeyErr, ok := errors.Cause(err).(*MissingKeyError); ok {
+			var fields interface{}
+			if yaml.Unmarshal(yamlData, &fields) == nil {
+				return "", errors.WithStack(findMissingKeyField(name, fields, vars, missingKeyErr))
+			}
+		}
+		return "", errors.WithStack(err)
+	}
+
+	return rendered, nil
+}
+
 // Renders a template from a template file to a buffer
-func TemplateFile(src string, outBuf *bytes.Buffer, vars map[string]interface{}) error {
+func TemplateFile(src string, outBuf *bytes.Buffer, vars map[string]interface{}, strict bool) error {
 
 	// verify that the input template exists
 	if _, err := os.Stat(src); err != nil {
@@ -54,14 +100,16 @@ func TemplateFile(src string, outBuf *bytes.Buffer, vars map[string]interface{})
 		return errors.Wrapf(err, "Error reading source template file %s", src)
 	}
 
-	return TemplateString(string(srcTemplate[:]), outBuf, vars)
+	return TemplateString(fmt.Sprintf("template '%s'", src), string(srcTemplate[:]), outBuf,
+		vars, strict)
 }
 
 // Renders a template into a buffer
-func TemplateString(src string, outBuf *bytes.Buffer, vars map[string]interface{}) error {
+func TemplateString(name string, src string, outBuf *bytes.Buffer, vars map[string]interface{},
+	strict bool) error {
 	log.Logger.Tracef("Rendering template in '%s' with vars: %#v", src, vars)
 
-	rendered, err := RenderTemplate(src, vars)
+	rendered, err := RenderNamedTemplate(name, src, vars, strict)
 	if err != nil {
 		return errors.WithStack(err)
 	}
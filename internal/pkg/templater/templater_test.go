@@ -21,7 +21,9 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/secrets"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 )
@@ -93,7 +95,7 @@ func TestTemplateFile(t *testing.T) {
 		assert.Nil(t, err)
 
 		var outBuf bytes.Buffer
-		err = TemplateFile(inputTemplatePath, &outBuf, test.vars)
+		err = TemplateFile(inputTemplatePath, &outBuf, test.vars, false)
 		assert.Nil(t, err)
 
 		assert.Equal(t, test.expected, outBuf.String(),
@@ -136,3 +138,25 @@ func TestCustomFunctions(t *testing.T) {
 		assert.Equal(t, test.expected, output)
 	}
 }
+
+func TestSecretFunctions(t *testing.T) {
+	os.Setenv("SKTEMPLATER_DB_PASSWORD", "templated-password")
+	defer os.Unsetenv("SKTEMPLATER_DB_PASSWORD")
+
+	output, err := RenderTemplate(`password={{ secret "env:sktemplater/db#password" }}`,
+		map[string]interface{}{})
+	assert.Nil(t, err)
+	assert.Equal(t, "password=templated-password", output)
+
+	defer secrets.DeleteSecretFiles()
+	path, err := RenderTemplate(`{{ secretFile "env:sktemplater/db#password" }}`,
+		map[string]interface{}{})
+	assert.Nil(t, err)
+
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "templated-password", string(data))
+
+	_, err = RenderTemplate(`{{ secret "env:sktemplater/missing" }}`, map[string]interface{}{})
+	assert.Error(t, err)
+}
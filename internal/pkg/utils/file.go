@@ -17,8 +17,11 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -136,3 +139,69 @@ func StripExtension(path string) string {
 	extension := filepath.Ext(path)
 	return strings.TrimSuffix(path, extension)
 }
+
+// Files with this before their extension are encrypted, e.g. `values.enc.yaml`
+const EncryptedFileMarker = ".enc"
+
+// Returns whether a file name says the file is encrypted
+func IsEncryptedFile(path string) bool {
+	return strings.HasSuffix(StripExtension(filepath.Base(path)), EncryptedFileMarker)
+}
+
+// Strips the extension and any encrypted file marker from a file name so e.g. `values.enc.yaml`
+// and `values.yaml` have the same base name
+func StripEncryptedExtension(path string) string {
+	return strings.TrimSuffix(StripExtension(path), EncryptedFileMarker)
+}
+
+// Returns the hex-encoded sha256 digest of a file
+func FileSha256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Recursively copies a directory, preserving symlinks and permissions
+func CopyTree(src string, dest string) error {
+	return filepath.Walk(src, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		relativePath, err := filepath.Rel(src, filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		target := filepath.Join(dest, relativePath)
+
+		if info.IsDir() {
+			return errors.WithStack(os.MkdirAll(target, info.Mode().Perm()|0700))
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			return errors.WithStack(os.Symlink(link, target))
+		}
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return errors.WithStack(ioutil.WriteFile(target, data, info.Mode().Perm()))
+	})
+}
@@ -114,3 +114,11 @@ func TestFindFilesByPattern(t *testing.T) {
 		assert.Equal(t, test.expectValues, result, "unexpected files returned for %s", test.name)
 	}
 }
+
+func TestIsEncryptedFile(t *testing.T) {
+	assert.True(t, IsEncryptedFile("/vars/values.enc.yaml"))
+	assert.False(t, IsEncryptedFile("/vars/values.yaml"))
+	assert.False(t, IsEncryptedFile("/vars.enc/values.yaml"))
+	assert.Equal(t, "/vars/values", StripEncryptedExtension("/vars/values.enc.yaml"))
+	assert.Equal(t, "/vars/values", StripEncryptedExtension("/vars/values.yaml"))
+}
@@ -105,7 +105,7 @@ func applyPrecdence(rootDir string, names []string, precedence []string) []strin
 	for _, rule := range dedupedPrecedence {
 		for _, name := range names {
 			// append the match to an array keyed by precedence rule
-			if rule == StripExtension(name) {
+			if rule == StripEncryptedExtension(name) {
 				matches, ok = matchMap[rule]
 				if !ok {
 					matches = make([]string, 0)
@@ -128,8 +128,8 @@ func applyPrecdence(rootDir string, names []string, precedence []string) []strin
 			leftExtension := filepath.Ext(left)
 			rightExtension := filepath.Ext(right)
 
-			leftBaseName := StripExtension(left)
-			rightBaseName := StripExtension(right)
+			leftBaseName := StripEncryptedExtension(left)
+			rightBaseName := StripEncryptedExtension(right)
 
 			absLeft := filepath.Join(rootDir, left)
 			absRight := filepath.Join(rootDir, right)
@@ -141,7 +141,11 @@ func applyPrecdence(rootDir string, names []string, precedence []string) []strin
 				if isFile(absLeft) && !isFile(absRight) || !isFile(absLeft) && isFile(absRight) {
 					return isFile(absLeft)
 				} else if isFile(absLeft) && isFile(absRight) {
-					// both are files. Return based on the extensions
+					// both are files. Return based on the extensions, putting encrypted files
+					// after plain ones so their values take precedence
+					if leftExtension == rightExtension {
+						return !IsEncryptedFile(left) && IsEncryptedFile(right)
+					}
 					return leftExtension < rightExtension
 				} else {
 					// the same, so return false to cover all branches
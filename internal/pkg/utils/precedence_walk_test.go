@@ -20,6 +20,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
@@ -136,3 +137,43 @@ func TestPrecedenceWalkDedupe(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, expected, visited)
 }
+
+// Encrypted files should be walked after plain ones with the same basename so their values
+// take precedence
+func TestPrecedenceWalkEncrypted(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "precedence-walk-")
+	assert.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"values.enc.yaml", "values.yaml", "region1.enc.yaml", "other.enc.yaml"} {
+		err = ioutil.WriteFile(filepath.Join(tmpDir, name), []byte("a: b\n"), 0644)
+		assert.Nil(t, err)
+	}
+
+	precedence := []string{
+		"values",
+		"region1",
+	}
+
+	expected := []string{
+		"values.yaml",
+		"values.enc.yaml",
+		"region1.enc.yaml",
+	}
+
+	visited := make([]string, 0)
+
+	err = PrecedenceWalk(tmpDir, precedence, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if !info.IsDir() {
+			visited = append(visited, filepath.Base(path))
+		}
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, expected, visited)
+}
@@ -21,6 +21,8 @@ import (
 	"github.com/pkg/errors"
 	"github.com/sugarkube/sugarkube/internal/pkg/config"
 	"github.com/sugarkube/sugarkube/internal/pkg/log"
+	"github.com/sugarkube/sugarkube/internal/pkg/secrets"
+	"github.com/sugarkube/sugarkube/internal/pkg/utils"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 )
@@ -28,13 +30,30 @@ import (
 // Merges YAML files from multiple paths, with data from files loaded later
 // overriding values loaded earlier.
 func MergePaths(result *map[string]interface{}, paths ...string) error {
+	return MergePathsWithProvenance(result, nil, paths...)
+}
+
+// Like MergePaths but records which file set each value in the given provenance (if any)
+func MergePathsWithProvenance(result *map[string]interface{}, provenance *Provenance, paths ...string) error {
 
 	for _, path := range paths {
 		log.Logger.Debug("Loading path ", path)
 
-		contents, err := ioutil.ReadFile(path)
-		if err != nil {
-			return errors.Wrapf(err, "Error reading file %s", path)
+		encrypted := utils.IsEncryptedFile(path)
+
+		var contents []byte
+		var err error
+
+		if encrypted {
+			contents, err = secrets.Decrypt(path)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+		} else {
+			contents, err = ioutil.ReadFile(path)
+			if err != nil {
+				return errors.Wrapf(err, "Error reading file %s", path)
+			}
 		}
 
 		var yamlData = map[string]interface{}{}
@@ -44,6 +63,13 @@ func MergePaths(result *map[string]interface{}, paths ...string) error {
 			return errors.Wrapf(err, "Error parsing YAML: %s", path)
 		}
 
+		// redact decrypted values from logs
+		if encrypted {
+			secrets.MarkSensitive(yamlData)
+		}
+
+		provenance.Record(path, yamlData)
+
 		log.Logger.Tracef("Merging %v with %v", result, yamlData)
 
 		err = MergeWithStrategy(result, yamlData)
@@ -58,8 +84,19 @@ func MergePaths(result *map[string]interface{}, paths ...string) error {
 // Merges all given fragments, with values from later fragments overriding values
 // from earlier ones.
 func MergeFragments(result *map[string]interface{}, fragments ...map[string]interface{}) error {
+	return MergeFragmentsWithProvenance(result, nil, nil, fragments...)
+}
+
+// Like MergeFragments but records where each value was set in the given provenance (if any).
+// The origins of the values in each fragment are taken from the provenance at the same index.
+func MergeFragmentsWithProvenance(result *map[string]interface{}, provenance *Provenance,
+	fragmentProvenances []*Provenance, fragments ...map[string]interface{}) error {
+
+	for i, fragment := range fragments {
+		if i < len(fragmentProvenances) {
+			provenance.Include(fragmentProvenances[i])
+		}
 
-	for _, fragment := range fragments {
 		log.Logger.Tracef("Merging map %#v into existing map %#v - values "+
 			"will be overridden", fragment, result)
 		err := MergeWithStrategy(result, fragment)
@@ -0,0 +1,276 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vars
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// A value set by a source of vars
+type Origin struct {
+	Source   string      // e.g. the path to a vars file or a description of a descriptor layer
+	Value    interface{} // the value before it was templated
+	Appended bool        // whether the value is a list appended to lists from earlier sources
+}
+
+// Records which sources set each leaf of some merged vars, in the order they were merged so
+// later origins override earlier ones. Methods are no-ops on nil instances so it can be
+// threaded through merges optionally.
+type Provenance struct {
+	origins map[string][]Origin // keyed by the dotted path to each leaf
+	prefix  []string            // prepended to the paths of recorded values
+	lock    *sync.Mutex
+}
+
+func NewProvenance() *Provenance {
+	return &Provenance{
+		origins: map[string][]Origin{},
+		lock:    &sync.Mutex{},
+	}
+}
+
+// Returns a view of the provenance that records values under the given path, e.g. so vars
+// loaded for a kapp can be recorded under `kapp.vars`
+func (p *Provenance) Under(path ...string) *Provenance {
+	if p == nil {
+		return nil
+	}
+
+	return &Provenance{
+		origins: p.origins,
+		prefix:  append(append([]string{}, p.prefix...), path...),
+		lock:    p.lock,
+	}
+}
+
+// Records the leaves of a fragment of vars merged from a source. Like MergeWithStrategy, empty
+// values don't override existing ones and lists are appended unless configured otherwise.
+func (p *Provenance) Record(source string, fragment map[string]interface{}) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for k, v := range fragment {
+		p.record(source, v, append(append([]string{}, p.prefix...), k))
+	}
+}
+
+// Returns a provenance recording that a single source set all the values in a fragment
+func ProvenanceOf(source string, fragment map[string]interface{}) *Provenance {
+	provenance := NewProvenance()
+	provenance.Record(source, fragment)
+	return provenance
+}
+
+// Records the origins recorded in another provenance as if its values were merged at this point
+func (p *Provenance) Include(other *Provenance) {
+	if p == nil || other == nil {
+		return
+	}
+
+	other.lock.Lock()
+	origins := make(map[string][]Origin, len(other.origins))
+	for key, keyOrigins := range other.origins {
+		origins[key] = append([]Origin{}, keyOrigins...)
+	}
+	other.lock.Unlock()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for key, keyOrigins := range origins {
+		path := append(append([]string{}, p.prefix...), key)
+		for _, origin := range keyOrigins {
+			p.recordLeaf(origin.Source, origin.Value, strings.Join(path, "."))
+		}
+	}
+}
+
+func (p *Provenance) record(source string, value interface{}, path []string) {
+	if children, ok := mapValues(value); ok && len(children) > 0 {
+		for k, v := range children {
+			p.record(source, v, append(append([]string{}, path...), k))
+		}
+		return
+	}
+
+	p.recordLeaf(source, value, strings.Join(path, "."))
+}
+
+func (p *Provenance) recordLeaf(source string, value interface{}, key string) {
+	existing := p.origins[key]
+
+	if len(existing) > 0 && isEmpty(value) {
+		return
+	}
+
+	origin := Origin{Source: source, Value: value}
+
+	appendLists := config.CurrentConfig == nil || !config.CurrentConfig.OverwriteMergedLists
+	if len(existing) > 0 && appendLists && reflect.ValueOf(value).Kind() == reflect.Slice {
+		origin.Appended = true
+	}
+
+	p.origins[key] = append(existing, origin)
+}
+
+// Returns the sorted paths of recorded leaves that equal or are under the given path. All
+// paths are returned if it's empty.
+func (p *Provenance) Paths(path string) []string {
+	if p == nil {
+		return []string{}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	paths := make([]string, 0)
+	for key := range p.origins {
+		if path == "" || key == path || strings.HasPrefix(key, path+".") {
+			paths = append(paths, key)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// Returns the origins of the leaf at a path, from lowest to highest precedence
+func (p *Provenance) Origins(path string) []Origin {
+	if p == nil {
+		return []Origin{}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return append([]Origin{}, p.origins[path]...)
+}
+
+// Returns a description of the source that set each leaf under a path (all leaves if the path
+// is empty) and the values it overrode. Final values are looked up in the given vars.
+func (p *Provenance) Explain(path string, values map[string]interface{}) string {
+	path = strings.TrimPrefix(path, ".")
+
+	paths := p.Paths(path)
+	if len(paths) == 0 {
+		return fmt.Sprintf("No sources set '%s'\n", path)
+	}
+
+	var buf bytes.Buffer
+
+	for _, leafPath := range paths {
+		origins := p.Origins(leafPath)
+
+		finalValue, ok := lookupPath(values, leafPath)
+		if ok {
+			buf.WriteString(fmt.Sprintf("%s: %s\n", leafPath, formatValue(finalValue)))
+		} else {
+			buf.WriteString(fmt.Sprintf("%s:\n", leafPath))
+		}
+
+		// list the winning source first
+		for i := len(origins) - 1; i >= 0; i-- {
+			origin := origins[i]
+
+			var verb string
+			switch {
+			case i == len(origins)-1 && origin.Appended:
+				verb = "appended by"
+			case i == len(origins)-1:
+				verb = "set by"
+			case origins[i+1].Appended && origin.Appended:
+				verb = "appended to by"
+			case origins[i+1].Appended:
+				verb = "appended to"
+			default:
+				verb = "overrides"
+			}
+
+			buf.WriteString(fmt.Sprintf("  %s %s: %s\n", verb, origin.Source,
+				formatValue(origin.Value)))
+		}
+	}
+
+	return buf.String()
+}
+
+// Returns the value at a dotted path in some vars
+func lookupPath(values map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = values
+	for _, segment := range strings.Split(path, ".") {
+		children, ok := mapValues(current)
+		if !ok {
+			return nil, false
+		}
+
+		current, ok = children[segment]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// Returns the values in a map keyed by string
+func mapValues(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case map[interface{}]interface{}:
+		values := map[string]interface{}{}
+		for k, v := range typed {
+			values[fmt.Sprintf("%v", k)] = v
+		}
+		return values, true
+	}
+
+	return nil, false
+}
+
+// Returns whether a value is empty in the way that stops it overriding values while merging
+func isEmpty(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	reflected := reflect.ValueOf(value)
+	switch reflected.Kind() {
+	case reflect.Map, reflect.Slice, reflect.String:
+		return reflected.Len() == 0
+	}
+
+	return reflect.DeepEqual(value, reflect.Zero(reflected.Type()).Interface())
+}
+
+func formatValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return fmt.Sprintf("'%s'", str)
+	}
+
+	return fmt.Sprintf("%v", value)
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2019 The Sugarkube Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package vars
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sugarkube/sugarkube/internal/pkg/config"
+	"testing"
+)
+
+func TestProvenance(t *testing.T) {
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+	config.CurrentConfig = &config.Config{}
+
+	provenance := NewProvenance()
+
+	provenance.Record("defaults", map[string]interface{}{
+		"region": "eu-west-1",
+		"kapp": map[interface{}]interface{}{
+			"replicas": 1,
+			"hosts":    []interface{}{"a"},
+		},
+	})
+	provenance.Under("kapp").Record("overrides", map[string]interface{}{
+		"replicas": 3,
+		"hosts":    []interface{}{"b"},
+		"debug":    false,
+	})
+	// empty values don't override
+	provenance.Record("cli", map[string]interface{}{"region": ""})
+
+	assert.Equal(t, []string{"kapp.debug", "kapp.hosts", "kapp.replicas", "region"}, provenance.Paths(""))
+	assert.Equal(t, []string{"kapp.replicas"}, provenance.Paths("kapp.replicas"))
+	assert.Equal(t, []Origin{{Source: "defaults", Value: "eu-west-1"}}, provenance.Origins("region"))
+	assert.Equal(t, []Origin{
+		{Source: "defaults", Value: 1},
+		{Source: "overrides", Value: 3},
+	}, provenance.Origins("kapp.replicas"))
+	assert.True(t, provenance.Origins("kapp.hosts")[1].Appended)
+
+	values := map[string]interface{}{
+		"kapp": map[string]interface{}{
+			"replicas": 3,
+			"hosts":    []interface{}{"a", "b"},
+		},
+	}
+
+	assert.Equal(t, `kapp.hosts: [a b]
+  appended by overrides: [b]
+  appended to defaults: [a]
+kapp.replicas: 3
+  set by overrides: 3
+  overrides defaults: 1
+`, provenance.Explain(".kapp.hosts", values)+provenance.Explain("kapp.replicas", values))
+
+	assert.Equal(t, "No sources set 'kapp.missing'\n", provenance.Explain("kapp.missing", values))
+
+	// lists replace earlier ones if they aren't appended
+	config.CurrentConfig = &config.Config{OverwriteMergedLists: true}
+	provenance.Record("cli", map[string]interface{}{"kapp": map[string]interface{}{"hosts": []interface{}{"c"}}})
+	assert.False(t, provenance.Origins("kapp.hosts")[2].Appended)
+}
+
+func TestNilProvenance(t *testing.T) {
+	var provenance *Provenance
+
+	provenance.Under("kapp").Record("defaults", map[string]interface{}{"region": "eu-west-1"})
+	assert.Empty(t, provenance.Paths(""))
+	assert.Empty(t, provenance.Origins("region"))
+}
+
+func TestMergePathsWithProvenance(t *testing.T) {
+	topAbsPath := getAbsPath(t, topPath)
+	sub1AbsPath := getAbsPath(t, subPath1)
+
+	provenance := NewProvenance()
+	values := map[string]interface{}{}
+
+	err := MergePathsWithProvenance(&values, provenance, topAbsPath, sub1AbsPath)
+	assert.Nil(t, err)
+
+	origins := provenance.Origins("topString")
+	assert.Equal(t, topAbsPath, origins[len(origins)-1].Source)
+
+	origins = provenance.Origins("sub1.subStringOvr")
+	assert.Equal(t, sub1AbsPath, origins[len(origins)-1].Source)
+	assert.Equal(t, topAbsPath, origins[0].Source)
+}
+
+func TestMergeFragmentsWithProvenance(t *testing.T) {
+	originalConfig := config.CurrentConfig
+	defer func() {
+		config.CurrentConfig = originalConfig
+	}()
+	config.CurrentConfig = &config.Config{}
+
+	files := NewProvenance()
+	files.Record("values.yaml", map[string]interface{}{"region": "eu-west-1", "hosts": []interface{}{"a"}})
+	files.Record("dev.yaml", map[string]interface{}{"region": "eu-west-2"})
+
+	defaults := map[string]interface{}{"region": "us-east-1", "hosts": []interface{}{"x"}}
+	merged := map[string]interface{}{"region": "eu-west-2", "hosts": []interface{}{"a"}}
+
+	provenance := NewProvenance()
+	values := map[string]interface{}{}
+
+	err := MergeFragmentsWithProvenance(&values, provenance,
+		[]*Provenance{ProvenanceOf("defaults", defaults), files}, defaults, merged)
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]interface{}{"region": "eu-west-2", "hosts": []interface{}{"x", "a"}}, values)
+	assert.Equal(t, []Origin{
+		{Source: "defaults", Value: "us-east-1"},
+		{Source: "values.yaml", Value: "eu-west-1"},
+		{Source: "dev.yaml", Value: "eu-west-2"},
+	}, provenance.Origins("region"))
+	assert.True(t, provenance.Origins("hosts")[1].Appended)
+}